@@ -11,8 +11,10 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -69,6 +71,36 @@ func (m IntType) MangleSymbol() string {
 	return m.String()
 }
 
+// LongType is the WACC type for 64 bit integers
+type LongType struct{}
+
+// Prints long Types. Format:
+//   "long"
+func (l LongType) String() string {
+	return "long"
+}
+
+// MangleSymbol returns the type in a form that is ready to be included in
+// the mangled function symbol
+func (m LongType) MangleSymbol() string {
+	return m.String()
+}
+
+// FloatType is the WACC type for single precision floating point numbers
+type FloatType struct{}
+
+// Prints float Types. Format:
+//   "float"
+func (f FloatType) String() string {
+	return "float"
+}
+
+// MangleSymbol returns the type in a form that is ready to be included in
+// the mangled function symbol
+func (m FloatType) MangleSymbol() string {
+	return m.String()
+}
+
 // EnumType is the WACC type for booleans
 type EnumType struct {
 	TokenBase
@@ -88,6 +120,33 @@ func (e EnumType) MangleSymbol() string {
 	return fmt.Sprintf("enum_%v", e.ident)
 }
 
+// Codes of the runtime errors that are raised as exceptions. Programs can throw
+// any int so the runtime errors use negative codes
+const (
+	exceptionDivideByZero     = -1
+	exceptionNullReference    = -2
+	exceptionArrayIndex       = -3
+	exceptionOverflow         = -4
+	exceptionKeyNotFound      = -5
+	exceptionMissingInterface = -6
+)
+
+// CreateExceptionEnum returns the built-in enum naming the runtime errors that
+// can be caught as exceptions
+func CreateExceptionEnum() *EnumType {
+	return &EnumType{
+		ident: "Exception",
+		values: map[string]int{
+			"DivideByZero":          exceptionDivideByZero,
+			"NullReference":         exceptionNullReference,
+			"ArrayIndexOutOfBounds": exceptionArrayIndex,
+			"Overflow":              exceptionOverflow,
+			"KeyNotFound":           exceptionKeyNotFound,
+			"MissingInterface":      exceptionMissingInterface,
+		},
+	}
+}
+
 // BoolType is the WACC type for booleans
 type BoolType struct{}
 
@@ -171,33 +230,262 @@ func (m ArrayType) MangleSymbol() string {
 	)
 }
 
+// MapType is the WACC type for maps from keys to values
+type MapType struct {
+	key   Type
+	value Type
+}
+
+// Prints map Types. Format:
+//   "map([key], [value])"
+// Recurses on key and value.
+func (m MapType) String() string {
+	return fmt.Sprintf("map(%v, %v)", m.key, m.value)
+}
+
+// MangleSymbol returns the type in a form that is ready to be included in
+// the mangled function symbol
+func (m MapType) MangleSymbol() string {
+	return fmt.Sprintf(
+		"m_k_%s_v_%s_e",
+		m.key.MangleSymbol(),
+		m.value.MangleSymbol(),
+	)
+}
+
+// FunctionType is the WACC type for function values and closures
+type FunctionType struct {
+	returnType Type
+	params     []Type
+}
+
+// Prints function Types. Format:
+//   "[ret]([params]*)"
+// Recurses on ret and params.
+func (m FunctionType) String() string {
+	var params string
+
+	if len(m.params) > 0 {
+		params = fmt.Sprintf("%v", m.params[0])
+
+		for _, param := range m.params[1:] {
+			params = fmt.Sprintf("%v, %v", params, param)
+		}
+	}
+
+	return fmt.Sprintf("%v(%v)", m.returnType, params)
+}
+
+// MangleSymbol returns the type in a form that is ready to be included in
+// the mangled function symbol
+func (m FunctionType) MangleSymbol() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString(fmt.Sprintf("f_r_%s", m.returnType.MangleSymbol()))
+
+	for _, param := range m.params {
+		buffer.WriteString(fmt.Sprintf("_p_%s", param.MangleSymbol()))
+	}
+
+	buffer.WriteString("_e")
+
+	return buffer.String()
+}
+
 // ClassMember holds class member data
 type ClassMember struct {
 	TokenBase
-	ident string
-	wtype Type
-	get   bool
-	set   bool
+	ident   string
+	wtype   Type
+	get     bool
+	set     bool
+	private bool
 }
 
 // ClassType represents a class in WACC
 type ClassType struct {
 	TokenBase
-	name    string
-	members []*ClassMember
-	methods []*FunctionDef
+	name       string
+	parent     *ClassType
+	interfaces []*InterfaceType
+	members    []*ClassMember
+	statics    []*GlobalDef
+	methods    []*FunctionDef
+	typeParams []string
+	typeArgs   []Type
+	generic    *ClassType
+	instances  []*ClassType
+	node       *node32
 }
 
 // Prints class Types. Format:
 //   [classname]
+//   [classname]<[typeargs]>
 func (m *ClassType) String() string {
-	return m.name
+	if len(m.typeArgs) == 0 {
+		return m.name
+	}
+
+	args := fmt.Sprintf("%v", m.typeArgs[0])
+
+	for _, arg := range m.typeArgs[1:] {
+		args = fmt.Sprintf("%v, %v", args, arg)
+	}
+
+	return fmt.Sprintf("%v<%v>", m.name, args)
+}
+
+// Symbol returns the name the class is declared with. Instances of generic
+// classes have their type arguments mangled into it to distinguish them
+func (m *ClassType) Symbol() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString(m.name)
+
+	if len(m.typeArgs) > 0 {
+		buffer.WriteString("__tp_")
+	}
+
+	for _, arg := range m.typeArgs {
+		buffer.WriteString(
+			fmt.Sprintf("_%s", arg.MangleSymbol()),
+		)
+	}
+
+	return buffer.String()
 }
 
 // MangleSymbol returns the type in a form that is ready to be included in
 // the mangled function symbol
 func (m *ClassType) MangleSymbol() string {
-	return fmt.Sprintf("class_%v", m.name)
+	return fmt.Sprintf("class_%v", m.Symbol())
+}
+
+// Members returns all the members of the class including the inherited ones.
+// Inherited members come first so a subclass keeps the layout of its parent
+func (m *ClassType) Members() []*ClassMember {
+	if m.parent == nil {
+		return m.members
+	}
+
+	return append(m.parent.Members(), m.members...)
+}
+
+// VTable returns the methods of the class in the order of their virtual table
+// slots. Inherited methods keep the slot they have in the parent class,
+// overriding methods replace them and new methods are appended at the end
+func (m *ClassType) VTable() []*FunctionDef {
+	var vtable []*FunctionDef
+
+	if m.parent != nil {
+		vtable = append(vtable, m.parent.VTable()...)
+	}
+
+	for _, method := range m.methods {
+		if method.ident == "init" || method.static {
+			continue
+		}
+
+		overrides := false
+		for i, pmethod := range vtable {
+			if pmethod.Signature() == method.Signature() {
+				vtable[i] = method
+				overrides = true
+			}
+		}
+
+		if !overrides {
+			vtable = append(vtable, method)
+		}
+	}
+
+	return vtable
+}
+
+// VTableLabel returns the label of the virtual table of the class
+func (m *ClassType) VTableLabel() string {
+	return fmt.Sprintf("%v_vtable", m.MangleSymbol())
+}
+
+// ReleaseLabel returns the label of the routine releasing the objects held by
+// the members of an instance when it is freed
+func (m *ClassType) ReleaseLabel() string {
+	return fmt.Sprintf("%v_release", m.MangleSymbol())
+}
+
+// Destructor returns the slot of the fini method in the virtual table of the
+// class. The boolean is false if neither the class nor its parents define one
+func (m *ClassType) Destructor() (int, bool) {
+	for slot, method := range m.VTable() {
+		if method.ident == "fini" && len(method.params) == 0 {
+			return slot, true
+		}
+	}
+
+	return 0, false
+}
+
+// InterfaceTable returns the methods of the class implementing the methods of
+// the interface in the order they are declared in the interface. The boolean
+// is false if the class does not provide all of them. Private methods do not
+// implement the methods of interfaces
+func (m *ClassType) InterfaceTable(i *InterfaceType) ([]*FunctionDef, bool) {
+	vtable := m.VTable()
+
+	var table []*FunctionDef
+
+	for _, imethod := range i.methods {
+		var found *FunctionDef
+		for _, method := range vtable {
+			if method.private {
+				continue
+			}
+			if method.Signature() == imethod.Signature() &&
+				imethod.returnType.Match(method.returnType) {
+				found = method
+			}
+		}
+
+		if found == nil {
+			return nil, false
+		}
+
+		table = append(table, found)
+	}
+
+	return table, true
+}
+
+// InterfaceTableLabel returns the label of the method table of the class for
+// the given interface
+func (m *ClassType) InterfaceTableLabel(i *InterfaceType) string {
+	return fmt.Sprintf("%v_%v", m.MangleSymbol(), i.MangleSymbol())
+}
+
+// InterfacesLabel returns the label of the list of interfaces implemented by
+// the class and their method tables
+func (m *ClassType) InterfacesLabel() string {
+	return fmt.Sprintf("%v_itables", m.MangleSymbol())
+}
+
+// InterfaceType represents an interface in WACC, a set of methods a class
+// has to provide to be used in its place
+type InterfaceType struct {
+	TokenBase
+	ident   string
+	methods []*FunctionDef
+}
+
+// Prints interface Types. Format:
+//   "interface [name]"
+func (m *InterfaceType) String() string {
+	return fmt.Sprintf("interface %v", m.ident)
+}
+
+// MangleSymbol returns the type in a form that is ready to be included in
+// the mangled function symbol
+func (m *InterfaceType) MangleSymbol() string {
+	return fmt.Sprintf("interface_%v", m.ident)
 }
 
 // Expression is the interface for WACC expressions
@@ -261,14 +549,18 @@ type SkipStatement struct {
 	BaseStatement
 }
 
-// Continue Statement is the struct for WACC continue statement
+// Continue Statement is the struct for WACC continue statement. The label
+// names the loop to continue, the innermost one when empty
 type ContinueStatement struct {
 	BaseStatement
+	label string
 }
 
-// BreakStatement is the struct for WACC break statement
+// BreakStatement is the struct for WACC break statement. The label names the
+// loop to break out of, the innermost one when empty
 type BreakStatement struct {
 	BaseStatement
+	label string
 }
 
 // BlockStatement is the struct for creating new block scope
@@ -278,12 +570,14 @@ type BlockStatement struct {
 }
 
 // DeclareAssignStatement declares a new variable and assigns the right hand
-// side expression to it
+// side expression to it. Scoped variables are destroyed and freed when they go
+// out of scope
 type DeclareAssignStatement struct {
 	BaseStatement
-	wtype Type
-	ident string
-	rhs   RHS
+	wtype  Type
+	ident  string
+	rhs    RHS
+	scoped bool
 }
 
 // LHS is the interface for the left hand side of an assignment
@@ -310,12 +604,14 @@ func (m *PairElemLHS) Type() Type {
 	return m.wtype
 }
 
-// ArrayLHS is the struct for an array on the lhs of an assignment
+// ArrayLHS is the struct for an array element or a map value on the lhs of an
+// assignment. The indexed types are the arrays and maps accessed by each index
 type ArrayLHS struct {
 	TokenBase
-	wtype Type
-	ident string
-	index []Expression
+	wtype   Type
+	ident   string
+	index   []Expression
+	indexed []Type
 }
 
 // Type returns the Type of the LHS
@@ -377,6 +673,29 @@ func (m *ArrayLiterRHS) Type() Type {
 	return ArrayType{t}
 }
 
+// MapLiterRHS is the struct for map literals on the rhs of an assignment
+// The literal takes the type of the map it is assigned to, so that even an
+// empty literal knows how to compare its keys
+type MapLiterRHS struct {
+	TokenBase
+	wtype  Type
+	keys   []Expression
+	values []Expression
+}
+
+// Type returns the deduced type of the right hand side assignment source.
+func (m *MapLiterRHS) Type() Type {
+	if m.wtype != nil {
+		return m.wtype
+	}
+
+	if len(m.keys) == 0 {
+		return MapType{key: VoidType{}, value: VoidType{}}
+	}
+
+	return MapType{key: m.keys[0].Type(), value: m.values[0].Type()}
+}
+
 // PairElemRHS is the struct for pair elements on the rhs of an assignment
 type PairElemRHS struct {
 	TokenBase
@@ -400,9 +719,16 @@ func (m *PairElemRHS) Type() Type {
 // FunctionCall is the base struct for function calls
 type FunctionCall struct {
 	obj          string
+	class        *ClassType
+	iface        *InterfaceType
 	ident        string
 	mangledIdent string
+	closure      bool
+	local        *FunctionDef
 	args         []Expression
+	names        []string
+	variadic     Type
+	packed       int
 	wtype        Type
 }
 
@@ -437,9 +763,12 @@ func (m *ExpressionRHS) Type() Type {
 // NewInstanceRHS is the for new class instance on the rhs of an assignment
 type NewInstanceRHS struct {
 	TokenBase
-	constr string
-	wtype  Type
-	args   []Expression
+	constr   string
+	wtype    Type
+	args     []Expression
+	names    []string
+	variadic Type
+	packed   int
 }
 
 // Type returns the deduced type of the right hand side assignment source.
@@ -466,6 +795,13 @@ type FreeStatement struct {
 	expr Expression
 }
 
+// DeleteStatement is the struct for removing a key from a map
+type DeleteStatement struct {
+	BaseStatement
+	expr Expression
+	key  Expression
+}
+
 // ReturnStatement is the struct for a return statement
 type ReturnStatement struct {
 	BaseStatement
@@ -478,6 +814,21 @@ type ExitStatement struct {
 	expr Expression
 }
 
+// ThrowStatement is the struct for a throw statement
+type ThrowStatement struct {
+	BaseStatement
+	expr Expression
+}
+
+// TryStatement is the struct for a try-catch statement. The exception caught
+// is declared in the catch body under ident
+type TryStatement struct {
+	BaseStatement
+	body      Statement
+	ident     string
+	catchStat Statement
+}
+
 // PrintLnStatement is the struct for a println statement
 type PrintLnStatement struct {
 	BaseStatement
@@ -501,8 +852,9 @@ type IfStatement struct {
 // WhileStatement is the struct for a while statement
 type WhileStatement struct {
 	BaseStatement
-	cond Expression
-	body Statement
+	label string
+	cond  Expression
+	body  Statement
 }
 
 // SwitchStatement is the struct for a while statement
@@ -518,24 +870,58 @@ type SwitchStatement struct {
 // DoWhileStatement is the struct for a doWhile statement
 type DoWhileStatement struct {
 	BaseStatement
-	cond Expression
-	body Statement
+	label string
+	cond  Expression
+	body  Statement
 }
 
 //ForStatement is the struct for a for statement
 type ForStatement struct {
 	BaseStatement
+	label string
 	init  Statement
 	cond  Expression
 	after Statement
 	body  Statement
 }
 
+// ForEachStatement is the struct for a for statement iterating over the
+// elements of an array. The type of ident is inferred when wtype is nil
+type ForEachStatement struct {
+	BaseStatement
+	label string
+	wtype Type
+	ident string
+	expr  Expression
+	body  Statement
+}
+
+// LocalFuncStatement is the struct for a function defined inside a block. It
+// can only be called from that block and accesses the variables of the
+// enclosing functions through the frames they are declared in
+type LocalFuncStatement struct {
+	BaseStatement
+	function *FunctionDef
+}
+
 // FunctionParam is the struct for a function parameter
 type FunctionParam struct {
 	TokenBase
-	name  string
-	wtype Type
+	name     string
+	wtype    Type
+	value    Expression
+	variadic bool
+}
+
+// MangleSymbol returns the type of the parameter in a form that is ready to
+// be included in assembly labels. Variadic parameters are told apart from
+// array parameters
+func (m *FunctionParam) MangleSymbol() string {
+	if m.variadic {
+		return fmt.Sprintf("v_%s", m.wtype.MangleSymbol())
+	}
+
+	return m.wtype.MangleSymbol()
 }
 
 // FunctionDef is the struct for a function definition
@@ -543,9 +929,41 @@ type FunctionDef struct {
 	TokenBase
 	ident      string
 	class      *ClassType
+	static     bool
+	private    bool
 	returnType Type
 	params     []*FunctionParam
+	captures   []*FunctionParam
 	body       Statement
+	typeParams []string
+	typeArgs   []Type
+	instances  []*FunctionDef
+	node       *node32
+	local      int
+	depth      int
+	shared     []string
+}
+
+// operatorLabels maps the symbol of an overloaded operator to the name used
+// in the label of the method
+var operatorLabels = map[string]string{
+	"*":   "mul",
+	"/":   "div",
+	"%":   "mod",
+	"+":   "add",
+	"-":   "sub",
+	"<<":  "shl",
+	">>>": "ushr",
+	">>":  "shr",
+	">":   "gt",
+	">=":  "ge",
+	"<":   "lt",
+	"<=":  "le",
+	"==":  "eq",
+	"!=":  "ne",
+	"&":   "and",
+	"|":   "or",
+	"^":   "xor",
 }
 
 // Symbol returns the mangled symbol of the function to distinguish overloaded
@@ -553,19 +971,113 @@ type FunctionDef struct {
 func (m *FunctionDef) Symbol() string {
 	var buffer bytes.Buffer
 
-	buffer.WriteString(m.ident)
+	// local functions with the same identifier are told apart by their number
+	if m.local > 0 {
+		buffer.WriteString(fmt.Sprintf("local_%d_", m.local))
+	}
+
+	if label, ok := operatorLabels[strings.TrimPrefix(m.ident, "operator")]; ok {
+		buffer.WriteString(fmt.Sprintf("operator_%s", label))
+	} else {
+		buffer.WriteString(m.ident)
+	}
+
+	switch {
+	case m.class != nil && m.static:
+		buffer.WriteString(fmt.Sprintf("__static_%s_", m.class.Symbol()))
+	case m.class != nil:
+		buffer.WriteString(fmt.Sprintf("__class_%s_", m.class.Symbol()))
+	}
+
+	if len(m.typeArgs) > 0 {
+		buffer.WriteString("__tp_")
+	}
+
+	for _, arg := range m.typeArgs {
+		buffer.WriteString(
+			fmt.Sprintf("_%s", arg.MangleSymbol()),
+		)
+	}
+
+	if len(m.params) > 0 {
+		buffer.WriteString("__ol_")
+	}
 
-	if m.class != nil {
-		buffer.WriteString(fmt.Sprintf("__class_%s_", m.class.name))
+	for _, param := range m.params {
+		buffer.WriteString(
+			fmt.Sprintf("_%s", param.MangleSymbol()),
+		)
+	}
+
+	return buffer.String()
+}
+
+// Capture records a variable of an enclosing scope used inside a lambda body.
+// Captured variables are copied into the closure environment on creation
+func (m *FunctionDef) Capture(ident string, wtype Type) {
+	for _, c := range m.captures {
+		if c.name == ident {
+			return
+		}
+	}
+
+	m.captures = append(m.captures, &FunctionParam{name: ident, wtype: wtype})
+}
+
+// Share records a variable of an enclosing function used inside the body of a
+// local function. Shared variables stay in the frame they are declared in
+func (m *FunctionDef) Share(ident string) {
+	for _, s := range m.shared {
+		if s == ident {
+			return
+		}
+	}
+
+	m.shared = append(m.shared, ident)
+}
+
+// Variadic returns whether the trailing arguments of calls to the function are
+// packed into an array passed as its last parameter
+func (m *FunctionDef) Variadic() bool {
+	return len(m.params) > 0 && m.params[len(m.params)-1].variadic
+}
+
+// Packed returns the type of the array the trailing arguments of a call with
+// the given number of arguments are packed into and how many of them are
+// packed. The type is nil if the function is not variadic
+func (m *FunctionDef) Packed(args int) (Type, int) {
+	if !m.Variadic() {
+		return nil, 0
+	}
+
+	return m.params[len(m.params)-1].wtype, args - len(m.params) + 1
+}
+
+// ValueType returns the type of the function when used as a value
+func (m *FunctionDef) ValueType() FunctionType {
+	var params []Type
+
+	for _, param := range m.params {
+		params = append(params, param.wtype)
 	}
 
+	return FunctionType{returnType: m.returnType, params: params}
+}
+
+// Signature returns the mangled symbol of the function ignoring the class it
+// belongs to. Methods with the same signature override each other
+func (m *FunctionDef) Signature() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString(m.ident)
+
 	if len(m.params) > 0 {
 		buffer.WriteString("__ol_")
 	}
 
 	for _, param := range m.params {
 		buffer.WriteString(
-			fmt.Sprintf("_%s", param.wtype.MangleSymbol()),
+			fmt.Sprintf("_%s", param.MangleSymbol()),
 		)
 	}
 
@@ -574,11 +1086,65 @@ func (m *FunctionDef) Symbol() string {
 
 // AST is the main struct that represents the abstract syntax tree
 type AST struct {
-	main      Statement
-	functions []*FunctionDef
-	includes  []string
-	classes   []*ClassType
-	enums     []*EnumType
+	main       Statement
+	functions  []*FunctionDef
+	includes   []string
+	modules    []*Module
+	exports    []string
+	aliases    map[string]map[string]string
+	hidden     map[string]string
+	classes    []*ClassType
+	interfaces []*InterfaceType
+	enums      []*EnumType
+	globals    []*GlobalDef
+	lambdas    []*FunctionDef
+	exceptions bool
+}
+
+// Module is a file imported under an alias. The definitions of a module are
+// merged in the AST prefixed by the identifier of the module so that they are
+// only accessible qualified by an alias of it
+type Module struct {
+	TokenBase
+	file       string
+	alias      string
+	ident      string
+	clash      bool
+	redeclared bool
+}
+
+// GlobalDef is a variable declared at the top level of a file. Globals are
+// stored in the data segment and initialised in order before the main program.
+// Constants cannot be assigned to and are initialised by a constant expression
+// Static fields of classes are globals referred to as Class::field
+type GlobalDef struct {
+	TokenBase
+	wtype    Type
+	ident    string
+	rhs      RHS
+	constant bool
+	static   bool
+}
+
+// Label returns the label of the word holding the global in the data segment
+func (m *GlobalDef) Label() string {
+	return fmt.Sprintf("global_%v", strings.Replace(m.ident, "::", "__static_", -1))
+}
+
+// Classes returns the classes code is generated for. Generic classes are
+// replaced by their instances
+func (m *AST) Classes() []*ClassType {
+	var classes []*ClassType
+
+	for _, c := range m.classes {
+		if len(c.typeParams) > 0 {
+			classes = append(classes, c.instances...)
+			continue
+		}
+		classes = append(classes, c)
+	}
+
+	return classes
 }
 
 // nodeRange given a node returns a channel from which all nodes at the same
@@ -628,8 +1194,10 @@ func parseArrayElem(node *node32) (Expression, error) {
 // Ident is the struct to represent an identifier
 type Ident struct {
 	TokenBase
-	wtype Type
-	ident string
+	wtype    Type
+	ident    string
+	function *FunctionDef
+	global   *GlobalDef
 }
 
 // Type returns the Type of the expression
@@ -637,6 +1205,17 @@ func (m *Ident) Type() Type {
 	return m.wtype
 }
 
+// Lambda is the struct to represent an anonymous function expression
+type Lambda struct {
+	TokenBase
+	function *FunctionDef
+}
+
+// Type returns the Type of the expression
+func (m *Lambda) Type() Type {
+	return m.function.ValueType()
+}
+
 // IntLiteral is the struct to represent an integer literal
 type IntLiteral struct {
 	TokenBase
@@ -648,22 +1227,44 @@ func (m *IntLiteral) Type() Type {
 	return IntType{}
 }
 
-// EnumLiteral is the struct to represent an integer literal
-type EnumLiteral struct {
+// LongLiteral is the struct to represent a long literal
+type LongLiteral struct {
 	TokenBase
-	wtype Type
-	ident string
-	field string
-	value int
+	value int64
 }
 
 // Type returns the Type of the expression
-func (m *EnumLiteral) Type() Type {
-	return m.wtype
+func (m *LongLiteral) Type() Type {
+	return LongType{}
 }
 
-// BoolLiteralTrue is the struct to represent a true boolean literal
-type BoolLiteralTrue struct {
+// FloatLiteral is the struct to represent a float literal
+type FloatLiteral struct {
+	TokenBase
+	value float32
+}
+
+// Type returns the Type of the expression
+func (m *FloatLiteral) Type() Type {
+	return FloatType{}
+}
+
+// EnumLiteral is the struct to represent an integer literal
+type EnumLiteral struct {
+	TokenBase
+	wtype Type
+	ident string
+	field string
+	value int
+}
+
+// Type returns the Type of the expression
+func (m *EnumLiteral) Type() Type {
+	return m.wtype
+}
+
+// BoolLiteralTrue is the struct to represent a true boolean literal
+type BoolLiteralTrue struct {
 	TokenBase
 }
 
@@ -727,13 +1328,15 @@ func (m *NullPair) Type() Type {
 	return PairType{first: VoidType{}, second: VoidType{}}
 }
 
-// ArrayElem is the struct to represent an array element
+// ArrayElem is the struct to represent an array element or a map value
+// The indexed types are the arrays and maps accessed by each index
 type ArrayElem struct {
 	TokenBase
 	weightCache int
 	ident       string
 	wtype       Type
 	indexes     []Expression
+	indexed     []Type
 }
 
 // Type returns the Type of the expression
@@ -741,6 +1344,19 @@ func (m *ArrayElem) Type() Type {
 	return m.wtype
 }
 
+// MapHas is the struct to represent the check whether a map holds a key
+type MapHas struct {
+	TokenBase
+	weightCache int
+	expr        Expression
+	key         Expression
+}
+
+// Type returns the Type of the expression
+func (m *MapHas) Type() Type {
+	return BoolType{}
+}
+
 // UnaryOperator is the struct to represent the unary operators
 type UnaryOperator interface {
 	Expression
@@ -783,6 +1399,22 @@ type UnaryOperatorNegate struct {
 
 // Type returns the Type of the expression
 func (m *UnaryOperatorNegate) Type() Type {
+	switch m.expr.Type().(type) {
+	case LongType:
+		return LongType{}
+	case FloatType:
+		return FloatType{}
+	}
+	return IntType{}
+}
+
+// UnaryOperatorBitNot represents '~'
+type UnaryOperatorBitNot struct {
+	UnaryOperatorBase
+}
+
+// Type returns the Type of the expression
+func (m *UnaryOperatorBitNot) Type() Type {
 	return IntType{}
 }
 
@@ -816,6 +1448,36 @@ func (m *UnaryOperatorChr) Type() Type {
 	return CharType{}
 }
 
+// UnaryOperatorLong represents 'long', converting an int to a long
+type UnaryOperatorLong struct {
+	UnaryOperatorBase
+}
+
+// Type returns the Type of the expression
+func (m *UnaryOperatorLong) Type() Type {
+	return LongType{}
+}
+
+// UnaryOperatorFloat represents 'float', converting an int to a float
+type UnaryOperatorFloat struct {
+	UnaryOperatorBase
+}
+
+// Type returns the Type of the expression
+func (m *UnaryOperatorFloat) Type() Type {
+	return FloatType{}
+}
+
+// UnaryOperatorInt represents 'int', truncating a long or a float to an int
+type UnaryOperatorInt struct {
+	UnaryOperatorBase
+}
+
+// Type returns the Type of the expression
+func (m *UnaryOperatorInt) Type() Type {
+	return IntType{}
+}
+
 // BinaryOperator represents a generic binaryOperator which might be an expr.
 type BinaryOperator interface {
 	Expression
@@ -823,6 +1485,8 @@ type BinaryOperator interface {
 	SetRHS(Expression)
 	GetLHS() Expression
 	SetLHS(Expression)
+	GetOverload() *FunctionCall
+	SetOverload(*FunctionCall)
 }
 
 // BinaryOperatorBase represents the base of a binary operator.
@@ -831,6 +1495,7 @@ type BinaryOperatorBase struct {
 	weightCache int
 	lhs         Expression
 	rhs         Expression
+	overload    *FunctionCall
 }
 
 // GetLHS returns the left-hand-side associated with a BinaryOperatorBase.
@@ -853,6 +1518,36 @@ func (m *BinaryOperatorBase) SetRHS(exp Expression) {
 	m.rhs = exp
 }
 
+// GetOverload returns the method call the operator is lowered into when the
+// left-hand-side is an object overloading it.
+func (m *BinaryOperatorBase) GetOverload() *FunctionCall {
+	return m.overload
+}
+
+// SetOverload sets the method call the operator is lowered into.
+func (m *BinaryOperatorBase) SetOverload(call *FunctionCall) {
+	m.overload = call
+}
+
+// overloadType returns the return type of the method overloading the
+// operator, or t if the operator is not overloaded.
+func (m *BinaryOperatorBase) overloadType(t Type) Type {
+	if m.overload != nil {
+		return m.overload.wtype
+	}
+	return t
+}
+
+// arithmeticType returns the type of an arithmetic operator, which operates
+// on longs or floats if the left-hand-side is one and on ints otherwise.
+func (m *BinaryOperatorBase) arithmeticType() Type {
+	switch t := m.lhs.Type().(type) {
+	case LongType, FloatType:
+		return m.overloadType(t)
+	}
+	return m.overloadType(IntType{})
+}
+
 // BinaryOperatorMult represents '*'
 type BinaryOperatorMult struct {
 	BinaryOperatorBase
@@ -860,7 +1555,7 @@ type BinaryOperatorMult struct {
 
 // Type returns the Type of the expression
 func (m *BinaryOperatorMult) Type() Type {
-	return IntType{}
+	return m.arithmeticType()
 }
 
 // BinaryOperatorDiv represents '/'
@@ -870,7 +1565,7 @@ type BinaryOperatorDiv struct {
 
 // Type returns the Type of the expression
 func (m *BinaryOperatorDiv) Type() Type {
-	return IntType{}
+	return m.arithmeticType()
 }
 
 // BinaryOperatorMod represents '%'
@@ -880,7 +1575,7 @@ type BinaryOperatorMod struct {
 
 // Type returns the Type of the expression
 func (m *BinaryOperatorMod) Type() Type {
-	return IntType{}
+	return m.arithmeticType()
 }
 
 // BinaryOperatorAdd represents '+'
@@ -890,7 +1585,7 @@ type BinaryOperatorAdd struct {
 
 // Type returns the Type of the expression
 func (m *BinaryOperatorAdd) Type() Type {
-	return IntType{}
+	return m.arithmeticType()
 }
 
 // BinaryOperatorSub represents '-'
@@ -900,7 +1595,7 @@ type BinaryOperatorSub struct {
 
 // Type returns the Type of the expression
 func (m *BinaryOperatorSub) Type() Type {
-	return IntType{}
+	return m.arithmeticType()
 }
 
 // BinaryOperatorGreaterThan represents '>'
@@ -910,7 +1605,7 @@ type BinaryOperatorGreaterThan struct {
 
 // Type returns the Type of the expression
 func (m *BinaryOperatorGreaterThan) Type() Type {
-	return BoolType{}
+	return m.overloadType(BoolType{})
 }
 
 // BinaryOperatorGreaterEqual represents '>='
@@ -920,7 +1615,7 @@ type BinaryOperatorGreaterEqual struct {
 
 // Type returns the Type of the expression
 func (m *BinaryOperatorGreaterEqual) Type() Type {
-	return BoolType{}
+	return m.overloadType(BoolType{})
 }
 
 // BinaryOperatorLessThan represents '<'
@@ -930,7 +1625,7 @@ type BinaryOperatorLessThan struct {
 
 // Type returns the Type of the expression
 func (m *BinaryOperatorLessThan) Type() Type {
-	return BoolType{}
+	return m.overloadType(BoolType{})
 }
 
 // BinaryOperatorLessEqual represents '<='
@@ -940,7 +1635,7 @@ type BinaryOperatorLessEqual struct {
 
 // Type returns the Type of the expression
 func (m *BinaryOperatorLessEqual) Type() Type {
-	return BoolType{}
+	return m.overloadType(BoolType{})
 }
 
 // BinaryOperatorEqual represents '=='
@@ -950,7 +1645,7 @@ type BinaryOperatorEqual struct {
 
 // Type returns the Type of the expression
 func (m *BinaryOperatorEqual) Type() Type {
-	return BoolType{}
+	return m.overloadType(BoolType{})
 }
 
 // BinaryOperatorNotEqual represents '!='
@@ -960,7 +1655,7 @@ type BinaryOperatorNotEqual struct {
 
 // Type returns the Type of the expression
 func (m *BinaryOperatorNotEqual) Type() Type {
-	return BoolType{}
+	return m.overloadType(BoolType{})
 }
 
 // BinaryOperatorAnd represents '&&'
@@ -990,7 +1685,7 @@ type BinaryOperatorBitAnd struct {
 
 // Type returns the Type of the expression
 func (m *BinaryOperatorBitAnd) Type() Type {
-	return IntType{}
+	return m.overloadType(IntType{})
 }
 
 // BinaryOperatorBitOr represents '|'
@@ -1000,7 +1695,70 @@ type BinaryOperatorBitOr struct {
 
 // Type returns the Type of the expression
 func (m *BinaryOperatorBitOr) Type() Type {
-	return IntType{}
+	return m.overloadType(IntType{})
+}
+
+// BinaryOperatorBitXor represents '^'
+type BinaryOperatorBitXor struct {
+	BinaryOperatorBase
+}
+
+// Type returns the Type of the expression
+func (m *BinaryOperatorBitXor) Type() Type {
+	return m.overloadType(IntType{})
+}
+
+// BinaryOperatorShiftLeft represents '<<'
+type BinaryOperatorShiftLeft struct {
+	BinaryOperatorBase
+}
+
+// Type returns the Type of the expression
+func (m *BinaryOperatorShiftLeft) Type() Type {
+	return m.overloadType(IntType{})
+}
+
+// BinaryOperatorShiftRight represents '>>', the sign bit is shifted in
+type BinaryOperatorShiftRight struct {
+	BinaryOperatorBase
+}
+
+// Type returns the Type of the expression
+func (m *BinaryOperatorShiftRight) Type() Type {
+	return m.overloadType(IntType{})
+}
+
+// BinaryOperatorShiftRightLogical represents '>>>', zeros are shifted in
+type BinaryOperatorShiftRightLogical struct {
+	BinaryOperatorBase
+}
+
+// Type returns the Type of the expression
+func (m *BinaryOperatorShiftRightLogical) Type() Type {
+	return m.overloadType(IntType{})
+}
+
+// TernaryExpr represents 'cond ? then : else', only one of the branches is
+// evaluated depending on the condition
+type TernaryExpr struct {
+	TokenBase
+	weightCache int
+	cond        Expression
+	then        Expression
+	elseExpr    Expression
+}
+
+// Type returns the Type of the expression, the more general of the types of
+// its branches. A null branch takes the type of the other branch
+func (m *TernaryExpr) Type() Type {
+	thenT, elseT := m.then.Type(), m.elseExpr.Type()
+	if _, ok := m.then.(*NullPair); ok {
+		return elseT
+	}
+	if !thenT.Match(elseT) && elseT.Match(thenT) {
+		return elseT
+	}
+	return thenT
 }
 
 // ExprParen represents '()'
@@ -1049,27 +1807,36 @@ func exprStream(node *node32) <-chan *node32 {
 // PriorityMap is a map from interface to integers. It holds all
 // the priority value of all the Unary/Binary Operators
 var PriorityMap = map[interface{}]int{
-	UnaryOperatorNot{}:           2,
-	UnaryOperatorNegate{}:        2,
-	UnaryOperatorLen{}:           2,
-	UnaryOperatorOrd{}:           2,
-	UnaryOperatorChr{}:           2,
-	BinaryOperatorMult{}:         3,
-	BinaryOperatorDiv{}:          3,
-	BinaryOperatorMod{}:          3,
-	BinaryOperatorAdd{}:          4,
-	BinaryOperatorSub{}:          4,
-	BinaryOperatorGreaterThan{}:  6,
-	BinaryOperatorGreaterEqual{}: 6,
-	BinaryOperatorLessThan{}:     6,
-	BinaryOperatorLessEqual{}:    6,
-	BinaryOperatorEqual{}:        7,
-	BinaryOperatorNotEqual{}:     7,
-	BinaryOperatorAnd{}:          11,
-	BinaryOperatorBitAnd{}:       11,
-	BinaryOperatorOr{}:           12,
-	BinaryOperatorBitOr{}:        12,
-	ExprParen{}:                  13,
+	UnaryOperatorNot{}:                2,
+	UnaryOperatorNegate{}:             2,
+	UnaryOperatorBitNot{}:             2,
+	UnaryOperatorLen{}:                2,
+	UnaryOperatorOrd{}:                2,
+	UnaryOperatorChr{}:                2,
+	UnaryOperatorLong{}:               2,
+	UnaryOperatorInt{}:                2,
+	UnaryOperatorFloat{}:              2,
+	BinaryOperatorMult{}:              3,
+	BinaryOperatorDiv{}:               3,
+	BinaryOperatorMod{}:               3,
+	BinaryOperatorAdd{}:               4,
+	BinaryOperatorSub{}:               4,
+	BinaryOperatorShiftLeft{}:         5,
+	BinaryOperatorShiftRight{}:        5,
+	BinaryOperatorShiftRightLogical{}: 5,
+	BinaryOperatorGreaterThan{}:       6,
+	BinaryOperatorGreaterEqual{}:      6,
+	BinaryOperatorLessThan{}:          6,
+	BinaryOperatorLessEqual{}:         6,
+	BinaryOperatorEqual{}:             7,
+	BinaryOperatorNotEqual{}:          7,
+	BinaryOperatorBitAnd{}:            8,
+	BinaryOperatorBitXor{}:            9,
+	BinaryOperatorBitOr{}:             10,
+	BinaryOperatorAnd{}:               11,
+	BinaryOperatorOr{}:                12,
+	TernaryExpr{}:                     13,
+	ExprParen{}:                       13,
 }
 
 // parseExpr parses an expression and builds an expression tree that respects
@@ -1123,6 +1890,9 @@ func parseExpr(node *node32) (Expression, error) {
 		case BinaryOperator:
 			t.SetRHS(pop())
 			t.SetLHS(pop())
+		case *TernaryExpr:
+			t.elseExpr = pop()
+			t.cond = pop()
 		case *ExprParen:
 			exp = nil
 		}
@@ -1153,9 +1923,13 @@ func parseExpr(node *node32) (Expression, error) {
 		switch exp.(type) {
 		case *UnaryOperatorNot,
 			*UnaryOperatorNegate,
+			*UnaryOperatorBitNot,
 			*UnaryOperatorLen,
 			*UnaryOperatorOrd,
-			*UnaryOperatorChr:
+			*UnaryOperatorChr,
+			*UnaryOperatorLong,
+			*UnaryOperatorInt,
+			*UnaryOperatorFloat:
 			return true
 		default:
 			return false
@@ -1169,9 +1943,13 @@ func parseExpr(node *node32) (Expression, error) {
 			ruleUNARYOPER: {
 				ruleBANG:  &UnaryOperatorNot{},
 				ruleMINUS: &UnaryOperatorNegate{},
+				ruleTILDE: &UnaryOperatorBitNot{},
 				ruleLEN:   &UnaryOperatorLen{},
 				ruleORD:   &UnaryOperatorOrd{},
 				ruleCHR:   &UnaryOperatorChr{},
+				ruleLONG:  &UnaryOperatorLong{},
+				ruleINT:   &UnaryOperatorInt{},
+				ruleFLOAT: &UnaryOperatorFloat{},
 			},
 			ruleBINARYOPER: {
 				ruleSTAR:    &BinaryOperatorMult{},
@@ -1189,6 +1967,10 @@ func parseExpr(node *node32) (Expression, error) {
 				ruleOROR:    &BinaryOperatorOr{},
 				ruleAND:     &BinaryOperatorBitAnd{},
 				ruleOR:      &BinaryOperatorBitOr{},
+				ruleCARET:   &BinaryOperatorBitXor{},
+				ruleLTLT:    &BinaryOperatorShiftLeft{},
+				ruleGTGT:    &BinaryOperatorShiftRight{},
+				ruleGTGTGT:  &BinaryOperatorShiftRightLogical{},
 			},
 		}
 
@@ -1218,6 +2000,37 @@ func parseExpr(node *node32) (Expression, error) {
 				return nil, err
 			}
 			push(&IntLiteral{value: int(num)})
+		case ruleLONGLITER:
+			match := strings.TrimRight(enode.match, "lL")
+			num, err := strconv.ParseInt(match, 10, 64)
+			if err != nil {
+				// number does not fit into WACC long size
+				numerr := err.(*strconv.NumError)
+				switch numerr.Err {
+				case strconv.ErrRange:
+					return nil, CreateBigIntError(
+						&enode.token32,
+						enode.match,
+					)
+				}
+				return nil, err
+			}
+			push(&LongLiteral{value: num})
+		case ruleFLOATLITER:
+			num, err := strconv.ParseFloat(enode.match, 32)
+			if err != nil {
+				// number does not fit into WACC float size
+				numerr := err.(*strconv.NumError)
+				switch numerr.Err {
+				case strconv.ErrRange:
+					return nil, CreateBigIntError(
+						&enode.token32,
+						enode.match,
+					)
+				}
+				return nil, err
+			}
+			push(&FloatLiteral{value: float32(num)})
 		case ruleFALSE:
 			push(&BoolLiteralFalse{})
 		case ruleTRUE:
@@ -1248,6 +2061,28 @@ func parseExpr(node *node32) (Expression, error) {
 				return nil, err
 			}
 			push(arrElem)
+		case ruleMAPHAS:
+			has := &MapHas{}
+
+			exprNode := nextNode(enode.up, ruleEXPR)
+			var err error
+			if has.expr, err = parseExpr(exprNode.up); err != nil {
+				return nil, err
+			}
+
+			keyNode := nextNode(exprNode.next, ruleEXPR)
+			if has.key, err = parseExpr(keyNode.up); err != nil {
+				return nil, err
+			}
+
+			push(has)
+		case ruleLAMBDA:
+			lambda, err := parseLambda(enode.up)
+			if err != nil {
+				return nil, err
+			}
+			lambda.SetToken(&enode.token32)
+			push(lambda)
 		case ruleUNARYOPER, ruleBINARYOPER:
 			op1 := ruleToOp(enode.pegRule, enode.up.pegRule)
 		op2l:
@@ -1266,6 +2101,29 @@ func parseExpr(node *node32) (Expression, error) {
 				}
 			}
 			pushop(op1)
+		case ruleQUESTION:
+			// the conditional operator is right associative
+			op1 := &TernaryExpr{}
+			for op2 := peekop(); op2 != nil && prio(op1) > prio(op2); op2 = peekop() {
+				popop()
+			}
+			pushop(op1)
+		case ruleCOLON:
+			// the branch taken when the condition holds is complete
+			// once all the operators since the '?' are popped
+		ternaryloop:
+			for {
+				switch t := peekop().(type) {
+				case *TernaryExpr:
+					if t.then == nil {
+						t.then = pop()
+						break ternaryloop
+					}
+					popop()
+				default:
+					popop()
+				}
+			}
 		case ruleLPAR:
 			pushop(&ExprParen{})
 		case ruleRPAR:
@@ -1384,6 +2242,32 @@ func parseRHS(node *node32) (RHS, error) {
 		}
 
 		return arr, nil
+	case ruleMAPLITER:
+		node = node.up
+
+		lit := new(MapLiterRHS)
+
+		lit.SetToken(&node.token32)
+
+		for node = nextNode(node, ruleMAPENTRY); node != nil; node = nextNode(node.next, ruleMAPENTRY) {
+			var err error
+			var key, value Expression
+
+			keyNode := nextNode(node.up, ruleEXPR)
+			if key, err = parseExpr(keyNode.up); err != nil {
+				return nil, err
+			}
+
+			valueNode := nextNode(keyNode.next, ruleEXPR)
+			if value, err = parseExpr(valueNode.up); err != nil {
+				return nil, err
+			}
+
+			lit.keys = append(lit.keys, key)
+			lit.values = append(lit.values, value)
+		}
+
+		return lit, nil
 	case rulePAIRELEM:
 		target := new(PairElemRHS)
 
@@ -1413,20 +2297,10 @@ func parseRHS(node *node32) (RHS, error) {
 		identNode := nextNode(node, ruleIDENT)
 		call.ident = identNode.match
 
-		arglistNode := nextNode(node, ruleARGLIST)
-		if arglistNode == nil {
-			return call, nil
-		}
-
-		for argNode := nextNode(arglistNode.up, ruleEXPR); argNode != nil; argNode = nextNode(argNode.next, ruleEXPR) {
-			var err error
-			var expr Expression
-
-			if expr, err = parseExpr(argNode.up); err != nil {
-				return nil, err
-			}
-
-			call.args = append(call.args, expr)
+		var err error
+		call.args, call.names, err = parseArgList(nextNode(node, ruleARGLIST))
+		if err != nil {
+			return nil, err
 		}
 
 		return call, nil
@@ -1453,20 +2327,21 @@ func parseRHS(node *node32) (RHS, error) {
 
 		newInst.wtype = &ClassType{name: identNode.match}
 
-		arglistNode := nextNode(node, ruleARGLIST)
-		if arglistNode != nil {
-			for argNode := nextNode(arglistNode.up, ruleEXPR); argNode != nil; argNode = nextNode(argNode.next, ruleEXPR) {
-				var err error
-				var expr Expression
-
-				if expr, err = parseExpr(argNode.up); err != nil {
-					return nil, err
-				}
+		if typeArgsNode := nextNode(node, ruleTYPEARGS); typeArgsNode != nil {
+			var err error
 
-				newInst.args = append(newInst.args, expr)
+			ct := newInst.wtype.(*ClassType)
+			if ct.typeArgs, err = parseTypeArgs(typeArgsNode.up); err != nil {
+				return nil, err
 			}
 		}
 
+		var err error
+		newInst.args, newInst.names, err = parseArgList(nextNode(node, ruleARGLIST))
+		if err != nil {
+			return nil, err
+		}
+
 		return newInst, nil
 	default:
 		return nil, fmt.Errorf("Unexpected rule %s %s", node.String(), node.match)
@@ -1478,6 +2353,10 @@ func parseBaseType(node *node32) (Type, error) {
 	switch node.pegRule {
 	case ruleINT:
 		return IntType{}, nil
+	case ruleLONG:
+		return LongType{}, nil
+	case ruleFLOAT:
+		return FloatType{}, nil
 	case ruleBOOL:
 		return BoolType{}, nil
 	case ruleCHAR:
@@ -1487,9 +2366,20 @@ func parseBaseType(node *node32) (Type, error) {
 	case ruleVOID:
 		return VoidType{}, nil
 	case ruleCLASSTYPE:
-		return &ClassType{name: node.up.match}, nil
+		class := &ClassType{name: node.up.match}
+
+		if typeArgsNode := nextNode(node.up, ruleTYPEARGS); typeArgsNode != nil {
+			var err error
+			if class.typeArgs, err = parseTypeArgs(typeArgsNode.up); err != nil {
+				return nil, err
+			}
+		}
+
+		return class, nil
 	case ruleENUMTYPE:
 		return &EnumType{ident: node.up.next.match}, nil
+	case ruleINTERFACETYPE:
+		return &InterfaceType{ident: node.up.next.match}, nil
 	default:
 		return nil, fmt.Errorf("Unknown type: %s", node.up.match)
 	}
@@ -1517,6 +2407,26 @@ func parsePairType(node *node32) (Type, error) {
 
 }
 
+// parseMapType parse a map type with its key and value types
+func parseMapType(node *node32) (Type, error) {
+	var err error
+
+	mapType := MapType{key: VoidType{}, value: VoidType{}}
+
+	key := nextNode(node, ruleTYPE)
+
+	value := nextNode(key.next, ruleTYPE)
+
+	if mapType.key, err = parseType(key.up); err != nil {
+		return nil, err
+	}
+	if mapType.value, err = parseType(value.up); err != nil {
+		return nil, err
+	}
+
+	return mapType, nil
+}
+
 // parseType parse a type definition
 func parseType(node *node32) (Type, error) {
 	var err error
@@ -1531,17 +2441,72 @@ func parseType(node *node32) (Type, error) {
 		if wtype, err = parsePairType(node.up); err != nil {
 			return nil, err
 		}
+	case ruleMAPTYPE:
+		if wtype, err = parseMapType(node.up); err != nil {
+			return nil, err
+		}
 	case rulePAIR: // pair inside a pair, that misses type information
 		return PairType{VoidType{}, VoidType{}}, nil
 	}
 
-	for node = nextNode(node.next, ruleARRAYTYPE); node != nil; node = nextNode(node.next, ruleARRAYTYPE) {
-		wtype = ArrayType{base: wtype}
+	// array and function type suffixes apply from left to right
+	for node = node.next; node != nil; node = node.next {
+		switch node.pegRule {
+		case ruleARRAYTYPE:
+			wtype = ArrayType{base: wtype}
+		case ruleFUNCTYPE:
+			if wtype, err = parseFuncType(node.up, wtype); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return wtype, nil
 }
 
+// parseFuncType parses the parameter types of a function type given the
+// already parsed return type
+func parseFuncType(node *node32, returnType Type) (Type, error) {
+	funcType := FunctionType{returnType: returnType}
+
+	for tnode := nextNode(node, ruleTYPE); tnode != nil; tnode = nextNode(tnode.next, ruleTYPE) {
+		param, err := parseType(tnode.up)
+		if err != nil {
+			return nil, err
+		}
+		funcType.params = append(funcType.params, param)
+	}
+
+	return funcType, nil
+}
+
+// parseTypeArgs parses the type arguments given to a generic class
+func parseTypeArgs(node *node32) ([]Type, error) {
+	var typeArgs []Type
+
+	for tnode := nextNode(node, ruleTYPE); tnode != nil; tnode = nextNode(tnode.next, ruleTYPE) {
+		arg, err := parseType(tnode.up)
+		if err != nil {
+			return nil, err
+		}
+		typeArgs = append(typeArgs, arg)
+	}
+
+	return typeArgs, nil
+}
+
+// parseTypeParams parses the names of the type parameters of a generic
+// function or class
+func parseTypeParams(node *node32) []string {
+	var typeParams []string
+
+	for tnode := nextNode(node, ruleIDENT); tnode != nil; tnode = nextNode(tnode.next, ruleIDENT) {
+		typeParams = append(typeParams, tnode.match)
+	}
+
+	return typeParams
+}
+
 // parseOpOpStat parses the ++, --, ** side effects.
 func parseOpOpStat(node *node32) (RHS, error) {
 	rhs := new(ExpressionRHS)
@@ -1621,13 +2586,32 @@ func parseStatement(node *node32) (Statement, error) {
 	var stm Statement
 	var err error
 
+	// a label names the loop following it
+	var label string
+	if node.pegRule == ruleLABEL {
+		label = nextNode(node.up, ruleIDENT).match
+		node = node.next
+	}
+
 	switch node.pegRule {
 	case ruleSKIP:
 		stm = &SkipStatement{}
 	case ruleCONTINUE:
-		stm = &ContinueStatement{}
+		continues := new(ContinueStatement)
+
+		if identNode := nextNode(node, ruleIDENT); identNode != nil {
+			continues.label = identNode.match
+		}
+
+		stm = continues
 	case ruleBREAK:
-		stm = &BreakStatement{}
+		breaks := new(BreakStatement)
+
+		if identNode := nextNode(node, ruleIDENT); identNode != nil {
+			breaks.label = identNode.match
+		}
+
+		stm = breaks
 	case ruleBEGIN:
 		block := new(BlockStatement)
 
@@ -1637,11 +2621,23 @@ func parseStatement(node *node32) (Statement, error) {
 		}
 
 		stm = block
+	case ruleFUNC:
+		local := new(LocalFuncStatement)
+
+		if local.function, err = parseFunction(node.up); err != nil {
+			return nil, err
+		}
+
+		stm = local
+	case ruleSCOPED:
+		fallthrough
 	case ruleVAR:
 		fallthrough
 	case ruleTYPE:
 		decl := new(DeclareAssignStatement)
 
+		decl.scoped = node.pegRule == ruleSCOPED
+
 		typeNode := nextNode(node, ruleTYPE)
 		if typeNode != nil {
 			if decl.wtype, err = parseType(typeNode.up); err != nil {
@@ -1716,6 +2712,20 @@ func parseStatement(node *node32) (Statement, error) {
 		}
 
 		stm = free
+	case ruleDELETE:
+		del := new(DeleteStatement)
+
+		exprNode := nextNode(node, ruleEXPR)
+		if del.expr, err = parseExpr(exprNode.up); err != nil {
+			return nil, err
+		}
+
+		keyNode := nextNode(exprNode.next, ruleEXPR)
+		if del.key, err = parseExpr(keyNode.up); err != nil {
+			return nil, err
+		}
+
+		stm = del
 	case ruleRETURN:
 		retur := new(ReturnStatement)
 
@@ -1770,19 +2780,10 @@ func parseStatement(node *node32) (Statement, error) {
 		identNode := nextNode(fnode, ruleIDENT)
 		call.ident = identNode.match
 
-		arglistNode := nextNode(fnode, ruleARGLIST)
-		if arglistNode != nil {
-			for argNode := nextNode(arglistNode.up, ruleEXPR); argNode != nil; argNode = nextNode(argNode.next, ruleEXPR) {
-				var err error
-				var expr Expression
-
-				if expr, err = parseExpr(argNode.up); err != nil {
-					return nil, err
-				}
-
-				call.args = append(call.args, expr)
-			}
-		}
+		call.args, call.names, err = parseArgList(nextNode(fnode, ruleARGLIST))
+		if err != nil {
+			return nil, err
+		}
 
 		stm = call
 	case ruleIF:
@@ -1808,6 +2809,7 @@ func parseStatement(node *node32) (Statement, error) {
 		stm = ifs
 	case ruleWHILE:
 		whiles := new(WhileStatement)
+		whiles.label = label
 
 		exprNode := nextNode(node, ruleEXPR)
 		if whiles.cond, err = parseExpr(exprNode.up); err != nil {
@@ -1821,6 +2823,7 @@ func parseStatement(node *node32) (Statement, error) {
 		stm = whiles
 	case ruleDO:
 		whiles := new(DoWhileStatement)
+		whiles.label = label
 
 		bodyNode := nextNode(node, ruleSTAT)
 		if whiles.body, err = parseStatement(bodyNode.up); err != nil {
@@ -1888,7 +2891,37 @@ func parseStatement(node *node32) (Statement, error) {
 
 		stm = switchs
 	case ruleFOR:
+		if nextNode(node, ruleIN) != nil {
+			foreach := new(ForEachStatement)
+			foreach.label = label
+
+			typeNode := nextNode(node, ruleTYPE)
+			if typeNode != nil {
+				if foreach.wtype, err = parseType(typeNode.up); err != nil {
+					return nil, err
+				}
+			}
+
+			foreach.ident = nextNode(node, ruleIDENT).match
+
+			exprNode := nextNode(node, ruleEXPR)
+			if foreach.expr, err = parseExpr(exprNode.up); err != nil {
+				return nil, err
+			}
+
+			bodyNode := nextNode(node, ruleSTAT)
+			if foreach.body, err = parseStatement(bodyNode.up); err != nil {
+				return nil, err
+			}
+
+			node = bodyNode
+
+			stm = foreach
+			break
+		}
+
 		fors := new(ForStatement)
+		fors.label = label
 
 		initNode := nextNode(node, ruleSTAT)
 		if fors.init, err = parseStatement(initNode.up); err != nil {
@@ -1913,6 +2946,31 @@ func parseStatement(node *node32) (Statement, error) {
 		node = bodyNode
 
 		stm = fors
+	case ruleTRY:
+		try := new(TryStatement)
+
+		bodyNode := nextNode(node, ruleSTAT)
+		if try.body, err = parseStatement(bodyNode.up); err != nil {
+			return nil, err
+		}
+
+		try.ident = nextNode(bodyNode, ruleIDENT).match
+
+		catchNode := nextNode(bodyNode.next, ruleSTAT)
+		if try.catchStat, err = parseStatement(catchNode.up); err != nil {
+			return nil, err
+		}
+
+		stm = try
+	case ruleTHROW:
+		throw := new(ThrowStatement)
+
+		exprNode := nextNode(node, ruleEXPR)
+		if throw.expr, err = parseExpr(exprNode.up); err != nil {
+			return nil, err
+		}
+
+		stm = throw
 	default:
 		return nil, fmt.Errorf(
 			"unexpected %s %s",
@@ -1951,13 +3009,26 @@ func parseParam(node *node32) (*FunctionParam, error) {
 		return nil, err
 	}
 
+	// variadic parameters hold the trailing arguments in an array
+	if nextNode(node, ruleELLIPSIS) != nil {
+		param.variadic = true
+		param.wtype = ArrayType{base: param.wtype}
+	}
+
 	param.name = nextNode(node, ruleIDENT).match
 
+	if valueNode := nextNode(node, ruleEXPR); valueNode != nil {
+		param.value, err = parseExpr(valueNode.up)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return param, nil
 }
 
-// parse a function defintion
-func parseFunction(node *node32) (*FunctionDef, error) {
+// parse the return type, name and parameters of a function
+func parseSignature(node *node32) (*FunctionDef, error) {
 	var err error
 	function := &FunctionDef{}
 
@@ -1970,19 +3041,103 @@ func parseFunction(node *node32) (*FunctionDef, error) {
 
 	function.ident = nextNode(node, ruleIDENT).match
 
-	paramListNode := nextNode(node, rulePARAMLIST)
+	if typeParamsNode := nextNode(node, ruleTYPEPARAMS); typeParamsNode != nil {
+		function.typeParams = parseTypeParams(typeParamsNode.up)
+	}
+
+	function.params, err = parseParamList(nextNode(node, rulePARAMLIST))
+	if err != nil {
+		return nil, err
+	}
+
+	return function, nil
+}
+
+// parse the parameter list of a function or lambda
+func parseParamList(node *node32) ([]*FunctionParam, error) {
+	var params []*FunctionParam
+
 	// argument list may be missing with zero arguments
-	if paramListNode != nil {
-		for pnode := range nodeRange(paramListNode.up) {
-			if pnode.pegRule == rulePARAM {
-				var param *FunctionParam
-				param, err = parseParam(pnode.up)
-				if err != nil {
-					return nil, err
-				}
-				function.params = append(function.params, param)
+	if node == nil {
+		return params, nil
+	}
+
+	for pnode := range nodeRange(node.up) {
+		if pnode.pegRule == rulePARAM {
+			param, err := parseParam(pnode.up)
+			if err != nil {
+				return nil, err
 			}
+			params = append(params, param)
+		}
+	}
+
+	return params, nil
+}
+
+// parse the arguments of a call
+// names holds the parameter each argument is given for, which is empty for
+// the positional arguments
+func parseArgList(node *node32) ([]Expression, []string, error) {
+	var args []Expression
+	var names []string
+
+	// argument list may be missing with zero arguments
+	if node == nil {
+		return args, names, nil
+	}
+
+	for anode := range nodeRange(node.up) {
+		name := ""
+		exprNode := anode
+		switch anode.pegRule {
+		case ruleNAMEDARG:
+			name = nextNode(anode.up, ruleIDENT).match
+			exprNode = nextNode(anode.up, ruleEXPR)
+		case ruleEXPR:
+		default:
+			continue
+		}
+
+		expr, err := parseExpr(exprNode.up)
+		if err != nil {
+			return nil, nil, err
 		}
+
+		args = append(args, expr)
+		names = append(names, name)
+	}
+
+	return args, names, nil
+}
+
+// parse an anonymous function expression
+// the return type is only known after the body has been type checked
+func parseLambda(node *node32) (Expression, error) {
+	var err error
+
+	function := &FunctionDef{}
+
+	function.SetToken(&node.token32)
+
+	function.params, err = parseParamList(nextNode(node, rulePARAMLIST))
+	if err != nil {
+		return nil, err
+	}
+
+	function.body, err = parseStatement(nextNode(node, ruleSTAT).up)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Lambda{function: function}, nil
+}
+
+// parse a function defintion
+func parseFunction(node *node32) (*FunctionDef, error) {
+	function, err := parseSignature(node)
+	if err != nil {
+		return nil, err
 	}
 
 	function.body, err = parseStatement(nextNode(node, ruleSTAT).up)
@@ -1990,6 +3145,45 @@ func parseFunction(node *node32) (*FunctionDef, error) {
 		return nil, err
 	}
 
+	// generic functions are parsed again for every instantiation
+	if len(function.typeParams) > 0 {
+		function.node = node
+	}
+
+	return function, nil
+}
+
+// parseOperator parses a method overloading a binary operator. The operator
+// symbol is kept in the identifier of the method
+func parseOperator(node *node32) (*FunctionDef, error) {
+	var err error
+	function := &FunctionDef{}
+
+	function.SetToken(&node.token32)
+
+	function.returnType, err = parseType(nextNode(node, ruleTYPE).up)
+	if err != nil {
+		return nil, err
+	}
+
+	opNode := nextNode(node, ruleBINARYOPER)
+	symbol := opNode.match
+	if spaceNode := nextNode(opNode.up.up, ruleSPACE); spaceNode != nil {
+		symbol = strings.TrimSuffix(symbol, spaceNode.match)
+	}
+	function.ident = fmt.Sprintf("operator%s", symbol)
+
+	param, err := parseParam(nextNode(node, rulePARAM).up)
+	if err != nil {
+		return nil, err
+	}
+	function.params = []*FunctionParam{param}
+
+	function.body, err = parseStatement(nextNode(node, ruleSTAT).up)
+	if err != nil {
+		return nil, err
+	}
+
 	return function, nil
 }
 
@@ -2001,6 +3195,52 @@ func parseInclude(node *node32) string {
 	return file
 }
 
+// parseImport parses the file and alias of an imported module
+func parseImport(node *node32) *Module {
+	module := &Module{}
+	module.SetToken(&node.token32)
+
+	module.file = parseInclude(node)
+	module.alias = nextNode(node, ruleIDENT).match
+
+	return module
+}
+
+// parseGlobal parses a global variable or constant definition
+func parseGlobal(node *node32) (*GlobalDef, error) {
+	var err error
+
+	global := &GlobalDef{}
+	global.SetToken(&node.token32)
+
+	global.constant = nextNode(node, ruleCONST) != nil
+
+	typeNode := nextNode(node, ruleTYPE)
+	if global.wtype, err = parseType(typeNode.up); err != nil {
+		return nil, err
+	}
+
+	global.ident = nextNode(node, ruleIDENT).match
+
+	rhsNode := nextNode(node, ruleASSIGNRHS)
+	if global.rhs, err = parseRHS(rhsNode.up); err != nil {
+		return nil, err
+	}
+
+	return global, nil
+}
+
+// parseExports parses the names of the definitions a module exports
+func parseExports(node *node32) []string {
+	var exports []string
+
+	for identNode := nextNode(node, ruleIDENT); identNode != nil; identNode = nextNode(identNode.next, ruleIDENT) {
+		exports = append(exports, identNode.match)
+	}
+
+	return exports
+}
+
 // autoGenerateGetSet generates getter and setter methods for the class members
 func autoGenerateGetSet(class *ClassType) (*ClassType, error) {
 	for _, member := range class.members {
@@ -2008,6 +3248,7 @@ func autoGenerateGetSet(class *ClassType) (*ClassType, error) {
 			autoGet := &FunctionDef{}
 			autoGet.returnType = member.wtype
 			autoGet.ident = member.ident
+			autoGet.private = member.private
 
 			autoGet.body = &ReturnStatement{
 				expr: &Ident{ident: fmt.Sprintf("@%v", member.ident)},
@@ -2020,6 +3261,7 @@ func autoGenerateGetSet(class *ClassType) (*ClassType, error) {
 			autoSet := &FunctionDef{}
 			autoSet.returnType = VoidType{}
 			autoSet.ident = member.ident
+			autoSet.private = member.private
 
 			param := &FunctionParam{
 				name:  "value",
@@ -2054,6 +3296,7 @@ func parseClassMembers(node *node32) (*ClassMember, error) {
 
 	member.SetToken(&node.token32)
 	member.ident = nextNode(node, ruleIDENT).match
+	member.private = nextNode(node, rulePRIVATE) != nil
 
 	member.wtype, err = parseType(nextNode(node, ruleTYPE).up)
 	if err != nil {
@@ -2089,26 +3332,77 @@ func parseClass(node *node32) (*ClassType, error) {
 
 	class.SetToken(&node.token32)
 
+	extends := false
+	implements := false
+	static := false
+	private := false
+
 	for node := range nodeRange(node) {
 		switch node.pegRule {
 		case ruleCLASS:
 		case ruleIS:
 		case ruleSPACE:
 		case ruleEND:
+		case ruleCOMMA:
+		case ruleEXTENDS:
+			extends = true
+		case ruleIMPLEMENTS:
+			implements = true
+		case ruleTYPEPARAMS:
+			class.typeParams = parseTypeParams(node.up)
+		case ruleTYPEARGS:
+			var err error
+			if class.parent.typeArgs, err = parseTypeArgs(node.up); err != nil {
+				return nil, err
+			}
 		case ruleIDENT:
-			class.name = node.match
+			switch {
+			case implements:
+				class.interfaces = append(class.interfaces,
+					&InterfaceType{ident: node.match})
+			case extends:
+				class.parent = &ClassType{name: node.match}
+				extends = false
+			default:
+				class.name = node.match
+			}
 		case ruleMEMBERDEF:
 			m, err := parseClassMembers(node.up)
 			class.members = append(class.members, m)
 			if err != nil {
 				return nil, err
 			}
+		case ruleSTATIC:
+			static = true
+		case rulePRIVATE:
+			private = true
+		case rulePUBLIC:
+		case ruleSTATICDEF:
+			g, err := parseGlobal(node.up)
+			if err != nil {
+				return nil, err
+			}
+			g.ident = fmt.Sprintf("%v::%v", class.name, g.ident)
+			g.static = true
+			class.statics = append(class.statics, g)
 		case ruleFUNC:
 			f, err := parseFunction(node.up)
 			class.methods = append(class.methods, f)
 			if err != nil {
 				return nil, err
 			}
+			f.static = static
+			f.private = private
+			static = false
+			private = false
+		case ruleOPERATORDEF:
+			f, err := parseOperator(node.up)
+			class.methods = append(class.methods, f)
+			if err != nil {
+				return nil, err
+			}
+			f.private = private
+			private = false
 		default:
 			return nil, fmt.Errorf(
 				"Unexpected %s %s",
@@ -2123,9 +3417,46 @@ func parseClass(node *node32) (*ClassType, error) {
 		return nil, err
 	}
 
+	// generic classes are parsed again for every instantiation
+	if len(class.typeParams) > 0 {
+		class.node = node
+	}
+
 	return class, nil
 }
 
+// parseInterface parses the method signatures declared in an interface
+func parseInterface(node *node32) (*InterfaceType, error) {
+	iface := &InterfaceType{}
+
+	iface.SetToken(&node.token32)
+
+	for node := range nodeRange(node) {
+		switch node.pegRule {
+		case ruleINTERFACE:
+		case ruleIS:
+		case ruleSPACE:
+		case ruleEND:
+		case ruleIDENT:
+			iface.ident = node.match
+		case ruleSIGNATURE:
+			f, err := parseSignature(node.up)
+			if err != nil {
+				return nil, err
+			}
+			iface.methods = append(iface.methods, f)
+		default:
+			return nil, fmt.Errorf(
+				"Unexpected %s %s",
+				node.String(),
+				node.match,
+			)
+		}
+	}
+
+	return iface, nil
+}
+
 func parseEnum(node *node32) (*EnumType, error) {
 	enum := &EnumType{}
 
@@ -2181,6 +3512,20 @@ func parseWACC(node *node32, ifm *IncludeFiles) (*AST, error) {
 			}
 
 			ast.enums = append(ast.enums, e)
+		case ruleGLOBALDEF:
+			g, err := parseGlobal(node.up)
+			if err != nil {
+				return nil, err
+			}
+
+			ast.globals = append(ast.globals, g)
+		case ruleINTERFACEDEF:
+			i, err := parseInterface(node.up)
+			if err != nil {
+				return nil, err
+			}
+
+			ast.interfaces = append(ast.interfaces, i)
 		case ruleCLASSDEF:
 			c, err := parseClass(node.up)
 			if err != nil {
@@ -2188,9 +3533,16 @@ func parseWACC(node *node32, ifm *IncludeFiles) (*AST, error) {
 			}
 
 			ast.classes = append(ast.classes, c)
+			ast.globals = append(ast.globals, c.statics...)
 		case ruleINCL:
+			if nextNode(node.up, ruleIMPORT) != nil {
+				ast.modules = append(ast.modules, parseImport(node.up))
+				break
+			}
 			i := parseInclude(node.up)
 			ast.includes = append(ast.includes, i)
+		case ruleEXPORTLIST:
+			ast.exports = parseExports(node.up)
 		case ruleFUNC:
 			f, err := parseFunction(node.up)
 			if err != nil {
@@ -2214,15 +3566,17 @@ func parseWACC(node *node32, ifm *IncludeFiles) (*AST, error) {
 	}
 
 	appendIncludedFiles(ast, ifm)
+	appendImportedModules(ast, ifm)
 
 	return ast, nil
 }
 
-// IncludeFiles holds the included files
+// IncludeFiles holds the included files and the modules being imported
 type IncludeFiles struct {
 	sync.RWMutex
-	files map[string]bool
-	dir   string
+	files   map[string]bool
+	modules map[string]string
+	dir     string
 }
 
 // Include will add the files to the map
@@ -2237,9 +3591,31 @@ func (m *IncludeFiles) Include(file string) {
 	m.files[file] = true
 }
 
+// Module registers the file of a module under the identifier of the module.
+// It returns the file registered first under the identifier and whether the
+// module was registered before
+func (m *IncludeFiles) Module(ident, file string) (string, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.modules == nil {
+		m.modules = make(map[string]string)
+	}
+
+	if f, ok := m.modules[ident]; ok {
+		return f, true
+	}
+
+	m.modules[ident] = file
+
+	return file, false
+}
+
 // appendIncludedFiles appends all the functions in the included files to base
 // wacc file. It discards the main function of the included file.
 func appendIncludedFiles(ast *AST, ifm *IncludeFiles) {
+	var globals []*GlobalDef
+
 	for _, include := range ast.includes {
 		absoluteFile := fmt.Sprintf("%v/%v", ifm.dir,
 			include)
@@ -2257,12 +3633,201 @@ func appendIncludedFiles(ast *AST, ifm *IncludeFiles) {
 		ast.enums = append(ast.enums,
 			astIncl.enums...)
 
+		ast.interfaces = append(ast.interfaces,
+			astIncl.interfaces...)
+
 		ast.classes = append(ast.classes,
 			astIncl.classes...)
 
 		ast.functions = append(ast.functions,
 			astIncl.functions...)
+
+		globals = append(globals,
+			astIncl.globals...)
 	}
+
+	// the globals of the included files are initialised first
+	ast.globals = append(globals, ast.globals...)
+}
+
+// appendImportedModules appends the definitions of the imported modules to the
+// base wacc file prefixed by the identifier of their module. Each module is
+// only appended once, by the first file importing it, so that all the files
+// importing it share its definitions. The main function of the module is
+// discarded. Aliases used twice and modules whose identifiers clash are
+// reported when type checking
+func appendImportedModules(ast *AST, ifm *IncludeFiles) {
+	var invalid []*Module
+	var globals []*GlobalDef
+
+	aliases := make(map[string]string)
+
+	for _, module := range ast.modules {
+		absoluteFile := filepath.Clean(fmt.Sprintf("%v/%v", ifm.dir,
+			module.file))
+
+		module.ident = moduleIdent(module.file)
+
+		if _, ok := aliases[module.alias]; ok {
+			module.redeclared = true
+			continue
+		}
+
+		file, loaded := ifm.Module(module.ident, absoluteFile)
+		if file != absoluteFile {
+			module.clash = true
+			continue
+		}
+
+		aliases[module.alias] = module.ident
+
+		if loaded {
+			continue
+		}
+
+		waccImport := parseInput(absoluteFile)
+		astImport := generateASTFromWACC(waccImport, ifm)
+
+		astImport.qualify(module.ident)
+
+		ast.enums = append(ast.enums,
+			astImport.enums...)
+
+		ast.interfaces = append(ast.interfaces,
+			astImport.interfaces...)
+
+		ast.classes = append(ast.classes,
+			astImport.classes...)
+
+		ast.functions = append(ast.functions,
+			astImport.functions...)
+
+		globals = append(globals,
+			astImport.globals...)
+
+		if ast.aliases == nil {
+			ast.aliases = make(map[string]map[string]string)
+		}
+		for m, a := range astImport.aliases {
+			ast.aliases[m] = a
+		}
+
+		if ast.hidden == nil {
+			ast.hidden = make(map[string]string)
+		}
+		for ident, m := range astImport.hidden {
+			ast.hidden[ident] = m
+		}
+
+		for _, m := range astImport.modules {
+			if m.clash || m.redeclared {
+				invalid = append(invalid, m)
+			}
+		}
+	}
+
+	if len(aliases) > 0 {
+		if ast.aliases == nil {
+			ast.aliases = make(map[string]map[string]string)
+		}
+		ast.aliases[""] = aliases
+	}
+
+	ast.modules = append(ast.modules, invalid...)
+
+	// the globals of the modules are initialised first
+	ast.globals = append(globals, ast.globals...)
+}
+
+// qualify prefixes the top level definitions of a module with the identifier
+// of the module. Definitions missing from the export list of the module are
+// hidden to the files importing it. Definitions of the modules it imports
+// have been prefixed already
+func (m *AST) qualify(module string) {
+	if m.hidden == nil {
+		m.hidden = make(map[string]string)
+	}
+
+	exported := func(ident string) bool {
+		if m.exports == nil {
+			return true
+		}
+		// static fields are exported with their class
+		if i := strings.Index(ident, "::"); i >= 0 {
+			ident = ident[:i]
+		}
+		for _, export := range m.exports {
+			if export == ident {
+				return true
+			}
+		}
+		return false
+	}
+
+	rename := func(ident string) string {
+		if strings.Contains(ident, ".") {
+			return ident
+		}
+
+		qualified := fmt.Sprintf("%v.%v", module, ident)
+		if !exported(ident) {
+			m.hidden[qualified] = module
+		}
+
+		return qualified
+	}
+
+	for _, e := range m.enums {
+		e.ident = rename(e.ident)
+	}
+
+	for _, i := range m.interfaces {
+		i.ident = rename(i.ident)
+	}
+
+	for _, c := range m.classes {
+		c.name = rename(c.name)
+	}
+
+	for _, f := range m.functions {
+		f.ident = rename(f.ident)
+	}
+
+	for _, g := range m.globals {
+		g.ident = rename(g.ident)
+	}
+
+	// the aliases of the module are used in its definitions
+	if aliases, ok := m.aliases[""]; ok {
+		delete(m.aliases, "")
+		m.aliases[module] = aliases
+	}
+}
+
+// moduleIdent returns the identifier of the module in a file, the path of the
+// file without extension and with the characters that cannot be in a label
+// replaced
+func moduleIdent(file string) string {
+	file = strings.TrimSuffix(filepath.Clean(file), filepath.Ext(file))
+
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, file)
+}
+
+// moduleOf returns the identifier of the module a top level definition was
+// imported from, empty for the definitions of the base wacc file
+func moduleOf(ident string) string {
+	if i := strings.LastIndex(ident, "."); i >= 0 {
+		return ident[:i]
+	}
+
+	return ""
 }
 
 // ParseAST given a syntax tree generated by the Peg library returns the
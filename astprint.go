@@ -104,14 +104,18 @@ func (p PairType) aststring(indent string) string {
 }
 
 // Prints a DECLARE statement. Format:
-// - DECLARE
+// - DECLARE / SCOPED DECLARE
 //   - LHS
 //     - [lhsEXPR]
 //   - RHS
 //     - [rhsEXPR]
 // REcurses on lhsEXPR and rhsEXPR.
 func (stmt DeclareAssignStatement) aststring(indent string) string {
-	declareStats := fmt.Sprintf("%vDECLARE\n", addMinToIndent(indent))
+	kind := "DECLARE"
+	if stmt.scoped {
+		kind = "SCOPED DECLARE"
+	}
+	declareStats := fmt.Sprintf("%v%v\n", addMinToIndent(indent), kind)
 	innerIndent := getGreaterIndent(indent)
 	lhsIndent := addDoubleIndent(innerIndent, "LHS", stmt.ident)
 	rhsIndent := addIndentForFirst(
@@ -129,6 +133,40 @@ func (stmt DeclareAssignStatement) aststring(indent string) string {
 	)
 }
 
+// Prints a global or constant definition. Format:
+// - GLOBAL / CONST / STATIC
+//   - [type]
+//   - LHS
+//     - [ident]
+//   - RHS
+//     - [rhs]
+// Recurses on type and rhs.
+func (m GlobalDef) aststring(indent string) string {
+	kind := "GLOBAL"
+	switch {
+	case m.constant:
+		kind = "CONST"
+	case m.static:
+		kind = "STATIC"
+	}
+	globalStats := fmt.Sprintf("%v%v\n", addMinToIndent(indent), kind)
+	innerIndent := getGreaterIndent(indent)
+	lhsIndent := addDoubleIndent(innerIndent, "LHS", m.ident)
+	rhsIndent := addIndentForFirst(
+		innerIndent,
+		"RHS",
+		m.rhs.aststring(getGreaterIndent(innerIndent)),
+	)
+
+	return fmt.Sprintf(
+		"%v%v%v%v",
+		globalStats,
+		m.wtype.aststring(innerIndent),
+		lhsIndent,
+		rhsIndent,
+	)
+}
+
 // Prints the LHS of a PairElem
 func (lhs PairElemLHS) aststring(indent string) string {
 	if lhs.snd {
@@ -196,6 +234,29 @@ func (rhs ArrayLiterRHS) aststring(indent string) string {
 	return addArrayIndent(indent, "ARRAY LITERAL", elemArr)
 }
 
+// Introduces a Map Literal. Recurses on the keys and values of rhs and prints.
+func (rhs MapLiterRHS) aststring(indent string) string {
+	entryArr := []string{}
+
+	nextIndent := getGreaterIndent(indent)
+
+	for i, key := range rhs.keys {
+		keyStats := addIndentForFirst(
+			nextIndent,
+			"KEY",
+			key.aststring(getGreaterIndent(nextIndent)),
+		)
+		valueStats := addIndentForFirst(
+			nextIndent,
+			"VALUE",
+			rhs.values[i].aststring(getGreaterIndent(nextIndent)),
+		)
+		entryArr = append(entryArr, keyStats, valueStats)
+	}
+
+	return addArrayIndent(indent, "MAP LITERAL", entryArr)
+}
+
 // Prints the RHS of a PairElem
 func (rhs PairElemRHS) aststring(indent string) string {
 	if rhs.snd {
@@ -500,8 +561,64 @@ func (stmt ForStatement) aststring(indent string) string {
 		condStats, afterStats, doStats)
 }
 
+// Prints a ForEachStatement. Format:
+// - FOREACH LOOP
+//   - DECLARE
+//     - [ident]
+//   - ARRAY
+//     - [expr]
+//   - DO
+//     - [doSTAT]
+// doSTAT is recursed upon
+func (stmt ForEachStatement) aststring(indent string) string {
+	var body string
+	innerIndent := getGreaterIndent(indent)
+
+	declStats := addDoubleIndent(innerIndent, "DECLARE", stmt.ident)
+
+	arrayStats := addIndentForFirst(
+		innerIndent,
+		"ARRAY",
+		stmt.expr.aststring(getGreaterIndent(innerIndent)),
+	)
+
+	doStats := addIndAndNewLine(innerIndent, "DO")
+
+	st := stmt.body
+	for st.GetNext() != nil {
+		body = st.aststring(getGreaterIndent(innerIndent))
+		doStats = fmt.Sprintf("%v%v", doStats, body)
+		st = st.GetNext()
+	}
+	body = st.aststring(getGreaterIndent(innerIndent))
+	doStats = fmt.Sprintf("%v%v", doStats, body)
+
+	loopStats := addIndAndNewLine(indent, "FOREACH LOOP")
+
+	return fmt.Sprintf("%v%v%v%v", loopStats, declStats, arrayStats, doStats)
+}
+
+// Prints a LocalFuncStatement. Format:
+// - LOCAL FUNCTION
+//   - [function]
+// function is recursed upon
+func (stmt LocalFuncStatement) aststring(indent string) string {
+	localStats := addIndAndNewLine(indent, "LOCAL FUNCTION")
+
+	return fmt.Sprintf("%v%v", localStats,
+		stmt.function.aststring(getGreaterIndent(indent)))
+}
+
 // Prints FunctionParameters in function declaration.
 func (fp FunctionParam) aststring(indent string) string {
+	if fp.variadic {
+		return fmt.Sprintf("%v... %v", fp.wtype.(ArrayType).base, fp.name)
+	}
+
+	if fp.value != nil {
+		return fmt.Sprintf("%v %v = %v", fp.wtype, fp.name, fp.value)
+	}
+
 	return fmt.Sprintf("%v %v", fp.wtype, fp.name)
 }
 
@@ -522,12 +639,23 @@ func (fd FunctionDef) aststring(indent string) string {
 		}
 	}
 
+	// methods are prefixed by their visibility
+	var modifiers string
+	if fd.class != nil {
+		modifiers = fmt.Sprintf("%v ", visibility(fd.private))
+	}
+	if fd.static {
+		modifiers = fmt.Sprintf("%vstatic ", modifiers)
+	}
+
 	declaration :=
 		addIndAndNewLine(indent,
 			fmt.Sprintf(
-				"%v %v(%v)",
+				"%v%v %v%v(%v)",
+				modifiers,
 				fd.returnType,
 				fd.ident,
+				typeParamsString(fd.typeParams),
 				params))
 
 	st := fd.body
@@ -568,6 +696,20 @@ func (a ArrayType) aststring(indent string) string {
 	return addType(indent, typeStats)
 }
 
+// Prints a map Type. Format:
+// - TYPE
+//   - map([key], [value])
+func (m MapType) aststring(indent string) string {
+	return addType(indent, m.String())
+}
+
+// Prints a function Type. Format:
+// - TYPE
+//   - [ret]([params])
+func (m FunctionType) aststring(indent string) string {
+	return addType(indent, m.String())
+}
+
 // Prints and invalid Type. Format:
 // - TYPE
 //   - <invalid>
@@ -589,6 +731,20 @@ func (i IntType) aststring(indent string) string {
 	return addType(indent, "int")
 }
 
+// Prints a long Type. Format:
+// - TYPE
+//   - long
+func (l LongType) aststring(indent string) string {
+	return addType(indent, "long")
+}
+
+// Prints a float Type. Format:
+// - TYPE
+//   - float
+func (f FloatType) aststring(indent string) string {
+	return addType(indent, "float")
+}
+
 // Prints and bool Type. Format:
 // - TYPE
 //   - bool
@@ -611,12 +767,52 @@ func (c CharType) aststring(indent string) string {
 	return addType(indent, "char")
 }
 
+// Prints interface Type. Format:
+// - TYPE
+//   - interface [name]
+func (m InterfaceType) aststring(indent string) string {
+	return addType(indent, fmt.Sprintf("interface %v", m.ident))
+}
+
 // Prints class Type. Format:
 // TODO
 func (m ClassType) aststring(indent string) string {
 	return "" // TODO
 }
 
+// visibility returns the modifier a class member or method is declared with
+func visibility(private bool) string {
+	if private {
+		return "private"
+	}
+	return "public"
+}
+
+// Prints a class definition. Format:
+// - class [name]
+//   - [visibility] [type] [member]
+//   - [visibility] [methods]
+// Recurses on the methods.
+func (m ClassType) defstring(indent string) string {
+	tree := addIndAndNewLine(indent, fmt.Sprintf("class %v", m.name))
+	innerIndent := getGreaterIndent(indent)
+
+	for _, member := range m.members {
+		tree = fmt.Sprintf(
+			"%v%v",
+			tree,
+			addIndAndNewLine(innerIndent, fmt.Sprintf("%v %v %v",
+				visibility(member.private), member.wtype, member.ident)),
+		)
+	}
+
+	for _, method := range m.methods {
+		tree = fmt.Sprintf("%v%v", tree, method.aststring(innerIndent))
+	}
+
+	return tree
+}
+
 // Prints a SKIP statement. Format:
 // - SKIP
 func (stmt SkipStatement) aststring(indent string) string {
@@ -625,12 +821,19 @@ func (stmt SkipStatement) aststring(indent string) string {
 
 // Prints a CONTINUE statement, Format:
 // - CONTINUE
+//   - [label]
 func (stat ContinueStatement) aststring(indent string) string {
-	return addIndAndNewLine(indent, "CONTINUE")
+	if stat.label == "" {
+		return addIndAndNewLine(indent, "CONTINUE")
+	}
+	return addDoubleIndent(indent, "CONTINUE", stat.label)
 }
 
 func (stat BreakStatement) aststring(indent string) string {
-	return addIndAndNewLine(indent, "BREAK")
+	if stat.label == "" {
+		return addIndAndNewLine(indent, "BREAK")
+	}
+	return addDoubleIndent(indent, "BREAK", stat.label)
 }
 
 // Prints a useless BlockStatement.
@@ -682,6 +885,20 @@ func (stmt FreeStatement) aststring(indent string) string {
 	)
 }
 
+// Prints a DELETE statement. Format:
+// - DELETE
+//   - [map]
+//   - [key]
+// Recurses on map and key.
+func (stmt DeleteStatement) aststring(indent string) string {
+	return addTripleIndentOnlyFst(
+		indent,
+		"DELETE",
+		stmt.expr.aststring(getGreaterIndent(indent)),
+		stmt.key.aststring(getGreaterIndent(indent)),
+	)
+}
+
 // Prints a RETURN statement. Format:
 // - RETURN
 //   - [args]
@@ -706,6 +923,43 @@ func (stmt ExitStatement) aststring(indent string) string {
 	)
 }
 
+// Prints a THROW statement. Format:
+// - THROW
+//   - [args]
+// Recurses on args.
+func (stmt ThrowStatement) aststring(indent string) string {
+	return addIndentForFirst(
+		indent,
+		"THROW",
+		stmt.expr.aststring(getGreaterIndent(indent)),
+	)
+}
+
+// Prints a TRY statement. Format:
+// - TRY
+//   - [STAT]
+// - CATCH
+//   - [ident]
+//   - [STAT]
+// STAT is recursed upon
+func (stmt TryStatement) aststring(indent string) string {
+	tryStats := addIndAndNewLine(indent, "TRY")
+	for st := stmt.body; st != nil; st = st.GetNext() {
+		tryStats = fmt.Sprintf("%v%v", tryStats,
+			st.aststring(getGreaterIndent(indent)))
+	}
+
+	catchStats := addIndAndNewLine(indent, "CATCH")
+	catchStats = fmt.Sprintf("%v%v", catchStats,
+		addIndAndNewLine(getGreaterIndent(indent), stmt.ident))
+	for st := stmt.catchStat; st != nil; st = st.GetNext() {
+		catchStats = fmt.Sprintf("%v%v", catchStats,
+			st.aststring(getGreaterIndent(indent)))
+	}
+
+	return fmt.Sprintf("%v%v", tryStats, catchStats)
+}
+
 // Prints a PRINTLN statement. Format:
 // - PRINTLN
 //   - [args]
@@ -735,11 +989,33 @@ func (ident Ident) aststring(indent string) string {
 	return addIndAndNewLine(indent, ident.ident)
 }
 
+// Prints a LAMBDA expression. Format:
+// - LAMBDA
+//   - [function]
+// Recurses on function.
+func (m Lambda) aststring(indent string) string {
+	return addIndentForFirst(
+		indent,
+		"LAMBDA",
+		m.function.aststring(getGreaterIndent(indent)),
+	)
+}
+
 // Prints a literal on a new line.
 func (liter IntLiteral) aststring(indent string) string {
 	return addIndAndNewLine(indent, strconv.Itoa(liter.value))
 }
 
+// Prints a long literal on a new line.
+func (liter LongLiteral) aststring(indent string) string {
+	return addIndAndNewLine(indent, strconv.FormatInt(liter.value, 10))
+}
+
+// Prints a float literal on a new line.
+func (liter FloatLiteral) aststring(indent string) string {
+	return addIndAndNewLine(indent, formatFloat(liter.value))
+}
+
 // Prints bool literal "true" on a new line.
 func (liter EnumLiteral) aststring(indent string) string {
 	tmp := fmt.Sprintf("%v->%v", liter.ident, liter.value)
@@ -802,6 +1078,18 @@ func (op UnaryOperatorNegate) aststring(indent string) string {
 	)
 }
 
+// Prints a ~ unaryOperator. Format:
+// - ~
+//   - [args]
+// Recurses on args.
+func (op UnaryOperatorBitNot) aststring(indent string) string {
+	return addIndentForFirst(
+		indent,
+		"~",
+		op.GetExpression().aststring(getGreaterIndent(indent)),
+	)
+}
+
 // Prints a len unaryOperator. Format:
 // - len
 //   - [args]
@@ -838,6 +1126,42 @@ func (op UnaryOperatorChr) aststring(indent string) string {
 	)
 }
 
+// Prints a long unaryOperator. Format:
+// - long
+//   - [args]
+// Recurses on args.
+func (op UnaryOperatorLong) aststring(indent string) string {
+	return addIndentForFirst(
+		indent,
+		"long",
+		op.GetExpression().aststring(getGreaterIndent(indent)),
+	)
+}
+
+// Prints an int unaryOperator. Format:
+// - int
+//   - [args]
+// Recurses on args.
+func (op UnaryOperatorInt) aststring(indent string) string {
+	return addIndentForFirst(
+		indent,
+		"int",
+		op.GetExpression().aststring(getGreaterIndent(indent)),
+	)
+}
+
+// Prints a float unaryOperator. Format:
+// - float
+//   - [args]
+// Recurses on args.
+func (op UnaryOperatorFloat) aststring(indent string) string {
+	return addIndentForFirst(
+		indent,
+		"float",
+		op.GetExpression().aststring(getGreaterIndent(indent)),
+	)
+}
+
 // Prints a * binaryOperator. Format:
 // - *
 //   - [arg1]
@@ -1048,19 +1372,125 @@ func (op BinaryOperatorBitOr) aststring(indent string) string {
 	)
 }
 
+// Prints the map key check. Format:
+// - HAS
+//   - [map]
+//   - [key]
+// Recurses on map and key.
+func (op MapHas) aststring(indent string) string {
+	return addTripleIndentOnlyFst(
+		indent,
+		"HAS",
+		op.expr.aststring(getGreaterIndent(indent)),
+		op.key.aststring(getGreaterIndent(indent)),
+	)
+}
+
+// Prints the conditional operator. Format:
+// - ?:
+//   - [cond]
+//   - [then]
+//   - [else]
+// Recurses on cond, then and else.
+func (op TernaryExpr) aststring(indent string) string {
+	return addArrayIndent(
+		indent,
+		"?:",
+		[]string{
+			op.cond.aststring(getGreaterIndent(indent)),
+			op.then.aststring(getGreaterIndent(indent)),
+			op.elseExpr.aststring(getGreaterIndent(indent)),
+		},
+	)
+}
+
+// Prints a ^ binaryOperator. Format:
+// - ^
+//   - [arg1]
+//   - [arg2]
+// Recurses on arg1 and arg2.
+func (op BinaryOperatorBitXor) aststring(indent string) string {
+	return addTripleIndentOnlyFst(
+		indent,
+		"^",
+		op.GetLHS().aststring(getGreaterIndent(indent)),
+		op.GetRHS().aststring(getGreaterIndent(indent)),
+	)
+}
+
+// Prints a << binaryOperator. Format:
+// - <<
+//   - [arg1]
+//   - [arg2]
+// Recurses on arg1 and arg2.
+func (op BinaryOperatorShiftLeft) aststring(indent string) string {
+	return addTripleIndentOnlyFst(
+		indent,
+		"<<",
+		op.GetLHS().aststring(getGreaterIndent(indent)),
+		op.GetRHS().aststring(getGreaterIndent(indent)),
+	)
+}
+
+// Prints a >> binaryOperator. Format:
+// - >>
+//   - [arg1]
+//   - [arg2]
+// Recurses on arg1 and arg2.
+func (op BinaryOperatorShiftRight) aststring(indent string) string {
+	return addTripleIndentOnlyFst(
+		indent,
+		">>",
+		op.GetLHS().aststring(getGreaterIndent(indent)),
+		op.GetRHS().aststring(getGreaterIndent(indent)),
+	)
+}
+
+// Prints a >>> binaryOperator. Format:
+// - >>>
+//   - [arg1]
+//   - [arg2]
+// Recurses on arg1 and arg2.
+func (op BinaryOperatorShiftRightLogical) aststring(indent string) string {
+	return addTripleIndentOnlyFst(
+		indent,
+		">>>",
+		op.GetLHS().aststring(getGreaterIndent(indent)),
+		op.GetRHS().aststring(getGreaterIndent(indent)),
+	)
+}
+
 //------------------------------------------------------------------------------
 
 // Main method. Format:
+// - [globals]
+// - [classes]
 // - [functions]
 // - int main()
 //   - [main]
-// Recurses on functions and main
+// Recurses on globals, classes, functions and main
 func (ast AST) aststring() string {
 	var tree string
 	var tmpIndent string
 
 	tree = addIndAndNewLine("", "Program")
 
+	for _, global := range ast.globals {
+		tree = fmt.Sprintf(
+			"%v%v",
+			tree,
+			global.aststring(basicIndent),
+		)
+	}
+
+	for _, class := range ast.classes {
+		tree = fmt.Sprintf(
+			"%v%v",
+			tree,
+			class.defstring(basicIndent),
+		)
+	}
+
 	for _, function := range ast.functions {
 		tree = fmt.Sprintf(
 			"%v%v",
@@ -9,6 +9,8 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"strings"
 	"sync"
 )
 
@@ -20,6 +22,8 @@ import (
 const (
 	mPrintString          = "%.*s\\0"
 	mPrintInt             = "%d\\0"
+	mPrintLong            = "%lld\\0"
+	mPrintFloat           = "%f\\0"
 	mReadChar             = " %c\\0"
 	mPrintReference       = "%p\\0"
 	mNullChar             = "\\0"
@@ -34,6 +38,8 @@ const (
 	mFreeLabel            = "free"
 	mPrintNewLineLabel    = "p_print_ln"
 	mPrintIntLabel        = "p_print_int"
+	mPrintLongLabel       = "p_print_long"
+	mPrintFloatLabel      = "p_print_float"
 	mPrintStringLabel     = "p_print_string"
 	mPrintStringLoopLabel = "p_print_string_loop"
 	mPrintStringEndLabel  = "p_print_string_return"
@@ -42,20 +48,80 @@ const (
 	mPrintReferenceLabel  = "p_print_reference"
 	mReadIntLabel         = "p_read_int"
 	mReadCharLabel        = "p_read_char"
+	mReadFloatLabel       = "p_read_float"
 	mExitLabel            = "exit"
 	mMalloc               = "malloc"
 	mThrowRuntimeErr      = "p_throw_runtime_error"
 	mDivideByZeroLbl      = "p_check_divide_by_zero"
 	mNullReferenceLbl     = "p_check_null_pointer"
 	mOverflowLbl          = "p_throw_overflow_error"
+	mLongOverflowLbl      = "p_throw_long_overflow_error"
 	mArrayBoundLbl        = "p_check_array_bounds"
+	mInterfaceTableLbl    = "p_find_interface_table"
+	mInterfaceTableLoop   = "p_find_interface_table_loop"
+	mInterfaceTableEnd    = "p_find_interface_table_return"
+	mThrowExceptionLbl    = "p_throw_exception"
+	mExceptionHandler     = "p_exception_handler"
+	mRetainLbl            = "p_rc_retain"
+	mReleaseLbl           = "p_rc_release"
+	mDisownLbl            = "p_rc_disown"
+	mReleaseFstLbl        = "p_rc_release_fst"
+	mReleaseSndLbl        = "p_rc_release_snd"
+	mReleaseSndWideLbl    = "p_rc_release_snd_wide"
+	mReleasePairLbl       = "p_rc_release_pair"
+	mReleaseElemsLbl      = "p_rc_release_elems"
+	mReleaseElemsLoop     = "p_rc_release_elems_loop"
+	mReleaseElemsEnd      = "p_rc_release_elems_return"
+	mReleaseMapLbl        = "p_rc_release_map"
+	mReleaseMapBucket     = "p_rc_release_map_bucket"
+	mReleaseMapNode       = "p_rc_release_map_node"
+	mReleaseMapEnd        = "p_rc_release_map_return"
+	mMapInitLbl           = "p_map_init"
+	mMapBucketsLbl        = "p_map_buckets"
+	mMapBucketsLoop       = "p_map_buckets_loop"
+	mMapBucketsEnd        = "p_map_buckets_return"
+	mMapBucketLbl         = "p_map_bucket"
+	mMapBucketLoop        = "p_map_bucket_loop"
+	mMapBucketMix         = "p_map_bucket_mix"
+	mMapStrEqualLbl       = "p_map_string_equal"
+	mMapStrEqualLoop      = "p_map_string_equal_loop"
+	mMapStrEqualEnd       = "p_map_string_equal_return"
+	mMapFindLbl           = "p_map_find"
+	mMapFindLoop          = "p_map_find_loop"
+	mMapFindNext          = "p_map_find_next"
+	mMapFindEnd           = "p_map_find_return"
+	mMapLookupLbl         = "p_map_lookup"
+	mMapHasLbl            = "p_map_has"
+	mMapInsertLbl         = "p_map_insert"
+	mMapInsertEnd         = "p_map_insert_return"
+	mMapGrowLbl           = "p_map_grow"
+	mMapGrowBucket        = "p_map_grow_bucket"
+	mMapGrowNode          = "p_map_grow_node"
+	mMapGrowEnd           = "p_map_grow_return"
+	mMapDeleteLbl         = "p_map_delete"
+	mMapDeleteEnd         = "p_map_delete_return"
+	mLongBoxLbl           = "p_long_box"
+	mLongMulLbl           = "p_long_multiply"
+	mLongMulRHS           = "p_long_multiply_rhs"
+	mLongMulProduct       = "p_long_multiply_product"
+	mLongMulPositive      = "p_long_multiply_positive"
+	mLongMulEnd           = "p_long_multiply_return"
+	mLongDivLbl           = "p_long_divide"
+	mLongModLbl           = "p_long_modulo"
+	mLongCmpLbl           = "p_long_compare"
 	mDivideByZeroErr      = "DivideByZeroError: divide or modulo by zero\\n\\0"
+	mUncaughtExceptionErr = "UncaughtExceptionError: uncaught exception\\n\\0"
 	mNullReferenceErr     = "NullReferenceError: dereference a null reference" +
 		"\\n\\0"
 	mArrayNegIndexErr = "ArrayIndexOutOfBoundsError: negative index\\n\\0"
 	mArrayLrgIndexErr = "ArrayIndexOutOfBoundsError: index too large\\n\\0"
+	mKeyNotFoundErr   = "KeyNotFoundError: the key is not in the map\\n\\0"
 	mOverflowErr      = "OverflowError: the result is too small/large to " +
 		"store in a 4-byte signed-integer.\\n\\0"
+	mLongOverflowErr = "OverflowError: the result is too small/large to " +
+		"store in an 8-byte signed-integer.\\n\\0"
+	mMissingInterfaceErr = "MissingInterfaceError: the object does not " +
+		"implement the interface\\n\\0"
 )
 
 //------------------------------------------------------------------------------
@@ -111,6 +177,24 @@ func (m *ARMNamedReg) Reg() int {
 	return m.r
 }
 
+// VFPReg is a single or double precision register of the floating point unit
+type VFPReg struct {
+	r      int
+	double bool
+}
+
+func (m *VFPReg) String() string {
+	if m.double {
+		return fmt.Sprintf("d%d", m.r)
+	}
+	return fmt.Sprintf("s%d", m.r)
+}
+
+// Reg returns the register number
+func (m *VFPReg) Reg() int {
+	return m.r
+}
+
 // registers that can be used
 var r0 = &ARMGenReg{r: 0}
 var r1 = &ARMGenReg{r: 1}
@@ -129,6 +213,12 @@ var sp = &ARMNamedReg{name: "sp", r: 13}
 var lr = &ARMNamedReg{name: "lr", r: 14}
 var pc = &ARMNamedReg{name: "pc", r: 15}
 
+// floating point registers, they only hold values within an instruction
+// sequence so they are never allocated
+var s0 = &VFPReg{r: 0}
+var s1 = &VFPReg{r: 1}
+var d0 = &VFPReg{r: 0, double: true}
+
 var argRegs = []Reg{r0, r1, r2, r3}
 var resReg = r0
 
@@ -137,15 +227,34 @@ type FunctionContext struct {
 	regUsage     []int
 	stringPool   *StringPool
 	builtInFuncs *BuiltInFuncs
+	globals      map[string]*GlobalDef
 	fname        string
 	labelCounter int
 	regs         []Reg
 	stackSize    int
 	stack        []map[string]int
+	frames       []map[string]int
 	members      map[string]int
+	captures     map[string]int
 	endLabels    []string
 	startLabels  []string
+	loopLabels   []string
 	stackSizes   []int
+	handlers     []int
+	cleanups     []int
+	scoped       []scopedVar
+	refCount     bool
+	exceptions   bool
+	vfp          bool
+	refs         []int
+	method       bool
+}
+
+// scopedVar is a variable destroyed when leaving its scope. The position is the
+// size of the stack when it was declared
+type scopedVar struct {
+	pos   int
+	class *ClassType
 }
 
 // CreateFunctionContext returns an contextator initialized with all the general
@@ -230,13 +339,130 @@ func (m *FunctionContext) DeclareVar(ident string, insch chan<- Instr) {
 	}
 }
 
-// DeclareMember registers a new member for use
-func (m *FunctionContext) DeclareMember(ident string) {
+// DeclareLong registers a new long variable held in two words on the stack
+// The high word is a variable of its own stored above the low word
+func (m *FunctionContext) DeclareLong(ident string, insch chan<- Instr) {
+	m.DeclareVar(ident+"$high", insch)
+	m.DeclareVar(ident, insch)
+}
+
+// DeclareMember registers a new member for use at the given offset from the
+// instance
+func (m *FunctionContext) DeclareMember(ident string, offset int) {
 	if m.members == nil {
 		m.members = make(map[string]int)
 	}
 
-	m.members[ident] = len(m.members) * 4
+	m.members[ident] = offset
+}
+
+// DeclareCapture registers a variable captured in the closure environment at
+// the given offset from the closure
+func (m *FunctionContext) DeclareCapture(ident string, offset int) {
+	if m.captures == nil {
+		m.captures = make(map[string]int)
+	}
+
+	m.captures[ident] = offset
+}
+
+// IsCaptured returns whether a variable is stored in the closure environment
+func (m *FunctionContext) IsCaptured(ident string) bool {
+	for _, scope := range m.stack {
+		if _, ok := scope[ident]; ok {
+			return false
+		}
+	}
+
+	if _, _, ok := m.frameOf(ident); ok {
+		return false
+	}
+
+	_, ok := m.captures[ident]
+
+	return ok
+}
+
+// IsGlobal returns whether a variable is stored in the data segment
+func (m *FunctionContext) IsGlobal(ident string) bool {
+	if ident[0] == '@' || m.IsCaptured(ident) {
+		return false
+	}
+
+	for _, scope := range m.stack {
+		if _, ok := scope[ident]; ok {
+			return false
+		}
+	}
+
+	if _, _, ok := m.frameOf(ident); ok {
+		return false
+	}
+
+	_, ok := m.globals[ident]
+
+	return ok
+}
+
+// Frame returns the positions of the variables visible in the current scope,
+// which the local functions defined there access relative to the base of the
+// frame
+func (m *FunctionContext) Frame() map[string]int {
+	frame := make(map[string]int)
+
+	for i := len(m.stack) - 1; i >= 0; i-- {
+		for ident, pos := range m.stack[i] {
+			frame[ident] = pos
+			// a variable may shadow a long held in two words
+			if _, ok := m.stack[i][ident+"$high"]; !ok &&
+				!strings.HasSuffix(ident, "$high") {
+				delete(frame, ident+"$high")
+			}
+		}
+	}
+
+	return frame
+}
+
+// frameOf returns how many frames out of the current one a variable of an
+// enclosing function is declared and its position in that frame
+// ok is false if the variable is not declared in an enclosing frame
+func (m *FunctionContext) frameOf(ident string) (depth int, pos int, ok bool) {
+	for _, scope := range m.stack {
+		if _, ok := scope[ident]; ok {
+			return 0, 0, false
+		}
+	}
+
+	for i, frame := range m.frames {
+		if pos, ok := frame[ident]; ok {
+			return i + 1, pos, true
+		}
+	}
+
+	return 0, 0, false
+}
+
+// FrameToRegister puts the base of the frame the given number of frames out of
+// the current one in the given register. The frame of a local function links
+// to the frame it is defined in through its last parameter
+func (m *FunctionContext) FrameToRegister(depth int, target Reg, insch chan<- Instr) {
+	if depth == 0 {
+		insch <- &MOVInstr{dest: target, source: sp}
+		for _, d := range createImmediateValuesFor(m.stackSize) {
+			rhsVal := &ImmediateOperand{d}
+			insch <- &ADDInstr{BaseBinaryInstr{dest: target, lhs: target, rhs: rhsVal}}
+		}
+		return
+	}
+
+	insch <- &LDRInstr{LoadInstr{reg: target,
+		value: &RegisterLoadOperand{reg: sp, value: m.ResolveVar("$link")}}}
+
+	for _, frame := range m.frames[:depth-1] {
+		insch <- &LDRInstr{LoadInstr{reg: target,
+			value: &RegisterLoadOperand{reg: target, value: -frame["$link"]}}}
+	}
 }
 
 // ResolveVar returns the location of a variable
@@ -252,15 +478,41 @@ func (m *FunctionContext) ResolveVar(ident string) int {
 				return (m.stackSize - v)
 			}
 		}
+		if offset, ok := m.captures[ident]; ok {
+			return offset
+		}
 	}
 	panic(fmt.Sprintf("var %s not found in scope", ident))
 }
 
 // ResolveVarToRegister puts the address of a variable to the given register
+// Members are relative to the instance and captures relative to the closure
+// environment, both of which are held in ip. Globals are loaded from the data
+// segment and the variables of enclosing functions are relative to the base of
+// their frame
 func (m *FunctionContext) ResolveVarToRegister(ident string, target Reg, insch chan<- Instr) {
+	if m.IsGlobal(ident) {
+		label := &BasicLoadOperand{m.globals[ident].Label()}
+		insch <- &LDRInstr{LoadInstr{reg: target, value: label}}
+		return
+	}
+
+	if depth, pos, ok := m.frameOf(ident); ok {
+		m.FrameToRegister(depth, target, insch)
+		for _, d := range createImmediateValuesFor(pos) {
+			rhsVal := &ImmediateOperand{d}
+			insch <- &SUBInstr{BaseBinaryInstr{dest: target, lhs: target, rhs: rhsVal}}
+		}
+		for _, d := range createImmediateValuesFor(-pos) {
+			rhsVal := &ImmediateOperand{d}
+			insch <- &ADDInstr{BaseBinaryInstr{dest: target, lhs: target, rhs: rhsVal}}
+		}
+		return
+	}
+
 	var source Reg
-	switch ident[0] {
-	case '@':
+	switch {
+	case ident[0] == '@', m.IsCaptured(ident):
 		source = ip
 	default:
 		source = sp
@@ -276,11 +528,237 @@ func (m *FunctionContext) ResolveVarToRegister(ident string, target Reg, insch c
 // StartScope starts a new scope with new variable mappings possible
 func (m *FunctionContext) StartScope(insch chan<- Instr) {
 	m.stack = append([]map[string]int{make(map[string]int)}, m.stack...)
+	m.cleanups = append([]int{0}, m.cleanups...)
+}
+
+// DeclareScoped registers a variable holding an instance of the class to be
+// destroyed when leaving its scope
+func (m *FunctionContext) DeclareScoped(ident string, class *ClassType) {
+	m.scoped = append(m.scoped, scopedVar{pos: m.stack[0][ident], class: class})
+}
+
+// DestroyScoped calls the destructors of the scoped variables stored after the
+// stack had the given size and frees them, the latest declared first. Null
+// instances are skipped. r0, r1 and ip are preserved
+func (m *FunctionContext) DestroyScoped(stackSize int, insch chan<- Instr) {
+	var vars []scopedVar
+	for i := len(m.scoped) - 1; i >= 0; i-- {
+		if m.scoped[i].pos > stackSize {
+			vars = append(vars, m.scoped[i])
+		}
+	}
+
+	if len(vars) == 0 {
+		return
+	}
+
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r0, r1, ip}}}
+	m.PushStack(12)
+
+	for _, v := range vars {
+		instance := &RegisterLoadOperand{reg: sp, value: m.stackSize - v.pos}
+		labelEnd := fmt.Sprintf("scoped_end%s", m.GetUniqueLabelSuffix())
+
+		insch <- &LDRInstr{LoadInstr{reg: r0, value: instance}}
+		insch <- &CMPInstr{BaseComparisonInstr{lhs: r0,
+			rhs: &ImmediateOperand{0}}}
+		insch <- &BInstr{cond: condEQ, label: labelEnd}
+
+		if slot, ok := v.class.Destructor(); ok {
+			insch <- &LDRInstr{LoadInstr{reg: ip,
+				value: &RegisterLoadOperand{reg: r0}}}
+			insch <- &LDRInstr{LoadInstr{reg: ip,
+				value: &RegisterLoadOperand{value: slot * 4, reg: ip}}}
+			insch <- &BLXInstr{reg: ip}
+			insch <- &LDRInstr{LoadInstr{reg: r0, value: instance}}
+		}
+
+		insch <- &BLInstr{BInstr{label: mFreeLabel}}
+		insch <- &LABELInstr{ident: labelEnd}
+	}
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r0, r1, ip}}}
+	m.PopStack(12)
+}
+
+// refCounted returns whether values of the type are heap objects carrying a
+// reference count. Character arrays are excluded as they can hold the string
+// literals of the data segment
+func refCounted(t Type) bool {
+	switch t := t.(type) {
+	case PairType, MapType, *ClassType, *InterfaceType:
+		return true
+	case ArrayType:
+		_, chars := t.base.(CharType)
+		return !chars
+	default:
+		return false
+	}
+}
+
+// wordsOf returns the number of words a value of the type takes in memory
+// Longs take two words, the low word first
+func wordsOf(t Type) int {
+	if _, ok := t.(LongType); ok {
+		return 2
+	}
+	return 1
+}
+
+// layout returns the offsets of values of the given types stored one after the
+// other from the given offset, and the offset following the last of them
+func layout(types []Type, offset int) ([]int, int) {
+	offsets := make([]int, len(types))
+	for i, t := range types {
+		offsets[i] = offset
+		offset += wordsOf(t) * 4
+	}
+	return offsets, offset
+}
+
+// memberLayout returns the offsets of the members of the instances of the class
+// and the size of the instances. The first word of an instance holds its
+// virtual table so members start after
+func memberLayout(c *ClassType) ([]int, int) {
+	var types []Type
+	for _, member := range c.Members() {
+		types = append(types, member.wtype)
+	}
+	return layout(types, 4)
+}
+
+// captureLayout returns the offsets of the variables captured by the function
+// and the size of its closures. The first word of a closure holds the function
+// address so captures start after
+func captureLayout(f *FunctionDef) ([]int, int) {
+	var types []Type
+	for _, c := range f.captures {
+		types = append(types, c.wtype)
+	}
+	return layout(types, 4)
+}
+
+// DeclareRef registers a variable holding a reference counted object to be
+// released when leaving its scope
+func (m *FunctionContext) DeclareRef(ident string) {
+	m.refs = append(m.refs, m.stack[0][ident])
+}
+
+// Retain increments the reference count of the object in the register
+// --> MOV r0, reg
+// --> BL p_rc_retain
+func (m *FunctionContext) Retain(reg Reg, insch chan<- Instr) {
+	m.builtInFuncs.Use(mRetainLbl)
+
+	insch <- &MOVInstr{dest: r0, source: reg}
+	insch <- &BLInstr{BInstr{label: mRetainLbl}}
+}
+
+// Release decrements the reference count of the object in the register and
+// frees it when no references are left
+// --> PUSH {ip}
+// --> MOV r0, reg
+// --> BL p_rc_release
+// --> POP {ip}
+func (m *FunctionContext) Release(reg Reg, insch chan<- Instr) {
+	m.builtInFuncs.Use(mReleaseLbl)
+
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	m.PushStack(4)
+
+	insch <- &MOVInstr{dest: r0, source: reg}
+	insch <- &BLInstr{BInstr{label: mReleaseLbl}}
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	m.PopStack(4)
+}
+
+// Disown decrements the reference count of the object in r0 without freeing
+// it, so that it can be returned to the caller
+// --> BL p_rc_disown
+func (m *FunctionContext) Disown(insch chan<- Instr) {
+	m.builtInFuncs.Use(mDisownLbl)
+
+	insch <- &BLInstr{BInstr{label: mDisownLbl}}
+}
+
+// ReleaseRefs releases the reference counted variables stored after the stack
+// had the given size, the latest declared first. r0, r1 and ip are preserved
+func (m *FunctionContext) ReleaseRefs(stackSize int, insch chan<- Instr) {
+	var refs []int
+	for i := len(m.refs) - 1; i >= 0; i-- {
+		if m.refs[i] > stackSize {
+			refs = append(refs, m.refs[i])
+		}
+	}
+
+	if len(refs) == 0 {
+		return
+	}
+
+	m.builtInFuncs.Use(mReleaseLbl)
+
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r0, r1, ip}}}
+	m.PushStack(12)
+
+	for _, pos := range refs {
+		insch <- &LDRInstr{LoadInstr{reg: r0,
+			value: &RegisterLoadOperand{reg: sp, value: m.stackSize - pos}}}
+		insch <- &BLInstr{BInstr{label: mReleaseLbl}}
+	}
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r0, r1, ip}}}
+	m.PopStack(12)
+}
+
+// Malloc allocates size bytes on the heap and returns their address in r0.
+// Reference counted objects are preceded by their count and the routine
+// releasing the objects they hold, if any
+// --> LDR r0, =size
+// --> BL malloc
+func (m *FunctionContext) Malloc(size int, release string, insch chan<- Instr) {
+	if !m.refCount {
+		insch <- &LDRInstr{LoadInstr{reg: r0, value: &ConstLoadOperand{size}}}
+		insch <- &BLInstr{BInstr{label: mMalloc}}
+		return
+	}
+
+	insch <- &LDRInstr{LoadInstr{reg: r0, value: &ConstLoadOperand{size + 8}}}
+	insch <- &BLInstr{BInstr{label: mMalloc}}
+
+	insch <- &MOVInstr{dest: r1, source: ImmediateOperand{0}}
+	insch <- &STRInstr{StoreInstr{reg: r1, value: &RegStoreOperand{r0}}}
+	if release != "" {
+		insch <- &LDRInstr{LoadInstr{reg: r1,
+			value: &BasicLoadOperand{value: release}}}
+	}
+	insch <- &STRInstr{StoreInstr{reg: r1,
+		value: &RegStoreOffsetOperand{reg: r0, offset: 4}}}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r0, lhs: r0,
+		rhs: ImmediateOperand{8}}}
 }
 
-// CleanupScope starts a new scope with new variable mappings possible
+// CleanupScope destroys the scoped variables of the innermost scope and
+// discards its variable mappings and cleanup handlers
 func (m *FunctionContext) CleanupScope(insch chan<- Instr) {
-	sl := len(m.stack[0]) * 4
+	sl := len(m.stack[0])*4 + m.cleanups[0]*12
+
+	if m.cleanups[0] > 0 {
+		m.RestoreHandler(m.stackSize-sl, insch)
+		m.handlers = m.handlers[:len(m.handlers)-m.cleanups[0]]
+	}
+
+	m.DestroyScoped(m.stackSize-sl, insch)
+	for len(m.scoped) > 0 && m.scoped[len(m.scoped)-1].pos > m.stackSize-sl {
+		m.scoped = m.scoped[:len(m.scoped)-1]
+	}
+
+	m.ReleaseRefs(m.stackSize-sl, insch)
+	for len(m.refs) > 0 && m.refs[len(m.refs)-1] > m.stackSize-sl {
+		m.refs = m.refs[:len(m.refs)-1]
+	}
+
 	for _, od := range createImmediateValuesFor(sl) {
 		insch <- &ADDInstr{
 			BaseBinaryInstr: BaseBinaryInstr{
@@ -292,11 +770,16 @@ func (m *FunctionContext) CleanupScope(insch chan<- Instr) {
 	}
 	m.PopStack(sl)
 	m.stack = m.stack[1:]
+	m.cleanups = m.cleanups[1:]
 }
 
 // PrepareForReturn rolls back all the scopes and gets the stack ready for
 // returning
 func (m *FunctionContext) PrepareForReturn(insch chan<- Instr) {
+	m.DestroyScoped(0, insch)
+	// parameters are stored below the stack of the function
+	m.ReleaseRefs(math.MinInt32, insch)
+
 	for _, od := range createImmediateValuesFor(m.stackSize) {
 		insch <- &ADDInstr{
 			BaseBinaryInstr: BaseBinaryInstr{
@@ -332,9 +815,10 @@ func (m *FunctionContext) PushLastEndLabel(endLabel string) {
 	m.endLabels = append(m.endLabels, endLabel)
 }
 
-// PeekLastEndLabel returns the last endLabel used
-func (m *FunctionContext) PeekLastEndLabel() string {
-	lastEndLabel := m.endLabels[len(m.endLabels)-1]
+// PeekLastEndLabel returns the endLabel of the loop depth levels out of the
+// innermost one
+func (m *FunctionContext) PeekLastEndLabel(depth int) string {
+	lastEndLabel := m.endLabels[len(m.endLabels)-1-depth]
 	return lastEndLabel
 }
 
@@ -350,12 +834,39 @@ func (m *FunctionContext) PushLastStartLabel(startLabel string) {
 	m.startLabels = append(m.startLabels, startLabel)
 }
 
-// PeekLastStartLabel returns the last startLabel used
-func (m *FunctionContext) PeekLastStartLabel() string {
-	lastStartLabel := m.startLabels[len(m.startLabels)-1]
+// PeekLastStartLabel returns the startLabel of the loop depth levels out of
+// the innermost one
+func (m *FunctionContext) PeekLastStartLabel(depth int) string {
+	lastStartLabel := m.startLabels[len(m.startLabels)-1-depth]
 	return lastStartLabel
 }
 
+// PushLoopLabel adds the label naming a loop to the stack of loopLabels. Loops
+// without a label push the empty string
+func (m *FunctionContext) PushLoopLabel(label string) {
+	m.loopLabels = append(m.loopLabels, label)
+}
+
+// PopLoopLabel discards the label of the innermost loop
+func (m *FunctionContext) PopLoopLabel() {
+	m.loopLabels = m.loopLabels[:len(m.loopLabels)-1]
+}
+
+// LoopDepth returns how many loops out of the innermost one the loop carrying
+// the label is. The empty label stands for the innermost loop
+func (m *FunctionContext) LoopDepth(label string) int {
+	if label == "" {
+		return 0
+	}
+
+	for i := len(m.loopLabels) - 1; i >= 0; i-- {
+		if m.loopLabels[i] == label {
+			return len(m.loopLabels) - 1 - i
+		}
+	}
+	panic(fmt.Sprintf("loop %s not found", label))
+}
+
 // PopStackSize returns the last stackSize saved
 func (m *FunctionContext) PopStackSize() int {
 	lastStackSize := m.stackSizes[len(m.stackSizes)-1]
@@ -368,16 +879,130 @@ func (m *FunctionContext) PushStackSize() {
 	m.stackSizes = append(m.stackSizes, m.stackSize)
 }
 
-// PeekStackSize returns the last stackSize saved
-func (m *FunctionContext) PeekStackSize() int {
-	lastStackSize := m.stackSizes[len(m.stackSizes)-1]
+// PeekStackSize returns the stackSize saved by the loop depth levels out of the
+// innermost one
+func (m *FunctionContext) PeekStackSize(depth int) int {
+	lastStackSize := m.stackSizes[len(m.stackSizes)-1-depth]
 	return lastStackSize
 }
 
-// Returns difference between last stack size saved
-// and current one
-func (m *FunctionContext) GetStackSizeDifference() int {
-	previousStackSize := m.PeekStackSize()
+// PushHandler records the stack size at which the exception handler of a try
+// statement is stored
+func (m *FunctionContext) PushHandler() {
+	m.handlers = append(m.handlers, m.stackSize)
+}
+
+// InstallHandler stores an exception handler jumping to the label on the stack
+// The handler holds the previous handler, the address of the label and ip
+// --> LDR reg1, =label
+// --> PUSH {ip}
+// --> PUSH {reg1}
+// --> LDR reg2, =p_exception_handler
+// --> LDR reg1, [reg2]
+// --> PUSH {reg1}
+// --> STR sp, [reg2]
+func (m *FunctionContext) InstallHandler(label string, insch chan<- Instr) {
+	reg := m.GetReg(insch)
+	handler := m.GetReg(insch)
+
+	insch <- &LDRInstr{LoadInstr{reg: reg,
+		value: &BasicLoadOperand{value: label}}}
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{reg}}}
+	insch <- &LDRInstr{LoadInstr{reg: handler,
+		value: &BasicLoadOperand{value: mExceptionHandler}}}
+	insch <- &LDRInstr{LoadInstr{reg: reg,
+		value: &RegisterLoadOperand{reg: handler}}}
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{reg}}}
+	insch <- &STRInstr{StoreInstr{reg: sp, value: &RegStoreOperand{handler}}}
+
+	m.FreeReg(handler, insch)
+	m.FreeReg(reg, insch)
+
+	m.PushStack(12)
+	m.PushHandler()
+}
+
+// PushCleanup installs an exception handler destroying and releasing the
+// variables declared since the last handler was installed before passing the
+// exception on, so that they are cleaned up when a throw unwinds the stack.
+// The handler is unlinked when leaving the scope
+// --> B cleanup_install_%l
+// cleanup_%l:
+// --> PUSH {r1}
+// --> [DestroyScoped]
+// --> [ReleaseRefs]
+// --> POP {r1}
+// --> BL p_throw_exception
+// cleanup_install_%l:
+// --> [InstallHandler cleanup_%l]
+func (m *FunctionContext) PushCleanup(insch chan<- Instr) {
+	stackSize := math.MinInt32
+	if len(m.handlers) > 0 {
+		stackSize = m.handlers[len(m.handlers)-1]
+	}
+
+	suffix := m.GetUniqueLabelSuffix()
+	labelCleanup := fmt.Sprintf("cleanup%s", suffix)
+	labelInstall := fmt.Sprintf("cleanup_install%s", suffix)
+
+	m.builtInFuncs.Use(mThrowExceptionLbl)
+	m.builtInFuncs.Use(mThrowRuntimeErr)
+
+	insch <- &BInstr{label: labelInstall}
+
+	// the throw leaves the stack as it was before installing the handler
+	// with the exception in r0 and the message printed if it is not caught
+	// in r1
+	insch <- &LABELInstr{ident: labelCleanup}
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r1}}}
+	m.PushStack(4)
+
+	m.DestroyScoped(stackSize, insch)
+	m.ReleaseRefs(stackSize, insch)
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r1}}}
+	m.PopStack(4)
+	insch <- &BLInstr{BInstr{label: mThrowExceptionLbl}}
+
+	insch <- &LABELInstr{ident: labelInstall}
+	m.InstallHandler(labelCleanup, insch)
+	m.cleanups[0]++
+}
+
+// PopHandler discards the last exception handler recorded
+func (m *FunctionContext) PopHandler() {
+	m.handlers = m.handlers[:len(m.handlers)-1]
+}
+
+// RestoreHandler unlinks the exception handlers stored after the stack had the
+// given size when jumping out of their try statements. The outermost of them
+// holds the handler to restore
+func (m *FunctionContext) RestoreHandler(stackSize int, insch chan<- Instr) {
+	for _, h := range m.handlers {
+		if h <= stackSize {
+			continue
+		}
+
+		reg := m.GetReg(insch)
+		addr := m.GetReg(insch)
+
+		insch <- &LDRInstr{LoadInstr{reg: reg,
+			value: &RegisterLoadOperand{reg: sp, value: m.stackSize - h}}}
+		insch <- &LDRInstr{LoadInstr{reg: addr,
+			value: &BasicLoadOperand{value: mExceptionHandler}}}
+		insch <- &STRInstr{StoreInstr{reg: reg, value: &RegStoreOperand{addr}}}
+
+		m.FreeReg(addr, insch)
+		m.FreeReg(reg, insch)
+		return
+	}
+}
+
+// Returns difference between the stack size saved by the loop depth levels out
+// of the innermost one and current one
+func (m *FunctionContext) GetStackSizeDifference(depth int) int {
+	previousStackSize := m.PeekStackSize(depth)
 	difference := m.stackSize - previousStackSize
 	return difference
 }
@@ -405,6 +1030,14 @@ func (m *BuiltInFuncs) Use(function string) {
 	m.pool[function] = true
 }
 
+// IsUsed returns whether the requested function is in the assembly code
+func (m *BuiltInFuncs) IsUsed(function string) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.pool[function]
+}
+
 //------------------------------------------------------------------------------
 // GLOBAL STRING STORAGE
 //------------------------------------------------------------------------------
@@ -490,11 +1123,19 @@ func (m *SkipStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 }
 
 // CodeGen for continue statements
-// restore Stack
+// destroy scoped variables
+// release reference counted variables
+// restore Stack up to the targeted loop
 // --> B start_%l
 // --> [Codegen next instruction]
 func (m *ContinueStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
-	difference := context.GetStackSizeDifference()
+	depth := context.LoopDepth(m.label)
+
+	context.RestoreHandler(context.PeekStackSize(depth), insch)
+	context.DestroyScoped(context.PeekStackSize(depth), insch)
+	context.ReleaseRefs(context.PeekStackSize(depth), insch)
+
+	difference := context.GetStackSizeDifference(depth)
 
 	for _, op := range createImmediateValuesFor(difference) {
 		insch <- &ADDInstr{
@@ -506,18 +1147,26 @@ func (m *ContinueStatement) CodeGen(context *FunctionContext, insch chan<- Instr
 		}
 	}
 
-	labelStart := context.PeekLastStartLabel()
+	labelStart := context.PeekLastStartLabel(depth)
 	insch <- &BInstr{label: labelStart}
 
 	m.BaseStatement.CodeGen(context, insch)
 }
 
 // CodeGen for break statements
-// restore Stack
+// destroy scoped variables
+// release reference counted variables
+// restore Stack up to the targeted loop
 // --> B end_%l
 // --> [CodeGen next instruction]
 func (m *BreakStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
-	difference := context.GetStackSizeDifference()
+	depth := context.LoopDepth(m.label)
+
+	context.RestoreHandler(context.PeekStackSize(depth), insch)
+	context.DestroyScoped(context.PeekStackSize(depth), insch)
+	context.ReleaseRefs(context.PeekStackSize(depth), insch)
+
+	difference := context.GetStackSizeDifference(depth)
 
 	for _, op := range createImmediateValuesFor(difference) {
 		insch <- &ADDInstr{
@@ -528,7 +1177,7 @@ func (m *BreakStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 			},
 		}
 	}
-	labelEnd := context.PeekLastEndLabel()
+	labelEnd := context.PeekLastEndLabel(depth)
 	insch <- &BInstr{label: labelEnd}
 
 	m.BaseStatement.CodeGen(context, insch)
@@ -556,9 +1205,34 @@ func (m *BlockStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 //CodeGen generates code for DeclareAssignStatement
 // --> [CodeGen rhs] << reg
 // --> STR reg [sp, #offset]
+// --> [BL p_rc_retain] if reference counting
 // --> [CodeGen next instruction]
+// Longs are stored in two words
+// --> {long}: [codeGenLongRHS rhs] << lo, hi
+// --> {long}: STR lo [sp, #offset]
+// --> {long}: STR hi [sp, #offset+4]
 func (m *DeclareAssignStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 	lhs := m.ident
+
+	if _, ok := m.wtype.(LongType); ok {
+		context.DeclareLong(lhs, insch)
+
+		lo := context.GetReg(insch)
+		hi := context.GetReg(insch)
+		codeGenLongRHS(m.rhs, context, lo, hi, insch)
+
+		insch <- &STRInstr{StoreInstr{reg: lo,
+			value: &MemoryStoreOperand{context.ResolveVar(lhs)}}}
+		insch <- &STRInstr{StoreInstr{reg: hi,
+			value: &MemoryStoreOperand{context.ResolveVar(lhs + "$high")}}}
+
+		context.FreeReg(hi, insch)
+		context.FreeReg(lo, insch)
+
+		m.BaseStatement.CodeGen(context, insch)
+		return
+	}
+
 	context.DeclareVar(lhs, insch)
 
 	rhs := m.rhs
@@ -569,16 +1243,58 @@ func (m *DeclareAssignStatement) CodeGen(context *FunctionContext, insch chan<-
 	storeValue := &MemoryStoreOperand{context.ResolveVar(lhs)}
 	insch <- &STRInstr{StoreInstr{reg: baseReg, value: storeValue}}
 
+	switch {
+	case context.refCount && refCounted(m.wtype):
+		// scoped objects are destroyed with their last reference
+		context.Retain(baseReg, insch)
+		context.DeclareRef(lhs)
+	case m.scoped:
+		context.DeclareScoped(lhs, m.wtype.(*ClassType))
+	}
+
 	context.FreeReg(baseReg, insch)
 
+	// destroy or release the object if an exception leaves its scope. An
+	// uncaught exception ends the program, so references are only released
+	// when the program catches exceptions
+	if m.scoped || context.exceptions && context.refCount && refCounted(m.wtype) {
+		context.PushCleanup(insch)
+	}
+
 	m.BaseStatement.CodeGen(context, insch)
 }
 
+// codeGenRefUpdate retains the object about to be stored at the address in
+// lhsReg and releases the one it replaces. Objects stored through erased pair
+// types are only retained as the replaced value may not be an object
+// --> [BL p_rc_retain]
+// --> LDR reg, [lhsReg]
+// --> [BL p_rc_release]
+func codeGenRefUpdate(lhsT, rhsT Type, context *FunctionContext, lhsReg, rhsReg Reg, insch chan<- Instr) {
+	if _, erased := lhsT.(VoidType); refCounted(lhsT) || erased && refCounted(rhsT) {
+		context.Retain(rhsReg, insch)
+	}
+
+	if !refCounted(lhsT) {
+		return
+	}
+
+	reg := context.GetReg(insch)
+	insch <- &LDRInstr{LoadInstr{reg: reg, value: &RegisterLoadOperand{reg: lhsReg}}}
+	context.Release(reg, insch)
+	context.FreeReg(reg, insch)
+}
+
 //CodeGen generates code for AssignStatement
 // --> [CodeGen lhs] << reg1
 // --> [CodeGen rhs] << reg2
+// --> [CodeGen reference update] if reference counting
 // --> STR reg2 [reg1]
 // --> [CodeGen next instruction]
+// Longs are stored in two words, on the stack and on the heap alike
+// --> {long}: [codeGenLongRHS rhs] << lo, hi
+// --> {long}: STR lo [reg1]
+// --> {long}: STR hi [reg1, #4]
 func (m *AssignStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 	lhs := m.target
 
@@ -587,9 +1303,30 @@ func (m *AssignStatement) CodeGen(context *FunctionContext, insch chan<- Instr)
 	lhsReg := context.GetReg(insch)
 	lhs.CodeGen(context, lhsReg, insch)
 
+	if _, ok := lhs.Type().(LongType); ok {
+		lo := context.GetReg(insch)
+		hi := context.GetReg(insch)
+		codeGenLongRHS(rhs, context, lo, hi, insch)
+
+		insch <- &STRInstr{StoreInstr{reg: lo, value: &RegStoreOperand{lhsReg}}}
+		insch <- &STRInstr{StoreInstr{reg: hi,
+			value: &RegStoreOffsetOperand{reg: lhsReg, offset: 4}}}
+
+		context.FreeReg(hi, insch)
+		context.FreeReg(lo, insch)
+		context.FreeReg(lhsReg, insch)
+
+		m.BaseStatement.CodeGen(context, insch)
+		return
+	}
+
 	rhsReg := context.GetReg(insch)
 	rhs.CodeGen(context, rhsReg, insch)
 
+	if context.refCount {
+		codeGenRefUpdate(lhs.Type(), rhs.Type(), context, lhsReg, rhsReg, insch)
+	}
+
 	storeValue := &RegStoreOperand{lhsReg}
 	insch <- &STRInstr{StoreInstr{reg: rhsReg, value: storeValue}}
 
@@ -604,6 +1341,7 @@ func (m *AssignStatement) CodeGen(context *FunctionContext, insch chan<- Instr)
 // --> MOV r0, reg
 // --> {int}: BL p_read_int
 // --> {char}: BL p_read_char
+// --> {float}: BL p_read_float
 // --> [CodeGen next instruction]
 func (m *ReadStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
@@ -624,6 +1362,9 @@ func (m *ReadStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 	case CharType:
 		context.builtInFuncs.Use(mReadCharLabel)
 		insch <- &BLInstr{BInstr: BInstr{label: mReadCharLabel}}
+	case FloatType:
+		context.builtInFuncs.Use(mReadFloatLabel)
+		insch <- &BLInstr{BInstr: BInstr{label: mReadFloatLabel}}
 	default:
 		panic(fmt.Errorf("%v has no type information", m.target))
 	}
@@ -640,10 +1381,17 @@ func (m *ReadStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 // --> [CodeGen expr] << reg
 // --> MOV r0, reg
 // --> BL pi_check_null_pointer
+// --> [BLX fini] if the instance has a destructor
 // --> MOV r0, reg
 // --> BL free
 // --> [CodeGen next instruction]
 func (m *FreeStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
+	// objects are freed when their last reference is released
+	if context.refCount {
+		m.BaseStatement.CodeGen(context, insch)
+		return
+	}
+
 	reg := context.GetReg(insch)
 	context.builtInFuncs.Use(mNullReferenceLbl)
 	context.builtInFuncs.Use(mThrowRuntimeErr)
@@ -657,6 +1405,18 @@ func (m *FreeStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 
 	insch <- &BLInstr{BInstr{label: mNullReferenceLbl}}
 
+	// call the destructor of class instances before releasing them
+	if class, ok := m.expr.Type().(*ClassType); ok {
+		if slot, ok := class.Destructor(); ok {
+			insch <- &MOVInstr{dest: r0, source: reg}
+			insch <- &LDRInstr{LoadInstr{reg: ip,
+				value: &RegisterLoadOperand{reg: r0}}}
+			insch <- &LDRInstr{LoadInstr{reg: ip,
+				value: &RegisterLoadOperand{value: slot * 4, reg: ip}}}
+			insch <- &BLXInstr{reg: ip}
+		}
+	}
+
 	insch <- &MOVInstr{dest: r0, source: reg}
 
 	insch <- &BLInstr{BInstr{label: mFreeLabel}}
@@ -667,24 +1427,80 @@ func (m *FreeStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 	m.BaseStatement.CodeGen(context, insch)
 }
 
+//CodeGen generates code for DeleteStatement
+// --> [CodeGen expr] << reg1
+// --> [codeGenMapKey key] << reg2
+// --> MOV r0, reg1
+// --> MOV r1, reg2
+// --> BL p_map_delete
+// --> [CodeGen next instruction]
+func (m *DeleteStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
+	useMapRoutines(context, m.expr.Type())
+	if context.refCount {
+		context.builtInFuncs.Use(mReleaseLbl)
+	}
+
+	mapReg := context.GetReg(insch)
+	m.expr.CodeGen(context, mapReg, insch)
+
+	keyReg := context.GetReg(insch)
+	box := codeGenMapKey(m.key, context, keyReg, insch)
+
+	insch <- &MOVInstr{dest: r0, source: mapReg}
+	insch <- &MOVInstr{dest: r1, source: keyReg}
+	insch <- &BLInstr{BInstr{label: mMapDeleteLbl}}
+	popMapKey(box, context, insch)
+
+	context.FreeReg(keyReg, insch)
+	context.FreeReg(mapReg, insch)
+
+	m.BaseStatement.CodeGen(context, insch)
+}
+
 //CodeGen generates code for ReturnStatement
 // --> [CodeGen expr] << reg
 // --> MOV r0, reg
+// --> {long}: [codeGenLong expr] << reg, hi
+// --> {long}: MOV r0, reg
+// --> {long}: MOV r1, hi
 // --> ADD sp, sp, #offset
+// --> [BL p_rc_disown] if the value is reference counted
 // --> B %l_return
 // --> [CodeGen next instruction]
 func (m *ReturnStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 	reg := context.GetReg(insch)
 
+	// the returned object is kept alive while the variables are released
+	owned := context.refCount && refCounted(m.expr.Type())
+
 	switch m.expr.Type().(type) {
 	case VoidType:
+		// methods return their instance, the reference count updates
+		// may have overwritten it
+		if context.refCount && context.method {
+			insch <- &MOVInstr{dest: resReg, source: ip}
+		}
+	case LongType:
+		hi := context.GetReg(insch)
+		codeGenLong(m.expr, context, reg, hi, insch)
+		insch <- &MOVInstr{dest: resReg, source: reg}
+		insch <- &MOVInstr{dest: r1, source: hi}
+		context.FreeReg(hi, insch)
 	default:
 		m.expr.CodeGen(context, reg, insch)
+		if owned {
+			context.Retain(reg, insch)
+		}
 		insch <- &MOVInstr{dest: resReg, source: reg}
 	}
 
+	context.RestoreHandler(0, insch)
 	context.PrepareForReturn(insch)
 
+	if owned {
+		context.Disown(insch)
+	}
+
 	insch <- &BInstr{label: fmt.Sprintf("%s_return", context.fname)}
 
 	context.FreeReg(reg, insch)
@@ -711,22 +1527,149 @@ func (m *ExitStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 	m.BaseStatement.CodeGen(context, insch)
 }
 
-func print(m Expression, context *FunctionContext, insch chan<- Instr) {
-	r := context.GetReg(insch)
-	m.CodeGen(context, r, insch)
-	insch <- &MOVInstr{dest: r0, source: r}
-	context.FreeReg(r, insch)
-	switch t := m.Type().(type) {
-	case IntType:
-		context.builtInFuncs.Use(mPrintIntLabel)
-		insch <- &BLInstr{BInstr: BInstr{label: mPrintIntLabel}}
-	case BoolType:
-		context.builtInFuncs.Use(mPrintBoolLabel)
-		insch <- &BLInstr{BInstr: BInstr{label: mPrintBoolLabel}}
-	case CharType:
-		context.builtInFuncs.Use(mPrintCharLabel)
-		insch <- &BLInstr{BInstr: BInstr{label: mPrintCharLabel}}
-	case PairType:
+//CodeGen generates code for ThrowStatement
+// --> [CodeGen expr] << reg
+// --> MOV r0, reg
+// --> LDR r1, =msg_uncaught
+// --> BL p_throw_exception
+// --> [CodeGen next instruction]
+func (m *ThrowStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
+	context.builtInFuncs.Use(mThrowExceptionLbl)
+	context.builtInFuncs.Use(mThrowRuntimeErr)
+
+	reg := context.GetReg(insch)
+
+	m.expr.CodeGen(context, reg, insch)
+
+	insch <- &MOVInstr{dest: r0, source: reg}
+
+	msg := context.stringPool.Lookup8(mUncaughtExceptionErr)
+	insch <- &LDRInstr{LoadInstr{reg: r1, value: &BasicLoadOperand{value: msg}}}
+
+	insch <- &BLInstr{BInstr: BInstr{label: mThrowExceptionLbl}}
+
+	context.FreeReg(reg, insch)
+
+	m.BaseStatement.CodeGen(context, insch)
+}
+
+//CodeGen generates code for TryStatement
+// The handler stored on the stack holds the previous handler, the address of
+// the catch body and ip
+// --> LDR reg1, =catch_%l
+// --> PUSH {ip}
+// --> PUSH {reg1}
+// --> LDR reg2, =p_exception_handler
+// --> LDR reg1, [reg2]
+// --> PUSH {reg1}
+// --> STR sp, [reg2]
+// --> [CodeGen body]
+// --> POP {reg1}
+// --> LDR reg2, =p_exception_handler
+// --> STR reg1, [reg2]
+// --> ADD sp, sp, #8
+// --> B try_end_%l
+// catch_%l:
+// --> SUB sp, sp, #4
+// --> STR r0, [sp]
+// --> [CodeGen catch body]
+// --> ADD sp, sp, #4
+// try_end_%l:
+// --> [CodeGen next instruction]
+func (m *TryStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
+	suffix := context.GetUniqueLabelSuffix()
+
+	labelCatch := fmt.Sprintf("catch%s", suffix)
+	labelEnd := fmt.Sprintf("try_end%s", suffix)
+
+	context.builtInFuncs.Use(mThrowExceptionLbl)
+	context.builtInFuncs.Use(mThrowRuntimeErr)
+
+	// Install the handler
+	context.InstallHandler(labelCatch, insch)
+
+	// Body
+	context.StartScope(insch)
+
+	if m.body != nil {
+		m.body.CodeGen(context, insch)
+	}
+
+	context.CleanupScope(insch)
+
+	// Restore the previous handler
+	context.PopHandler()
+
+	reg := context.GetReg(insch)
+	handler := context.GetReg(insch)
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{reg}}}
+	insch <- &LDRInstr{LoadInstr{reg: handler,
+		value: &BasicLoadOperand{value: mExceptionHandler}}}
+	insch <- &STRInstr{StoreInstr{reg: reg, value: &RegStoreOperand{handler}}}
+	insch <- &ADDInstr{BaseBinaryInstr: BaseBinaryInstr{dest: sp, lhs: sp,
+		rhs: ImmediateOperand{8}}}
+
+	context.FreeReg(handler, insch)
+	context.FreeReg(reg, insch)
+
+	context.PopStack(12)
+
+	insch <- &BInstr{label: labelEnd}
+
+	// Catch body, the exception is passed in r0
+	insch <- &LABELInstr{ident: labelCatch}
+
+	context.StartScope(insch)
+
+	context.DeclareVar(m.ident, insch)
+	insch <- &STRInstr{StoreInstr{reg: r0,
+		value: &MemoryStoreOperand{context.ResolveVar(m.ident)}}}
+
+	if m.catchStat != nil {
+		m.catchStat.CodeGen(context, insch)
+	}
+
+	context.CleanupScope(insch)
+
+	insch <- &LABELInstr{ident: labelEnd}
+
+	m.BaseStatement.CodeGen(context, insch)
+}
+
+func print(m Expression, context *FunctionContext, insch chan<- Instr) {
+	// longs are printed from a pair of registers
+	if _, ok := m.Type().(LongType); ok {
+		lo := context.GetReg(insch)
+		hi := context.GetReg(insch)
+		codeGenLong(m, context, lo, hi, insch)
+		insch <- &MOVInstr{dest: r0, source: lo}
+		insch <- &MOVInstr{dest: r1, source: hi}
+		context.FreeReg(hi, insch)
+		context.FreeReg(lo, insch)
+		context.builtInFuncs.Use(mPrintLongLabel)
+		insch <- &BLInstr{BInstr: BInstr{label: mPrintLongLabel}}
+		return
+	}
+
+	r := context.GetReg(insch)
+	m.CodeGen(context, r, insch)
+	insch <- &MOVInstr{dest: r0, source: r}
+	context.FreeReg(r, insch)
+	switch t := m.Type().(type) {
+	case IntType:
+		context.builtInFuncs.Use(mPrintIntLabel)
+		insch <- &BLInstr{BInstr: BInstr{label: mPrintIntLabel}}
+	case FloatType:
+		context.builtInFuncs.Use(mPrintFloatLabel)
+		insch <- &BLInstr{BInstr: BInstr{label: mPrintFloatLabel}}
+	case BoolType:
+		context.builtInFuncs.Use(mPrintBoolLabel)
+		insch <- &BLInstr{BInstr: BInstr{label: mPrintBoolLabel}}
+	case CharType:
+		context.builtInFuncs.Use(mPrintCharLabel)
+		insch <- &BLInstr{BInstr: BInstr{label: mPrintCharLabel}}
+	case PairType, MapType, FunctionType:
 		context.builtInFuncs.Use(mPrintReferenceLabel)
 		insch <- &BLInstr{BInstr: BInstr{label: mPrintReferenceLabel}}
 	case ArrayType:
@@ -774,26 +1717,193 @@ func (m *PrintStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 	m.BaseStatement.CodeGen(context, insch)
 }
 
-//CodeGen generates code for FunctionCallStat
-// [CodeGen param] << reg
-// PUSH reg
-// POP r0,r1,r2,r3
-// BL f
-// MOV target, r0
-// POP [params]
-func (m *FunctionCallStat) CodeGen(context *FunctionContext, insch chan<- Instr) {
-	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+//codeGenBranch generates the branch to the called function
+// Methods are dispatched through the virtual table of the object in r0
+// --> LDR ip, [r0]
+// --> LDR ip, [ip, #slot]
+// --> BLX ip
+// Interface methods through the method table the class has for the interface
+// --> LDR ip, =interface
+// --> BL p_find_interface_table
+// --> LDR ip, [ip, #slot]
+// --> BLX ip
+// Function values through the closure in ip, which the callee keeps in ip
+// --> LDR lr, [ip]
+// --> BLX lr
+func (m *FunctionCall) codeGenBranch(context *FunctionContext, insch chan<- Instr) {
+	if m.closure {
+		insch <- &LDRInstr{LoadInstr{reg: lr,
+			value: &RegisterLoadOperand{reg: ip}}}
+		insch <- &BLXInstr{reg: lr}
+
+		return
+	}
+
+	if m.iface != nil {
+		for slot, method := range m.iface.methods {
+			if method.Symbol() != m.mangledIdent {
+				continue
+			}
+
+			context.builtInFuncs.Use(mInterfaceTableLbl)
+			context.builtInFuncs.Use(mThrowRuntimeErr)
+
+			insch <- &LDRInstr{LoadInstr{reg: ip,
+				value: &BasicLoadOperand{value: m.iface.MangleSymbol()}}}
+			insch <- &BLInstr{BInstr{label: mInterfaceTableLbl}}
+			insch <- &LDRInstr{LoadInstr{reg: ip,
+				value: &RegisterLoadOperand{value: slot * 4, reg: ip}}}
+			insch <- &BLXInstr{reg: ip}
+
+			return
+		}
+	}
+
+	if m.class != nil {
+		for slot, method := range m.class.VTable() {
+			if method.Symbol() != m.mangledIdent {
+				continue
+			}
+
+			insch <- &LDRInstr{LoadInstr{reg: ip,
+				value: &RegisterLoadOperand{reg: r0}}}
+			insch <- &LDRInstr{LoadInstr{reg: ip,
+				value: &RegisterLoadOperand{value: slot * 4, reg: ip}}}
+			insch <- &BLXInstr{reg: ip}
+
+			return
+		}
+	}
+
+	insch <- &BLInstr{BInstr: BInstr{label: m.mangledIdent}}
+}
+
+//codeGenLink pushes the frame a local function is defined in, which it is
+//passed as a hidden last argument. It returns the number of arguments pushed
+// --> [FrameToRegister] << reg
+// --> PUSH reg
+func (m *FunctionCall) codeGenLink(context *FunctionContext, insch chan<- Instr) int {
+	if m.local == nil {
+		return 0
+	}
+
+	reg := context.GetReg(insch)
+	context.FrameToRegister(len(context.frames)-m.local.depth+1, reg, insch)
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{reg}}}
 	context.PushStack(4)
+	context.FreeReg(reg, insch)
+
+	return 1
+}
+
+//argLayout returns the words the arguments of the given types are passed in,
+//counting from the given word, and the word following them. The first four
+//words are passed in r0-r3 and the others on the stack. Longs are passed in two
+//words, which are never split between r3 and the stack
+func argLayout(types []Type, first int) ([]int, int) {
+	words := make([]int, len(types))
+	word := first
+	for i, t := range types {
+		if wordsOf(t) == 2 && word == 3 {
+			word++
+		}
+		words[i] = word
+		word += wordsOf(t)
+	}
+	return words, word
+}
+
+//codeGenArgs pushes the arguments of a call from the last to the first. The
+//trailing arguments of calls to variadic functions are packed into an array
+//pushed in their place. Longs are pushed in two words, the low word first, and
+//the word skipped to keep them whole is left unset. The first word is 1 for
+//methods, which are passed their object first. It returns the number of words
+//pushed
+// --> [CodeGen packed args] << reg
+// --> PUSH reg
+// --> [CodeGen arg] << reg
+// --> PUSH reg
+// --> {long}: [codeGenLong arg] << lo, hi
+// --> {long}: PUSH hi
+// --> {long}: PUSH lo
+func codeGenArgs(args []Expression, variadic Type, packed int, first int, context *FunctionContext, insch chan<- Instr) int {
+	argL := len(args)
+	if variadic != nil {
+		argL -= packed
+	}
+
+	var types []Type
+	for _, arg := range args[:argL] {
+		types = append(types, arg.Type())
+	}
+	if variadic != nil {
+		types = append(types, variadic)
+	}
+	words, end := argLayout(types, first)
+
+	if variadic != nil {
+		reg := context.GetReg(insch)
+		codeGenArray(args[argL:], variadic.(ArrayType).base, context, reg, insch)
+		insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{reg}}}
+		context.PushStack(4)
+		context.FreeReg(reg, insch)
+	}
+
+	next := end
+	if variadic != nil {
+		next = words[len(words)-1]
+	}
 
-	argL := len(m.args)
 	for i := argL - 1; i >= 0; i-- {
+		if skip := next - words[i] - wordsOf(types[i]); skip > 0 {
+			insch <- &SUBInstr{BaseBinaryInstr{dest: sp, lhs: sp,
+				rhs: ImmediateOperand{skip * 4}}}
+			context.PushStack(skip * 4)
+		}
+		next = words[i]
+
+		if _, ok := types[i].(LongType); ok {
+			lo := context.GetReg(insch)
+			hi := context.GetReg(insch)
+			codeGenLong(args[i], context, lo, hi, insch)
+			insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{hi}}}
+			insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{lo}}}
+			context.PushStack(8)
+			context.FreeReg(hi, insch)
+			context.FreeReg(lo, insch)
+			continue
+		}
+
 		reg := context.GetReg(insch)
-		m.args[i].CodeGen(context, reg, insch)
+		args[i].CodeGen(context, reg, insch)
 		insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{reg}}}
 		context.PushStack(4)
 		context.FreeReg(reg, insch)
 	}
 
+	return end - first
+}
+
+//codeGenCall calls the function leaving its result in r0, or in r0 and r1 if
+//it is a long
+// --> [codeGenLink]
+// --> [codeGenArgs]
+// --> PUSH obj
+// --> POP r0,r1,r2,r3
+// --> [codeGenBranch]
+// --> ADD sp, sp, #offset
+func (m *FunctionCall) codeGenCall(context *FunctionContext, insch chan<- Instr) {
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PushStack(4)
+
+	first := 0
+	if len(m.obj) > 0 {
+		first = 1
+	}
+
+	argL := m.codeGenLink(context, insch)
+	argL += codeGenArgs(m.args, m.variadic, m.packed, first, context, insch)
+
 	// if method call resolve the obj and pass it as first argument
 	switch {
 	case m.obj == "@this":
@@ -823,11 +1933,18 @@ func (m *FunctionCallStat) CodeGen(context *FunctionContext, insch chan<- Instr)
 		argL++
 	}
 
+	// if function value call load the closure in ip
+	if m.closure {
+		context.ResolveVarToRegister(m.ident, ip, insch)
+		loadValue := &RegisterLoadOperand{reg: ip}
+		insch <- &LDRInstr{LoadInstr{reg: ip, value: loadValue}}
+	}
+
 	for i := 0; i < 4 && i < argL; i++ {
 		insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{argRegs[i]}}}
 	}
 
-	insch <- &BLInstr{BInstr: BInstr{label: m.mangledIdent}}
+	m.codeGenBranch(context, insch)
 
 	if pl := argL; pl > 4 {
 		insch <- &ADDInstr{BaseBinaryInstr: BaseBinaryInstr{dest: sp, lhs: sp,
@@ -838,6 +1955,12 @@ func (m *FunctionCallStat) CodeGen(context *FunctionContext, insch chan<- Instr)
 
 	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
 	context.PopStack(4)
+}
+
+//CodeGen generates code for FunctionCallStat
+// --> [codeGenCall]
+func (m *FunctionCallStat) CodeGen(context *FunctionContext, insch chan<- Instr) {
+	m.codeGenCall(context, insch)
 
 	m.BaseStatement.CodeGen(context, insch)
 }
@@ -922,6 +2045,7 @@ func (m *WhileStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 
 	context.PushLastEndLabel(labelEnd)
 	context.PushLastStartLabel(labelWhile)
+	context.PushLoopLabel(m.label)
 
 	// CMP Check
 
@@ -950,6 +2074,7 @@ func (m *WhileStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 
 	context.PopLastEndLabel()
 	context.PopLastStartLabel()
+	context.PopLoopLabel()
 
 	context.PopStackSize()
 
@@ -1043,6 +2168,7 @@ func (m *DoWhileStatement) CodeGen(context *FunctionContext, insch chan<- Instr)
 
 	context.PushLastEndLabel(labelEnd)
 	context.PushLastStartLabel(labelCond)
+	context.PushLoopLabel(m.label)
 
 	insch <- &LABELInstr{ident: labelDo}
 
@@ -1051,6 +2177,8 @@ func (m *DoWhileStatement) CodeGen(context *FunctionContext, insch chan<- Instr)
 
 	m.body.CodeGen(context, insch)
 
+	context.CleanupScope(insch)
+
 	// Condition
 	insch <- &LABELInstr{ident: labelCond}
 
@@ -1067,11 +2195,10 @@ func (m *DoWhileStatement) CodeGen(context *FunctionContext, insch chan<- Instr)
 
 	context.PopLastEndLabel()
 	context.PopLastStartLabel()
+	context.PopLoopLabel()
 
 	context.PopStackSize()
 
-	context.CleanupScope(insch)
-
 	m.BaseStatement.CodeGen(context, insch)
 }
 
@@ -1094,6 +2221,7 @@ func (m *ForStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 
 	context.PushLastEndLabel(labelEnd)
 	context.PushLastStartLabel(labelAfter)
+	context.PushLoopLabel(m.label)
 
 	// Initialization
 	context.StartScope(insch)
@@ -1122,13 +2250,13 @@ func (m *ForStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 		m.body.CodeGen(context, insch)
 	}
 
+	context.CleanupScope(insch)
+
 	// After
 	insch <- &LABELInstr{ident: labelAfter}
 
 	m.after.CodeGen(context, insch)
 
-	context.CleanupScope(insch)
-
 	insch <- &BInstr{label: labelFor}
 
 	insch <- &LABELInstr{ident: labelEnd}
@@ -1137,9 +2265,213 @@ func (m *ForStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
 
 	context.PopLastEndLabel()
 	context.PopLastStartLabel()
+	context.PopLoopLabel()
+
+	context.PopStackSize()
+
+	m.BaseStatement.CodeGen(context, insch)
+}
+
+//CodeGen generates code for ForEachStatement
+// The array, its length and the index are kept in variables that the body
+// cannot name
+// --> [CodeGen expr] << reg
+// --> STR reg, [sp, #array]
+// --> LDR reg, [reg]
+// --> STR reg, [sp, #length]
+// --> MOV reg, #0
+// --> STR reg, [sp, #index]
+// foreach_start_%l
+// --> LDR reg, [sp, #index]
+// --> LDR reg2, [sp, #length]
+// --> CMP reg, reg2
+// --> BGE foreach_end_%l
+// --> LDR reg2, [sp, #array]
+// --> ADD reg2, reg2, reg, LSL #2
+// --> LDR reg2, [reg2, #4]
+// --> STR reg2, [sp, #ident]
+// --> {long}: ADD reg2, reg2, reg, LSL #3
+// --> {long}: LDR reg3, [reg2, #8]
+// --> {long}: STR reg3, [sp, #ident+4]
+// --> {long}: LDR reg2, [reg2, #4]
+// --> {long}: STR reg2, [sp, #ident]
+// --> [CodeGen body]
+// foreach_after_%l
+// --> LDR reg, [sp, #index]
+// --> ADD reg, reg, #1
+// --> STR reg, [sp, #index]
+// --> B foreach_start_%l
+// foreach_end_%l
+// --> [CodeGen next instruction]
+func (m *ForEachStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
+	suffix := context.GetUniqueLabelSuffix()
+
+	labelStart := fmt.Sprintf("foreach_start%s", suffix)
+	labelEnd := fmt.Sprintf("foreach_end%s", suffix)
+	labelAfter := fmt.Sprintf("foreach_after%s", suffix)
+
+	arrayVar := fmt.Sprintf("$array%s", suffix)
+	lengthVar := fmt.Sprintf("$length%s", suffix)
+	indexVar := fmt.Sprintf("$index%s", suffix)
+
+	load := func(reg Reg, ident string) {
+		insch <- &LDRInstr{LoadInstr{reg: reg,
+			value: &RegisterLoadOperand{reg: sp, value: context.ResolveVar(ident)}}}
+	}
+	store := func(reg Reg, ident string) {
+		insch <- &STRInstr{StoreInstr{reg: reg,
+			value: &MemoryStoreOperand{context.ResolveVar(ident)}}}
+	}
+
+	// Initialization
+	context.StartScope(insch)
+
+	context.DeclareVar(arrayVar, insch)
+	context.DeclareVar(lengthVar, insch)
+	context.DeclareVar(indexVar, insch)
+
+	reg := context.GetReg(insch)
+
+	m.expr.CodeGen(context, reg, insch)
+	store(reg, arrayVar)
+
+	// the array outlives the variables the body could reassign
+	if context.refCount && refCounted(m.expr.Type()) {
+		context.Retain(reg, insch)
+		context.DeclareRef(arrayVar)
+		if context.exceptions {
+			context.PushCleanup(insch)
+		}
+	}
+
+	insch <- &LDRInstr{LoadInstr{reg: reg, value: &RegisterLoadOperand{reg: reg}}}
+	store(reg, lengthVar)
+
+	insch <- &MOVInstr{dest: reg, source: ImmediateOperand{0}}
+	store(reg, indexVar)
+
+	context.FreeReg(reg, insch)
+
+	context.PushLastEndLabel(labelEnd)
+	context.PushLastStartLabel(labelAfter)
+	context.PushLoopLabel(m.label)
+
+	insch <- &LABELInstr{ident: labelStart}
+
+	// Condition
+	reg = context.GetReg(insch)
+	elem := context.GetReg(insch)
+
+	load(reg, indexVar)
+	load(elem, lengthVar)
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: reg,
+		rhs: &RegisterOperand{reg: elem}}}
+	insch <- &BInstr{cond: condGE, label: labelEnd}
+
+	//Body
+	context.PushStackSize()
+
+	context.StartScope(insch)
+
+	_, long := m.wtype.(LongType)
+	if long {
+		context.DeclareLong(m.ident, insch)
+	} else {
+		context.DeclareVar(m.ident, insch)
+	}
+
+	load(elem, arrayVar)
+
+	// longs are stored in two words
+	if long {
+		insch <- &ADDInstr{BaseBinaryInstr{dest: elem, lhs: elem,
+			rhs: &LSLRegOperand{reg: reg, offset: 3}}}
+		hi := context.GetReg(insch)
+		insch <- &LDRInstr{LoadInstr{reg: hi,
+			value: &RegisterLoadOperand{reg: elem, value: 8}}}
+		store(hi, m.ident+"$high")
+		context.FreeReg(hi, insch)
+	} else {
+		insch <- &ADDInstr{BaseBinaryInstr{dest: elem, lhs: elem,
+			rhs: &LSLRegOperand{reg: reg, offset: 2}}}
+	}
+	insch <- &LDRInstr{LoadInstr{reg: elem,
+		value: &RegisterLoadOperand{reg: elem, value: 4}}}
+	store(elem, m.ident)
+
+	if context.refCount && refCounted(m.wtype) {
+		context.Retain(elem, insch)
+		context.DeclareRef(m.ident)
+		if context.exceptions {
+			context.PushCleanup(insch)
+		}
+	}
+
+	context.FreeReg(elem, insch)
+	context.FreeReg(reg, insch)
+
+	if m.body != nil {
+		m.body.CodeGen(context, insch)
+	}
+
+	context.CleanupScope(insch)
+
+	// After
+	insch <- &LABELInstr{ident: labelAfter}
+
+	reg = context.GetReg(insch)
+
+	load(reg, indexVar)
+	insch <- &ADDInstr{BaseBinaryInstr{dest: reg, lhs: reg,
+		rhs: ImmediateOperand{1}}}
+	store(reg, indexVar)
+
+	context.FreeReg(reg, insch)
+
+	insch <- &BInstr{label: labelStart}
+
+	insch <- &LABELInstr{ident: labelEnd}
+
+	context.PopLastEndLabel()
+	context.PopLastStartLabel()
+	context.PopLoopLabel()
 
 	context.PopStackSize()
 
+	context.CleanupScope(insch)
+
+	m.BaseStatement.CodeGen(context, insch)
+}
+
+//CodeGen generates code for LocalFuncStatement
+// The function is generated in place with the frames of the enclosing
+// functions known, and branched over
+// --> B local_end_%l
+// --> [CodeGen function]
+// local_end_%l
+// --> [CodeGen next instruction]
+func (m *LocalFuncStatement) CodeGen(context *FunctionContext, insch chan<- Instr) {
+	labelEnd := fmt.Sprintf("local_end%s", context.GetUniqueLabelSuffix())
+
+	insch <- &BInstr{label: labelEnd}
+
+	local := CreateFunctionContext()
+	local.stringPool = context.stringPool
+	local.builtInFuncs = context.builtInFuncs
+	local.globals = context.globals
+	local.refCount = context.refCount
+	local.exceptions = context.exceptions
+	local.vfp = context.vfp
+	local.members = context.members
+	local.captures = context.captures
+	local.method = context.method
+	local.frames = append([]map[string]int{context.Frame()}, context.frames...)
+
+	m.function.codeGen(local, insch)
+
+	insch <- &LABELInstr{ident: labelEnd}
+
 	m.BaseStatement.CodeGen(context, insch)
 }
 
@@ -1151,25 +2483,52 @@ func (m *PairElemLHS) CodeGen(context *FunctionContext, target Reg, insch chan<-
 	pairElem(m.expr, context, target, insch)
 
 	if m.snd {
-		insch <- &ADDInstr{BaseBinaryInstr{dest: target, lhs: target, rhs: &ImmediateOperand{4}}}
+		offset := sndOffset(m.expr.Type())
+		insch <- &ADDInstr{BaseBinaryInstr{dest: target, lhs: target, rhs: &ImmediateOperand{offset}}}
 	}
 }
 
-func arrayHelper(ident string, exprs []Expression, context *FunctionContext, target Reg, insch chan<- Instr) {
+// arrayHelper leaves the address of the indexed element in target. Maps are
+// looked up by their keys, a missing key is inserted into the map when the
+// address is assigned to
+func arrayHelper(ident string, exprs []Expression, indexed []Type, insert bool, context *FunctionContext, target Reg, insch chan<- Instr) {
 	//Load array Address
 	context.ResolveVarToRegister(ident, target, insch)
 
 	//Place index in new Register
 	indexReg := context.GetReg(insch)
 	for index := 0; index < len(exprs); index++ {
-		context.builtInFuncs.Use(mArrayBoundLbl)
-		context.builtInFuncs.Use(mThrowRuntimeErr)
-
 		//Retrieve content of Array Address
 		insch <- &LDRInstr{LoadInstr{reg: target, value: &RegisterLoadOperand{reg: target}}}
 
+		// longs are stored in two words
+		shift := 2
+		if index < len(indexed) {
+			if t, ok := indexed[index].(MapType); ok {
+				routine := mMapLookupLbl
+				if insert && index == len(exprs)-1 {
+					routine = mMapInsertLbl
+				}
+				useMapRoutines(context, t)
+
+				box := codeGenMapKey(exprs[index], context, indexReg, insch)
+				insch <- &MOVInstr{dest: r0, source: target}
+				insch <- &MOVInstr{dest: r1, source: indexReg}
+				insch <- &BLInstr{BInstr{label: routine}}
+				insch <- &MOVInstr{dest: target, source: r0}
+				popMapKey(box, context, insch)
+				continue
+			}
+			if t, ok := indexed[index].(ArrayType); ok && wordsOf(t.base) == 2 {
+				shift = 3
+			}
+		}
+
 		exprs[index].CodeGen(context, indexReg, insch)
 
+		context.builtInFuncs.Use(mArrayBoundLbl)
+		context.builtInFuncs.Use(mThrowRuntimeErr)
+
 		//Check array Bounds
 		insch <- &MOVInstr{dest: r0, source: indexReg}
 		insch <- &MOVInstr{dest: r1, source: target}
@@ -1180,7 +2539,7 @@ func arrayHelper(ident string, exprs []Expression, context *FunctionContext, tar
 		insch <- &ADDInstr{BaseBinaryInstr{dest: target, lhs: target, rhs: rhsVal}}
 
 		//Target now points to the index element
-		OpTwoRegLSL := &LSLRegOperand{reg: indexReg, offset: 2}
+		OpTwoRegLSL := &LSLRegOperand{reg: indexReg, offset: shift}
 		insch <- &ADDInstr{BaseBinaryInstr{dest: target, lhs: target, rhs: OpTwoRegLSL}}
 	}
 
@@ -1196,8 +2555,13 @@ func arrayHelper(ident string, exprs []Expression, context *FunctionContext, tar
 // --> BL p_check_array_bounds
 // --> ADD target, target, #4
 // --> ADD target, target, [reg, LSL 2]
+// --> {long[]}: ADD target, target, [reg, LSL 3]
+// --> {map}: MOV r0, target
+// -->        MOV r1, reg
+// -->        BL p_map_insert
+// -->        MOV target, r0
 func (m *ArrayLHS) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
-	arrayHelper(m.ident, m.index, context, target, insch)
+	arrayHelper(m.ident, m.index, m.indexed, true, context, target, insch)
 }
 
 //CodeGen generates code for VarLHS
@@ -1214,22 +2578,34 @@ func (m *PairLiterRHS) CodeGen(context *FunctionContext, target Reg, insch chan<
 }
 
 //CodeGen generates code for ArrayLiterRHS
-// --> LDR r0, =(length+1)*4
+// --> LDR r0, =length*stride+4
 // --> BL malloc
 // --> MOV target, r0
 // --> [Codegen elem] << reg
+// --> [BL p_rc_retain] if the elements are reference counted
 // --> STR reg, [target, #offset]
+// --> {long}: [codeGenStoreLong elem]
 // --> LDR reg, #length
 // --> STR reg, [target]
 func (m *ArrayLiterRHS) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	codeGenArray(m.elements, m.Type().(ArrayType).base, context, target, insch)
+}
+
+//codeGenArray allocates an array on the heap holding the given elements
+func codeGenArray(elements []Expression, base Type, context *FunctionContext, target Reg, insch chan<- Instr) {
 	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
 	context.PushStack(4)
 
 	//Call Malloc
-	leng := &ConstLoadOperand{len(m.elements)*4 + 4}
-	insch <- &LDRInstr{LoadInstr{reg: r0, value: leng}}
-
-	insch <- &BLInstr{BInstr{label: mMalloc}}
+	elems := refCounted(base)
+	var release string
+	if elems && context.refCount {
+		context.builtInFuncs.Use(mReleaseElemsLbl)
+		context.builtInFuncs.Use(mReleaseLbl)
+		release = mReleaseElemsLbl
+	}
+	stride := wordsOf(base) * 4
+	context.Malloc(len(elements)*stride+4, release, insch)
 
 	insch <- &MOVInstr{dest: target, source: resReg}
 
@@ -1237,18 +2613,28 @@ func (m *ArrayLiterRHS) CodeGen(context *FunctionContext, target Reg, insch chan
 	arrayReg := context.GetReg(insch)
 
 	//Populate Heap at array indexes
-	for pos := 1; pos <= len(m.elements); pos++ {
-		element := m.elements[pos-1]
+	for pos, element := range elements {
+		offset := pos*stride + 4
+
+		if _, ok := base.(LongType); ok {
+			codeGenStoreLong(element, context, target, offset, insch)
+			continue
+		}
+
 		element.CodeGen(context, arrayReg, insch)
 
-		regOffset := &RegStoreOffsetOperand{reg: target, offset: (pos * 4)}
+		if context.refCount && elems {
+			context.Retain(arrayReg, insch)
+		}
+
+		regOffset := &RegStoreOffsetOperand{reg: target, offset: offset}
 		insch <- &STRInstr{StoreInstr{reg: arrayReg, value: regOffset}}
 	}
 
 	context.FreeReg(arrayReg, insch)
 
 	//Mov length into position 0
-	lenInt := &ConstLoadOperand{len(m.elements)}
+	lenInt := &ConstLoadOperand{len(elements)}
 	insch <- &LDRInstr{LoadInstr{reg: arrayReg, value: lenInt}}
 
 	insch <- &STRInstr{StoreInstr{reg: arrayReg, value: &RegStoreOperand{target}}}
@@ -1257,10 +2643,191 @@ func (m *ArrayLiterRHS) CodeGen(context *FunctionContext, target Reg, insch chan
 	context.PopStack(4)
 }
 
-func pairElem(expr Expression, context *FunctionContext, target Reg, insch chan<- Instr) {
-	expr.CodeGen(context, target, insch)
-	context.builtInFuncs.Use(mNullReferenceLbl)
-	context.builtInFuncs.Use(mThrowRuntimeErr)
+// mapFlags returns the flags stored in a map telling the runtime how to compare
+// its keys and whether its keys and values are reference counted
+// --> 1: the keys are strings compared by their characters or longs compared
+//        by their words
+// --> 2: the keys are reference counted
+// --> 4: the values are reference counted
+// --> 8: the keys are longs, which the map copies into boxes of its own
+func mapFlags(t Type, refCount bool) int {
+	mapT, ok := t.(MapType)
+	if !ok {
+		return 0
+	}
+
+	flags := 0
+	if keyT, ok := mapT.key.(ArrayType); ok {
+		if _, chars := keyT.base.(CharType); chars {
+			flags |= 1
+		}
+	}
+	if _, ok := mapT.key.(LongType); ok {
+		flags |= 1 | 8
+	}
+	if refCount && refCounted(mapT.key) {
+		flags |= 2
+	}
+	if refCount && refCounted(mapT.value) {
+		flags |= 4
+	}
+
+	return flags
+}
+
+// useMapRoutines adds the routines operating on maps, which call each other,
+// to the assembly code
+func useMapRoutines(context *FunctionContext, t Type) {
+	for _, routine := range []string{mMapInitLbl, mMapBucketsLbl,
+		mMapBucketLbl, mMapStrEqualLbl, mMapFindLbl, mMapLookupLbl,
+		mMapHasLbl, mMapInsertLbl, mMapGrowLbl, mMapDeleteLbl,
+		mNullReferenceLbl, mThrowRuntimeErr} {
+		context.builtInFuncs.Use(routine)
+	}
+
+	flags := mapFlags(t, context.refCount)
+	if flags&2 != 0 {
+		context.builtInFuncs.Use(mRetainLbl)
+	}
+	if flags&8 != 0 {
+		context.builtInFuncs.Use(mLongBoxLbl)
+	}
+}
+
+// codeGenMapKey generates the key a map is looked up by into target. Long keys
+// are boxed in a temporary box on the stack, which the map copies when the key
+// is inserted. It returns the size of the box to pop once the map routine
+// returns
+// --> [CodeGen key] << target
+// --> {long}: SUB sp, sp, #12
+// --> {long}: [codeGenLong key] << target, reg
+// --> {long}: STR target, [sp, #4]
+// --> {long}: STR reg, [sp, #8]
+// --> {long}: MOV reg, #2
+// --> {long}: STR reg, [sp]
+// --> {long}: MOV target, sp
+func codeGenMapKey(key Expression, context *FunctionContext, target Reg, insch chan<- Instr) int {
+	if _, ok := key.Type().(LongType); !ok {
+		key.CodeGen(context, target, insch)
+		return 0
+	}
+
+	insch <- &SUBInstr{BaseBinaryInstr{dest: sp, lhs: sp,
+		rhs: ImmediateOperand{12}}}
+	context.PushStack(12)
+	box := context.stackSize
+
+	reg := context.GetReg(insch)
+	codeGenLong(key, context, target, reg, insch)
+	insch <- &STRInstr{StoreInstr{reg: target,
+		value: &MemoryStoreOperand{context.stackSize - box + 4}}}
+	insch <- &STRInstr{StoreInstr{reg: reg,
+		value: &MemoryStoreOperand{context.stackSize - box + 8}}}
+	insch <- &MOVInstr{dest: reg, source: ImmediateOperand{2}}
+	insch <- &STRInstr{StoreInstr{reg: reg,
+		value: &MemoryStoreOperand{context.stackSize - box}}}
+	context.FreeReg(reg, insch)
+
+	insch <- &MOVInstr{dest: target, source: sp}
+
+	return 12
+}
+
+// popMapKey pops the temporary box of size bytes of a long key
+// --> ADD sp, sp, #size
+func popMapKey(size int, context *FunctionContext, insch chan<- Instr) {
+	if size == 0 {
+		return
+	}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: sp, lhs: sp,
+		rhs: ImmediateOperand{size}}}
+	context.PopStack(size)
+}
+
+//CodeGen generates code for MapLiterRHS
+// --> LDR r0, =16
+// --> BL malloc
+// --> MOV r1, #flags
+// --> BL p_map_init
+// --> MOV target, r0
+// --> [codeGenMapKey key] << reg
+// --> MOV r0, target
+// --> MOV r1, reg
+// --> BL p_map_insert
+// --> MOV reg, r0
+// --> [Codegen value] << reg2
+// --> [CodeGen reference update] if the values are reference counted
+// --> STR reg2, [reg]
+func (m *MapLiterRHS) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PushStack(4)
+
+	useMapRoutines(context, m.Type())
+	var release string
+	if context.refCount {
+		context.builtInFuncs.Use(mReleaseMapLbl)
+		context.builtInFuncs.Use(mReleaseLbl)
+		release = mReleaseMapLbl
+	}
+
+	context.Malloc(16, release, insch)
+
+	flags := mapFlags(m.Type(), context.refCount)
+	insch <- &MOVInstr{dest: r1, source: ImmediateOperand{flags}}
+	insch <- &BLInstr{BInstr{label: mMapInitLbl}}
+
+	insch <- &MOVInstr{dest: target, source: resReg}
+
+	slotReg := context.GetReg(insch)
+	valueReg := context.GetReg(insch)
+
+	for i, key := range m.keys {
+		box := codeGenMapKey(key, context, slotReg, insch)
+
+		insch <- &MOVInstr{dest: r0, source: target}
+		insch <- &MOVInstr{dest: r1, source: slotReg}
+		insch <- &BLInstr{BInstr{label: mMapInsertLbl}}
+		insch <- &MOVInstr{dest: slotReg, source: resReg}
+		popMapKey(box, context, insch)
+
+		if _, ok := m.values[i].Type().(LongType); ok {
+			codeGenStoreLong(m.values[i], context, slotReg, 0, insch)
+			continue
+		}
+
+		m.values[i].CodeGen(context, valueReg, insch)
+
+		// a repeated key replaces the value stored before
+		if flags&4 != 0 {
+			codeGenRefUpdate(m.Type().(MapType).value, m.values[i].Type(),
+				context, slotReg, valueReg, insch)
+		}
+
+		insch <- &STRInstr{StoreInstr{reg: valueReg,
+			value: &RegStoreOperand{slotReg}}}
+	}
+
+	context.FreeReg(valueReg, insch)
+	context.FreeReg(slotReg, insch)
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PopStack(4)
+}
+
+// sndOffset returns the offset of the second element of pairs of the type,
+// which follows a long first element in two words
+func sndOffset(t Type) int {
+	if pairT, ok := t.(PairType); ok {
+		return wordsOf(pairT.first) * 4
+	}
+	return 4
+}
+
+func pairElem(expr Expression, context *FunctionContext, target Reg, insch chan<- Instr) {
+	expr.CodeGen(context, target, insch)
+	context.builtInFuncs.Use(mNullReferenceLbl)
+	context.builtInFuncs.Use(mThrowRuntimeErr)
 
 	//Mov + CheckNullPointer Label
 	insch <- &MOVInstr{dest: r0, source: target}
@@ -1271,7 +2838,16 @@ func pairElem(expr Expression, context *FunctionContext, target Reg, insch chan<
 // --> MOV r0, target
 // --> BL pi_check_null_pointer
 // --> LDR target, [target, #offset]
+// --> {long}: [codeGenLongBox]
 func (m *PairElemRHS) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	if _, ok := m.Type().(LongType); ok {
+		hi := context.GetReg(insch)
+		m.CodeGenLong(context, target, hi, insch)
+		boxLong(context, target, hi, insch)
+		context.FreeReg(hi, insch)
+		return
+	}
+
 	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
 	context.PushStack(4)
 
@@ -1280,7 +2856,7 @@ func (m *PairElemRHS) CodeGen(context *FunctionContext, target Reg, insch chan<-
 	offset := 0
 
 	if m.snd {
-		offset = 4
+		offset = sndOffset(m.expr.Type())
 	}
 
 	//Load fst or snd
@@ -1291,71 +2867,21 @@ func (m *PairElemRHS) CodeGen(context *FunctionContext, target Reg, insch chan<-
 }
 
 //CodeGen generates code for FunctionCallRHS
-// [CodeGen param] << reg
-// PUSH reg
-// POP r0,r1,r2,r3
-// BL f
-// MOV target, r0
-// POP [params]
+// --> [codeGenCall]
+// --> MOV target, r0
+// --> {long}: [codeGenLongBox]
 func (m *FunctionCallRHS) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
-	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
-	context.PushStack(4)
-
-	argL := len(m.args)
-	for i := argL - 1; i >= 0; i-- {
-		reg := context.GetReg(insch)
-		m.args[i].CodeGen(context, reg, insch)
-		insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{reg}}}
-		context.PushStack(4)
-		context.FreeReg(reg, insch)
-	}
-
-	// if method call resolve the obj and pass it as first argument
-	switch {
-	case m.obj == "@this":
-		insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
-
-		context.PushStack(4)
-		argL++
-	case len(m.obj) > 0:
-		reg := context.GetReg(insch)
-
-		context.ResolveVarToRegister(m.obj, reg, insch)
-		loadValue := &RegisterLoadOperand{reg: reg}
-		insch <- &LDRInstr{LoadInstr{reg: reg, value: loadValue}}
-
-		context.builtInFuncs.Use(mNullReferenceLbl)
-		context.builtInFuncs.Use(mThrowRuntimeErr)
-
-		//Mov + CheckNullPointer Label
-		insch <- &MOVInstr{dest: r0, source: reg}
-		insch <- &BLInstr{BInstr{label: mNullReferenceLbl}}
-
-		insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{reg}}}
-
-		context.PushStack(4)
-		context.FreeReg(reg, insch)
-
-		argL++
-	}
-
-	for i := 0; i < 4 && i < argL; i++ {
-		insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{argRegs[i]}}}
+	if _, ok := m.wtype.(LongType); ok {
+		hi := context.GetReg(insch)
+		m.CodeGenLong(context, target, hi, insch)
+		boxLong(context, target, hi, insch)
+		context.FreeReg(hi, insch)
+		return
 	}
 
-	insch <- &BLInstr{BInstr: BInstr{label: m.mangledIdent}}
+	m.codeGenCall(context, insch)
 
 	insch <- &MOVInstr{dest: target, source: resReg}
-
-	if pl := argL; pl > 4 {
-		insch <- &ADDInstr{BaseBinaryInstr: BaseBinaryInstr{dest: sp, lhs: sp,
-			rhs: ImmediateOperand{(pl - 4) * 4}}}
-	}
-
-	context.PopStack(argL * 4)
-
-	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
-	context.PopStack(4)
 }
 
 //CodeGen generates code for ExpressionRHS
@@ -1371,22 +2897,30 @@ func (m *NewInstanceRHS) CodeGen(context *FunctionContext, target Reg, insch cha
 	context.PushStack(4)
 
 	// evaluate constructor arguments
-	argL := len(m.args)
-	for i := argL - 1; i >= 0; i-- {
-		reg := context.GetReg(insch)
-		m.args[i].CodeGen(context, reg, insch)
-		insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{reg}}}
-		context.PushStack(4)
-		context.FreeReg(reg, insch)
-	}
+	argL := codeGenArgs(m.args, m.variadic, m.packed, 1, context, insch)
 
 	// create new instance
 	cT := m.wtype.(*ClassType)
+	offsets, size := memberLayout(cT)
 
-	leng := &ConstLoadOperand{len(cT.members) * 4}
-	insch <- &LDRInstr{LoadInstr{reg: r0, value: leng}}
+	context.Malloc(size, cT.ReleaseLabel(), insch)
 
-	insch <- &BLInstr{BInstr{label: mMalloc}}
+	// members holding objects are released when first assigned
+	if context.refCount {
+		insch <- &MOVInstr{dest: r1, source: ImmediateOperand{0}}
+		for i, member := range cT.Members() {
+			if !refCounted(member.wtype) {
+				continue
+			}
+			insch <- &STRInstr{StoreInstr{reg: r1,
+				value: &RegStoreOffsetOperand{reg: r0, offset: offsets[i]}}}
+		}
+	}
+
+	// store the virtual table in the first word of the instance
+	vtable := &BasicLoadOperand{value: cT.VTableLabel()}
+	insch <- &LDRInstr{LoadInstr{reg: r1, value: vtable}}
+	insch <- &STRInstr{StoreInstr{reg: r1, value: &RegStoreOperand{reg: r0}}}
 
 	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r0}}}
 	context.PushStack(4)
@@ -1420,12 +2954,88 @@ func (m *NewInstanceRHS) CodeGen(context *FunctionContext, target Reg, insch cha
 
 //CodeGen generates code for Ident
 // --> LDR target, [sp, #offset]
+// Named functions are wrapped in a closure without captures
+// Globals are resolved when type checking as the default values of parameters
+// are generated at call sites where locals may shadow them
+// Longs are copied into a new box
+// --> {long}: [codeGenLongBox]
 func (m *Ident) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
-	context.ResolveVarToRegister(m.ident, target, insch)
+	if m.function != nil {
+		codeGenClosure(m.function, context, target, insch)
+		return
+	}
+
+	if _, ok := m.Type().(LongType); ok {
+		codeGenLongBox(m, context, target, insch)
+		return
+	}
+
+	if m.global != nil {
+		label := &BasicLoadOperand{m.global.Label()}
+		insch <- &LDRInstr{LoadInstr{reg: target, value: label}}
+	} else {
+		context.ResolveVarToRegister(m.ident, target, insch)
+	}
 	loadValue := &RegisterLoadOperand{reg: target}
 	insch <- &LDRInstr{LoadInstr{reg: target, value: loadValue}}
 }
 
+//CodeGen generates code for Lambda
+// --> [CodeGen closure] << target
+func (m *Lambda) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	codeGenClosure(m.function, context, target, insch)
+}
+
+//codeGenClosure allocates the closure of a function copying the values of the
+//variables it captures. Longs are copied in two words
+// --> LDR r0, =size
+// --> BL malloc
+// --> MOV target, r0
+// --> LDR reg, =function
+// --> STR reg, [target]
+// --> [CodeGen capture] << reg
+// --> STR reg, [target, #offset]
+func codeGenClosure(f *FunctionDef, context *FunctionContext, target Reg, insch chan<- Instr) {
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PushStack(4)
+
+	offsets, size := captureLayout(f)
+	insch <- &LDRInstr{LoadInstr{reg: r0, value: &ConstLoadOperand{size}}}
+	insch <- &BLInstr{BInstr{label: mMalloc}}
+	insch <- &MOVInstr{dest: target, source: resReg}
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PopStack(4)
+
+	reg := context.GetReg(insch)
+
+	label := &BasicLoadOperand{value: f.Symbol()}
+	insch <- &LDRInstr{LoadInstr{reg: reg, value: label}}
+	insch <- &STRInstr{StoreInstr{reg: reg, value: &RegStoreOperand{target}}}
+
+	for i, c := range f.captures {
+		context.ResolveVarToRegister(c.name, reg, insch)
+		if _, ok := c.wtype.(LongType); ok {
+			hi := context.GetReg(insch)
+			insch <- &LDRInstr{LoadInstr{reg: hi,
+				value: &RegisterLoadOperand{value: 4, reg: reg}}}
+			insch <- &STRInstr{StoreInstr{reg: hi,
+				value: &RegStoreOffsetOperand{reg: target, offset: offsets[i] + 4}}}
+			context.FreeReg(hi, insch)
+		}
+		insch <- &LDRInstr{LoadInstr{reg: reg,
+			value: &RegisterLoadOperand{reg: reg}}}
+		// closures are never freed so the captured objects stay alive
+		if context.refCount && refCounted(c.wtype) {
+			context.Retain(reg, insch)
+		}
+		insch <- &STRInstr{StoreInstr{reg: reg,
+			value: &RegStoreOffsetOperand{reg: target, offset: offsets[i]}}}
+	}
+
+	context.FreeReg(reg, insch)
+}
+
 //CodeGen generates code for IntLiteral
 // --> LDR target, =offset
 func (m *IntLiteral) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
@@ -1433,6 +3043,19 @@ func (m *IntLiteral) CodeGen(context *FunctionContext, target Reg, insch chan<-
 	insch <- &LDRInstr{LoadInstr{reg: target, value: loadValue}}
 }
 
+//CodeGen generates code for LongLiteral
+// --> [codeGenLongBox]
+func (m *LongLiteral) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	codeGenLongBox(m, context, target, insch)
+}
+
+//CodeGen generates code for FloatLiteral
+// --> LDR target, =bits
+func (m *FloatLiteral) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	loadValue := &ConstLoadOperand{int(int32(math.Float32bits(m.value)))}
+	insch <- &LDRInstr{LoadInstr{reg: target, value: loadValue}}
+}
+
 //CodeGen generates code for BoolLiteralTrue
 // --> MOV target, 1
 func (m *BoolLiteralTrue) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
@@ -1466,27 +3089,59 @@ func (m *EnumLiteral) CodeGen(context *FunctionContext, target Reg, insch chan<-
 }
 
 //CodeGen generates code for PairLiteral
-// --> LDR r0, =8
+// Longs take two words so the second element follows at offset 4 or 8
+// --> LDR r0, =size
 // --> BL malloc
 // --> MOV target, r0
 // --> [Codegen fst] << reg
+// --> [BL p_rc_retain] if fst is reference counted
 // --> STR reg, [target]
 // --> [Codegen snd] << reg
-// --> STR reg, [target, #4]
+// --> [BL p_rc_retain] if snd is reference counted
+// --> STR reg, [target, #offset]
+// --> {long}: [codeGenStoreLong elem]
 func (m *PairLiteral) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
 	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
 	context.PushStack(4)
 
-	insch <- &LDRInstr{LoadInstr{reg: r0, value: &ConstLoadOperand{8}}}
-	insch <- &BLInstr{BInstr{label: mMalloc}}
+	elems := []Expression{m.fst, m.snd}
+	offsets, size := layout([]Type{m.fst.Type(), m.snd.Type()}, 0)
+
+	fst := refCounted(m.fst.Type())
+	snd := refCounted(m.snd.Type())
+	var release string
+	switch {
+	case fst && snd:
+		release = mReleasePairLbl
+	case fst:
+		release = mReleaseFstLbl
+	case snd && offsets[1] == 8:
+		release = mReleaseSndWideLbl
+	case snd:
+		release = mReleaseSndLbl
+	}
+	if release != "" && context.refCount {
+		context.builtInFuncs.Use(release)
+		context.builtInFuncs.Use(mReleaseLbl)
+	}
+
+	context.Malloc(size, release, insch)
 	//target cointains address of newpair
 	insch <- &MOVInstr{dest: target, source: resReg}
 	elemReg := context.GetReg(insch)
-	m.fst.CodeGen(context, elemReg, insch)
-	insch <- &STRInstr{StoreInstr{reg: elemReg, value: &RegStoreOperand{target}}}
-	m.snd.CodeGen(context, elemReg, insch)
-	insch <- &STRInstr{StoreInstr{reg: elemReg,
-		value: &RegStoreOffsetOperand{reg: target, offset: 4}}}
+	for i, elem := range elems {
+		if _, ok := elem.Type().(LongType); ok {
+			codeGenStoreLong(elem, context, target, offsets[i], insch)
+			continue
+		}
+
+		elem.CodeGen(context, elemReg, insch)
+		if context.refCount && refCounted(elem.Type()) {
+			context.Retain(elemReg, insch)
+		}
+		insch <- &STRInstr{StoreInstr{reg: elemReg,
+			value: &RegStoreOffsetOperand{reg: target, offset: offsets[i]}}}
+	}
 	context.FreeReg(elemReg, insch)
 
 	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
@@ -1508,13 +3163,48 @@ func (m *NullPair) CodeGen(context *FunctionContext, target Reg, insch chan<- In
 // --> BL p_check_array_bounds
 // --> ADD target, target, #4
 // --> ADD target, target, [reg, LSL 2]
+// --> {long[]}: ADD target, target, [reg, LSL 3]
+// --> {map}: MOV r0, target
+// -->        MOV r1, reg
+// -->        BL p_map_lookup
+// -->        MOV target, r0
 // --> LDR target, [target]
+// --> {long}: [codeGenLongBox]
 func (m *ArrayElem) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
-	arrayHelper(m.ident, m.indexes, context, target, insch)
+	if _, ok := m.Type().(LongType); ok {
+		codeGenLongBox(m, context, target, insch)
+		return
+	}
+
+	arrayHelper(m.ident, m.indexes, m.indexed, false, context, target, insch)
 
 	insch <- &LDRInstr{LoadInstr{reg: target, value: &RegisterLoadOperand{reg: target}}}
 }
 
+//CodeGen generates code for MapHas
+// --> [CodeGen expr] << target
+// --> [codeGenMapKey key] << reg
+// --> MOV r0, target
+// --> MOV r1, reg
+// --> BL p_map_has
+// --> MOV target, r0
+func (m *MapHas) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	useMapRoutines(context, m.expr.Type())
+
+	m.expr.CodeGen(context, target, insch)
+
+	keyReg := context.GetReg(insch)
+	box := codeGenMapKey(m.key, context, keyReg, insch)
+
+	insch <- &MOVInstr{dest: r0, source: target}
+	insch <- &MOVInstr{dest: r1, source: keyReg}
+	insch <- &BLInstr{BInstr{label: mMapHasLbl}}
+	insch <- &MOVInstr{dest: target, source: resReg}
+	popMapKey(box, context, insch)
+
+	context.FreeReg(keyReg, insch)
+}
+
 //------------------------------------------------------------------------------
 // UNARY OPERATOR CODEGEN
 //------------------------------------------------------------------------------
@@ -1529,7 +3219,22 @@ func (m *UnaryOperatorNot) CodeGen(context *FunctionContext, target Reg, insch c
 //CodeGen generates code for UnaryOperatorNegate
 // --> NEGS target, target
 // --> BL p_throw_overflow_error
+// Floats are negated by flipping their sign bit
+// --> {float}: EOR target, target, #2147483648
+// --> {long}: [codeGenLongBox]
 func (m *UnaryOperatorNegate) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	if _, ok := m.Type().(FloatType); ok {
+		m.expr.CodeGen(context, target, insch)
+		insch <- &EORInstr{BaseBinaryInstr{dest: target, lhs: target,
+			rhs: ImmediateOperand{1 << 31}}}
+		return
+	}
+
+	if _, ok := m.Type().(LongType); ok {
+		codeGenLongBox(m, context, target, insch)
+		return
+	}
+
 	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
 	context.PushStack(4)
 
@@ -1545,6 +3250,13 @@ func (m *UnaryOperatorNegate) CodeGen(context *FunctionContext, target Reg, insc
 	context.PopStack(4)
 }
 
+//CodeGen generates code for UnaryOperatorBitNot
+// --> MVN target, target
+func (m *UnaryOperatorBitNot) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	m.expr.CodeGen(context, target, insch)
+	insch <- &MVNInstr{BaseUnaryInstr{arg: target, dest: target}}
+}
+
 //CodeGen generates code for UnaryOperatorLen
 // --> [CodeGen expr]
 // --> LDR target, [target]
@@ -1567,70 +3279,561 @@ func (m *UnaryOperatorChr) CodeGen(context *FunctionContext, target Reg, insch c
 	m.expr.CodeGen(context, target, insch)
 }
 
-//------------------------------------------------------------------------------
-// BINARY OPERATOR CODEGEN
-//------------------------------------------------------------------------------
+//CodeGen generates code for UnaryOperatorLong
+// --> [codeGenLongBox]
+func (m *UnaryOperatorLong) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	codeGenLongBox(m, context, target, insch)
+}
 
-//CodeGen generates code for BinaryOperatorMult
-// If LHS.Weight > RHS.Weight LHS is executed first
-// otherwise RHS is executed first
-//CodeGen generates code for BinaryOperatorMult
-// If LHS.Weight > RHS.Weight LHS is executed first
-// otherwise RHS is executed first
-// --> [CodeGen exprLHS] < target
-// --> [CodeGen exprRHS] < target2
-// --> SMULL target, target2, target, target2
-// --> CMP target2, target, ASR #31
+//CodeGen generates code for UnaryOperatorFloat
+// --> [CodeGen expr]
+// --> [codeGenFloatConversion F32 S32]
+func (m *UnaryOperatorFloat) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	m.expr.CodeGen(context, target, insch)
+	codeGenFloatConversion("F32", "S32", "__aeabi_i2f", context, target, insch)
+}
+
+//CodeGen generates code for UnaryOperatorInt
+// Floats are truncated towards zero, saturating when they are out of range
+// --> {float}: [CodeGen expr]
+// --> {float}: [codeGenFloatConversion S32 F32]
+// Of longs the high word has to be the sign extension of the low word
+// --> PUSH {ip}
+// --> [codeGenLong expr] << target, reg
+// --> CMP reg, target, ASR #31
 // --> BLNE p_throw_overflow_error
-func (m *BinaryOperatorMult) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+// --> POP {ip}
+func (m *UnaryOperatorInt) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	if _, ok := m.expr.Type().(FloatType); ok {
+		m.expr.CodeGen(context, target, insch)
+		codeGenFloatConversion("S32", "F32", "__aeabi_f2iz", context, target,
+			insch)
+		return
+	}
+
 	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
 	context.PushStack(4)
 
-	target2 := binaryOperatorSimple(m.GetRHS(), m.GetLHS(), context, target, insch)
-	binaryInstrMul := &SMULLInstr{RdLo: target, RdHi: target2, Rm: target,
-		Rs: target2}
-
 	context.builtInFuncs.Use(mOverflowLbl)
 	context.builtInFuncs.Use(mThrowRuntimeErr)
 
-	context.FreeReg(target2, insch)
-	insch <- binaryInstrMul
+	reg := context.GetReg(insch)
 
-	insch <- &CMPInstr{BaseComparisonInstr: BaseComparisonInstr{lhs: target2,
+	codeGenLong(m.expr, context, target, reg, insch)
+	insch <- &CMPInstr{BaseComparisonInstr: BaseComparisonInstr{lhs: reg,
 		rhs: &RegisterOperand{reg: target, shift: shiftASR, amount: 31}}}
-
 	insch <- &BLInstr{BInstr: BInstr{cond: condNE, label: mOverflowLbl}}
 
+	context.FreeReg(reg, insch)
+
 	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
 	context.PopStack(4)
 }
 
-//CodeGen generates code for BinaryOperatorDiv
-//CodeGen generates code for BinaryOperatorDiv
-// If LHS.Weight > RHS.Weight LHS is executed first
-// otherwise RHS is executed first
+//------------------------------------------------------------------------------
+// BINARY OPERATOR CODEGEN
+//------------------------------------------------------------------------------
+
+// codeGenOverload is a helper function for CodeGen over the binary operators
+// An operator overloaded by the class of the LHS is lowered into a call of the
+// method with the LHS as the object and the RHS as the argument
+// It returns false if the operator is not overloaded
+// --> PUSH {ip}
 // --> [CodeGen exprLHS] < target
-// --> [CodeGen exprRHS] < target2
-// --> MOV r0, lhsResult
-// --> MOV r1, rhsResult
-// --> BL p_check_divide_by_zero
-// --> BL __aeabi_idiv
+// --> MOV r0, target
+// --> BL p_check_null_pointer
+// --> PUSH {target}
+// --> [CodeGen exprRHS] < target
+// --> PUSH {target}
+// --> POP {r1}
+// --> POP {r0}
+// --> {long}: [codeGenLong exprRHS] < target, reg
+// --> {long}: PUSH {reg}
+// --> {long}: PUSH {target}
+// --> {long}: POP {r1}
+// --> {long}: POP {r2}
+// --> {long}: POP {r0}
+// --> [Call method through the virtual table]
 // --> MOV target, r0
-func (m *BinaryOperatorDiv) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+// --> POP {ip}
+func codeGenOverload(m BinaryOperator, context *FunctionContext, target Reg, insch chan<- Instr) bool {
+	call := m.GetOverload()
+	if call == nil {
+		return false
+	}
+
 	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
 	context.PushStack(4)
 
-	lhs := m.GetLHS()
-	rhs := m.GetRHS()
-	var target2 Reg
-	var rhsResult Reg
-	lhsResult := target
-	if lhs.Weight() > rhs.Weight() {
-		lhs.CodeGen(context, target, insch)
-		target2 = context.GetReg(insch)
-		rhs.CodeGen(context, target2, insch)
-		rhsResult = target2
-	} else {
+	context.builtInFuncs.Use(mNullReferenceLbl)
+	context.builtInFuncs.Use(mThrowRuntimeErr)
+
+	m.GetLHS().CodeGen(context, target, insch)
+	insch <- &MOVInstr{dest: r0, source: target}
+	insch <- &BLInstr{BInstr{label: mNullReferenceLbl}}
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{target}}}
+	context.PushStack(4)
+
+	args := []Reg{r1}
+	if _, ok := m.GetRHS().Type().(LongType); ok {
+		// the long is passed in the words following the object
+		hi := context.GetReg(insch)
+		codeGenLong(m.GetRHS(), context, target, hi, insch)
+		insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{hi}}}
+		context.PushStack(4)
+		context.FreeReg(hi, insch)
+		args = append(args, r2)
+	} else {
+		m.GetRHS().CodeGen(context, target, insch)
+	}
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{target}}}
+	context.PushStack(4)
+
+	for _, arg := range append(args, r0) {
+		insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{arg}}}
+	}
+
+	call.codeGenBranch(context, insch)
+
+	insch <- &MOVInstr{dest: target, source: resReg}
+	context.PopStack(4 + len(args)*4)
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PopStack(4)
+
+	return true
+}
+
+// useLongRoutine adds the routine operating on longs to the assembly code along
+// with the routines it calls
+func useLongRoutine(context *FunctionContext, routine string) {
+	for _, r := range []string{routine, mLongOverflowLbl, mThrowRuntimeErr} {
+		context.builtInFuncs.Use(r)
+	}
+
+	switch routine {
+	case mLongDivLbl, mLongModLbl:
+		context.builtInFuncs.Use(mDivideByZeroLbl)
+	}
+}
+
+// LongGenerator is implemented by the expressions generating longs into a pair
+// of registers without boxing them
+type LongGenerator interface {
+	CodeGenLong(*FunctionContext, Reg, Reg, chan<- Instr)
+}
+
+// codeGenLong generates the long the expression evaluates to into lo and hi
+// Expressions only generating boxes have theirs unboxed and freed
+// --> [CodeGenLong expr] << lo, hi
+// --> {box}: [CodeGen expr] << lo
+// --> {box}: [unboxFreshLong lo, hi]
+func codeGenLong(m Expression, context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	if l, ok := m.(LongGenerator); ok {
+		l.CodeGenLong(context, lo, hi, insch)
+		return
+	}
+
+	m.CodeGen(context, lo, insch)
+	unboxFreshLong(context, lo, hi, insch)
+}
+
+// codeGenLongRHS generates the long the right hand side evaluates to into lo
+// and hi
+func codeGenLongRHS(m RHS, context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	switch rhs := m.(type) {
+	case *ExpressionRHS:
+		codeGenLong(rhs.expr, context, lo, hi, insch)
+	case LongGenerator:
+		rhs.CodeGenLong(context, lo, hi, insch)
+	default:
+		m.CodeGen(context, lo, insch)
+		unboxFreshLong(context, lo, hi, insch)
+	}
+}
+
+// codeGenStoreLong stores the long the expression evaluates to in the two words
+// at the given offset from base
+// --> [codeGenLong expr] << lo, hi
+// --> STR lo, [base, #offset]
+// --> STR hi, [base, #offset+4]
+func codeGenStoreLong(m Expression, context *FunctionContext, base Reg, offset int, insch chan<- Instr) {
+	lo := context.GetReg(insch)
+	hi := context.GetReg(insch)
+
+	codeGenLong(m, context, lo, hi, insch)
+	insch <- &STRInstr{StoreInstr{reg: lo,
+		value: &RegStoreOffsetOperand{reg: base, offset: offset}}}
+	insch <- &STRInstr{StoreInstr{reg: hi,
+		value: &RegStoreOffsetOperand{reg: base, offset: offset + 4}}}
+
+	context.FreeReg(hi, insch)
+	context.FreeReg(lo, insch)
+}
+
+// codeGenLongBox boxes the long the expression evaluates to in a new box,
+// which is how longs are generated where a single word is expected
+// --> [codeGenLong expr] << target, reg
+// --> [boxLong target, reg]
+func codeGenLongBox(m Expression, context *FunctionContext, target Reg, insch chan<- Instr) {
+	reg := context.GetReg(insch)
+	codeGenLong(m, context, target, reg, insch)
+	boxLong(context, target, reg, insch)
+	context.FreeReg(reg, insch)
+}
+
+// boxLong boxes the long in lo and hi into lo
+// --> PUSH {ip}
+// --> MOV r0, lo
+// --> MOV r1, hi
+// --> BL p_long_box
+// --> MOV lo, r0
+// --> POP {ip}
+func boxLong(context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	context.builtInFuncs.Use(mLongBoxLbl)
+
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PushStack(4)
+
+	insch <- &MOVInstr{dest: r0, source: lo}
+	insch <- &MOVInstr{dest: r1, source: hi}
+	insch <- &BLInstr{BInstr: BInstr{label: mLongBoxLbl}}
+	insch <- &MOVInstr{dest: lo, source: resReg}
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PopStack(4)
+}
+
+// unboxLong loads the long in the box in lo into lo and hi
+// --> LDR hi, [lo, #8]
+// --> LDR lo, [lo, #4]
+func unboxLong(lo, hi Reg, insch chan<- Instr) {
+	insch <- &LDRInstr{LoadInstr{reg: hi,
+		value: &RegisterLoadOperand{value: 8, reg: lo}}}
+	insch <- &LDRInstr{LoadInstr{reg: lo,
+		value: &RegisterLoadOperand{value: 4, reg: lo}}}
+}
+
+// unboxFreshLong loads the long in the box in lo, which nothing else refers to,
+// into lo and hi and frees the box
+// --> PUSH {ip}
+// --> MOV r0, lo
+// --> [unboxLong lo, hi]
+// --> BL free
+// --> POP {ip}
+func unboxFreshLong(context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PushStack(4)
+
+	insch <- &MOVInstr{dest: r0, source: lo}
+	unboxLong(lo, hi, insch)
+	insch <- &BLInstr{BInstr: BInstr{label: mFreeLabel}}
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PopStack(4)
+}
+
+// codeGenLongOperands is a helper function for CodeGenLong over the binary
+// operators on longs. It returns the pairs of registers holding the results of
+// the LHS and the RHS, the one which is not lo and hi has to be freed by the
+// caller high word first
+// If LHS.Weight > RHS.Weight LHS is executed first
+// otherwise RHS is executed first
+// --> [codeGenLong exprLHS] << lo, hi
+// --> [codeGenLong exprRHS] << lo2, hi2
+func codeGenLongOperands(m BinaryOperator, context *FunctionContext, lo, hi Reg, insch chan<- Instr) (lhsResult, rhsResult, pair2 [2]Reg) {
+	first, second := m.GetRHS(), m.GetLHS()
+	if m.GetLHS().Weight() > m.GetRHS().Weight() {
+		first, second = second, first
+	}
+
+	codeGenLong(first, context, lo, hi, insch)
+	pair2 = [2]Reg{context.GetReg(insch), context.GetReg(insch)}
+	codeGenLong(second, context, pair2[0], pair2[1], insch)
+
+	if first == m.GetLHS() {
+		return [2]Reg{lo, hi}, pair2, pair2
+	}
+	return pair2, [2]Reg{lo, hi}, pair2
+}
+
+// codeGenLongRoutine is a helper function for CodeGenLong over the binary
+// operators on longs calling a routine of the runtime. The operands are passed
+// to the routine in r0 and r1 and in r2 and r3 and the result is returned in
+// r0 and r1
+// --> [codeGenLongOperands]
+// --> MOV r0, lhsLo
+// --> MOV r1, lhsHi
+// --> MOV r2, rhsLo
+// --> MOV r3, rhsHi
+// --> BL routine
+// --> MOV lo, r0
+// --> MOV hi, r1
+func codeGenLongRoutine(m BinaryOperator, routine string, context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	lhsResult, rhsResult, pair2 := codeGenLongOperands(m, context, lo, hi,
+		insch)
+
+	for i, reg := range append(lhsResult[:], rhsResult[:]...) {
+		insch <- &MOVInstr{dest: argRegs[i], source: reg}
+	}
+	context.FreeReg(pair2[1], insch)
+	context.FreeReg(pair2[0], insch)
+
+	insch <- &BLInstr{BInstr: BInstr{label: routine}}
+	insch <- &MOVInstr{dest: lo, source: r0}
+	insch <- &MOVInstr{dest: hi, source: r1}
+}
+
+// codeGenOperands is a helper function for CodeGen over the binary operators
+// It returns the registers holding the results of the LHS and the RHS, the
+// one which is not the target has to be freed by the caller
+// If LHS.Weight > RHS.Weight LHS is executed first
+// otherwise RHS is executed first
+// --> [CodeGen exprLHS] < target
+// --> [CodeGen exprRHS] < target2
+func codeGenOperands(m BinaryOperator, context *FunctionContext, target Reg, insch chan<- Instr) (lhsResult, rhsResult, target2 Reg) {
+	lhs := m.GetLHS()
+	rhs := m.GetRHS()
+	if lhs.Weight() > rhs.Weight() {
+		lhs.CodeGen(context, target, insch)
+		target2 = context.GetReg(insch)
+		rhs.CodeGen(context, target2, insch)
+		return target, target2, target2
+	}
+
+	rhs.CodeGen(context, target, insch)
+	target2 = context.GetReg(insch)
+	lhs.CodeGen(context, target2, insch)
+	return target2, target, target2
+}
+
+// codeGenRoutine is a helper function for CodeGen over the binary operators on
+// longs and soft floats. The operands are passed to the routine which returns
+// the result
+// --> PUSH {ip}
+// --> [CodeGen operands]
+// --> MOV r0, lhsResult
+// --> MOV r1, rhsResult
+// --> BL routine
+// --> MOV target, r0
+// --> POP {ip}
+func codeGenRoutine(m BinaryOperator, routine string, context *FunctionContext, target Reg, insch chan<- Instr) {
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PushStack(4)
+
+	lhsResult, rhsResult, target2 := codeGenOperands(m, context, target, insch)
+
+	insch <- &MOVInstr{dest: r0, source: lhsResult}
+	insch <- &MOVInstr{dest: r1, source: rhsResult}
+	insch <- &BLInstr{BInstr: BInstr{label: routine}}
+	insch <- &MOVInstr{dest: target, source: resReg}
+	context.FreeReg(target2, insch)
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PopStack(4)
+}
+
+// codeGenFloat is a helper function for CodeGen over the arithmetic operators
+// on floats. The VFP carries out the operation when it is targeted, otherwise
+// the soft-float routine of the runtime library is called
+// --> {vfp}: [CodeGen operands]
+// --> {vfp}: VMOV s0, lhsResult
+// --> {vfp}: VMOV s1, rhsResult
+// --> {vfp}: [instr] s0, s0, s1
+// --> {vfp}: VMOV target, s0
+// --> {soft}: [codeGenRoutine routine]
+func codeGenFloat(m BinaryOperator, routine string, instr Instr, context *FunctionContext, target Reg, insch chan<- Instr) {
+	if !context.vfp {
+		codeGenRoutine(m, routine, context, target, insch)
+		return
+	}
+
+	lhsResult, rhsResult, target2 := codeGenOperands(m, context, target, insch)
+
+	insch <- &VMOVInstr{dest: s0, source: lhsResult}
+	insch <- &VMOVInstr{dest: s1, source: rhsResult}
+	context.FreeReg(target2, insch)
+	insch <- instr
+	insch <- &VMOVInstr{dest: target, source: s0}
+}
+
+// softFloatCmps maps the conditions to the soft-float routines returning
+// whether they hold, not equal negates the result of the equality
+var softFloatCmps = map[int]string{
+	condEQ: "__aeabi_fcmpeq",
+	condNE: "__aeabi_fcmpeq",
+	condGE: "__aeabi_fcmpge",
+	condLT: "__aeabi_fcmplt",
+	condGT: "__aeabi_fcmpgt",
+	condLE: "__aeabi_fcmple",
+}
+
+// vfpCmpConds maps the conditions which hold after a VCMP of unordered floats,
+// when either of them is NaN, to the ones which do not
+var vfpCmpConds = map[int]int{
+	condLT: condMI,
+	condLE: condLS,
+}
+
+// codeGenFloatComparator is a helper function for CodeGen over the comparators
+// on floats
+// --> {vfp}: [CodeGen operands]
+// --> {vfp}: VMOV s0, lhsResult
+// --> {vfp}: VMOV s1, rhsResult
+// --> {vfp}: VCMP.F32 s0, s1
+// --> {vfp}: VMRS APSR_nzcv, FPSCR
+// --> {vfp}: MOV(COND) target, 1
+// --> {vfp}: MOV(NOT-COND) target, 0
+// --> {soft}: [codeGenRoutine __aeabi_fcmp(COND)]
+// --> {soft}: {NE}: EOR target, target, #1
+func codeGenFloatComparator(m BinaryOperator, context *FunctionContext, target Reg, insch chan<- Instr, condCode int) {
+	if !context.vfp {
+		codeGenRoutine(m, softFloatCmps[condCode], context, target, insch)
+		if condCode == condNE {
+			insch <- &NOTInstr{BaseUnaryInstr{arg: target, dest: target}}
+		}
+		return
+	}
+
+	lhsResult, rhsResult, target2 := codeGenOperands(m, context, target, insch)
+
+	insch <- &VMOVInstr{dest: s0, source: lhsResult}
+	insch <- &VMOVInstr{dest: s1, source: rhsResult}
+	context.FreeReg(target2, insch)
+	insch <- &VCMPInstr{lhs: s0, rhs: s1}
+	insch <- &VMRSInstr{}
+
+	cond := Cond(condCode)
+	if c, ok := vfpCmpConds[condCode]; ok {
+		cond = Cond(c)
+	}
+	insch <- &MOVInstr{cond: cond, dest: target, source: ImmediateOperand{1}}
+	insch <- &MOVInstr{cond: cond.getOpposite(), dest: target,
+		source: ImmediateOperand{0}}
+}
+
+// codeGenFloatConversion is a helper function for CodeGen over the conversions
+// between ints and floats of the value in target. The VFP converts the value
+// when it is targeted, otherwise the soft-float routine is called
+// --> {vfp}: VMOV s0, target
+// --> {vfp}: VCVT.to.from s0, s0
+// --> {vfp}: VMOV target, s0
+// --> {soft}: PUSH {ip}
+// --> {soft}: MOV r0, target
+// --> {soft}: BL routine
+// --> {soft}: MOV target, r0
+// --> {soft}: POP {ip}
+func codeGenFloatConversion(to, from, routine string, context *FunctionContext, target Reg, insch chan<- Instr) {
+	if context.vfp {
+		insch <- &VMOVInstr{dest: s0, source: target}
+		insch <- &VCVTInstr{to: to, from: from, dest: s0, source: s0}
+		insch <- &VMOVInstr{dest: target, source: s0}
+		return
+	}
+
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PushStack(4)
+
+	insch <- &MOVInstr{dest: r0, source: target}
+	insch <- &BLInstr{BInstr: BInstr{label: routine}}
+	insch <- &MOVInstr{dest: target, source: resReg}
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PopStack(4)
+}
+
+//CodeGen generates code for BinaryOperatorMult
+// If LHS.Weight > RHS.Weight LHS is executed first
+// otherwise RHS is executed first
+//CodeGen generates code for BinaryOperatorMult
+// If LHS.Weight > RHS.Weight LHS is executed first
+// otherwise RHS is executed first
+// --> [CodeGen exprLHS] < target
+// --> [CodeGen exprRHS] < target2
+// --> SMULL target, target2, target, target2
+// --> CMP target2, target, ASR #31
+// --> BLNE p_throw_overflow_error
+func (m *BinaryOperatorMult) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	if _, ok := m.Type().(LongType); ok {
+		codeGenLongBox(m, context, target, insch)
+		return
+	}
+
+	if codeGenOverload(m, context, target, insch) {
+		return
+	}
+
+	if _, ok := m.Type().(FloatType); ok {
+		codeGenFloat(m, "__aeabi_fmul",
+			&VMULInstr{BaseVFPInstr{dest: s0, lhs: s0, rhs: s1}},
+			context, target, insch)
+		return
+	}
+
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PushStack(4)
+
+	target2 := binaryOperatorSimple(m.GetRHS(), m.GetLHS(), context, target, insch)
+	binaryInstrMul := &SMULLInstr{RdLo: target, RdHi: target2, Rm: target,
+		Rs: target2}
+
+	context.builtInFuncs.Use(mOverflowLbl)
+	context.builtInFuncs.Use(mThrowRuntimeErr)
+
+	context.FreeReg(target2, insch)
+	insch <- binaryInstrMul
+
+	insch <- &CMPInstr{BaseComparisonInstr: BaseComparisonInstr{lhs: target2,
+		rhs: &RegisterOperand{reg: target, shift: shiftASR, amount: 31}}}
+
+	insch <- &BLInstr{BInstr: BInstr{cond: condNE, label: mOverflowLbl}}
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PopStack(4)
+}
+
+//CodeGen generates code for BinaryOperatorDiv
+//CodeGen generates code for BinaryOperatorDiv
+// If LHS.Weight > RHS.Weight LHS is executed first
+// otherwise RHS is executed first
+// --> [CodeGen exprLHS] < target
+// --> [CodeGen exprRHS] < target2
+// --> MOV r0, lhsResult
+// --> MOV r1, rhsResult
+// --> BL p_check_divide_by_zero
+// --> BL __aeabi_idiv
+// --> MOV target, r0
+func (m *BinaryOperatorDiv) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	if _, ok := m.Type().(LongType); ok {
+		codeGenLongBox(m, context, target, insch)
+		return
+	}
+
+	if codeGenOverload(m, context, target, insch) {
+		return
+	}
+
+	if _, ok := m.Type().(FloatType); ok {
+		codeGenFloat(m, "__aeabi_fdiv",
+			&VDIVInstr{BaseVFPInstr{dest: s0, lhs: s0, rhs: s1}},
+			context, target, insch)
+		return
+	}
+
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PushStack(4)
+
+	lhs := m.GetLHS()
+	rhs := m.GetRHS()
+	var target2 Reg
+	var rhsResult Reg
+	lhsResult := target
+	if lhs.Weight() > rhs.Weight() {
+		lhs.CodeGen(context, target, insch)
+		target2 = context.GetReg(insch)
+		rhs.CodeGen(context, target2, insch)
+		rhsResult = target2
+	} else {
 		rhs.CodeGen(context, target, insch)
 		target2 = context.GetReg(insch)
 		lhs.CodeGen(context, target2, insch)
@@ -1664,6 +3867,15 @@ func (m *BinaryOperatorDiv) CodeGen(context *FunctionContext, target Reg, insch
 // --> BL __aeabi_idivmod
 // --> MOV target, r1
 func (m *BinaryOperatorMod) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	if _, ok := m.Type().(LongType); ok {
+		codeGenLongBox(m, context, target, insch)
+		return
+	}
+
+	if codeGenOverload(m, context, target, insch) {
+		return
+	}
+
 	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
 	context.PushStack(4)
 
@@ -1709,6 +3921,22 @@ func (m *BinaryOperatorMod) CodeGen(context *FunctionContext, target Reg, insch
 // --> MOV r1, rhsResult
 // --> BLVS p_throw_overflow_error
 func (m *BinaryOperatorAdd) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	if _, ok := m.Type().(LongType); ok {
+		codeGenLongBox(m, context, target, insch)
+		return
+	}
+
+	if codeGenOverload(m, context, target, insch) {
+		return
+	}
+
+	if _, ok := m.Type().(FloatType); ok {
+		codeGenFloat(m, "__aeabi_fadd",
+			&VADDInstr{BaseVFPInstr{dest: s0, lhs: s0, rhs: s1}},
+			context, target, insch)
+		return
+	}
+
 	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
 	context.PushStack(4)
 
@@ -1742,6 +3970,22 @@ func (m *BinaryOperatorAdd) CodeGen(context *FunctionContext, target Reg, insch
 // --> SUB target, target, target2
 // --> BLVS p_throw_overflow_errorcode
 func (m *BinaryOperatorSub) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	if _, ok := m.Type().(LongType); ok {
+		codeGenLongBox(m, context, target, insch)
+		return
+	}
+
+	if codeGenOverload(m, context, target, insch) {
+		return
+	}
+
+	if _, ok := m.Type().(FloatType); ok {
+		codeGenFloat(m, "__aeabi_fsub",
+			&VSUBInstr{BaseVFPInstr{dest: s0, lhs: s0, rhs: s1}},
+			context, target, insch)
+		return
+	}
+
 	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
 	context.PushStack(4)
 
@@ -1784,6 +4028,30 @@ func (m *BinaryOperatorSub) CodeGen(context *FunctionContext, target Reg, insch
 // --> MOV(COND) target, 1
 // --> MOV(NOT-COND) target, 0
 func codeGenComparators(m BinaryOperator, context *FunctionContext, target Reg, insch chan<- Instr, condCode int) {
+	if codeGenOverload(m, context, target, insch) {
+		return
+	}
+
+	// longs are compared by the sign of the result of p_long_compare
+	if _, ok := m.GetLHS().Type().(LongType); ok {
+		context.builtInFuncs.Use(mLongCmpLbl)
+		reg := context.GetReg(insch)
+		codeGenLongRoutine(m, mLongCmpLbl, context, target, reg, insch)
+		context.FreeReg(reg, insch)
+		insch <- &CMPInstr{BaseComparisonInstr{lhs: target,
+			rhs: ImmediateOperand{0}}}
+		insch <- &MOVInstr{cond: Cond(condCode), dest: target,
+			source: ImmediateOperand{1}}
+		insch <- &MOVInstr{cond: Cond(condCode).getOpposite(), dest: target,
+			source: ImmediateOperand{0}}
+		return
+	}
+
+	if _, ok := m.GetLHS().Type().(FloatType); ok {
+		codeGenFloatComparator(m, context, target, insch, condCode)
+		return
+	}
+
 	lhs := m.GetLHS()
 	rhs := m.GetRHS()
 	var target2 Reg
@@ -1860,6 +4128,10 @@ func binaryOperatorSimple(rhs Expression, lhs Expression, context *FunctionConte
 }
 
 func codeGenAnd(m BinaryOperator, context *FunctionContext, target Reg, insch chan<- Instr) {
+	if codeGenOverload(m, context, target, insch) {
+		return
+	}
+
 	target2 := binaryOperatorSimple(m.GetRHS(), m.GetLHS(), context, target, insch)
 	binaryInstrAnd := &ANDInstr{BaseBinaryInstr{dest: target, lhs: target2,
 		rhs: target}}
@@ -1867,14 +4139,32 @@ func codeGenAnd(m BinaryOperator, context *FunctionContext, target Reg, insch ch
 	insch <- binaryInstrAnd
 }
 
+// codeGenShortCircuit generates code for the logical operators. The LHS is
+// always executed first, the RHS is skipped if the LHS already decides the
+// result, which is when the comparison of the LHS with false holds on cond
+func codeGenShortCircuit(m BinaryOperator, context *FunctionContext, target Reg, insch chan<- Instr, cond Cond) {
+	labelEnd := fmt.Sprintf("sc_end%s", context.GetUniqueLabelSuffix())
+
+	m.GetLHS().CodeGen(context, target, insch)
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: target,
+		rhs: &ImmediateOperand{0}}}
+	insch <- &BInstr{label: labelEnd, cond: cond}
+
+	m.GetRHS().CodeGen(context, target, insch)
+
+	insch <- &LABELInstr{ident: labelEnd}
+}
+
 //CodeGen generates code for BinaryOperatorAnd
-// If LHS.Weight > RHS.Weight LHS is executed first
-// otherwise RHS is executed first
+// The RHS is only executed if the LHS is true
 // --> [CodeGen exprLHS] < target
-// --> [CodeGen exprRHS] < target2
-// --> AND target, target2, target
+// --> CMP target, #0
+// --> BEQ sc_end
+// --> [CodeGen exprRHS] < target
+// --> sc_end:
 func (m *BinaryOperatorAnd) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
-	codeGenAnd(m, context, target, insch)
+	codeGenShortCircuit(m, context, target, insch, condEQ)
 }
 
 //CodeGen generates code for BinaryOperatorBitAnd
@@ -1888,6 +4178,10 @@ func (m *BinaryOperatorBitAnd) CodeGen(context *FunctionContext, target Reg, ins
 }
 
 func codeGenOr(m BinaryOperator, context *FunctionContext, target Reg, insch chan<- Instr) {
+	if codeGenOverload(m, context, target, insch) {
+		return
+	}
+
 	target2 := binaryOperatorSimple(m.GetRHS(), m.GetLHS(), context, target, insch)
 
 	binaryInstrOrr := &ORRInstr{BaseBinaryInstr{dest: target, lhs: target2,
@@ -1897,13 +4191,14 @@ func codeGenOr(m BinaryOperator, context *FunctionContext, target Reg, insch cha
 }
 
 //CodeGen generates code for BinaryOperatorOr
-// If LHS.Weight > RHS.Weight LHS is executed first
-// otherwise RHS is executed first
+// The RHS is only executed if the LHS is false
 // --> [CodeGen exprLHS] < target
-// --> [CodeGen exprRHS] < target2
-// --> ORR target, target2, target
+// --> CMP target, #0
+// --> BNE sc_end
+// --> [CodeGen exprRHS] < target
+// --> sc_end:
 func (m *BinaryOperatorOr) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
-	codeGenOr(m, context, target, insch)
+	codeGenShortCircuit(m, context, target, insch, condNE)
 }
 
 //CodeGen generates code for BinaryOperatorBitOr
@@ -1916,14 +4211,354 @@ func (m *BinaryOperatorBitOr) CodeGen(context *FunctionContext, target Reg, insc
 	codeGenOr(m, context, target, insch)
 }
 
-//CodeGen generates code for VoidExpr
-func (m *VoidExpr) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
-}
+//CodeGen generates code for BinaryOperatorBitXor
+// If LHS.Weight > RHS.Weight LHS is executed first
+// otherwise RHS is executed first
+// --> [CodeGen exprLHS] < target
+// --> [CodeGen exprRHS] < target2
+// --> EOR target, target2, target
+func (m *BinaryOperatorBitXor) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	if codeGenOverload(m, context, target, insch) {
+		return
+	}
+
+	target2 := binaryOperatorSimple(m.GetRHS(), m.GetLHS(), context, target, insch)
+	insch <- &EORInstr{BaseBinaryInstr{dest: target, lhs: target2,
+		rhs: target}}
+	context.FreeReg(target2, insch)
+}
+
+// codeGenShift is a helper function for CodeGen over the shift operators
+// The amount the LHS is shifted by is held in a register
+// If LHS.Weight > RHS.Weight LHS is executed first
+// otherwise RHS is executed first
+// --> [CodeGen exprLHS] < target
+// --> [CodeGen exprRHS] < target2
+// --> MOV target, target, shift target2
+func codeGenShift(m BinaryOperator, context *FunctionContext, target Reg, insch chan<- Instr, shift Shift) {
+	if codeGenOverload(m, context, target, insch) {
+		return
+	}
+
+	lhs := m.GetLHS()
+	rhs := m.GetRHS()
+	var target2 Reg
+	var operand *RegisterOperand
+	if lhs.Weight() > rhs.Weight() {
+		lhs.CodeGen(context, target, insch)
+		target2 = context.GetReg(insch)
+		rhs.CodeGen(context, target2, insch)
+		operand = &RegisterOperand{reg: target, shift: shift, by: target2}
+	} else {
+		rhs.CodeGen(context, target, insch)
+		target2 = context.GetReg(insch)
+		lhs.CodeGen(context, target2, insch)
+		operand = &RegisterOperand{reg: target2, shift: shift, by: target}
+	}
+
+	insch <- &MOVInstr{dest: target, source: operand}
+
+	context.FreeReg(target2, insch)
+}
+
+//CodeGen generates code for BinaryOperatorShiftLeft
+//Calls codeGenShift helper function
+func (m *BinaryOperatorShiftLeft) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	codeGenShift(m, context, target, insch, shiftLSL)
+}
+
+//CodeGen generates code for BinaryOperatorShiftRight
+//Calls codeGenShift helper function
+func (m *BinaryOperatorShiftRight) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	codeGenShift(m, context, target, insch, shiftASR)
+}
+
+//CodeGen generates code for BinaryOperatorShiftRightLogical
+//Calls codeGenShift helper function
+func (m *BinaryOperatorShiftRightLogical) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	codeGenShift(m, context, target, insch, shiftLSR)
+}
+
+//CodeGen generates code for TernaryExpr
+// Only the branch selected by the condition is executed
+// --> [CodeGen cond] << target
+// --> CMP target, #0
+// --> BEQ ternary_else
+// --> [CodeGen then] << target
+// --> B ternary_end
+// --> ternary_else:
+// --> [CodeGen else] << target
+// --> ternary_end:
+func (m *TernaryExpr) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+	suffix := context.GetUniqueLabelSuffix()
+	labelElse := fmt.Sprintf("ternary_else%s", suffix)
+	labelEnd := fmt.Sprintf("ternary_end%s", suffix)
+
+	m.cond.CodeGen(context, target, insch)
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: target,
+		rhs: &ImmediateOperand{0}}}
+	insch <- &BInstr{label: labelElse, cond: condEQ}
+
+	m.then.CodeGen(context, target, insch)
+	insch <- &BInstr{label: labelEnd}
+
+	insch <- &LABELInstr{ident: labelElse}
+	m.elseExpr.CodeGen(context, target, insch)
+
+	insch <- &LABELInstr{ident: labelEnd}
+}
+
+//CodeGen generates code for VoidExpr
+func (m *VoidExpr) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
+}
 
 //CodeGen generates code for ExprParen
 func (m *ExprParen) CodeGen(context *FunctionContext, target Reg, insch chan<- Instr) {
 }
 
+//------------------------------------------------------------------------------
+// LONG CODEGEN
+//------------------------------------------------------------------------------
+//
+// Longs are computed in pairs of registers, lo holding the low word and hi the
+// high word. They are stored in two words, the low word first, on the stack, in
+// the data segment and on the heap alike, and passed to and returned from
+// functions in two words. They are only boxed where a single word is expected
+
+//CodeGenLong generates code for Ident
+// Longs are held in two words wherever the variable is stored
+// --> ADD lo, sp, #offset
+// --> LDR hi, [lo, #4]
+// --> LDR lo, [lo]
+func (m *Ident) CodeGenLong(context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	if m.global != nil {
+		label := &BasicLoadOperand{m.global.Label()}
+		insch <- &LDRInstr{LoadInstr{reg: lo, value: label}}
+	} else {
+		context.ResolveVarToRegister(m.ident, lo, insch)
+	}
+	insch <- &LDRInstr{LoadInstr{reg: hi,
+		value: &RegisterLoadOperand{value: 4, reg: lo}}}
+	insch <- &LDRInstr{LoadInstr{reg: lo, value: &RegisterLoadOperand{reg: lo}}}
+}
+
+//CodeGenLong generates code for LongLiteral
+// --> LDR lo, =low
+// --> LDR hi, =high
+func (m *LongLiteral) CodeGenLong(context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	insch <- &LDRInstr{LoadInstr{reg: lo,
+		value: &ConstLoadOperand{int(int32(m.value))}}}
+	insch <- &LDRInstr{LoadInstr{reg: hi,
+		value: &ConstLoadOperand{int(int32(m.value >> 32))}}}
+}
+
+//CodeGenLong generates code for ArrayElem holding a long in two words
+// --> [arrayHelper] << lo
+// --> LDR hi, [lo, #4]
+// --> LDR lo, [lo]
+func (m *ArrayElem) CodeGenLong(context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	arrayHelper(m.ident, m.indexes, m.indexed, false, context, lo, insch)
+
+	insch <- &LDRInstr{LoadInstr{reg: hi,
+		value: &RegisterLoadOperand{value: 4, reg: lo}}}
+	insch <- &LDRInstr{LoadInstr{reg: lo, value: &RegisterLoadOperand{reg: lo}}}
+}
+
+//CodeGenLong generates code for PairElemRHS holding a long in two words
+// --> MOV r0, lo
+// --> BL pi_check_null_pointer
+// --> LDR hi, [lo, #offset+4]
+// --> LDR lo, [lo, #offset]
+func (m *PairElemRHS) CodeGenLong(context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PushStack(4)
+
+	pairElem(m.expr, context, lo, insch)
+
+	offset := 0
+
+	if m.snd {
+		offset = sndOffset(m.expr.Type())
+	}
+
+	insch <- &LDRInstr{LoadInstr{reg: hi,
+		value: &RegisterLoadOperand{reg: lo, value: offset + 4}}}
+	insch <- &LDRInstr{LoadInstr{reg: lo,
+		value: &RegisterLoadOperand{reg: lo, value: offset}}}
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip}}}
+	context.PopStack(4)
+}
+
+//CodeGenLong generates code for FunctionCallRHS returning a long in r0 and r1
+// --> [codeGenCall]
+// --> MOV lo, r0
+// --> MOV hi, r1
+func (m *FunctionCallRHS) CodeGenLong(context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	m.codeGenCall(context, insch)
+
+	insch <- &MOVInstr{dest: lo, source: r0}
+	insch <- &MOVInstr{dest: hi, source: r1}
+}
+
+//CodeGenLong generates code for UnaryOperatorNegate
+// --> [codeGenLong expr] << lo, hi
+// --> RSBS lo, lo, #0
+// --> RSCS hi, hi, #0
+// --> BLVS p_throw_long_overflow_error
+func (m *UnaryOperatorNegate) CodeGenLong(context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	context.builtInFuncs.Use(mLongOverflowLbl)
+	context.builtInFuncs.Use(mThrowRuntimeErr)
+
+	codeGenLong(m.expr, context, lo, hi, insch)
+
+	insch <- &RSBInstr{BaseBinaryInstr{dest: lo, lhs: lo,
+		rhs: ImmediateOperand{0}}}
+	insch <- &RSCInstr{BaseBinaryInstr{dest: hi, lhs: hi,
+		rhs: ImmediateOperand{0}}}
+	insch <- &BLInstr{BInstr{cond: condVS, label: mLongOverflowLbl}}
+}
+
+//CodeGenLong generates code for UnaryOperatorLong
+// --> [CodeGen expr] << lo
+// --> MOV hi, lo, ASR #31
+func (m *UnaryOperatorLong) CodeGenLong(context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	m.expr.CodeGen(context, lo, insch)
+	insch <- &MOVInstr{dest: hi,
+		source: &RegisterOperand{reg: lo, shift: shiftASR, amount: 31}}
+}
+
+// codeGenOverloadLong is a helper function for CodeGenLong over the binary
+// operators. The long returned by an operator overloaded by the class of the
+// LHS is taken from r0 and r1
+// It returns false if the operator is not overloaded
+// --> [codeGenOverload] << lo
+// --> MOV hi, r1
+func codeGenOverloadLong(m BinaryOperator, context *FunctionContext, lo, hi Reg, insch chan<- Instr) bool {
+	if !codeGenOverload(m, context, lo, insch) {
+		return false
+	}
+
+	insch <- &MOVInstr{dest: hi, source: r1}
+
+	return true
+}
+
+//CodeGenLong generates code for BinaryOperatorMult
+// --> [codeGenLongRoutine p_long_multiply]
+func (m *BinaryOperatorMult) CodeGenLong(context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	if codeGenOverloadLong(m, context, lo, hi, insch) {
+		return
+	}
+
+	useLongRoutine(context, mLongMulLbl)
+	codeGenLongRoutine(m, mLongMulLbl, context, lo, hi, insch)
+}
+
+//CodeGenLong generates code for BinaryOperatorDiv
+// --> [codeGenLongRoutine p_long_divide]
+func (m *BinaryOperatorDiv) CodeGenLong(context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	if codeGenOverloadLong(m, context, lo, hi, insch) {
+		return
+	}
+
+	useLongRoutine(context, mLongDivLbl)
+	codeGenLongRoutine(m, mLongDivLbl, context, lo, hi, insch)
+}
+
+//CodeGenLong generates code for BinaryOperatorMod
+// --> [codeGenLongRoutine p_long_modulo]
+func (m *BinaryOperatorMod) CodeGenLong(context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	if codeGenOverloadLong(m, context, lo, hi, insch) {
+		return
+	}
+
+	useLongRoutine(context, mLongModLbl)
+	codeGenLongRoutine(m, mLongModLbl, context, lo, hi, insch)
+}
+
+//CodeGenLong generates code for BinaryOperatorAdd
+// --> [codeGenLongOperands]
+// --> ADDS lo, lhsLo, rhsLo
+// --> ADCS hi, lhsHi, rhsHi
+// --> BLVS p_throw_long_overflow_error
+func (m *BinaryOperatorAdd) CodeGenLong(context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	if codeGenOverloadLong(m, context, lo, hi, insch) {
+		return
+	}
+
+	context.builtInFuncs.Use(mLongOverflowLbl)
+	context.builtInFuncs.Use(mThrowRuntimeErr)
+
+	lhsResult, rhsResult, pair2 := codeGenLongOperands(m, context, lo, hi,
+		insch)
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: lo, lhs: lhsResult[0],
+		rhs: rhsResult[0]}}
+	insch <- &ADCInstr{BaseBinaryInstr{dest: hi, lhs: lhsResult[1],
+		rhs: rhsResult[1]}}
+	insch <- &BLInstr{BInstr{cond: condVS, label: mLongOverflowLbl}}
+
+	context.FreeReg(pair2[1], insch)
+	context.FreeReg(pair2[0], insch)
+}
+
+//CodeGenLong generates code for BinaryOperatorSub
+// --> [codeGenLongOperands]
+// --> SUBS lo, lhsLo, rhsLo
+// --> SBCS hi, lhsHi, rhsHi
+// --> BLVS p_throw_long_overflow_error
+func (m *BinaryOperatorSub) CodeGenLong(context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	if codeGenOverloadLong(m, context, lo, hi, insch) {
+		return
+	}
+
+	context.builtInFuncs.Use(mLongOverflowLbl)
+	context.builtInFuncs.Use(mThrowRuntimeErr)
+
+	lhsResult, rhsResult, pair2 := codeGenLongOperands(m, context, lo, hi,
+		insch)
+
+	insch <- &SUBInstr{BaseBinaryInstr{dest: lo, lhs: lhsResult[0],
+		rhs: rhsResult[0]}}
+	insch <- &SBCInstr{BaseBinaryInstr{dest: hi, lhs: lhsResult[1],
+		rhs: rhsResult[1]}}
+	insch <- &BLInstr{BInstr{cond: condVS, label: mLongOverflowLbl}}
+
+	context.FreeReg(pair2[1], insch)
+	context.FreeReg(pair2[0], insch)
+}
+
+//CodeGenLong generates code for TernaryExpr
+// --> [CodeGen cond] << lo
+// --> CMP lo, #0
+// --> BEQ ternary_else
+// --> [codeGenLong then] << lo, hi
+// --> B ternary_end
+// --> ternary_else:
+// --> [codeGenLong else] << lo, hi
+// --> ternary_end:
+func (m *TernaryExpr) CodeGenLong(context *FunctionContext, lo, hi Reg, insch chan<- Instr) {
+	suffix := context.GetUniqueLabelSuffix()
+	labelElse := fmt.Sprintf("ternary_else%s", suffix)
+	labelEnd := fmt.Sprintf("ternary_end%s", suffix)
+
+	m.cond.CodeGen(context, lo, insch)
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: lo,
+		rhs: &ImmediateOperand{0}}}
+	insch <- &BInstr{label: labelElse, cond: condEQ}
+
+	codeGenLong(m.then, context, lo, hi, insch)
+	insch <- &BInstr{label: labelEnd}
+
+	insch <- &LABELInstr{ident: labelElse}
+	codeGenLong(m.elseExpr, context, lo, hi, insch)
+
+	insch <- &LABELInstr{ident: labelEnd}
+}
+
 //------------------------------------------------------------------------------
 // WEIGHT FUNCTIONS
 //------------------------------------------------------------------------------
@@ -1933,11 +4568,26 @@ func (m *Ident) Weight() int {
 	return 1
 }
 
+//Weight returns weight of Lambda
+func (m *Lambda) Weight() int {
+	return 2
+}
+
 //Weight returns weight of IntLiteral
 func (m *IntLiteral) Weight() int {
 	return 1
 }
 
+//Weight returns weight of LongLiteral
+func (m *LongLiteral) Weight() int {
+	return 1
+}
+
+//Weight returns weight of FloatLiteral
+func (m *FloatLiteral) Weight() int {
+	return 1
+}
+
 //Weight returns weight of IntLiteral
 func (m *EnumLiteral) Weight() int {
 	return 1
@@ -1992,6 +4642,15 @@ func (m *ArrayElem) Weight() int {
 	return m.weightCache
 }
 
+//Weight returns weight of MapHas
+func (m *MapHas) Weight() int {
+	if m.weightCache > 0 {
+		return m.weightCache
+	}
+	m.weightCache = maxWeight(m.expr.Weight(), m.key.Weight()+1)
+	return m.weightCache
+}
+
 //Weight returns weight of all UnaryOperators
 func (m *UnaryOperatorBase) Weight() int {
 	if m.weightCache > 0 {
@@ -2026,6 +4685,37 @@ func (m *BinaryOperatorBase) Weight() int {
 	return m.weightCache
 }
 
+//Weight returns weight of the logical operators. Both operands are evaluated
+// into the same register one after the other
+func (m *BinaryOperatorAnd) Weight() int {
+	if m.weightCache > 0 {
+		return m.weightCache
+	}
+	m.weightCache = maxWeight(m.lhs.Weight(), m.rhs.Weight())
+	return m.weightCache
+}
+
+//Weight returns weight of the logical operators. Both operands are evaluated
+// into the same register one after the other
+func (m *BinaryOperatorOr) Weight() int {
+	if m.weightCache > 0 {
+		return m.weightCache
+	}
+	m.weightCache = maxWeight(m.lhs.Weight(), m.rhs.Weight())
+	return m.weightCache
+}
+
+//Weight returns weight of TernaryExpr. The condition and the branches are
+// evaluated into the same register one after the other
+func (m *TernaryExpr) Weight() int {
+	if m.weightCache > 0 {
+		return m.weightCache
+	}
+	m.weightCache = maxWeight(m.cond.Weight(),
+		maxWeight(m.then.Weight(), m.elseExpr.Weight()))
+	return m.weightCache
+}
+
 //Weight returns weight of VoidExpr
 func (m *VoidExpr) Weight() int {
 	return -1
@@ -2163,6 +4853,96 @@ func printInt(context *FunctionContext, insch chan<- Instr) {
 	insch <- &POPInstr{BaseStackInstr{regs: []Reg{pc}}}
 }
 
+//printLong generates code to print the long in r0 and r1
+// p_print_long:
+// -->	PUSH {lr}
+// -->	MOV r2, r0
+// -->	MOV r3, r1
+// -->	LDR r0, =msg_0
+// -->	ADDS r0, r0, #4
+// -->	BL printf
+// -->	MOV r0, #0
+// -->	BL fflush
+// -->	POP {pc}
+func printLong(context *FunctionContext, insch chan<- Instr) {
+	msg := context.stringPool.Lookup8(mPrintLong)
+
+	insch <- &LABELInstr{mPrintLongLabel}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{lr}}}
+
+	// a 64 bit argument is passed in an even pair of registers
+	insch <- &MOVInstr{dest: r2, source: r0}
+
+	insch <- &MOVInstr{dest: r3, source: r1}
+
+	insch <- &LDRInstr{LoadInstr{reg: r0,
+		value: &BasicLoadOperand{value: msg}}}
+
+	insch <- &ADDInstr{BaseBinaryInstr: BaseBinaryInstr{dest: r0, lhs: r0,
+		rhs: ImmediateOperand{n: 4}}}
+
+	insch <- &BLInstr{BInstr{label: mPrintf}}
+
+	insch <- &MOVInstr{dest: r0, source: &ImmediateOperand{n: 0}}
+
+	insch <- &BLInstr{BInstr{label: mFFlush}}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{pc}}}
+}
+
+//printFloat generates code to print the float in r0, printf takes it as a
+//double in an even pair of registers
+// p_print_float:
+// -->	PUSH {lr}
+// -->	{vfp}: VMOV s0, r0
+// -->	{vfp}: VCVT.F64.F32 d0, s0
+// -->	{vfp}: VMOV r2, r3, d0
+// -->	{soft}: BL __aeabi_f2d
+// -->	{soft}: MOV r2, r0
+// -->	{soft}: MOV r3, r1
+// -->	LDR r0, =msg_0
+// -->	ADDS r0, r0, #4
+// -->	BL printf
+// -->	MOV r0, #0
+// -->	BL fflush
+// -->	POP {pc}
+func printFloat(context *FunctionContext, insch chan<- Instr) {
+	msg := context.stringPool.Lookup8(mPrintFloat)
+
+	insch <- &LABELInstr{mPrintFloatLabel}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{lr}}}
+
+	if context.vfp {
+		insch <- &VMOVInstr{dest: s0, source: r0}
+
+		insch <- &VCVTInstr{to: "F64", from: "F32", dest: d0, source: s0}
+
+		insch <- &VMOVPairInstr{RdLo: r2, RdHi: r3, Dm: d0}
+	} else {
+		insch <- &BLInstr{BInstr{label: "__aeabi_f2d"}}
+
+		insch <- &MOVInstr{dest: r2, source: r0}
+
+		insch <- &MOVInstr{dest: r3, source: r1}
+	}
+
+	insch <- &LDRInstr{LoadInstr{reg: r0,
+		value: &BasicLoadOperand{value: msg}}}
+
+	insch <- &ADDInstr{BaseBinaryInstr: BaseBinaryInstr{dest: r0, lhs: r0,
+		rhs: ImmediateOperand{n: 4}}}
+
+	insch <- &BLInstr{BInstr{label: mPrintf}}
+
+	insch <- &MOVInstr{dest: r0, source: &ImmediateOperand{n: 0}}
+
+	insch <- &BLInstr{BInstr{label: mFFlush}}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{pc}}}
+}
+
 //printChar code to print a given char
 // p_print_char:
 // -->	PUSH {lr}
@@ -2281,6 +5061,35 @@ func readInt(context *FunctionContext, insch chan<- Instr) {
 	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{pc}}}
 }
 
+//readFloat code to read a given float
+// p_read_float:
+// -->	PUSH {lr}
+// -->	MOV r1, r0
+// -->	LDR r0, =msg_7
+// -->	ADDS r0, r0, #4
+// -->	BL scanf
+// -->	POP {pc}
+func readFloat(context *FunctionContext, insch chan<- Instr) {
+	msg := context.stringPool.Lookup8(mPrintFloat)
+
+	insch <- &LABELInstr{mReadFloatLabel}
+
+	insch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{lr}}}
+
+	insch <- &MOVInstr{dest: r1, source: r0}
+
+	insch <- &LDRInstr{LoadInstr: LoadInstr{reg: r0,
+		value: &BasicLoadOperand{value: msg}}}
+
+	insch <- &ADDInstr{
+		BaseBinaryInstr: BaseBinaryInstr{dest: r0, lhs: r0,
+			rhs: &ImmediateOperand{n: 4}}}
+
+	insch <- &BLInstr{BInstr: BInstr{label: mScanf}}
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{pc}}}
+}
+
 //readChar code to read a given char
 // p_read_char:
 // -->	PUSH {lr}
@@ -2314,6 +5123,7 @@ func readChar(context *FunctionContext, insch chan<- Instr) {
 // -->	PUSH {lr}
 // -->	CMP r1, #0
 // -->	LDREQ r0, =msg_7
+// -->	MOVEQ r1, #-1 (if exceptions are used)
 // -->	BLEQ p_throw_runtime_error
 // -->	POP {pc}
 func checkDivideByZero(context *FunctionContext, insch chan<- Instr) {
@@ -2329,6 +5139,8 @@ func checkDivideByZero(context *FunctionContext, insch chan<- Instr) {
 	insch <- &LDRInstr{LoadInstr{reg: r0, cond: condEQ,
 		value: &BasicLoadOperand{value: msg}}}
 
+	exceptionCode(context, condEQ, exceptionDivideByZero, insch)
+
 	insch <- &BLInstr{BInstr: BInstr{cond: condEQ, label: mThrowRuntimeErr}}
 
 	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{pc}}}
@@ -2340,6 +5152,7 @@ func checkDivideByZero(context *FunctionContext, insch chan<- Instr) {
 // -->	PUSH {lr}
 // -->	CMP r0, #0
 // -->	LDREQ r0, =msg_8
+// -->	MOVEQ r1, #-2 (if exceptions are used)
 // -->	BLEQ p_throw_runtime_error
 // -->	POP {pc}
 func checkNullPointer(context *FunctionContext, insch chan<- Instr) {
@@ -2355,6 +5168,8 @@ func checkNullPointer(context *FunctionContext, insch chan<- Instr) {
 	insch <- &LDRInstr{LoadInstr: LoadInstr{reg: r0, cond: condEQ,
 		value: &BasicLoadOperand{value: msg}}}
 
+	exceptionCode(context, condEQ, exceptionNullReference, insch)
+
 	insch <- &BLInstr{BInstr: BInstr{cond: condEQ, label: mThrowRuntimeErr}}
 
 	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{pc}}}
@@ -2365,10 +5180,12 @@ func checkNullPointer(context *FunctionContext, insch chan<- Instr) {
 // -->	PUSH {lr}
 // -->	CMP r0, #0
 // -->	LDRLT r0, =msg_9
+// -->	MOVLT r1, #-3 (if exceptions are used)
 // -->	BLLT p_throw_runtime_error
 // -->	LDR r1, [r1]
 // -->	CMP r0, r1
 // -->	LDRCS r0, =msg_10
+// -->	MOVCS r1, #-3 (if exceptions are used)
 // -->	BLCS p_throw_runtime_error
 // -->	POP {pc}
 func checkArrayBounds(context *FunctionContext, insch chan<- Instr) {
@@ -2385,6 +5202,8 @@ func checkArrayBounds(context *FunctionContext, insch chan<- Instr) {
 	insch <- &LDRInstr{LoadInstr: LoadInstr{reg: r0, cond: condLT,
 		value: &BasicLoadOperand{value: msg0}}}
 
+	exceptionCode(context, condLT, exceptionArrayIndex, insch)
+
 	insch <- &BLInstr{BInstr: BInstr{cond: condLT, label: mThrowRuntimeErr}}
 
 	insch <- &LDRInstr{LoadInstr: LoadInstr{reg: r1,
@@ -2395,6 +5214,8 @@ func checkArrayBounds(context *FunctionContext, insch chan<- Instr) {
 	insch <- &LDRInstr{LoadInstr: LoadInstr{reg: r0, cond: condCS,
 		value: &BasicLoadOperand{value: msg1}}}
 
+	exceptionCode(context, condCS, exceptionArrayIndex, insch)
+
 	insch <- &BLInstr{BInstr: BInstr{cond: condCS, label: mThrowRuntimeErr}}
 
 	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{pc}}}
@@ -2403,6 +5224,7 @@ func checkArrayBounds(context *FunctionContext, insch chan<- Instr) {
 //checkOverflowUnderflow code to check if operation is in under/overflow
 // p_throw_overflow_error:
 // -->	LDR r0, =msg_11
+// -->	MOV r1, #-4 (if exceptions are used)
 // -->	BL p_throw_runtime_error
 func checkOverflowUnderflow(context *FunctionContext, insch chan<- Instr) {
 	msg := context.stringPool.Lookup8(mOverflowErr)
@@ -2413,213 +5235,1862 @@ func checkOverflowUnderflow(context *FunctionContext, insch chan<- Instr) {
 		LoadInstr: LoadInstr{reg: r0, value: &BasicLoadOperand{value: msg}},
 	}
 
+	exceptionCode(context, condAL, exceptionOverflow, insch)
+
 	insch <- &BLInstr{BInstr: BInstr{label: mThrowRuntimeErr}}
 }
 
-//throwRuntimeError throws a runtime error
-// p_throw_runtime_error:
-// -->	LDR r1, [r0]
-// -->	ADDS r2, r0, #4
+//checkLongOverflow code to raise an overflow of an operation on longs
+// p_throw_long_overflow_error:
 // -->	LDR r0, =msg_12
-// -->	ADDS r0, r0, #4
-// -->	BL printf
-// -->	MOV r0, #0
-// -->	BL fflush
-// -->	MOV r0, #-1
-// -->	BL exit
-func throwRuntimeError(context *FunctionContext, insch chan<- Instr) {
-	msg := context.stringPool.Lookup8(mPrintString)
+// -->	MOV r1, #-4 (if exceptions are used)
+// -->	BL p_throw_runtime_error
+func checkLongOverflow(context *FunctionContext, insch chan<- Instr) {
+	msg := context.stringPool.Lookup8(mLongOverflowErr)
 
-	insch <- &LABELInstr{ident: mThrowRuntimeErr}
+	insch <- &LABELInstr{ident: mLongOverflowLbl}
 
 	insch <- &LDRInstr{
-		LoadInstr: LoadInstr{reg: r1, value: &RegisterLoadOperand{reg: r0}}}
+		LoadInstr: LoadInstr{reg: r0, value: &BasicLoadOperand{value: msg}},
+	}
+
+	exceptionCode(context, condAL, exceptionOverflow, insch)
+
+	insch <- &BLInstr{BInstr: BInstr{label: mThrowRuntimeErr}}
+}
+
+//exceptionCode passes the exception code of a runtime error in r1 so that
+// it can be caught. Nothing is generated if the program never throws
+// -->	MOV(cond) r1, #code
+func exceptionCode(context *FunctionContext, cond Cond, code int,
+	insch chan<- Instr) {
+	if !context.builtInFuncs.IsUsed(mThrowExceptionLbl) {
+		return
+	}
+
+	insch <- &MOVInstr{cond: cond, dest: r1, source: &ImmediateOperand{n: code}}
+}
+
+//throwRuntimeError throws a runtime error
+// If exceptions are used the error is thrown as the exception code in r1
+// when a handler is installed, passing on its message in case it is not caught
+// p_throw_runtime_error:
+// -->	LDR r2, =p_exception_handler (if exceptions are used)
+// -->	LDR r2, [r2]
+// -->	CMP r2, #0
+// -->	MOVNE r2, r0
+// -->	MOVNE r0, r1
+// -->	MOVNE r1, r2
+// -->	BNE p_throw_exception
+// -->	LDR r1, [r0]
+// -->	ADDS r2, r0, #4
+// -->	LDR r0, =msg_12
+// -->	ADDS r0, r0, #4
+// -->	BL printf
+// -->	MOV r0, #0
+// -->	BL fflush
+// -->	MOV r0, #-1
+// -->	BL exit
+func throwRuntimeError(context *FunctionContext, insch chan<- Instr) {
+	msg := context.stringPool.Lookup8(mPrintString)
+
+	insch <- &LABELInstr{ident: mThrowRuntimeErr}
+
+	if context.builtInFuncs.IsUsed(mThrowExceptionLbl) {
+		insch <- &LDRInstr{LoadInstr: LoadInstr{reg: r2,
+			value: &BasicLoadOperand{value: mExceptionHandler}}}
+
+		insch <- &LDRInstr{
+			LoadInstr: LoadInstr{reg: r2, value: &RegisterLoadOperand{reg: r2}}}
+
+		insch <- &CMPInstr{BaseComparisonInstr: BaseComparisonInstr{lhs: r2,
+			rhs: &ImmediateOperand{n: 0}}}
+
+		insch <- &MOVInstr{cond: condNE, dest: r2, source: r0}
+
+		insch <- &MOVInstr{cond: condNE, dest: r0, source: r1}
+
+		insch <- &MOVInstr{cond: condNE, dest: r1, source: r2}
+
+		insch <- &BInstr{cond: condNE, label: mThrowExceptionLbl}
+	}
+
+	insch <- &LDRInstr{
+		LoadInstr: LoadInstr{reg: r1, value: &RegisterLoadOperand{reg: r0}}}
+
+	insch <- &ADDInstr{BaseBinaryInstr: BaseBinaryInstr{dest: r2, lhs: r0,
+		rhs: ImmediateOperand{n: 4}}}
+
+	insch <- &LDRInstr{
+		LoadInstr: LoadInstr{reg: r0, value: &BasicLoadOperand{value: msg}}}
+
+	insch <- &ADDInstr{BaseBinaryInstr: BaseBinaryInstr{dest: r0, lhs: r0,
+		rhs: ImmediateOperand{n: 4}}}
+
+	insch <- &BLInstr{BInstr: BInstr{label: mPrintf}}
+
+	insch <- &MOVInstr{dest: r0, source: &ImmediateOperand{n: 0}}
+
+	insch <- &BLInstr{BInstr: BInstr{label: mFFlush}}
+
+	insch <- &MOVInstr{dest: r0, source: &ImmediateOperand{n: -1}}
+
+	insch <- &BLInstr{BInstr: BInstr{label: mExitLabel}}
+}
+
+//throwException unwinds the stack to the innermost exception handler and
+// jumps to its catch body with the exception in r0. The message in r1 is
+// printed if no handler is left
+// p_throw_exception:
+// -->	LDR r3, =p_exception_handler
+// -->	LDR r2, [r3]
+// -->	CMP r2, #0
+// -->	MOVEQ r0, r1
+// -->	BEQ p_throw_runtime_error
+// -->	MOV sp, r2
+// -->	POP {r2}
+// -->	STR r2, [r3]
+// -->	POP {r2, ip}
+// -->	MOV pc, r2
+func throwException(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{ident: mThrowExceptionLbl}
+
+	insch <- &LDRInstr{LoadInstr: LoadInstr{reg: r3,
+		value: &BasicLoadOperand{value: mExceptionHandler}}}
+
+	insch <- &LDRInstr{
+		LoadInstr: LoadInstr{reg: r2, value: &RegisterLoadOperand{reg: r3}}}
+
+	insch <- &CMPInstr{BaseComparisonInstr: BaseComparisonInstr{lhs: r2,
+		rhs: &ImmediateOperand{n: 0}}}
+
+	insch <- &MOVInstr{cond: condEQ, dest: r0, source: r1}
+
+	insch <- &BInstr{cond: condEQ, label: mThrowRuntimeErr}
+
+	insch <- &MOVInstr{dest: sp, source: r2}
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r2}}}
+
+	insch <- &STRInstr{StoreInstr{reg: r2, value: &RegStoreOperand{r3}}}
+
+	insch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r2, ip}}}
+
+	insch <- &MOVInstr{dest: pc, source: r2}
+}
+
+//------------------------------------------------------------------------------
+// GENERAL CODEGEN UTILITY
+//------------------------------------------------------------------------------
+
+func codeGenBuiltin(strPool *StringPool, builtInFuncs *BuiltInFuncs, vfp bool, f func(*FunctionContext, chan<- Instr)) <-chan Instr {
+	ch := make(chan Instr)
+
+	context := CreateFunctionContext()
+	context.stringPool = strPool
+	context.builtInFuncs = builtInFuncs
+	context.vfp = vfp
+
+	go func() {
+		f(context, ch)
+		close(ch)
+	}()
+
+	return ch
+}
+
+// CodeGen generates instructions for functions
+func (m *FunctionDef) CodeGen(strPool *StringPool, builtInFuncs *BuiltInFuncs, globals map[string]*GlobalDef, refCount bool, exceptions bool, vfp bool) <-chan Instr {
+	ch := make(chan Instr)
+
+	go func() {
+		context := CreateFunctionContext()
+		context.stringPool = strPool
+		context.builtInFuncs = builtInFuncs
+		context.globals = globals
+		context.refCount = refCount
+		context.exceptions = exceptions
+		context.vfp = vfp
+
+		m.codeGen(context, ch)
+
+		close(ch)
+	}()
+
+	return ch
+}
+
+// codeGen generates the instructions of the function in the given context
+// Local functions are passed the frame they are defined in as a hidden last
+// parameter
+func (m *FunctionDef) codeGen(context *FunctionContext, ch chan<- Instr) {
+	context.fname = m.Symbol()
+
+	params := m.params
+	if m.depth > 0 {
+		link := &FunctionParam{name: "$link", wtype: IntType{}}
+		params = append(params[:len(params):len(params)], link)
+	}
+
+	// static methods are not called on an object
+	class := m.class
+	if m.static {
+		class = nil
+	}
+
+	ch <- &LABELInstr{m.Symbol()}
+
+	context.StartScope(ch)
+
+	// save previous pc for returning
+	ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip, lr}}}
+
+	if m.body == nil {
+		// return
+		if class == nil {
+			ch <- &MOVInstr{dest: resReg, source: ImmediateOperand{0}}
+		}
+		ch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip, pc}}}
+		return
+	}
+
+	// save callee saved registers
+	ch <- &PUSHInstr{
+		BaseStackInstr: BaseStackInstr{
+			regs: []Reg{r4, r5, r6, r7, r8, r9, r10, r11},
+		},
+	}
+
+	// put the first four words of the params on the stack
+	first := 0
+	if class != nil {
+		first = 1
+	}
+	var types []Type
+	for _, p := range params {
+		types = append(types, p.wtype)
+	}
+	words, end := argLayout(types, first)
+	pl := end - first
+
+	switch {
+	case pl >= 4 && class == nil:
+		ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r3}}}
+		fallthrough
+	case pl == 3 && class == nil:
+		ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r2}}}
+		fallthrough
+	case pl == 2 && class == nil:
+		ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r1}}}
+		fallthrough
+	case pl == 1 && class == nil:
+		ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r0}}}
+
+	case pl >= 3 && class != nil:
+		ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r3}}}
+		fallthrough
+	case pl == 2 && class != nil:
+		ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r2}}}
+		fallthrough
+	case pl == 1 && class != nil:
+		ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r1}}}
+	}
+
+	// set the addresses of the arguments relative to sp on the
+	// stack. The high word of a long follows its low word
+	for i, p := range params {
+		for j := 0; j < wordsOf(p.wtype); j++ {
+			ident := p.name
+			if j > 0 {
+				ident += "$high"
+			}
+			w := words[i] + j - first
+			switch {
+			case w < 4-first:
+				context.stack[0][ident] = w * -4
+			default:
+				context.stack[0][ident] = -4 + -4 + w*-4 + 8*-4
+			}
+		}
+	}
+
+	// if we are in a function put the this address in ip
+	if class != nil {
+		ch <- &MOVInstr{dest: ip, source: r0}
+		context.method = true
+	}
+
+	// if we are in a function set up the members
+	if class != nil {
+		offsets, _ := memberLayout(class)
+		for i, member := range class.Members() {
+			context.DeclareMember(member.ident, offsets[i])
+		}
+	}
+
+	// if we are in a lambda set up the captured variables
+	offsets, _ := captureLayout(m)
+	for i, capture := range m.captures {
+		context.DeclareCapture(capture.name, offsets[i])
+	}
+
+	// the objects passed as arguments are retained until returning
+	// so that the parameters can be assigned
+	for _, p := range params {
+		if !context.refCount || !refCounted(p.wtype) {
+			continue
+		}
+		reg := context.GetReg(ch)
+		ch <- &LDRInstr{LoadInstr{reg: reg, value: &RegisterLoadOperand{
+			reg: sp, value: context.ResolveVar(p.name)}}}
+		context.Retain(reg, ch)
+		context.FreeReg(reg, ch)
+		context.DeclareRef(p.name)
+	}
+
+	// an exception unwinding the function releases them as well, if it can
+	// be caught
+	if context.exceptions && len(context.refs) > 0 {
+		context.PushCleanup(ch)
+	}
+
+	context.StartScope(ch)
+
+	// codegen the function body
+	m.body.CodeGen(context, ch)
+
+	context.CleanupScope(ch)
+
+	// unlink the handler releasing the parameters
+	if context.cleanups[0] > 0 {
+		context.RestoreHandler(0, ch)
+		context.PopHandler()
+		ch <- &ADDInstr{BaseBinaryInstr: BaseBinaryInstr{dest: sp, lhs: sp,
+			rhs: ImmediateOperand{12}}}
+		context.PopStack(12)
+	}
+
+	// release the parameters
+	context.ReleaseRefs(math.MinInt32, ch)
+
+	// if the function has no return type then zero r0 before
+	// returning
+	switch m.returnType.(type) {
+	case VoidType:
+		if class == nil {
+			ch <- &MOVInstr{dest: resReg, source: ImmediateOperand{0}}
+		} else {
+			ch <- &MOVInstr{dest: resReg, source: ip}
+		}
+	}
+
+	ch <- &LABELInstr{fmt.Sprintf("%s_return", m.Symbol())}
+
+	// restore the stack from pushing first four parameters
+	if pl > 0 {
+		ppregs := pl * 4
+		if ppregs > 16 {
+			ppregs = 16
+		}
+		if ppregs > 12 && class != nil {
+			ppregs = 12
+		}
+		ch <- &ADDInstr{BaseBinaryInstr: BaseBinaryInstr{dest: sp, lhs: sp,
+			rhs: ImmediateOperand{ppregs}}}
+	}
+
+	// restore callee saved registers
+	ch <- &POPInstr{
+		BaseStackInstr: BaseStackInstr{
+			regs: []Reg{r4, r5, r6, r7, r8, r9, r10, r11},
+		},
+	}
+
+	// return
+	ch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip, pc}}}
+
+	// ensures literal pools for LDR are in range
+	ch <- &LTORGInstr{}
+
+}
+
+//findInterfaceTable finds the method table of the interface in ip for the
+//object in r0. The method table is returned in ip, all the other registers are
+//preserved. The interfaces of a class are terminated by a zero word
+// p_find_interface_table:
+// -->	PUSH {r0, r1, lr}
+// -->	LDR r0, [r0]
+// -->	LDR r0, [r0, #-4]
+// p_find_interface_table_loop:
+// -->	LDR r1, [r0]
+// -->	CMP r1, #0
+// -->	LDREQ r0, =msg_13
+// -->	MOVEQ r1, #-6 (if exceptions are used)
+// -->	BLEQ p_throw_runtime_error
+// -->	CMP r1, ip
+// -->	LDREQ ip, [r0, #4]
+// -->	BEQ p_find_interface_table_return
+// -->	ADDS r0, r0, #8
+// -->	B p_find_interface_table_loop
+// p_find_interface_table_return:
+// -->	POP {r0, r1, pc}
+func findInterfaceTable(context *FunctionContext, insch chan<- Instr) {
+	msg := context.stringPool.Lookup8(mMissingInterfaceErr)
+
+	insch <- &LABELInstr{mInterfaceTableLbl}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r0, r1, lr}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r0, value: &RegisterLoadOperand{reg: r0}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r0,
+		value: &RegisterLoadOperand{value: -4, reg: r0}}}
+
+	insch <- &LABELInstr{mInterfaceTableLoop}
+
+	insch <- &LDRInstr{LoadInstr{reg: r1, value: &RegisterLoadOperand{reg: r0}}}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r1, rhs: ImmediateOperand{0}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r0, cond: condEQ,
+		value: &BasicLoadOperand{value: msg}}}
+
+	exceptionCode(context, condEQ, exceptionMissingInterface, insch)
+
+	insch <- &BLInstr{BInstr{cond: condEQ, label: mThrowRuntimeErr}}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r1, rhs: ip}}
+
+	insch <- &LDRInstr{LoadInstr{reg: ip,
+		value: &RegisterLoadOperand{value: 4, reg: r0}, cond: condEQ}}
+
+	insch <- &BInstr{cond: condEQ, label: mInterfaceTableEnd}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r0, lhs: r0,
+		rhs: ImmediateOperand{8}}}
+
+	insch <- &BInstr{label: mInterfaceTableLoop}
+
+	insch <- &LABELInstr{mInterfaceTableEnd}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r0, r1, pc}}}
+}
+
+//retainObject increments the reference count stored before the object in r0.
+//Null references are ignored. Only r1 is modified
+// p_rc_retain:
+// -->	CMP r0, #0
+// -->	MOVEQ pc, lr
+// -->	LDR r1, [r0, #-8]
+// -->	ADDS r1, r1, #1
+// -->	STR r1, [r0, #-8]
+// -->	MOV pc, lr
+func retainObject(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mRetainLbl}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r0, rhs: &ImmediateOperand{0}}}
+
+	insch <- &MOVInstr{cond: condEQ, dest: pc, source: lr}
+
+	insch <- &LDRInstr{LoadInstr{reg: r1,
+		value: &RegisterLoadOperand{value: -8, reg: r0}}}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r1, lhs: r1,
+		rhs: ImmediateOperand{1}}}
+
+	insch <- &STRInstr{StoreInstr{reg: r1,
+		value: &RegStoreOffsetOperand{reg: r0, offset: -8}}}
+
+	insch <- &MOVInstr{dest: pc, source: lr}
+}
+
+//disownObject decrements the reference count stored before the object in r0
+//without freeing it. Null references are ignored. Only r1 is modified
+// p_rc_disown:
+// -->	CMP r0, #0
+// -->	MOVEQ pc, lr
+// -->	LDR r1, [r0, #-8]
+// -->	SUBS r1, r1, #1
+// -->	STR r1, [r0, #-8]
+// -->	MOV pc, lr
+func disownObject(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mDisownLbl}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r0, rhs: &ImmediateOperand{0}}}
+
+	insch <- &MOVInstr{cond: condEQ, dest: pc, source: lr}
+
+	insch <- &LDRInstr{LoadInstr{reg: r1,
+		value: &RegisterLoadOperand{value: -8, reg: r0}}}
+
+	insch <- &SUBInstr{BaseBinaryInstr{dest: r1, lhs: r1,
+		rhs: ImmediateOperand{1}}}
+
+	insch <- &STRInstr{StoreInstr{reg: r1,
+		value: &RegStoreOffsetOperand{reg: r0, offset: -8}}}
+
+	insch <- &MOVInstr{dest: pc, source: lr}
+}
+
+//releaseObject decrements the reference count stored before the object in r0.
+//When no references are left the routine stored after the count releases the
+//objects it holds and the object is freed. Null references are ignored
+// p_rc_release:
+// -->	CMP r0, #0
+// -->	MOVEQ pc, lr
+// -->	LDR r1, [r0, #-8]
+// -->	SUBS r1, r1, #1
+// -->	STR r1, [r0, #-8]
+// -->	MOVGT pc, lr
+// -->	PUSH {r0, lr}
+// -->	LDR r1, [r0, #-4]
+// -->	CMP r1, #0
+// -->	BLXNE r1
+// -->	POP {r0}
+// -->	SUBS r0, r0, #8
+// -->	BL free
+// -->	POP {pc}
+func releaseObject(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mReleaseLbl}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r0, rhs: &ImmediateOperand{0}}}
+
+	insch <- &MOVInstr{cond: condEQ, dest: pc, source: lr}
+
+	insch <- &LDRInstr{LoadInstr{reg: r1,
+		value: &RegisterLoadOperand{value: -8, reg: r0}}}
+
+	insch <- &SUBInstr{BaseBinaryInstr{dest: r1, lhs: r1,
+		rhs: ImmediateOperand{1}}}
+
+	insch <- &STRInstr{StoreInstr{reg: r1,
+		value: &RegStoreOffsetOperand{reg: r0, offset: -8}}}
+
+	insch <- &MOVInstr{cond: condGT, dest: pc, source: lr}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r0, lr}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r1,
+		value: &RegisterLoadOperand{value: -4, reg: r0}}}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r1, rhs: &ImmediateOperand{0}}}
+
+	insch <- &BLXInstr{cond: condNE, reg: r1}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r0}}}
+
+	insch <- &SUBInstr{BaseBinaryInstr{dest: r0, lhs: r0,
+		rhs: ImmediateOperand{8}}}
+
+	insch <- &BLInstr{BInstr{label: mFreeLabel}}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{pc}}}
+}
+
+//releasePairElems releases the elements of the pair in r0 selected by the
+//offsets
+// --> PUSH {r4, lr}
+// --> MOV r4, r0
+// --> LDR r0, [r4, #offset]
+// --> BL p_rc_release
+// --> POP {r4, pc}
+func releasePairElems(label string, offsets []int, insch chan<- Instr) {
+	insch <- &LABELInstr{label}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r4, lr}}}
+
+	insch <- &MOVInstr{dest: r4, source: r0}
+
+	for _, offset := range offsets {
+		insch <- &LDRInstr{LoadInstr{reg: r0,
+			value: &RegisterLoadOperand{value: offset, reg: r4}}}
+		insch <- &BLInstr{BInstr{label: mReleaseLbl}}
+	}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r4, pc}}}
+}
+
+//releaseFst releases the first element of the pair in r0
+func releaseFst(context *FunctionContext, insch chan<- Instr) {
+	releasePairElems(mReleaseFstLbl, []int{0}, insch)
+}
+
+//releaseSnd releases the second element of the pair in r0
+func releaseSnd(context *FunctionContext, insch chan<- Instr) {
+	releasePairElems(mReleaseSndLbl, []int{4}, insch)
+}
+
+//releaseSndWide releases the second element of the pair in r0 following a
+//long held in two words
+func releaseSndWide(context *FunctionContext, insch chan<- Instr) {
+	releasePairElems(mReleaseSndWideLbl, []int{8}, insch)
+}
+
+//releasePair releases both elements of the pair in r0
+func releasePair(context *FunctionContext, insch chan<- Instr) {
+	releasePairElems(mReleasePairLbl, []int{0, 4}, insch)
+}
+
+//releaseElems releases the elements of the array in r0, the last one first
+// p_rc_release_elems:
+// -->	PUSH {r4, r5, lr}
+// -->	MOV r4, r0
+// -->	LDR r5, [r4]
+// p_rc_release_elems_loop:
+// -->	CMP r5, #0
+// -->	BEQ p_rc_release_elems_return
+// -->	ADDS r0, r4, r5, LSL #2
+// -->	LDR r0, [r0]
+// -->	BL p_rc_release
+// -->	SUBS r5, r5, #1
+// -->	B p_rc_release_elems_loop
+// p_rc_release_elems_return:
+// -->	POP {r4, r5, pc}
+func releaseElems(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mReleaseElemsLbl}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r4, r5, lr}}}
+
+	insch <- &MOVInstr{dest: r4, source: r0}
+
+	insch <- &LDRInstr{LoadInstr{reg: r5, value: &RegisterLoadOperand{reg: r4}}}
+
+	insch <- &LABELInstr{mReleaseElemsLoop}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r5, rhs: &ImmediateOperand{0}}}
+
+	insch <- &BInstr{cond: condEQ, label: mReleaseElemsEnd}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r0, lhs: r4,
+		rhs: &RegisterOperand{reg: r5, shift: shiftLSL, amount: 2}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r0, value: &RegisterLoadOperand{reg: r0}}}
+
+	insch <- &BLInstr{BInstr{label: mReleaseLbl}}
+
+	insch <- &SUBInstr{BaseBinaryInstr{dest: r5, lhs: r5,
+		rhs: ImmediateOperand{1}}}
+
+	insch <- &BInstr{label: mReleaseElemsLoop}
+
+	insch <- &LABELInstr{mReleaseElemsEnd}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r4, r5, pc}}}
+}
+
+//releaseMembers calls the destructor of the instance of the class in r0 and
+//releases the objects held by its members
+// <class>_release:
+// -->	PUSH {r4, lr}
+// -->	MOV r4, r0
+// -->	[BLX fini] if the class has a destructor
+// -->	LDR r0, [r4, #offset]
+// -->	BL p_rc_release
+// -->	POP {r4, pc}
+func releaseMembers(c *ClassType, context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{c.ReleaseLabel()}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r4, lr}}}
+
+	insch <- &MOVInstr{dest: r4, source: r0}
+
+	if slot, ok := c.Destructor(); ok {
+		insch <- &LDRInstr{LoadInstr{reg: ip,
+			value: &RegisterLoadOperand{reg: r0}}}
+		insch <- &LDRInstr{LoadInstr{reg: ip,
+			value: &RegisterLoadOperand{value: slot * 4, reg: ip}}}
+		insch <- &BLXInstr{reg: ip}
+	}
+
+	offsets, _ := memberLayout(c)
+	for i, member := range c.Members() {
+		if !refCounted(member.wtype) {
+			continue
+		}
+		context.builtInFuncs.Use(mReleaseLbl)
+		insch <- &LDRInstr{LoadInstr{reg: r0,
+			value: &RegisterLoadOperand{value: offsets[i], reg: r4}}}
+		insch <- &BLInstr{BInstr{label: mReleaseLbl}}
+	}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r4, pc}}}
+}
+
+//releaseMap releases the keys and values held by the map in r0 if they are
+//reference counted and frees its nodes and buckets
+// p_rc_release_map:
+// -->	PUSH {r4, r5, r6, r7, lr}
+// -->	MOV r4, r0
+// -->	LDR r5, [r4, #4]
+// p_rc_release_map_bucket:
+// -->	SUBS r5, r5, #1
+// -->	BLT p_rc_release_map_return
+// -->	LDR r0, [r4, #8]
+// -->	ADDS r0, r0, r5, LSL #2
+// -->	LDR r6, [r0]
+// p_rc_release_map_node:
+// -->	CMP r6, #0
+// -->	BEQ p_rc_release_map_bucket
+// -->	LDR r1, [r4, #12]
+// -->	TST r1, #2
+// -->	LDRNE r0, [r6]
+// -->	BLNE p_rc_release
+// -->	LDR r1, [r4, #12]
+// -->	TST r1, #4
+// -->	LDRNE r0, [r6, #8]
+// -->	BLNE p_rc_release
+// -->	LDR r1, [r4, #12] (if long keys are used)
+// -->	TST r1, #8
+// -->	LDRNE r0, [r6]
+// -->	BLNE free
+// -->	LDR r7, [r6, #4]
+// -->	MOV r0, r6
+// -->	BL free
+// -->	MOV r6, r7
+// -->	B p_rc_release_map_node
+// p_rc_release_map_return:
+// -->	LDR r0, [r4, #8]
+// -->	BL free
+// -->	POP {r4, r5, r6, r7, pc}
+func releaseMap(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mReleaseMapLbl}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r4, r5, r6, r7, lr}}}
+
+	insch <- &MOVInstr{dest: r4, source: r0}
+
+	insch <- &LDRInstr{LoadInstr{reg: r5,
+		value: &RegisterLoadOperand{value: 4, reg: r4}}}
+
+	insch <- &LABELInstr{mReleaseMapBucket}
+
+	insch <- &SUBInstr{BaseBinaryInstr{dest: r5, lhs: r5,
+		rhs: ImmediateOperand{1}}}
+
+	insch <- &BInstr{cond: condLT, label: mReleaseMapEnd}
+
+	insch <- &LDRInstr{LoadInstr{reg: r0,
+		value: &RegisterLoadOperand{value: 8, reg: r4}}}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r0, lhs: r0,
+		rhs: &RegisterOperand{reg: r5, shift: shiftLSL, amount: 2}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r6, value: &RegisterLoadOperand{reg: r0}}}
+
+	insch <- &LABELInstr{mReleaseMapNode}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r6, rhs: &ImmediateOperand{0}}}
+
+	insch <- &BInstr{cond: condEQ, label: mReleaseMapBucket}
+
+	for _, entry := range []struct{ flag, offset int }{{2, 0}, {4, 8}} {
+		insch <- &LDRInstr{LoadInstr{reg: r1,
+			value: &RegisterLoadOperand{value: 12, reg: r4}}}
+		insch <- &TSTInstr{BaseComparisonInstr{lhs: r1,
+			rhs: &ImmediateOperand{entry.flag}}}
+		insch <- &LDRInstr{LoadInstr{reg: r0, cond: condNE,
+			value: &RegisterLoadOperand{value: entry.offset, reg: r6}}}
+		insch <- &BLInstr{BInstr{cond: condNE, label: mReleaseLbl}}
+	}
+
+	if context.builtInFuncs.IsUsed(mLongBoxLbl) {
+		freeLongKey(r6, insch)
+	}
+
+	insch <- &LDRInstr{LoadInstr{reg: r7,
+		value: &RegisterLoadOperand{value: 4, reg: r6}}}
+
+	insch <- &MOVInstr{dest: r0, source: r6}
+
+	insch <- &BLInstr{BInstr{label: mFreeLabel}}
+
+	insch <- &MOVInstr{dest: r6, source: r7}
+
+	insch <- &BInstr{label: mReleaseMapNode}
+
+	insch <- &LABELInstr{mReleaseMapEnd}
+
+	insch <- &LDRInstr{LoadInstr{reg: r0,
+		value: &RegisterLoadOperand{value: 8, reg: r4}}}
+
+	insch <- &BLInstr{BInstr{label: mFreeLabel}}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r4, r5, r6, r7, pc}}}
+}
+
+//------------------------------------------------------------------------------
+// MAP RUNTIME
+//------------------------------------------------------------------------------
+//
+// A map is a hash table chaining its entries in buckets. The map holds
+// [count] [number of buckets] [buckets] [flags]
+// and each of its nodes holds
+// [key] [next node] [value] [high word of a long value]
+// The flags are described by mapFlags
+
+// mapBuckets is the number of buckets of a new map. The number of buckets is
+// always a power of two so that the bucket of a key is selected by a mask
+const mapBuckets = 8
+
+//mapInit initialises the map in r0 with the flags in r1 and empty buckets
+// p_map_init:
+// -->	PUSH {r4, lr}
+// -->	MOV r4, r0
+// -->	STR r1, [r4, #12]
+// -->	MOV r1, #0
+// -->	STR r1, [r4]
+// -->	MOV r1, #8
+// -->	STR r1, [r4, #4]
+// -->	MOV r0, #8
+// -->	BL p_map_buckets
+// -->	STR r0, [r4, #8]
+// -->	MOV r0, r4
+// -->	POP {r4, pc}
+func mapInit(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mMapInitLbl}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r4, lr}}}
+
+	insch <- &MOVInstr{dest: r4, source: r0}
+
+	insch <- &STRInstr{StoreInstr{reg: r1,
+		value: &RegStoreOffsetOperand{reg: r4, offset: 12}}}
+
+	insch <- &MOVInstr{dest: r1, source: ImmediateOperand{0}}
+
+	insch <- &STRInstr{StoreInstr{reg: r1, value: &RegStoreOperand{r4}}}
+
+	insch <- &MOVInstr{dest: r1, source: ImmediateOperand{mapBuckets}}
+
+	insch <- &STRInstr{StoreInstr{reg: r1,
+		value: &RegStoreOffsetOperand{reg: r4, offset: 4}}}
+
+	insch <- &MOVInstr{dest: r0, source: ImmediateOperand{mapBuckets}}
+
+	insch <- &BLInstr{BInstr{label: mMapBucketsLbl}}
+
+	insch <- &STRInstr{StoreInstr{reg: r0,
+		value: &RegStoreOffsetOperand{reg: r4, offset: 8}}}
+
+	insch <- &MOVInstr{dest: r0, source: r4}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r4, pc}}}
+}
+
+//mapBucketsAlloc allocates as many empty buckets as given in r0
+// p_map_buckets:
+// -->	PUSH {r4, ip, lr}
+// -->	MOV r4, r0
+// -->	MOV r0, r0, LSL #2
+// -->	BL malloc
+// -->	MOV r1, #0
+// p_map_buckets_loop:
+// -->	SUBS r4, r4, #1
+// -->	BLT p_map_buckets_return
+// -->	ADDS r2, r0, r4, LSL #2
+// -->	STR r1, [r2]
+// -->	B p_map_buckets_loop
+// p_map_buckets_return:
+// -->	POP {r4, ip, pc}
+func mapBucketsAlloc(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mMapBucketsLbl}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r4, ip, lr}}}
+
+	insch <- &MOVInstr{dest: r4, source: r0}
+
+	insch <- &MOVInstr{dest: r0,
+		source: &RegisterOperand{reg: r0, shift: shiftLSL, amount: 2}}
+
+	insch <- &BLInstr{BInstr{label: mMalloc}}
+
+	insch <- &MOVInstr{dest: r1, source: ImmediateOperand{0}}
+
+	insch <- &LABELInstr{mMapBucketsLoop}
+
+	insch <- &SUBInstr{BaseBinaryInstr{dest: r4, lhs: r4,
+		rhs: ImmediateOperand{1}}}
+
+	insch <- &BInstr{cond: condLT, label: mMapBucketsEnd}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r2, lhs: r0,
+		rhs: &RegisterOperand{reg: r4, shift: shiftLSL, amount: 2}}}
+
+	insch <- &STRInstr{StoreInstr{reg: r1, value: &RegStoreOperand{r2}}}
+
+	insch <- &BInstr{label: mMapBucketsLoop}
+
+	insch <- &LABELInstr{mMapBucketsEnd}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r4, ip, pc}}}
+}
+
+//mapBucket returns the address of the bucket of the map in r0 holding the key
+//in r1. Strings are hashed by their characters, other keys by their value
+// p_map_bucket:
+// -->	PUSH {r4, lr}
+// -->	MOV r3, r1
+// -->	LDR r2, [r0, #12]
+// -->	TST r2, #1
+// -->	BEQ p_map_bucket_mix
+// -->	CMP r1, #0
+// -->	BEQ p_map_bucket_mix
+// -->	MOV r3, #0
+// -->	LDR r2, [r1]
+// -->	ADDS r4, r1, r2, LSL #2
+// p_map_bucket_loop:
+// -->	CMP r2, #0
+// -->	BEQ p_map_bucket_mix
+// -->	LDR lr, [r4]
+// -->	RSBS r3, r3, r3, LSL #5
+// -->	ADDS r3, r3, lr
+// -->	SUBS r4, r4, #4
+// -->	SUBS r2, r2, #1
+// -->	B p_map_bucket_loop
+// p_map_bucket_mix:
+// -->	EOR r3, r3, r3, LSR #16
+// -->	EOR r3, r3, r3, LSR #3
+// -->	LDR r2, [r0, #4]
+// -->	SUBS r2, r2, #1
+// -->	AND r3, r3, r2
+// -->	LDR r2, [r0, #8]
+// -->	ADDS r0, r2, r3, LSL #2
+// -->	POP {r4, pc}
+func mapBucket(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mMapBucketLbl}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r4, lr}}}
+
+	insch <- &MOVInstr{dest: r3, source: r1}
+
+	insch <- &LDRInstr{LoadInstr{reg: r2,
+		value: &RegisterLoadOperand{value: 12, reg: r0}}}
+
+	insch <- &TSTInstr{BaseComparisonInstr{lhs: r2, rhs: &ImmediateOperand{1}}}
+
+	insch <- &BInstr{cond: condEQ, label: mMapBucketMix}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r1, rhs: &ImmediateOperand{0}}}
+
+	insch <- &BInstr{cond: condEQ, label: mMapBucketMix}
+
+	insch <- &MOVInstr{dest: r3, source: ImmediateOperand{0}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r2, value: &RegisterLoadOperand{reg: r1}}}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r4, lhs: r1,
+		rhs: &RegisterOperand{reg: r2, shift: shiftLSL, amount: 2}}}
+
+	insch <- &LABELInstr{mMapBucketLoop}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r2, rhs: &ImmediateOperand{0}}}
+
+	insch <- &BInstr{cond: condEQ, label: mMapBucketMix}
+
+	insch <- &LDRInstr{LoadInstr{reg: lr, value: &RegisterLoadOperand{reg: r4}}}
+
+	// hash = hash * 31 + char
+	insch <- &RSBInstr{BaseBinaryInstr{dest: r3, lhs: r3,
+		rhs: &RegisterOperand{reg: r3, shift: shiftLSL, amount: 5}}}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r3, lhs: r3, rhs: lr}}
+
+	insch <- &SUBInstr{BaseBinaryInstr{dest: r4, lhs: r4,
+		rhs: ImmediateOperand{4}}}
+
+	insch <- &SUBInstr{BaseBinaryInstr{dest: r2, lhs: r2,
+		rhs: ImmediateOperand{1}}}
+
+	insch <- &BInstr{label: mMapBucketLoop}
+
+	insch <- &LABELInstr{mMapBucketMix}
+
+	// mix the high bits into the low bits selecting the bucket
+	insch <- &EORInstr{BaseBinaryInstr{dest: r3, lhs: r3,
+		rhs: &RegisterOperand{reg: r3, shift: shiftLSR, amount: 16}}}
+
+	insch <- &EORInstr{BaseBinaryInstr{dest: r3, lhs: r3,
+		rhs: &RegisterOperand{reg: r3, shift: shiftLSR, amount: 3}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r2,
+		value: &RegisterLoadOperand{value: 4, reg: r0}}}
+
+	insch <- &SUBInstr{BaseBinaryInstr{dest: r2, lhs: r2,
+		rhs: ImmediateOperand{1}}}
+
+	insch <- &ANDInstr{BaseBinaryInstr{dest: r3, lhs: r3, rhs: r2}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r2,
+		value: &RegisterLoadOperand{value: 8, reg: r0}}}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r0, lhs: r2,
+		rhs: &RegisterOperand{reg: r3, shift: shiftLSL, amount: 2}}}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r4, pc}}}
+}
+
+//mapStringEqual returns whether the strings in r0 and r1 hold the same
+//characters
+// p_map_string_equal:
+// -->	CMP r0, #0
+// -->	CMPNE r1, #0
+// -->	MOVEQ r0, #0
+// -->	MOVEQ pc, lr
+// -->	PUSH {r4, lr}
+// -->	LDR r2, [r0]
+// -->	LDR r3, [r1]
+// -->	CMP r2, r3
+// -->	MOVNE r0, #0
+// -->	BNE p_map_string_equal_return
+// p_map_string_equal_loop:
+// -->	CMP r2, #0
+// -->	MOVEQ r0, #1
+// -->	BEQ p_map_string_equal_return
+// -->	ADDS r3, r0, r2, LSL #2
+// -->	LDR r3, [r3]
+// -->	ADDS r4, r1, r2, LSL #2
+// -->	LDR r4, [r4]
+// -->	CMP r3, r4
+// -->	MOVNE r0, #0
+// -->	BNE p_map_string_equal_return
+// -->	SUBS r2, r2, #1
+// -->	B p_map_string_equal_loop
+// p_map_string_equal_return:
+// -->	POP {r4, pc}
+func mapStringEqual(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mMapStrEqualLbl}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r0, rhs: &ImmediateOperand{0}}}
+
+	insch <- &CMPInstr{BaseComparisonInstr{cond: condNE, lhs: r1,
+		rhs: &ImmediateOperand{0}}}
+
+	insch <- &MOVInstr{cond: condEQ, dest: r0, source: ImmediateOperand{0}}
+
+	insch <- &MOVInstr{cond: condEQ, dest: pc, source: lr}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r4, lr}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r2, value: &RegisterLoadOperand{reg: r0}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r3, value: &RegisterLoadOperand{reg: r1}}}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r2, rhs: r3}}
+
+	insch <- &MOVInstr{cond: condNE, dest: r0, source: ImmediateOperand{0}}
+
+	insch <- &BInstr{cond: condNE, label: mMapStrEqualEnd}
+
+	insch <- &LABELInstr{mMapStrEqualLoop}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r2, rhs: &ImmediateOperand{0}}}
+
+	insch <- &MOVInstr{cond: condEQ, dest: r0, source: ImmediateOperand{1}}
+
+	insch <- &BInstr{cond: condEQ, label: mMapStrEqualEnd}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r3, lhs: r0,
+		rhs: &RegisterOperand{reg: r2, shift: shiftLSL, amount: 2}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r3, value: &RegisterLoadOperand{reg: r3}}}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r4, lhs: r1,
+		rhs: &RegisterOperand{reg: r2, shift: shiftLSL, amount: 2}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r4, value: &RegisterLoadOperand{reg: r4}}}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r3, rhs: r4}}
+
+	insch <- &MOVInstr{cond: condNE, dest: r0, source: ImmediateOperand{0}}
+
+	insch <- &BInstr{cond: condNE, label: mMapStrEqualEnd}
+
+	insch <- &SUBInstr{BaseBinaryInstr{dest: r2, lhs: r2,
+		rhs: ImmediateOperand{1}}}
+
+	insch <- &BInstr{label: mMapStrEqualLoop}
+
+	insch <- &LABELInstr{mMapStrEqualEnd}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r4, pc}}}
+}
+
+//mapFind returns the address of the link to the node holding the key in r1 in
+//the map in r0. The link holds null if the map does not hold the key
+// p_map_find:
+// -->	PUSH {r4, r5, r6, lr}
+// -->	BL p_check_null_pointer
+// -->	MOV r4, r0
+// -->	MOV r5, r1
+// -->	BL p_map_bucket
+// -->	MOV r6, r0
+// p_map_find_loop:
+// -->	LDR r0, [r6]
+// -->	CMP r0, #0
+// -->	BEQ p_map_find_return
+// -->	LDR r0, [r0]
+// -->	CMP r0, r5
+// -->	BEQ p_map_find_return
+// -->	LDR r2, [r4, #12]
+// -->	TST r2, #1
+// -->	BEQ p_map_find_next
+// -->	MOV r1, r5
+// -->	BL p_map_string_equal
+// -->	CMP r0, #0
+// -->	BNE p_map_find_return
+// p_map_find_next:
+// -->	LDR r0, [r6]
+// -->	ADDS r6, r0, #4
+// -->	B p_map_find_loop
+// p_map_find_return:
+// -->	MOV r0, r6
+// -->	POP {r4, r5, r6, pc}
+func mapFind(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mMapFindLbl}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r4, r5, r6, lr}}}
+
+	insch <- &BLInstr{BInstr{label: mNullReferenceLbl}}
+
+	insch <- &MOVInstr{dest: r4, source: r0}
+
+	insch <- &MOVInstr{dest: r5, source: r1}
+
+	insch <- &BLInstr{BInstr{label: mMapBucketLbl}}
+
+	insch <- &MOVInstr{dest: r6, source: r0}
+
+	insch <- &LABELInstr{mMapFindLoop}
+
+	insch <- &LDRInstr{LoadInstr{reg: r0, value: &RegisterLoadOperand{reg: r6}}}
 
-	insch <- &ADDInstr{BaseBinaryInstr: BaseBinaryInstr{dest: r2, lhs: r0,
-		rhs: ImmediateOperand{n: 4}}}
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r0, rhs: &ImmediateOperand{0}}}
 
-	insch <- &LDRInstr{
-		LoadInstr: LoadInstr{reg: r0, value: &BasicLoadOperand{value: msg}}}
+	insch <- &BInstr{cond: condEQ, label: mMapFindEnd}
 
-	insch <- &ADDInstr{BaseBinaryInstr: BaseBinaryInstr{dest: r0, lhs: r0,
-		rhs: ImmediateOperand{n: 4}}}
+	insch <- &LDRInstr{LoadInstr{reg: r0, value: &RegisterLoadOperand{reg: r0}}}
 
-	insch <- &BLInstr{BInstr: BInstr{label: mPrintf}}
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r0, rhs: r5}}
 
-	insch <- &MOVInstr{dest: r0, source: &ImmediateOperand{n: 0}}
+	insch <- &BInstr{cond: condEQ, label: mMapFindEnd}
 
-	insch <- &BLInstr{BInstr: BInstr{label: mFFlush}}
+	insch <- &LDRInstr{LoadInstr{reg: r2,
+		value: &RegisterLoadOperand{value: 12, reg: r4}}}
 
-	insch <- &MOVInstr{dest: r0, source: &ImmediateOperand{n: -1}}
+	insch <- &TSTInstr{BaseComparisonInstr{lhs: r2, rhs: &ImmediateOperand{1}}}
 
-	insch <- &BLInstr{BInstr: BInstr{label: mExitLabel}}
+	insch <- &BInstr{cond: condEQ, label: mMapFindNext}
+
+	insch <- &MOVInstr{dest: r1, source: r5}
+
+	insch <- &BLInstr{BInstr{label: mMapStrEqualLbl}}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r0, rhs: &ImmediateOperand{0}}}
+
+	insch <- &BInstr{cond: condNE, label: mMapFindEnd}
+
+	insch <- &LABELInstr{mMapFindNext}
+
+	insch <- &LDRInstr{LoadInstr{reg: r0, value: &RegisterLoadOperand{reg: r6}}}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r6, lhs: r0,
+		rhs: ImmediateOperand{4}}}
+
+	insch <- &BInstr{label: mMapFindLoop}
+
+	insch <- &LABELInstr{mMapFindEnd}
+
+	insch <- &MOVInstr{dest: r0, source: r6}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r4, r5, r6, pc}}}
 }
 
-//------------------------------------------------------------------------------
-// GENERAL CODEGEN UTILITY
-//------------------------------------------------------------------------------
+//mapLookup returns the address of the value of the key in r1 in the map in r0.
+//A missing key is a runtime error
+// p_map_lookup:
+// -->	PUSH {lr}
+// -->	BL p_map_find
+// -->	LDR r0, [r0]
+// -->	CMP r0, #0
+// -->	LDREQ r0, =msg_14
+// -->	MOVEQ r1, #-5 (if exceptions are used)
+// -->	BLEQ p_throw_runtime_error
+// -->	ADDS r0, r0, #8
+// -->	POP {pc}
+func mapLookup(context *FunctionContext, insch chan<- Instr) {
+	msg := context.stringPool.Lookup8(mKeyNotFoundErr)
 
-func codeGenBuiltin(strPool *StringPool, builtInFuncs *BuiltInFuncs, f func(*FunctionContext, chan<- Instr)) <-chan Instr {
-	ch := make(chan Instr)
+	insch <- &LABELInstr{mMapLookupLbl}
 
-	context := CreateFunctionContext()
-	context.stringPool = strPool
-	context.builtInFuncs = builtInFuncs
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{lr}}}
 
-	go func() {
-		f(context, ch)
-		close(ch)
-	}()
+	insch <- &BLInstr{BInstr{label: mMapFindLbl}}
 
-	return ch
+	insch <- &LDRInstr{LoadInstr{reg: r0, value: &RegisterLoadOperand{reg: r0}}}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r0, rhs: &ImmediateOperand{0}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r0, cond: condEQ,
+		value: &BasicLoadOperand{value: msg}}}
+
+	exceptionCode(context, condEQ, exceptionKeyNotFound, insch)
+
+	insch <- &BLInstr{BInstr{cond: condEQ, label: mThrowRuntimeErr}}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r0, lhs: r0,
+		rhs: ImmediateOperand{8}}}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{pc}}}
 }
 
-// CodeGen generates instructions for functions
-func (m *FunctionDef) CodeGen(strPool *StringPool, builtInFuncs *BuiltInFuncs) <-chan Instr {
-	ch := make(chan Instr)
+//mapHas returns whether the map in r0 holds the key in r1
+// p_map_has:
+// -->	PUSH {lr}
+// -->	BL p_map_find
+// -->	LDR r0, [r0]
+// -->	CMP r0, #0
+// -->	MOVNE r0, #1
+// -->	POP {pc}
+func mapHas(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mMapHasLbl}
 
-	go func() {
-		context := CreateFunctionContext()
-		context.stringPool = strPool
-		context.builtInFuncs = builtInFuncs
-		context.fname = m.Symbol()
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{lr}}}
 
-		ch <- &LABELInstr{m.Symbol()}
+	insch <- &BLInstr{BInstr{label: mMapFindLbl}}
 
-		context.StartScope(ch)
+	insch <- &LDRInstr{LoadInstr{reg: r0, value: &RegisterLoadOperand{reg: r0}}}
 
-		// save previous pc for returning
-		ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip, lr}}}
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r0, rhs: &ImmediateOperand{0}}}
 
-		if m.body == nil {
-			// return
-			if m.class == nil {
-				ch <- &MOVInstr{dest: resReg, source: ImmediateOperand{0}}
-			}
-			ch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip, pc}}}
-			close(ch)
-			return
-		}
+	insch <- &MOVInstr{cond: condNE, dest: r0, source: ImmediateOperand{1}}
 
-		// save callee saved registers
-		ch <- &PUSHInstr{
-			BaseStackInstr: BaseStackInstr{
-				regs: []Reg{r4, r5, r6, r7, r8, r9, r10, r11},
-			},
-		}
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{pc}}}
+}
 
-		// put the first four params on the stack
-		pl := len(m.params)
-
-		switch {
-		case pl >= 4 && m.class == nil:
-			ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r3}}}
-			fallthrough
-		case pl == 3 && m.class == nil:
-			ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r2}}}
-			fallthrough
-		case pl == 2 && m.class == nil:
-			ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r1}}}
-			fallthrough
-		case pl == 1 && m.class == nil:
-			ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r0}}}
-
-		case pl >= 3 && m.class != nil:
-			ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r3}}}
-			fallthrough
-		case pl == 2 && m.class != nil:
-			ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r2}}}
-			fallthrough
-		case pl == 1 && m.class != nil:
-			ch <- &PUSHInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{r1}}}
-		}
-
-		// set the addresses of the arguments relative to sp on the
-		// stack
-		for i := 0; i < len(m.params); i++ {
-			switch {
-			case i < 4 && m.class == nil:
-				p := m.params[i]
-				context.stack[0][p.name] = i * -4
-			case i < 3 && m.class != nil:
-				p := m.params[i]
-				context.stack[0][p.name] = i * -4
-			case i >= 4 && m.class == nil:
-				p := m.params[i]
-				context.stack[0][p.name] = -4 + -4 + i*-4 + 8*-4
-			case i >= 3 && m.class != nil:
-				p := m.params[i]
-				context.stack[0][p.name] = -4 + -4 + i*-4 + 8*-4
-			}
-		}
+//mapInsert returns the address of the value of the key in r1 in the map in r0.
+//A missing key is added with a null value, growing the map when its buckets
+//hold two keys on average. Long keys are copied into a box of the map
+// p_map_insert:
+// -->	PUSH {r4, r5, r6, ip, lr}
+// -->	MOV r4, r0
+// -->	MOV r5, r1
+// -->	BL p_map_find
+// -->	LDR r6, [r0]
+// -->	CMP r6, #0
+// -->	BNE p_map_insert_return
+// -->	PUSH {r0}
+// -->	MOV r0, #16
+// -->	BL malloc
+// -->	MOV r6, r0
+// -->	POP {r0}
+// -->	STR r6, [r0]
+// -->	LDR r1, [r4, #12] (if long keys are used)
+// -->	TST r1, #8
+// -->	LDRNE r0, [r5, #4]
+// -->	LDRNE r1, [r5, #8]
+// -->	BLNE p_long_box
+// -->	LDR r1, [r4, #12]
+// -->	TST r1, #8
+// -->	MOVNE r5, r0
+// -->	STR r5, [r6]
+// -->	MOV r0, #0
+// -->	STR r0, [r6, #4]
+// -->	STR r0, [r6, #8]
+// -->	STR r0, [r6, #12]
+// -->	LDR r1, [r4, #12] (if reference counting)
+// -->	TST r1, #2
+// -->	MOVNE r0, r5
+// -->	BLNE p_rc_retain
+// -->	LDR r0, [r4]
+// -->	ADDS r0, r0, #1
+// -->	STR r0, [r4]
+// -->	LDR r1, [r4, #4]
+// -->	CMP r0, r1, LSL #1
+// -->	MOVGT r0, r4
+// -->	BLGT p_map_grow
+// p_map_insert_return:
+// -->	ADDS r0, r6, #8
+// -->	POP {r4, r5, r6, ip, pc}
+func mapInsert(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mMapInsertLbl}
 
-		// if we are in a function put the this address in ip
-		if m.class != nil {
-			ch <- &MOVInstr{dest: ip, source: r0}
-		}
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r4, r5, r6, ip, lr}}}
 
-		// if we are in a function set up the members
-		if m.class != nil {
-			for _, member := range m.class.members {
-				context.DeclareMember(member.ident)
-			}
-		}
+	insch <- &MOVInstr{dest: r4, source: r0}
 
-		context.StartScope(ch)
+	insch <- &MOVInstr{dest: r5, source: r1}
 
-		// codegen the function body
-		m.body.CodeGen(context, ch)
+	insch <- &BLInstr{BInstr{label: mMapFindLbl}}
 
-		context.CleanupScope(ch)
+	insch <- &LDRInstr{LoadInstr{reg: r6, value: &RegisterLoadOperand{reg: r0}}}
 
-		// if the function has no return type then zero r0 before
-		// returning
-		switch m.returnType.(type) {
-		case VoidType:
-			if m.class == nil {
-				ch <- &MOVInstr{dest: resReg, source: ImmediateOperand{0}}
-			} else {
-				ch <- &MOVInstr{dest: resReg, source: ip}
-			}
-		}
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r6, rhs: &ImmediateOperand{0}}}
 
-		ch <- &LABELInstr{fmt.Sprintf("%s_return", m.Symbol())}
+	insch <- &BInstr{cond: condNE, label: mMapInsertEnd}
 
-		// restore the stack from pushing first four parameters
-		if pl > 0 {
-			ppregs := pl * 4
-			if ppregs > 16 {
-				ppregs = 16
-			}
-			if ppregs > 12 && m.class != nil {
-				ppregs = 12
-			}
-			ch <- &ADDInstr{BaseBinaryInstr: BaseBinaryInstr{dest: sp, lhs: sp,
-				rhs: ImmediateOperand{ppregs}}}
-		}
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r0}}}
 
-		// restore callee saved registers
-		ch <- &POPInstr{
-			BaseStackInstr: BaseStackInstr{
-				regs: []Reg{r4, r5, r6, r7, r8, r9, r10, r11},
-			},
+	insch <- &MOVInstr{dest: r0, source: ImmediateOperand{16}}
+
+	insch <- &BLInstr{BInstr{label: mMalloc}}
+
+	insch <- &MOVInstr{dest: r6, source: r0}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r0}}}
+
+	insch <- &STRInstr{StoreInstr{reg: r6, value: &RegStoreOperand{r0}}}
+
+	// the key looked up may be a temporary box of a long
+	if context.builtInFuncs.IsUsed(mLongBoxLbl) {
+		insch <- &LDRInstr{LoadInstr{reg: r1,
+			value: &RegisterLoadOperand{value: 12, reg: r4}}}
+		insch <- &TSTInstr{BaseComparisonInstr{lhs: r1,
+			rhs: &ImmediateOperand{8}}}
+		insch <- &LDRInstr{LoadInstr{reg: r0, cond: condNE,
+			value: &RegisterLoadOperand{value: 4, reg: r5}}}
+		insch <- &LDRInstr{LoadInstr{reg: r1, cond: condNE,
+			value: &RegisterLoadOperand{value: 8, reg: r5}}}
+		insch <- &BLInstr{BInstr{cond: condNE, label: mLongBoxLbl}}
+		insch <- &LDRInstr{LoadInstr{reg: r1,
+			value: &RegisterLoadOperand{value: 12, reg: r4}}}
+		insch <- &TSTInstr{BaseComparisonInstr{lhs: r1,
+			rhs: &ImmediateOperand{8}}}
+		insch <- &MOVInstr{cond: condNE, dest: r5, source: r0}
+	}
+
+	insch <- &STRInstr{StoreInstr{reg: r5, value: &RegStoreOperand{r6}}}
+
+	insch <- &MOVInstr{dest: r0, source: ImmediateOperand{0}}
+
+	for _, offset := range []int{4, 8, 12} {
+		insch <- &STRInstr{StoreInstr{reg: r0,
+			value: &RegStoreOffsetOperand{reg: r6, offset: offset}}}
+	}
+
+	// the map holds a reference to reference counted keys
+	if context.builtInFuncs.IsUsed(mRetainLbl) {
+		insch <- &LDRInstr{LoadInstr{reg: r1,
+			value: &RegisterLoadOperand{value: 12, reg: r4}}}
+		insch <- &TSTInstr{BaseComparisonInstr{lhs: r1,
+			rhs: &ImmediateOperand{2}}}
+		insch <- &MOVInstr{cond: condNE, dest: r0, source: r5}
+		insch <- &BLInstr{BInstr{cond: condNE, label: mRetainLbl}}
+	}
+
+	insch <- &LDRInstr{LoadInstr{reg: r0, value: &RegisterLoadOperand{reg: r4}}}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r0, lhs: r0,
+		rhs: ImmediateOperand{1}}}
+
+	insch <- &STRInstr{StoreInstr{reg: r0, value: &RegStoreOperand{r4}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r1,
+		value: &RegisterLoadOperand{value: 4, reg: r4}}}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r0,
+		rhs: &RegisterOperand{reg: r1, shift: shiftLSL, amount: 1}}}
+
+	insch <- &MOVInstr{cond: condGT, dest: r0, source: r4}
+
+	insch <- &BLInstr{BInstr{cond: condGT, label: mMapGrowLbl}}
+
+	insch <- &LABELInstr{mMapInsertEnd}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r0, lhs: r6,
+		rhs: ImmediateOperand{8}}}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r4, r5, r6, ip, pc}}}
+}
+
+//mapGrow doubles the number of buckets of the map in r0 and moves its nodes to
+//the new buckets
+// p_map_grow:
+// -->	PUSH {r4, r5, r6, r7, r8, ip, lr}
+// -->	MOV r4, r0
+// -->	LDR r5, [r4, #4]
+// -->	LDR r6, [r4, #8]
+// -->	MOV r0, r5, LSL #1
+// -->	BL p_map_buckets
+// -->	STR r0, [r4, #8]
+// -->	MOV r0, r5, LSL #1
+// -->	STR r0, [r4, #4]
+// p_map_grow_bucket:
+// -->	SUBS r5, r5, #1
+// -->	BLT p_map_grow_return
+// -->	ADDS r0, r6, r5, LSL #2
+// -->	LDR r7, [r0]
+// p_map_grow_node:
+// -->	CMP r7, #0
+// -->	BEQ p_map_grow_bucket
+// -->	MOV r0, r4
+// -->	LDR r1, [r7]
+// -->	BL p_map_bucket
+// -->	LDR r8, [r7, #4]
+// -->	LDR r1, [r0]
+// -->	STR r1, [r7, #4]
+// -->	STR r7, [r0]
+// -->	MOV r7, r8
+// -->	B p_map_grow_node
+// p_map_grow_return:
+// -->	MOV r0, r6
+// -->	BL free
+// -->	POP {r4, r5, r6, r7, r8, ip, pc}
+func mapGrow(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mMapGrowLbl}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r4, r5, r6, r7, r8, ip, lr}}}
+
+	insch <- &MOVInstr{dest: r4, source: r0}
+
+	insch <- &LDRInstr{LoadInstr{reg: r5,
+		value: &RegisterLoadOperand{value: 4, reg: r4}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r6,
+		value: &RegisterLoadOperand{value: 8, reg: r4}}}
+
+	insch <- &MOVInstr{dest: r0,
+		source: &RegisterOperand{reg: r5, shift: shiftLSL, amount: 1}}
+
+	insch <- &BLInstr{BInstr{label: mMapBucketsLbl}}
+
+	insch <- &STRInstr{StoreInstr{reg: r0,
+		value: &RegStoreOffsetOperand{reg: r4, offset: 8}}}
+
+	insch <- &MOVInstr{dest: r0,
+		source: &RegisterOperand{reg: r5, shift: shiftLSL, amount: 1}}
+
+	insch <- &STRInstr{StoreInstr{reg: r0,
+		value: &RegStoreOffsetOperand{reg: r4, offset: 4}}}
+
+	insch <- &LABELInstr{mMapGrowBucket}
+
+	insch <- &SUBInstr{BaseBinaryInstr{dest: r5, lhs: r5,
+		rhs: ImmediateOperand{1}}}
+
+	insch <- &BInstr{cond: condLT, label: mMapGrowEnd}
+
+	insch <- &ADDInstr{BaseBinaryInstr{dest: r0, lhs: r6,
+		rhs: &RegisterOperand{reg: r5, shift: shiftLSL, amount: 2}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r7, value: &RegisterLoadOperand{reg: r0}}}
+
+	insch <- &LABELInstr{mMapGrowNode}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r7, rhs: &ImmediateOperand{0}}}
+
+	insch <- &BInstr{cond: condEQ, label: mMapGrowBucket}
+
+	insch <- &MOVInstr{dest: r0, source: r4}
+
+	insch <- &LDRInstr{LoadInstr{reg: r1, value: &RegisterLoadOperand{reg: r7}}}
+
+	insch <- &BLInstr{BInstr{label: mMapBucketLbl}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r8,
+		value: &RegisterLoadOperand{value: 4, reg: r7}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r1, value: &RegisterLoadOperand{reg: r0}}}
+
+	insch <- &STRInstr{StoreInstr{reg: r1,
+		value: &RegStoreOffsetOperand{reg: r7, offset: 4}}}
+
+	insch <- &STRInstr{StoreInstr{reg: r7, value: &RegStoreOperand{r0}}}
+
+	insch <- &MOVInstr{dest: r7, source: r8}
+
+	insch <- &BInstr{label: mMapGrowNode}
+
+	insch <- &LABELInstr{mMapGrowEnd}
+
+	insch <- &MOVInstr{dest: r0, source: r6}
+
+	insch <- &BLInstr{BInstr{label: mFreeLabel}}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r4, r5, r6, r7, r8, ip, pc}}}
+}
+
+//freeLongKey frees the copy of the long key of the node in the given register
+//if the map in r4 owns it
+// -->	LDR r1, [r4, #12]
+// -->	TST r1, #8
+// -->	LDRNE r0, [node]
+// -->	BLNE free
+func freeLongKey(node Reg, insch chan<- Instr) {
+	insch <- &LDRInstr{LoadInstr{reg: r1,
+		value: &RegisterLoadOperand{value: 12, reg: r4}}}
+	insch <- &TSTInstr{BaseComparisonInstr{lhs: r1,
+		rhs: &ImmediateOperand{8}}}
+	insch <- &LDRInstr{LoadInstr{reg: r0, cond: condNE,
+		value: &RegisterLoadOperand{reg: node}}}
+	insch <- &BLInstr{BInstr{cond: condNE, label: mFreeLabel}}
+}
+
+//mapDelete removes the key in r1 from the map in r0 if the map holds it
+// p_map_delete:
+// -->	PUSH {r4, r5, ip, lr}
+// -->	MOV r4, r0
+// -->	BL p_map_find
+// -->	LDR r5, [r0]
+// -->	CMP r5, #0
+// -->	BEQ p_map_delete_return
+// -->	LDR r1, [r5, #4]
+// -->	STR r1, [r0]
+// -->	LDR r1, [r4]
+// -->	SUBS r1, r1, #1
+// -->	STR r1, [r4]
+// -->	LDR r1, [r4, #12] (if reference counting)
+// -->	TST r1, #2
+// -->	LDRNE r0, [r5]
+// -->	BLNE p_rc_release
+// -->	LDR r1, [r4, #12]
+// -->	TST r1, #4
+// -->	LDRNE r0, [r5, #8]
+// -->	BLNE p_rc_release
+// -->	LDR r1, [r4, #12] (if long keys are used)
+// -->	TST r1, #8
+// -->	LDRNE r0, [r5]
+// -->	BLNE free
+// -->	MOV r0, r5
+// -->	BL free
+// p_map_delete_return:
+// -->	POP {r4, r5, ip, pc}
+func mapDelete(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mMapDeleteLbl}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r4, r5, ip, lr}}}
+
+	insch <- &MOVInstr{dest: r4, source: r0}
+
+	insch <- &BLInstr{BInstr{label: mMapFindLbl}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r5, value: &RegisterLoadOperand{reg: r0}}}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r5, rhs: &ImmediateOperand{0}}}
+
+	insch <- &BInstr{cond: condEQ, label: mMapDeleteEnd}
+
+	insch <- &LDRInstr{LoadInstr{reg: r1,
+		value: &RegisterLoadOperand{value: 4, reg: r5}}}
+
+	insch <- &STRInstr{StoreInstr{reg: r1, value: &RegStoreOperand{r0}}}
+
+	insch <- &LDRInstr{LoadInstr{reg: r1, value: &RegisterLoadOperand{reg: r4}}}
+
+	insch <- &SUBInstr{BaseBinaryInstr{dest: r1, lhs: r1,
+		rhs: ImmediateOperand{1}}}
+
+	insch <- &STRInstr{StoreInstr{reg: r1, value: &RegStoreOperand{r4}}}
+
+	// the map releases its references to the removed key and value
+	if context.builtInFuncs.IsUsed(mReleaseLbl) {
+		for _, entry := range []struct{ flag, offset int }{{2, 0}, {4, 8}} {
+			insch <- &LDRInstr{LoadInstr{reg: r1,
+				value: &RegisterLoadOperand{value: 12, reg: r4}}}
+			insch <- &TSTInstr{BaseComparisonInstr{lhs: r1,
+				rhs: &ImmediateOperand{entry.flag}}}
+			insch <- &LDRInstr{LoadInstr{reg: r0, cond: condNE,
+				value: &RegisterLoadOperand{value: entry.offset, reg: r5}}}
+			insch <- &BLInstr{BInstr{cond: condNE, label: mReleaseLbl}}
 		}
+	}
 
-		// return
-		ch <- &POPInstr{BaseStackInstr: BaseStackInstr{regs: []Reg{ip, pc}}}
+	// and frees its copy of a long key
+	if context.builtInFuncs.IsUsed(mLongBoxLbl) {
+		freeLongKey(r5, insch)
+	}
 
-		// ensures literal pools for LDR are in range
-		ch <- &LTORGInstr{}
+	insch <- &MOVInstr{dest: r0, source: r5}
 
-		close(ch)
-	}()
+	insch <- &BLInstr{BInstr{label: mFreeLabel}}
 
-	return ch
+	insch <- &LABELInstr{mMapDeleteEnd}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r4, r5, ip, pc}}}
+}
+
+//------------------------------------------------------------------------------
+// LONG RUNTIME
+//------------------------------------------------------------------------------
+//
+// The routines take and return longs in pairs of registers. Where a single word
+// is expected a long is a reference to a box holding
+// [2] [low word] [high word]
+// The box is laid out as an array of two words so that maps compare and hash
+// long keys by their value like strings
+
+//longBox allocates a box holding the long with the low word in r0 and the high
+//word in r1
+// p_long_box:
+// -->	PUSH {r4, r5, ip, lr}
+// -->	MOV r4, r0
+// -->	MOV r5, r1
+// -->	LDR r0, =12
+// -->	BL malloc
+// -->	MOV r1, #2
+// -->	STR r1, [r0]
+// -->	STR r4, [r0, #4]
+// -->	STR r5, [r0, #8]
+// -->	POP {r4, r5, ip, pc}
+func longBox(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mLongBoxLbl}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r4, r5, ip, lr}}}
+
+	insch <- &MOVInstr{dest: r4, source: r0}
+
+	insch <- &MOVInstr{dest: r5, source: r1}
+
+	insch <- &LDRInstr{LoadInstr{reg: r0, value: &ConstLoadOperand{12}}}
+
+	insch <- &BLInstr{BInstr{label: mMalloc}}
+
+	insch <- &MOVInstr{dest: r1, source: ImmediateOperand{2}}
+
+	insch <- &STRInstr{StoreInstr{reg: r1, value: &RegStoreOperand{r0}}}
+
+	insch <- &STRInstr{StoreInstr{reg: r4,
+		value: &RegStoreOffsetOperand{reg: r0, offset: 4}}}
+
+	insch <- &STRInstr{StoreInstr{reg: r5,
+		value: &RegStoreOffsetOperand{reg: r0, offset: 8}}}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r4, r5, ip, pc}}}
+}
+
+//longMultiply multiplies the long in r0 and r1 by the long in r2 and r3. The
+//magnitudes are multiplied as unsigned numbers and the sign is applied to the
+//product returned in r0 and r1
+// p_long_multiply:
+// -->	PUSH {r4, r5, r6, r7, r8, lr}
+// -->	MOV r4, r2
+// -->	MOV r5, r3
+// -->	MOV r2, r0
+// -->	MOV r3, r1
+// -->	EOR r7, r3, r5
+// -->	CMP r3, #0
+// -->	BGE p_long_multiply_rhs
+// -->	RSBS r2, r2, #0
+// -->	RSCS r3, r3, #0
+// p_long_multiply_rhs:
+// -->	CMP r5, #0
+// -->	BGE p_long_multiply_product
+// -->	RSBS r4, r4, #0
+// -->	RSCS r5, r5, #0
+// p_long_multiply_product:
+// -->	CMP r3, #0
+// -->	CMPNE r5, #0
+// -->	BLNE p_throw_long_overflow_error
+// -->	UMULL r0, r1, r2, r4
+// -->	UMULL r6, r8, r3, r4
+// -->	CMP r8, #0
+// -->	BLNE p_throw_long_overflow_error
+// -->	ADDS r1, r1, r6
+// -->	BLCS p_throw_long_overflow_error
+// -->	UMULL r6, r8, r2, r5
+// -->	CMP r8, #0
+// -->	BLNE p_throw_long_overflow_error
+// -->	ADDS r1, r1, r6
+// -->	BLCS p_throw_long_overflow_error
+// -->	CMP r7, #0
+// -->	BGE p_long_multiply_positive
+// -->	RSBS r0, r0, #0
+// -->	RSCS r1, r1, #0
+// -->	CMP r1, #0
+// -->	BLT p_long_multiply_return
+// -->	ORR r6, r0, r1
+// -->	CMP r6, #0
+// -->	BLNE p_throw_long_overflow_error
+// -->	B p_long_multiply_return
+// p_long_multiply_positive:
+// -->	CMP r1, #0
+// -->	BLLT p_throw_long_overflow_error
+// p_long_multiply_return:
+// -->	POP {r4, r5, r6, r7, r8, pc}
+func longMultiply(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mLongMulLbl}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r4, r5, r6, r7, r8, lr}}}
+
+	insch <- &MOVInstr{dest: r4, source: r2}
+
+	insch <- &MOVInstr{dest: r5, source: r3}
+
+	insch <- &MOVInstr{dest: r2, source: r0}
+
+	insch <- &MOVInstr{dest: r3, source: r1}
+
+	// the sign of the product
+	insch <- &EORInstr{BaseBinaryInstr{dest: r7, lhs: r3, rhs: r5}}
+
+	// take the magnitudes of the operands
+	for _, operand := range []struct {
+		lo, hi Reg
+		next   string
+	}{{r2, r3, mLongMulRHS}, {r4, r5, mLongMulProduct}} {
+		insch <- &CMPInstr{BaseComparisonInstr{lhs: operand.hi,
+			rhs: ImmediateOperand{0}}}
+
+		insch <- &BInstr{cond: condGE, label: operand.next}
+
+		insch <- &RSBInstr{BaseBinaryInstr{dest: operand.lo, lhs: operand.lo,
+			rhs: ImmediateOperand{0}}}
+
+		insch <- &RSCInstr{BaseBinaryInstr{dest: operand.hi, lhs: operand.hi,
+			rhs: ImmediateOperand{0}}}
+
+		insch <- &LABELInstr{operand.next}
+	}
+
+	// the high words cannot both be set
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r3, rhs: ImmediateOperand{0}}}
+
+	insch <- &CMPInstr{BaseComparisonInstr{cond: condNE, lhs: r5,
+		rhs: ImmediateOperand{0}}}
+
+	insch <- &BLInstr{BInstr{cond: condNE, label: mLongOverflowLbl}}
+
+	insch <- &UMULLInstr{RdLo: r0, RdHi: r1, Rm: r2, Rs: r4}
+
+	// add the cross products to the high word of the product
+	for _, cross := range [][2]Reg{{r3, r4}, {r2, r5}} {
+		insch <- &UMULLInstr{RdLo: r6, RdHi: r8, Rm: cross[0], Rs: cross[1]}
+
+		insch <- &CMPInstr{BaseComparisonInstr{lhs: r8,
+			rhs: ImmediateOperand{0}}}
+
+		insch <- &BLInstr{BInstr{cond: condNE, label: mLongOverflowLbl}}
+
+		insch <- &ADDInstr{BaseBinaryInstr{dest: r1, lhs: r1, rhs: r6}}
+
+		insch <- &BLInstr{BInstr{cond: condCS, label: mLongOverflowLbl}}
+	}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r7, rhs: ImmediateOperand{0}}}
+
+	insch <- &BInstr{cond: condGE, label: mLongMulPositive}
+
+	// a negative product can have a magnitude up to 2^63
+	insch <- &RSBInstr{BaseBinaryInstr{dest: r0, lhs: r0,
+		rhs: ImmediateOperand{0}}}
+
+	insch <- &RSCInstr{BaseBinaryInstr{dest: r1, lhs: r1,
+		rhs: ImmediateOperand{0}}}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r1, rhs: ImmediateOperand{0}}}
+
+	insch <- &BInstr{cond: condLT, label: mLongMulEnd}
+
+	insch <- &ORRInstr{BaseBinaryInstr{dest: r6, lhs: r0, rhs: r1}}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r6, rhs: ImmediateOperand{0}}}
+
+	insch <- &BLInstr{BInstr{cond: condNE, label: mLongOverflowLbl}}
+
+	insch <- &BInstr{label: mLongMulEnd}
+
+	insch <- &LABELInstr{mLongMulPositive}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r1, rhs: ImmediateOperand{0}}}
+
+	insch <- &BLInstr{BInstr{cond: condLT, label: mLongOverflowLbl}}
+
+	insch <- &LABELInstr{mLongMulEnd}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r4, r5, r6, r7, r8, pc}}}
+}
+
+// longDivMod divides the long in r0 and r1 by the long in r2 and r3 and returns
+// either the quotient or the remainder in r0 and r1
+func longDivMod(label string, remainder bool, insch chan<- Instr) {
+	insch <- &LABELInstr{label}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{r4, ip, lr}}}
+
+	insch <- &MOVInstr{dest: r4, source: r1}
+
+	// r1 is zero only if both words of the divisor are
+	insch <- &ORRInstr{BaseBinaryInstr{dest: r1, lhs: r2, rhs: r3}}
+
+	insch <- &BLInstr{BInstr{label: mDivideByZeroLbl}}
+
+	insch <- &MOVInstr{dest: r1, source: r4}
+
+	insch <- &BLInstr{BInstr{label: "__aeabi_ldivmod"}}
+
+	if remainder {
+		insch <- &MOVInstr{dest: r0, source: r2}
+
+		insch <- &MOVInstr{dest: r1, source: r3}
+	}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{r4, ip, pc}}}
+}
+
+//longDivide divides the long in r0 and r1 by the long in r2 and r3
+// p_long_divide:
+// -->	PUSH {r4, ip, lr}
+// -->	MOV r4, r1
+// -->	ORR r1, r2, r3
+// -->	BL p_check_divide_by_zero
+// -->	MOV r1, r4
+// -->	BL __aeabi_ldivmod
+// -->	POP {r4, ip, pc}
+func longDivide(context *FunctionContext, insch chan<- Instr) {
+	longDivMod(mLongDivLbl, false, insch)
+}
+
+//longModulo takes the remainder of the long in r0 and r1 divided by the long
+//in r2 and r3
+// p_long_modulo:
+// -->	[as p_long_divide]
+// -->	BL __aeabi_ldivmod
+// -->	MOV r0, r2
+// -->	MOV r1, r3
+// -->	POP {r4, ip, pc}
+func longModulo(context *FunctionContext, insch chan<- Instr) {
+	longDivMod(mLongModLbl, true, insch)
+}
+
+//longCompare compares the long in r0 and r1 to the long in r2 and r3
+//returning -1, 0 or 1 if the first is less than, equal to or greater than the
+//second
+// p_long_compare:
+// -->	PUSH {lr}
+// -->	SUBS r2, r0, r2
+// -->	SBCS r3, r1, r3
+// -->	MOVLT r0, #-1
+// -->	MOVGE r0, #1
+// -->	ORR r2, r2, r3
+// -->	CMP r2, #0
+// -->	MOVEQ r0, #0
+// -->	POP {pc}
+func longCompare(context *FunctionContext, insch chan<- Instr) {
+	insch <- &LABELInstr{mLongCmpLbl}
+
+	insch <- &PUSHInstr{BaseStackInstr{regs: []Reg{lr}}}
+
+	insch <- &SUBInstr{BaseBinaryInstr{dest: r2, lhs: r0, rhs: r2}}
+
+	insch <- &SBCInstr{BaseBinaryInstr{dest: r3, lhs: r1, rhs: r3}}
+
+	insch <- &MOVInstr{cond: condLT, dest: r0, source: ImmediateOperand{-1}}
+
+	insch <- &MOVInstr{cond: condGE, dest: r0, source: ImmediateOperand{1}}
+
+	insch <- &ORRInstr{BaseBinaryInstr{dest: r2, lhs: r2, rhs: r3}}
+
+	insch <- &CMPInstr{BaseComparisonInstr{lhs: r2, rhs: ImmediateOperand{0}}}
+
+	insch <- &MOVInstr{cond: condEQ, dest: r0, source: ImmediateOperand{0}}
+
+	insch <- &POPInstr{BaseStackInstr{regs: []Reg{pc}}}
 }
 
 // FSMap is a map from the function labels to instruction generating functions
 var FSMap = map[string]func(*FunctionContext, chan<- Instr){
 	mPrintIntLabel:       printInt,
+	mPrintLongLabel:      printLong,
+	mPrintFloatLabel:     printFloat,
 	mPrintCharLabel:      printChar,
 	mPrintBoolLabel:      printBool,
 	mPrintStringLabel:    printString,
@@ -2627,38 +7098,139 @@ var FSMap = map[string]func(*FunctionContext, chan<- Instr){
 	mPrintNewLineLabel:   printNewLine,
 	mReadIntLabel:        readInt,
 	mReadCharLabel:       readChar,
+	mReadFloatLabel:      readFloat,
 	mDivideByZeroLbl:     checkDivideByZero,
 	mNullReferenceLbl:    checkNullPointer,
 	mArrayBoundLbl:       checkArrayBounds,
 	mOverflowLbl:         checkOverflowUnderflow,
+	mLongOverflowLbl:     checkLongOverflow,
 	mThrowRuntimeErr:     throwRuntimeError,
+	mInterfaceTableLbl:   findInterfaceTable,
+	mThrowExceptionLbl:   throwException,
+	mRetainLbl:           retainObject,
+	mReleaseLbl:          releaseObject,
+	mDisownLbl:           disownObject,
+	mReleaseFstLbl:       releaseFst,
+	mReleaseSndLbl:       releaseSnd,
+	mReleaseSndWideLbl:   releaseSndWide,
+	mReleasePairLbl:      releasePair,
+	mReleaseElemsLbl:     releaseElems,
+	mReleaseMapLbl:       releaseMap,
+	mMapInitLbl:          mapInit,
+	mMapBucketsLbl:       mapBucketsAlloc,
+	mMapBucketLbl:        mapBucket,
+	mMapStrEqualLbl:      mapStringEqual,
+	mMapFindLbl:          mapFind,
+	mMapLookupLbl:        mapLookup,
+	mMapHasLbl:           mapHas,
+	mMapInsertLbl:        mapInsert,
+	mMapGrowLbl:          mapGrow,
+	mMapDeleteLbl:        mapDelete,
+	mLongBoxLbl:          longBox,
+	mLongMulLbl:          longMultiply,
+	mLongDivLbl:          longDivide,
+	mLongModLbl:          longModulo,
+	mLongCmpLbl:          longCompare,
+}
+
+// initialValue returns the value a global is stored with in the data segment
+// ok is false if the global has to be initialised by the main program
+func (m *GlobalDef) initialValue() (int, bool) {
+	if eRHS, ok := m.rhs.(*ExpressionRHS); ok {
+		switch e := eRHS.expr.(type) {
+		case *IntLiteral:
+			return e.value, true
+		case *FloatLiteral:
+			return int(int32(math.Float32bits(e.value))), true
+		case *BoolLiteralTrue:
+			return 1, true
+		case *BoolLiteralFalse:
+			return 0, true
+		}
+	}
+
+	return 0, false
+}
+
+// initGlobals returns the main program preceded by the initialisation of the
+// globals whose values are not known at compile time
+func (m *AST) initGlobals() Statement {
+	main := m.main
+
+	for i := len(m.globals) - 1; i >= 0; i-- {
+		g := m.globals[i]
+		if _, ok := g.initialValue(); ok {
+			continue
+		}
+
+		init := &AssignStatement{
+			target: &VarLHS{ident: g.ident, wtype: g.wtype},
+			rhs:    g.rhs,
+		}
+		init.SetNext(main)
+		main = init
+	}
+
+	return main
 }
 
-// CodeGen generates instructions for the whole program
-func (m *AST) CodeGen() <-chan Instr {
+// CodeGen generates instructions for the whole program. Heap objects carry a
+// reference count when refCount is set and floats are operated on by the VFP
+// when vfp is set. References are only released on unwinding when the program
+// catches exceptions
+func (m *AST) CodeGen(refCount bool, vfp bool) <-chan Instr {
 	ch := make(chan Instr)
 	var charr []<-chan Instr
 
 	strPool := &StringPool{}
 	builtInFuncs := &BuiltInFuncs{}
 
+	globals := make(map[string]*GlobalDef)
+	for _, g := range m.globals {
+		globals[g.ident] = g
+	}
+
 	// start codegen for all functions concurrently
-	for _, c := range m.classes {
-		for _, m := range c.methods {
-			charr = append(charr, m.CodeGen(strPool, builtInFuncs))
+	for _, c := range m.Classes() {
+		for _, f := range c.methods {
+			charr = append(charr, f.CodeGen(strPool, builtInFuncs, globals, refCount, m.exceptions, vfp))
 		}
 	}
 	for _, f := range m.functions {
-		charr = append(charr, f.CodeGen(strPool, builtInFuncs))
+		if len(f.typeParams) > 0 {
+			for _, inst := range f.instances {
+				charr = append(charr, inst.CodeGen(strPool, builtInFuncs, globals, refCount, m.exceptions, vfp))
+			}
+			continue
+		}
+		charr = append(charr, f.CodeGen(strPool, builtInFuncs, globals, refCount, m.exceptions, vfp))
+	}
+	for _, f := range m.lambdas {
+		charr = append(charr, f.CodeGen(strPool, builtInFuncs, globals, refCount, m.exceptions, vfp))
 	}
 	mainF := &FunctionDef{
 		ident:      "main",
 		returnType: VoidType{},
-		body:       m.main,
+		body:       m.initGlobals(),
+	}
+	charr = append(charr, mainF.CodeGen(strPool, builtInFuncs, globals, refCount, m.exceptions, vfp))
+
+	// the routines releasing the members of the instances of each class
+	if refCount {
+		for _, c := range m.Classes() {
+			c := c
+			charr = append(charr, codeGenBuiltin(strPool, builtInFuncs, vfp,
+				func(context *FunctionContext, insch chan<- Instr) {
+					releaseMembers(c, context, insch)
+				}))
+		}
 	}
-	charr = append(charr, mainF.CodeGen(strPool, builtInFuncs))
 
 	go func() {
+		if vfp {
+			ch <- &FPUInstr{"vfp"}
+		}
+
 		ch <- &DataSegInstr{}
 
 		// buffer all the text instructions so the global stringpool
@@ -2677,7 +7249,7 @@ func (m *AST) CodeGen() <-chan Instr {
 		// prints, reads, runtime errors
 		for function, print := range builtInFuncs.pool {
 			if print {
-				for instr := range codeGenBuiltin(strPool, builtInFuncs, FSMap[function]) {
+				for instr := range codeGenBuiltin(strPool, builtInFuncs, vfp, FSMap[function]) {
 					txtInstr = append(txtInstr, instr)
 				}
 			}
@@ -2691,6 +7263,59 @@ func (m *AST) CodeGen() <-chan Instr {
 			ch <- &DataASCIIInstr{v.str}
 		}
 
+		// output the globals, longs take two words
+		for _, g := range m.globals {
+			value, _ := g.initialValue()
+			ch <- &LABELInstr{g.Label()}
+			ch <- &DataWordInstr{value}
+			if wordsOf(g.wtype) == 2 {
+				ch <- &DataWordInstr{0}
+			}
+		}
+
+		// output the exception handler, it points to the innermost handler
+		// stored on the stack by a try statement
+		if builtInFuncs.IsUsed(mThrowExceptionLbl) {
+			ch <- &LABELInstr{mExceptionHandler}
+			ch <- &DataWordInstr{0}
+		}
+
+		// output the interfaces, their addresses identify them at runtime
+		for _, i := range m.interfaces {
+			ch <- &LABELInstr{i.MangleSymbol()}
+			ch <- &DataWordInstr{len(i.methods)}
+		}
+
+		// output the virtual tables of the classes preceded by the
+		// method tables of the interfaces they implement
+		for _, c := range m.Classes() {
+			var implemented []*InterfaceType
+			for _, i := range m.interfaces {
+				table, ok := c.InterfaceTable(i)
+				if !ok {
+					continue
+				}
+				implemented = append(implemented, i)
+				ch <- &LABELInstr{c.InterfaceTableLabel(i)}
+				for _, method := range table {
+					ch <- &DataWordLabelInstr{method.Symbol()}
+				}
+			}
+
+			ch <- &LABELInstr{c.InterfacesLabel()}
+			for _, i := range implemented {
+				ch <- &DataWordLabelInstr{i.MangleSymbol()}
+				ch <- &DataWordLabelInstr{c.InterfaceTableLabel(i)}
+			}
+			ch <- &DataWordInstr{0}
+
+			ch <- &DataWordLabelInstr{c.InterfacesLabel()}
+			ch <- &LABELInstr{c.VTableLabel()}
+			for _, method := range c.VTable() {
+				ch <- &DataWordLabelInstr{method.Symbol()}
+			}
+		}
+
 		// output the instructions
 		for _, tin := range txtInstr {
 			ch <- tin
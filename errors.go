@@ -9,6 +9,7 @@ package main
 
 import (
 	"fmt"
+	"strings"
 )
 
 // WACCError is the base error type with filename, line and column number
@@ -202,6 +203,29 @@ func CreateBreakNotInLoopError(token *token32) error {
 	}
 }
 
+// UnknownLabelError is a semantic error when a break or continue statement
+// names a label that no enclosing loop carries
+type UnknownLabelError struct {
+	SemanticError
+	label string
+}
+
+func (e *UnknownLabelError) Error() string {
+	return fmt.Sprintf(
+		"%s: no enclosing loop is labeled '%s'",
+		e.SemanticError.Error(),
+		e.label,
+	)
+}
+
+// CreateUnknownLabelError creates an error from the token and the label
+func CreateUnknownLabelError(token *token32, label string) error {
+	return &UnknownLabelError{
+		SemanticError: CreateSemanticError(token),
+		label:         label,
+	}
+}
+
 // VariableRedeclarationError is a semantic error when a variable is declared
 // again within the same scope
 type VariableRedeclarationError struct {
@@ -449,7 +473,7 @@ func (e *FunctionCallOnNonObjectError) Error() string {
 		"%s: trying to call '%s' on non object of type '%s'",
 		e.SemanticError.Error(),
 		e.ident,
-		e.wtype,
+		e.wtype.String(),
 	)
 }
 
@@ -538,6 +562,150 @@ func CreateNoSuchOverloadError(token *token32, ident string) error {
 	}
 }
 
+// DefaultParamOrderError is a semantic error when a parameter without a
+// default value follows one with a default value
+type DefaultParamOrderError struct {
+	SemanticError
+	ident string
+}
+
+func (e *DefaultParamOrderError) Error() string {
+	return fmt.Sprintf(
+		"%s: parameter '%s' has no default value but follows one that has",
+		e.SemanticError.Error(),
+		e.ident,
+	)
+}
+
+// CreateDefaultParamOrderError creates an error from a token and the name of
+// the parameter
+func CreateDefaultParamOrderError(token *token32, ident string) error {
+	return &DefaultParamOrderError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+	}
+}
+
+// DefaultParamValueError is a semantic error when the default value of a
+// parameter cannot be evaluated at compile time
+type DefaultParamValueError struct {
+	SemanticError
+	ident string
+}
+
+func (e *DefaultParamValueError) Error() string {
+	return fmt.Sprintf(
+		"%s: default value of parameter '%s' is not a constant expression",
+		e.SemanticError.Error(),
+		e.ident,
+	)
+}
+
+// CreateDefaultParamValueError creates an error from a token and the name of
+// the parameter
+func CreateDefaultParamValueError(token *token32, ident string) error {
+	return &DefaultParamValueError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+	}
+}
+
+// InvalidVariadicParamError is a semantic error when a parameter other than
+// the last one, or a parameter of a lambda or an operator, is variadic
+type InvalidVariadicParamError struct {
+	SemanticError
+	ident string
+}
+
+func (e *InvalidVariadicParamError) Error() string {
+	return fmt.Sprintf(
+		"%s: parameter '%s' cannot be variadic here",
+		e.SemanticError.Error(),
+		e.ident,
+	)
+}
+
+// CreateInvalidVariadicParamError creates an error from a token and the name
+// of the parameter
+func CreateInvalidVariadicParamError(token *token32, ident string) error {
+	return &InvalidVariadicParamError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+	}
+}
+
+// GenericLocalFunctionError is a semantic error when a function defined inside
+// a block has type parameters
+type GenericLocalFunctionError struct {
+	SemanticError
+	ident string
+}
+
+func (e *GenericLocalFunctionError) Error() string {
+	return fmt.Sprintf(
+		"%s: local function '%s' cannot have type parameters",
+		e.SemanticError.Error(),
+		e.ident,
+	)
+}
+
+// CreateGenericLocalFunctionError creates an error from a token and the name
+// of the function
+func CreateGenericLocalFunctionError(token *token32, ident string) error {
+	return &GenericLocalFunctionError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+	}
+}
+
+// LocalFunctionValueError is a semantic error when a function defined inside
+// a block is used as a value, which would outlive the frames it accesses
+type LocalFunctionValueError struct {
+	SemanticError
+	ident string
+}
+
+func (e *LocalFunctionValueError) Error() string {
+	return fmt.Sprintf(
+		"%s: local function '%s' cannot be used as a value",
+		e.SemanticError.Error(),
+		e.ident,
+	)
+}
+
+// CreateLocalFunctionValueError creates an error from a token and the name of
+// the function
+func CreateLocalFunctionValueError(token *token32, ident string) error {
+	return &LocalFunctionValueError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+	}
+}
+
+// InvalidDefaultParamError is a semantic error when a parameter of a generic
+// function, an operator, a lambda or an interface method has a default value
+type InvalidDefaultParamError struct {
+	SemanticError
+	ident string
+}
+
+func (e *InvalidDefaultParamError) Error() string {
+	return fmt.Sprintf(
+		"%s: parameter '%s' cannot have a default value here",
+		e.SemanticError.Error(),
+		e.ident,
+	)
+}
+
+// CreateInvalidDefaultParamError creates an error from a token and the name of
+// the parameter
+func CreateInvalidDefaultParamError(token *token32, ident string) error {
+	return &InvalidDefaultParamError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+	}
+}
+
 // InvalidVoidTypeError is a semantic error declaring a variable with void type
 type InvalidVoidTypeError struct {
 	SemanticError
@@ -583,3 +751,535 @@ func CreateVoidAssignmentError(token *token32, ident string) error {
 		ident:         ident,
 	}
 }
+
+// CyclicInheritanceError is a semantic error when a class extends itself
+// directly or through its parents
+type CyclicInheritanceError struct {
+	SemanticError
+	ident string
+}
+
+func (e *CyclicInheritanceError) Error() string {
+	return fmt.Sprintf(
+		"%s: class '%s' inherits from itself",
+		e.SemanticError.Error(),
+		e.ident,
+	)
+}
+
+// CreateCyclicInheritanceError creates an error from a token and a class
+// identifier
+func CreateCyclicInheritanceError(token *token32, ident string) error {
+	return &CyclicInheritanceError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+	}
+}
+
+// OverrideMismatchError is a semantic error when a method overrides an
+// inherited method with an incompatible return type
+type OverrideMismatchError struct {
+	SemanticError
+	ident    string
+	expected Type
+	got      Type
+}
+
+func (e *OverrideMismatchError) Error() string {
+	return fmt.Sprintf(
+		"%s: method '%s' overrides a method returning '%s' but returns '%s'",
+		e.SemanticError.Error(),
+		e.ident,
+		e.expected.String(),
+		e.got.String(),
+	)
+}
+
+// CreateOverrideMismatchError creates an error from a token, a method
+// identifier, the inherited and the overriding return type
+func CreateOverrideMismatchError(token *token32, ident string, expected, got Type) error {
+	return &OverrideMismatchError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+		expected:      expected,
+		got:           got,
+	}
+}
+
+// InterfaceRedeclarationError is a semantic error when an interface is
+// declared again
+type InterfaceRedeclarationError struct {
+	SemanticError
+	ident string
+}
+
+func (e *InterfaceRedeclarationError) Error() string {
+	return fmt.Sprintf(
+		"%s: interface '%s' already declared",
+		e.SemanticError.Error(),
+		e.ident,
+	)
+}
+
+// CreateInterfaceRedeclarationError creates an error from a token and an
+// interface identifier
+func CreateInterfaceRedeclarationError(token *token32, ident string) error {
+	return &InterfaceRedeclarationError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+	}
+}
+
+// UndeclaredInterfaceError is a semantic error when trying to implement an
+// undeclared interface
+type UndeclaredInterfaceError struct {
+	SemanticError
+	ident string
+}
+
+func (e *UndeclaredInterfaceError) Error() string {
+	return fmt.Sprintf(
+		"%s: interface '%s' is undeclared",
+		e.SemanticError.Error(),
+		e.ident,
+	)
+}
+
+// CreateUndeclaredInterfaceError creates an error from a token and an
+// interface identifier
+func CreateUndeclaredInterfaceError(token *token32, ident string) error {
+	return &UndeclaredInterfaceError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+	}
+}
+
+// InterfaceNotImplementedError is a semantic error when a class does not
+// provide all the methods of an interface it declares to implement
+type InterfaceNotImplementedError struct {
+	SemanticError
+	class string
+	ident string
+}
+
+func (e *InterfaceNotImplementedError) Error() string {
+	return fmt.Sprintf(
+		"%s: class '%s' does not implement interface '%s'",
+		e.SemanticError.Error(),
+		e.class,
+		e.ident,
+	)
+}
+
+// CreateInterfaceNotImplementedError creates an error from a token, a class
+// and an interface identifier
+func CreateInterfaceNotImplementedError(token *token32, class, ident string) error {
+	return &InterfaceNotImplementedError{
+		SemanticError: CreateSemanticError(token),
+		class:         class,
+		ident:         ident,
+	}
+}
+
+// TypeInferenceError is a semantic error when the type arguments of a generic
+// function cannot be deduced from its use
+type TypeInferenceError struct {
+	SemanticError
+	ident string
+}
+
+func (e *TypeInferenceError) Error() string {
+	return fmt.Sprintf(
+		"%s: cannot infer the type parameters of function '%s'",
+		e.SemanticError.Error(),
+		e.ident,
+	)
+}
+
+// CreateTypeInferenceError creates an error from a token and a function
+// identifier
+func CreateTypeInferenceError(token *token32, ident string) error {
+	return &TypeInferenceError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+	}
+}
+
+// GenericMethodError is a semantic error when a class method declares type
+// parameters
+type GenericMethodError struct {
+	SemanticError
+	class string
+	ident string
+}
+
+func (e *GenericMethodError) Error() string {
+	return fmt.Sprintf(
+		"%s: method '%s' of class '%s' cannot have type parameters",
+		e.SemanticError.Error(),
+		e.ident,
+		e.class,
+	)
+}
+
+// CreateGenericMethodError creates an error from a token, a class and a method
+// identifier
+func CreateGenericMethodError(token *token32, class, ident string) error {
+	return &GenericMethodError{
+		SemanticError: CreateSemanticError(token),
+		class:         class,
+		ident:         ident,
+	}
+}
+
+// TypeArgumentsError is a semantic error when a class is used with a number of
+// type arguments different from the number of its type parameters
+type TypeArgumentsError struct {
+	SemanticError
+	class Type
+}
+
+func (e *TypeArgumentsError) Error() string {
+	return fmt.Sprintf(
+		"%s: wrong number of type arguments for class '%s'",
+		e.SemanticError.Error(),
+		e.class,
+	)
+}
+
+// CreateTypeArgumentsError creates an error from a token and a class type
+func CreateTypeArgumentsError(token *token32, class Type) error {
+	return &TypeArgumentsError{
+		SemanticError: CreateSemanticError(token),
+		class:         class,
+	}
+}
+
+// EnumCaseError is a semantic error when a case of a switch on an enum is not
+// a value of that enum
+type EnumCaseError struct {
+	SemanticError
+	enum Type
+}
+
+func (e *EnumCaseError) Error() string {
+	return fmt.Sprintf(
+		"%s: case is not a value of '%s'",
+		e.SemanticError.Error(),
+		e.enum,
+	)
+}
+
+// CreateEnumCaseError creates an error from a token and the enum switched on
+func CreateEnumCaseError(token *token32, enum Type) error {
+	return &EnumCaseError{
+		SemanticError: CreateSemanticError(token),
+		enum:          enum,
+	}
+}
+
+// DuplicateCaseError is a semantic error when a value of an enum is handled by
+// more than one case of a switch
+type DuplicateCaseError struct {
+	SemanticError
+	ident string
+	field string
+}
+
+func (e *DuplicateCaseError) Error() string {
+	return fmt.Sprintf(
+		"%s: duplicate case '%s->%s'",
+		e.SemanticError.Error(),
+		e.ident,
+		e.field,
+	)
+}
+
+// CreateDuplicateCaseError creates an error from a token, the enum identifier
+// and the value handled twice
+func CreateDuplicateCaseError(token *token32, ident, field string) error {
+	return &DuplicateCaseError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+		field:         field,
+	}
+}
+
+// MissingCasesError is a semantic error when a switch on an enum without a
+// default case does not handle all of its values
+type MissingCasesError struct {
+	SemanticError
+	enum    Type
+	missing []string
+}
+
+func (e *MissingCasesError) Error() string {
+	return fmt.Sprintf(
+		"%s: switch on '%s' does not handle %s",
+		e.SemanticError.Error(),
+		e.enum,
+		strings.Join(e.missing, ", "),
+	)
+}
+
+// CreateMissingCasesError creates an error from a token, the enum switched on
+// and the values not handled
+func CreateMissingCasesError(token *token32, enum Type, missing []string) error {
+	return &MissingCasesError{
+		SemanticError: CreateSemanticError(token),
+		enum:          enum,
+		missing:       missing,
+	}
+}
+
+// ModuleRedeclarationError is a semantic error when two modules are imported
+// under the same alias
+type ModuleRedeclarationError struct {
+	SemanticError
+	alias string
+}
+
+func (e *ModuleRedeclarationError) Error() string {
+	return fmt.Sprintf(
+		"%s: module '%s' already imported",
+		e.SemanticError.Error(),
+		e.alias,
+	)
+}
+
+// CreateModuleRedeclarationError creates an error from a token and the alias
+// of the module
+func CreateModuleRedeclarationError(token *token32, alias string) error {
+	return &ModuleRedeclarationError{
+		SemanticError: CreateSemanticError(token),
+		alias:         alias,
+	}
+}
+
+// ModuleClashError is a semantic error when modules in different files have
+// the same identifier
+type ModuleClashError struct {
+	SemanticError
+	file  string
+	ident string
+}
+
+func (e *ModuleClashError) Error() string {
+	return fmt.Sprintf(
+		"%s: module '%s' clashes with another module named '%s'",
+		e.SemanticError.Error(),
+		e.file,
+		e.ident,
+	)
+}
+
+// CreateModuleClashError creates an error from a token, the file and the
+// identifier of the module
+func CreateModuleClashError(token *token32, file, ident string) error {
+	return &ModuleClashError{
+		SemanticError: CreateSemanticError(token),
+		file:          file,
+		ident:         ident,
+	}
+}
+
+// ConstantAssignmentError is a semantic error when a constant is assigned to
+type ConstantAssignmentError struct {
+	SemanticError
+	ident string
+}
+
+func (e *ConstantAssignmentError) Error() string {
+	return fmt.Sprintf(
+		"%s: cannot assign to constant '%s'",
+		e.SemanticError.Error(),
+		e.ident,
+	)
+}
+
+// CreateConstantAssignmentError creates an error from a token and the name of
+// the constant
+func CreateConstantAssignmentError(token *token32, ident string) error {
+	return &ConstantAssignmentError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+	}
+}
+
+// ConstantExpressionError is a semantic error when a constant is initialised
+// by an expression that cannot be evaluated at compile time
+type ConstantExpressionError struct {
+	SemanticError
+	ident string
+}
+
+func (e *ConstantExpressionError) Error() string {
+	return fmt.Sprintf(
+		"%s: constant '%s' is not initialised by a constant expression",
+		e.SemanticError.Error(),
+		e.ident,
+	)
+}
+
+// CreateConstantExpressionError creates an error from a token and the name of
+// the constant
+func CreateConstantExpressionError(token *token32, ident string) error {
+	return &ConstantExpressionError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+	}
+}
+
+// AccessViolationError is a semantic error when a private method or member is
+// used outside of the class declaring it
+type AccessViolationError struct {
+	SemanticError
+	class string
+	ident string
+}
+
+func (e *AccessViolationError) Error() string {
+	return fmt.Sprintf(
+		"%s: '%s' is private to class '%s'",
+		e.SemanticError.Error(),
+		e.ident,
+		e.class,
+	)
+}
+
+// CreateAccessViolationError creates an error from a token, the name of the
+// class and the private method or member
+func CreateAccessViolationError(token *token32, class, ident string) error {
+	return &AccessViolationError{
+		SemanticError: CreateSemanticError(token),
+		class:         class,
+		ident:         ident,
+	}
+}
+
+// InvalidDestructorError is a semantic error when the fini method of a class
+// takes parameters or returns a value
+type InvalidDestructorError struct {
+	SemanticError
+	class string
+}
+
+func (e *InvalidDestructorError) Error() string {
+	return fmt.Sprintf(
+		"%s: destructor of class '%s' must be declared as 'void fini()'",
+		e.SemanticError.Error(),
+		e.class,
+	)
+}
+
+// CreateInvalidDestructorError creates an error from a token and the name of
+// the class
+func CreateInvalidDestructorError(token *token32, class string) error {
+	return &InvalidDestructorError{
+		SemanticError: CreateSemanticError(token),
+		class:         class,
+	}
+}
+
+// ScopedTypeError is a semantic error when declaring a scoped variable that
+// does not hold a class instance
+type ScopedTypeError struct {
+	SemanticError
+	ident string
+	wtype Type
+}
+
+func (e *ScopedTypeError) Error() string {
+	return fmt.Sprintf(
+		"%s: scoped variable '%s' must hold a class instance, not '%s'",
+		e.SemanticError.Error(),
+		e.ident,
+		e.wtype,
+	)
+}
+
+// CreateScopedTypeError creates an error from a token, an identifier and the
+// type of the variable
+func CreateScopedTypeError(token *token32, ident string, wtype Type) error {
+	return &ScopedTypeError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+		wtype:         wtype,
+	}
+}
+
+// ScopedVariableError is a semantic error when freeing or reassigning a scoped
+// variable, whose instance is destroyed when leaving its scope
+type ScopedVariableError struct {
+	SemanticError
+	ident  string
+	action string
+}
+
+func (e *ScopedVariableError) Error() string {
+	return fmt.Sprintf(
+		"%s: scoped variable '%s' cannot be %s",
+		e.SemanticError.Error(),
+		e.ident,
+		e.action,
+	)
+}
+
+// CreateScopedVariableError creates an error from a token, an identifier and
+// the action performed on the variable
+func CreateScopedVariableError(token *token32, ident, action string) error {
+	return &ScopedVariableError{
+		SemanticError: CreateSemanticError(token),
+		ident:         ident,
+		action:        action,
+	}
+}
+
+// Warning is the base type for warnings, which do not stop the compilation
+type Warning struct {
+	WACCError
+}
+
+// CreateWarning initializes the WACCError position information
+func CreateWarning(token *token32) Warning {
+	return Warning{
+		WACCError: CreateWACCError(token),
+	}
+}
+
+func (e *Warning) Error() string {
+	return fmt.Sprintf(
+		"%s:warning",
+		e.WACCError.Error(),
+	)
+}
+
+func (e *Warning) warning() {}
+
+// IsWarning returns whether the error is a warning
+func IsWarning(err error) bool {
+	_, ok := err.(interface {
+		warning()
+	})
+	return ok
+}
+
+// FreeIgnoredWarning is a warning when freeing memory by hand while the
+// objects are reference counted
+type FreeIgnoredWarning struct {
+	Warning
+}
+
+func (e *FreeIgnoredWarning) Error() string {
+	return fmt.Sprintf(
+		"%s: free is ignored as objects are reference counted",
+		e.Warning.Error(),
+	)
+}
+
+// CreateFreeIgnoredWarning creates a warning from a token
+func CreateFreeIgnoredWarning(token *token32) error {
+	return &FreeIgnoredWarning{
+		Warning: CreateWarning(token),
+	}
+}
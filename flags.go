@@ -10,6 +10,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"path/filepath"
 	"strings"
 )
@@ -25,6 +26,8 @@ type Flags struct {
 	printAssembly bool
 	noassembly    bool
 	optimise      bool
+	gc            string
+	float         string
 }
 
 // Parse defines all the flags and then parses the command line args
@@ -45,9 +48,21 @@ func (f *Flags) Parse() {
 		"Assembly file not produced, no assembly to STD Output")
 	flag.BoolVar(&f.optimise, "optimise", false,
 		"Optimise the AST generated from the WACC file")
+	flag.StringVar(&f.gc, "gc", "",
+		"Memory management mode, rc counts the references to heap objects")
+	flag.StringVar(&f.float, "float", "soft",
+		"Floating point mode, soft calls library helpers, vfp uses the FPU")
 
 	flag.Parse()
 
+	if f.gc != "" && f.gc != "rc" {
+		log.Fatalf("unknown memory management mode %s", f.gc)
+	}
+
+	if f.float != "soft" && f.float != "vfp" {
+		log.Fatalf("unknown floating point mode %s", f.float)
+	}
+
 	f.assemblyfile = filepath.Base(
 		strings.TrimSuffix(
 			f.filename,
@@ -63,6 +78,18 @@ func (f *Flags) Start() {
 	}
 }
 
+// RefCount returns whether the heap objects of the program are reference
+// counted
+func (f *Flags) RefCount() bool {
+	return f.gc == "rc"
+}
+
+// VFP returns whether floating point operations are compiled to VFP
+// instructions rather than calls to the soft-float library
+func (f *Flags) VFP() bool {
+	return f.float == "vfp"
+}
+
 // Finish prints finished message when verbose flag is set
 func (f *Flags) Finish() {
 	if f.verbose {
@@ -16,27 +16,35 @@ import (
 type Cond int
 
 var condMap = map[int]string{
-	1: "EQ",
-	2: "NE",
-	3: "GE",
-	4: "LT",
-	5: "GT",
-	6: "LE",
-	7: "AL",
-	8: "CS",
-	9: "VS",
+	1:  "EQ",
+	2:  "NE",
+	3:  "GE",
+	4:  "LT",
+	5:  "GT",
+	6:  "LE",
+	7:  "AL",
+	8:  "CS",
+	9:  "VS",
+	10: "MI",
+	11: "PL",
+	12: "LS",
+	13: "HI",
 }
 
 var oppCondMap = map[int]int{
-	1: condNE,
-	2: condEQ,
-	3: condLT,
-	4: condGE,
-	5: condLE,
-	6: condGT,
-	7: condAL,
-	8: condCS,
-	9: condVS,
+	1:  condNE,
+	2:  condEQ,
+	3:  condLT,
+	4:  condGE,
+	5:  condLE,
+	6:  condGT,
+	7:  condAL,
+	8:  condCS,
+	9:  condVS,
+	10: condPL,
+	11: condMI,
+	12: condHI,
+	13: condLS,
 }
 
 const (
@@ -49,6 +57,10 @@ const (
 	condAL = 7
 	condCS = 8
 	condVS = 9
+	condMI = 10
+	condPL = 11
+	condLS = 12
+	condHI = 13
 )
 
 // Returns String representation Cond given,
@@ -124,6 +136,12 @@ type NOTInstr struct {
 	BaseUnaryInstr
 }
 
+//MVNInstr struct
+// --> MVN dest, arg
+type MVNInstr struct {
+	BaseUnaryInstr
+}
+
 // Returns string representation of NEGString struct
 // --> NEG dest, arg
 func (m *NEGInstr) String() string {
@@ -136,6 +154,12 @@ func (m *NOTInstr) String() string {
 	return fmt.Sprintf("\tEOR %v, %v, #1", m.dest, m.arg)
 }
 
+// Returns string representation of MVNInstr struct
+// --> MVN dest, arg
+func (m *MVNInstr) String() string {
+	return fmt.Sprintf("\tMVN %v, %v", m.dest, m.arg)
+}
+
 //------------------------------------------------------------------------------
 // ARITHMETIC OPERATORS
 //------------------------------------------------------------------------------
@@ -163,10 +187,13 @@ type ImmediateOperand struct {
 
 //RegisterOperand struct
 //-->reg, shift, #amount
+//-->reg, shift, by
+//where the register by holds the amount if set
 type RegisterOperand struct {
 	reg    Reg
 	shift  Shift
 	amount int
+	by     Reg
 }
 
 //CharOperand struct
@@ -192,6 +219,24 @@ type RSBInstr struct {
 	BaseBinaryInstr
 }
 
+//ADCInstr struct
+//-->ADC(COND) dest, lhs, rhs
+type ADCInstr struct {
+	BaseBinaryInstr
+}
+
+//SBCInstr struct
+//-->SBC(COND) dest, lhs, rhs
+type SBCInstr struct {
+	BaseBinaryInstr
+}
+
+//RSCInstr struct
+//-->RSC(COND) dest, lhs, rhs
+type RSCInstr struct {
+	BaseBinaryInstr
+}
+
 // Returns String representation of ImmediateOperand
 //--> #n
 func (m ImmediateOperand) String() string {
@@ -200,7 +245,12 @@ func (m ImmediateOperand) String() string {
 
 // Returns String representation of RegisterOperand
 // --> reg, shift, #amount
+// --> reg, shift, by
 func (m RegisterOperand) String() string {
+	if m.shift > 0 && m.by != nil {
+		return fmt.Sprintf("%v, %v %v", m.reg, m.shift, m.by)
+	}
+
 	if m.shift > 0 {
 		return fmt.Sprintf("%v, %v #%d", m.reg, m.shift, m.amount)
 	}
@@ -232,6 +282,24 @@ func (m *RSBInstr) String() string {
 	return fmt.Sprintf("\tRSBS%v %v, %v, %v", m.cond, m.dest, m.lhs, m.rhs)
 }
 
+// Returns the String representation of the ADC Instruction
+//--> ADC(COND) dest, lhs, rhs
+func (m *ADCInstr) String() string {
+	return fmt.Sprintf("\tADCS%v %v, %v, %v", m.cond, m.dest, m.lhs, m.rhs)
+}
+
+// Returns the String representation of the SBC Instruction
+//--> SBC(COND) dest, lhs, rhs
+func (m *SBCInstr) String() string {
+	return fmt.Sprintf("\tSBCS%v %v, %v, %v", m.cond, m.dest, m.lhs, m.rhs)
+}
+
+// Returns the String representation of the RSC Instruction
+//--> RSC(COND) dest, lhs, rhs
+func (m *RSCInstr) String() string {
+	return fmt.Sprintf("\tRSCS%v %v, %v, %v", m.cond, m.dest, m.lhs, m.rhs)
+}
+
 //------------------------------------------------------------------------------
 //COMPARISON OPERATORS
 //------------------------------------------------------------------------------
@@ -394,6 +462,149 @@ func (m *SMULLInstr) String() string {
 	return fmt.Sprintf("\tSMULL%v %v, %v, %v, %v", m.cond, m.RdLo, m.RdHi, m.Rm, m.Rs)
 }
 
+//UMULLInstr struct
+// UMULL(COND) RdLo, RdHi, Rm, Rs
+type UMULLInstr struct {
+	cond Cond
+	RdLo Reg
+	RdHi Reg
+	Rm   Reg
+	Rs   Reg
+}
+
+//Returns the string representation of the UMULLInstr given
+// UMULL(COND) RdLo, RdHi, Rm, Rs
+func (m *UMULLInstr) String() string {
+	return fmt.Sprintf("\tUMULL%v %v, %v, %v, %v", m.cond, m.RdLo, m.RdHi, m.Rm, m.Rs)
+}
+
+//------------------------------------------------------------------------------
+// FLOATING POINT INSTRUCTIONS
+//------------------------------------------------------------------------------
+
+//VMOVInstr struct
+//--> VMOV dest, source
+//where one of the registers is a VFP register
+type VMOVInstr struct {
+	dest   Reg
+	source Reg
+}
+
+//VMOVPairInstr struct
+//--> VMOV RdLo, RdHi, Dm
+type VMOVPairInstr struct {
+	RdLo Reg
+	RdHi Reg
+	Dm   Reg
+}
+
+//BaseVFPInstr struct
+//--> (VFPINSTR).F32 dest, lhs, rhs
+type BaseVFPInstr struct {
+	dest Reg
+	lhs  Reg
+	rhs  Reg
+}
+
+//VADDInstr struct
+//--> VADD.F32 dest, lhs, rhs
+type VADDInstr struct {
+	BaseVFPInstr
+}
+
+//VSUBInstr struct
+//--> VSUB.F32 dest, lhs, rhs
+type VSUBInstr struct {
+	BaseVFPInstr
+}
+
+//VMULInstr struct
+//--> VMUL.F32 dest, lhs, rhs
+type VMULInstr struct {
+	BaseVFPInstr
+}
+
+//VDIVInstr struct
+//--> VDIV.F32 dest, lhs, rhs
+type VDIVInstr struct {
+	BaseVFPInstr
+}
+
+//VCMPInstr struct
+//--> VCMP.F32 lhs, rhs
+type VCMPInstr struct {
+	lhs Reg
+	rhs Reg
+}
+
+//VMRSInstr struct
+//--> VMRS APSR_nzcv, FPSCR
+type VMRSInstr struct{}
+
+//VCVTInstr struct
+//--> VCVT.to.from dest, source
+//where to and from are F64, F32 or S32
+type VCVTInstr struct {
+	to     string
+	from   string
+	dest   Reg
+	source Reg
+}
+
+//Returns the string representation of the VMOVInstr given
+//--> VMOV dest, source
+func (m *VMOVInstr) String() string {
+	return fmt.Sprintf("\tVMOV %v, %v", m.dest, m.source)
+}
+
+//Returns the string representation of the VMOVPairInstr given
+//--> VMOV RdLo, RdHi, Dm
+func (m *VMOVPairInstr) String() string {
+	return fmt.Sprintf("\tVMOV %v, %v, %v", m.RdLo, m.RdHi, m.Dm)
+}
+
+//Returns the string representation of the VADDInstr given
+//--> VADD.F32 dest, lhs, rhs
+func (m *VADDInstr) String() string {
+	return fmt.Sprintf("\tVADD.F32 %v, %v, %v", m.dest, m.lhs, m.rhs)
+}
+
+//Returns the string representation of the VSUBInstr given
+//--> VSUB.F32 dest, lhs, rhs
+func (m *VSUBInstr) String() string {
+	return fmt.Sprintf("\tVSUB.F32 %v, %v, %v", m.dest, m.lhs, m.rhs)
+}
+
+//Returns the string representation of the VMULInstr given
+//--> VMUL.F32 dest, lhs, rhs
+func (m *VMULInstr) String() string {
+	return fmt.Sprintf("\tVMUL.F32 %v, %v, %v", m.dest, m.lhs, m.rhs)
+}
+
+//Returns the string representation of the VDIVInstr given
+//--> VDIV.F32 dest, lhs, rhs
+func (m *VDIVInstr) String() string {
+	return fmt.Sprintf("\tVDIV.F32 %v, %v, %v", m.dest, m.lhs, m.rhs)
+}
+
+//Returns the string representation of the VCMPInstr given
+//--> VCMP.F32 lhs, rhs
+func (m *VCMPInstr) String() string {
+	return fmt.Sprintf("\tVCMP.F32 %v, %v", m.lhs, m.rhs)
+}
+
+//Returns the string representation of the VMRSInstr given
+//--> VMRS APSR_nzcv, FPSCR
+func (m *VMRSInstr) String() string {
+	return "\tVMRS APSR_nzcv, FPSCR"
+}
+
+//Returns the string representation of the VCVTInstr given
+//--> VCVT.to.from dest, source
+func (m *VCVTInstr) String() string {
+	return fmt.Sprintf("\tVCVT.%s.%s %v, %v", m.to, m.from, m.dest, m.source)
+}
+
 //------------------------------------------------------------------------------
 // LOAD / STORE INSTRUCTIONS
 //------------------------------------------------------------------------------
@@ -647,6 +858,19 @@ func (m *BLInstr) String() string {
 	return fmt.Sprintf("\tBL%s %s", m.cond.String(), m.label)
 }
 
+//BLXInstr struct
+//--> BLX(COND) reg
+type BLXInstr struct {
+	reg  Reg
+	cond Cond
+}
+
+//Returns the string representation of BLXInstr given
+//--> BLX(COND) reg
+func (m *BLXInstr) String() string {
+	return fmt.Sprintf("\tBLX%s %v", m.cond.String(), m.reg)
+}
+
 //------------------------------------------------------------------------------
 //SEGMENTS
 //------------------------------------------------------------------------------
@@ -665,6 +889,15 @@ func (m *TextSegInstr) String() string {
 	return ".text"
 }
 
+// FPUInstr selects the floating point unit the code is assembled for
+type FPUInstr struct {
+	fpu string
+}
+
+func (m *FPUInstr) String() string {
+	return fmt.Sprintf(".fpu %s", m.fpu)
+}
+
 // GlobalInstr exposes the argument to the linker
 type GlobalInstr struct {
 	label string
@@ -694,6 +927,15 @@ func (m *DataWordInstr) String() string {
 	return fmt.Sprintf("\t.word %d", m.n)
 }
 
+//DataWordLabelInstr struct
+type DataWordLabelInstr struct {
+	label string
+}
+
+func (m *DataWordLabelInstr) String() string {
+	return fmt.Sprintf("\t.word %s", m.label)
+}
+
 //DataASCIIInstr type
 type DataASCIIInstr struct {
 	str string
@@ -6,10 +6,15 @@ package main
 //
 // The File contains functions to optimise a given AST.
 
+import (
+	"math"
+)
+
 // OptimisationContext holds information that can be useful during optimisation
 type OptimisationContext struct {
 	conditional []bool
 	literals    []map[string]Expression
+	shared      map[string]bool
 }
 
 // StartScope starts a new scope where variables can be declared
@@ -51,9 +56,21 @@ func (m *OptimisationContext) AssignLiteral(ident string, expr Expression) {
 	}
 }
 
+// Share marks a variable as accessed by a local function. Calls to the
+// function can change it so it is no longer replaced by a literal
+func (m *OptimisationContext) Share(ident string) {
+	if m.shared == nil {
+		m.shared = make(map[string]bool)
+	}
+	m.shared[ident] = true
+}
+
 // LookupLiteral looks for a literal for the identifier in the scope
 // ok is true if found and has value
 func (m *OptimisationContext) LookupLiteral(ident string) (Expression, bool) {
+	if m.shared[ident] {
+		return nil, false
+	}
 	for i, lmap := range m.literals {
 		if m.conditional[i] {
 			return nil, false
@@ -107,6 +124,8 @@ func (m *DeclareAssignStatement) Optimise(context *OptimisationContext) Statemen
 	case *ExpressionRHS:
 		switch e := eRHS.expr.(type) {
 		case *IntLiteral,
+			*LongLiteral,
+			*FloatLiteral,
 			*CharLiteral,
 			*BoolLiteralTrue,
 			*BoolLiteralFalse:
@@ -135,6 +154,8 @@ func (m *AssignStatement) Optimise(context *OptimisationContext) Statement {
 		case *ExpressionRHS:
 			switch e := eRHS.expr.(type) {
 			case *IntLiteral,
+				*LongLiteral,
+				*FloatLiteral,
 				*CharLiteral,
 				*BoolLiteralTrue,
 				*BoolLiteralFalse:
@@ -180,6 +201,18 @@ func (m *FreeStatement) Optimise(context *OptimisationContext) Statement {
 	return m
 }
 
+//Optimise optimises for DeleteStatement
+func (m *DeleteStatement) Optimise(context *OptimisationContext) Statement {
+	m.expr = m.expr.Optimise(context)
+	m.key = m.key.Optimise(context)
+
+	if m.next != nil {
+		m.SetNext(m.next.Optimise(context))
+	}
+
+	return m
+}
+
 //Optimise optimises for ReturnStatement
 func (m *ReturnStatement) Optimise(context *OptimisationContext) Statement {
 	m.expr = m.expr.Optimise(context)
@@ -199,6 +232,38 @@ func (m *ExitStatement) Optimise(context *OptimisationContext) Statement {
 	return m
 }
 
+//Optimise optimises for ThrowStatement
+func (m *ThrowStatement) Optimise(context *OptimisationContext) Statement {
+	m.expr = m.expr.Optimise(context)
+
+	m.next = nil
+	return m
+}
+
+//Optimise optimises for TryStatement
+// The body can be left at any point so both bodies are conditional
+func (m *TryStatement) Optimise(context *OptimisationContext) Statement {
+	context.StartCondScope()
+	m.body = m.body.Optimise(context)
+	context.EndScope()
+
+	context.StartCondScope()
+	if m.catchStat != nil {
+		m.catchStat = m.catchStat.Optimise(context)
+	}
+	context.EndScope()
+
+	if m.next != nil {
+		m.SetNext(m.next.Optimise(context))
+	}
+
+	if m.body == nil {
+		return m.next
+	}
+
+	return m
+}
+
 //Optimise optimises for PrintLnStatement
 func (m *PrintLnStatement) Optimise(context *OptimisationContext) Statement {
 	m.expr = m.expr.Optimise(context)
@@ -427,6 +492,49 @@ func (m *ForStatement) Optimise(context *OptimisationContext) Statement {
 	return m
 }
 
+//Optimise optimises for ForEachStatement
+func (m *ForEachStatement) Optimise(context *OptimisationContext) Statement {
+	m.expr = m.expr.Optimise(context)
+
+	context.StartCondScope()
+	context.DeclareLiteral(m.ident, nil)
+	m.body = m.body.Optimise(context)
+	context.EndScope()
+
+	if m.body != nil {
+		context.StartScope()
+		context.DeclareLiteral(m.ident, nil)
+		m.body = m.body.Optimise(context)
+		context.EndScope()
+	}
+
+	if m.next != nil {
+		m.SetNext(m.next.Optimise(context))
+	}
+
+	return m
+}
+
+//Optimise optimises for LocalFuncStatement
+// The body is optimised on its own as the values of the variables it shares
+// with the enclosing functions are only known when it is called
+func (m *LocalFuncStatement) Optimise(context *OptimisationContext) Statement {
+	for _, ident := range m.function.shared {
+		context.Share(ident)
+	}
+
+	ctx := &OptimisationContext{}
+	ctx.StartScope()
+	m.function.body = m.function.body.Optimise(ctx)
+	ctx.EndScope()
+
+	if m.next != nil {
+		m.SetNext(m.next.Optimise(context))
+	}
+
+	return m
+}
+
 //Optimise optimises for PairElemLHS
 func (m *PairElemLHS) Optimise(context *OptimisationContext) LHS {
 	m.expr = m.expr.Optimise(context)
@@ -461,6 +569,15 @@ func (m *ArrayLiterRHS) Optimise(context *OptimisationContext) RHS {
 	return m
 }
 
+//Optimise optimises for MapLiterRHS
+func (m *MapLiterRHS) Optimise(context *OptimisationContext) RHS {
+	for i := range m.keys {
+		m.keys[i] = m.keys[i].Optimise(context)
+		m.values[i] = m.values[i].Optimise(context)
+	}
+	return m
+}
+
 //Optimise optimises for PairElemRHS
 func (m *PairElemRHS) Optimise(context *OptimisationContext) RHS {
 	m.expr = m.expr.Optimise(context)
@@ -495,6 +612,12 @@ func (m *NewInstanceRHS) Optimise(context *OptimisationContext) RHS {
 
 //Optimise optimises for Ident
 func (m *Ident) Optimise(context *OptimisationContext) Expression {
+	if m.global != nil {
+		if liter, ok := m.global.Literal(); ok {
+			return liter
+		}
+		return m
+	}
 	if liter, ok := context.LookupLiteral(m.ident); ok {
 		if !m.Type().Match(liter.Type()) {
 			panic("replacing wrong type")
@@ -504,11 +627,28 @@ func (m *Ident) Optimise(context *OptimisationContext) Expression {
 	return m
 }
 
+//Optimise optimises for Lambda
+// The body is optimised with the other functions as the values it captures
+// are only known when the closure is created
+func (m *Lambda) Optimise(context *OptimisationContext) Expression {
+	return m
+}
+
 //Optimise optimises for IntLiteral
 func (m *IntLiteral) Optimise(context *OptimisationContext) Expression {
 	return m
 }
 
+//Optimise optimises for LongLiteral
+func (m *LongLiteral) Optimise(context *OptimisationContext) Expression {
+	return m
+}
+
+//Optimise optimises for FloatLiteral
+func (m *FloatLiteral) Optimise(context *OptimisationContext) Expression {
+	return m
+}
+
 func (m *EnumLiteral) Optimise(context *OptimisationContext) Expression {
 	return &IntLiteral{value: m.value}
 }
@@ -546,6 +686,13 @@ func (m *ArrayElem) Optimise(context *OptimisationContext) Expression {
 	return m
 }
 
+//Optimise optimises for MapHas
+func (m *MapHas) Optimise(context *OptimisationContext) Expression {
+	m.expr = m.expr.Optimise(context)
+	m.key = m.key.Optimise(context)
+	return m
+}
+
 //------------------------------------------------------------------------------
 // UNARY OPERATOR OPTIMISATION
 //------------------------------------------------------------------------------
@@ -564,6 +711,26 @@ func getIntLiter(unaryExpr UnaryOperator) (value int, ok bool) {
 	return
 }
 
+func getLongLiter(unaryExpr UnaryOperator) (value int64, ok bool) {
+	val, ok := unaryExpr.GetExpression().(*LongLiteral)
+
+	if ok {
+		value = val.value
+	}
+
+	return
+}
+
+func getFloatLiter(unaryExpr UnaryOperator) (value float32, ok bool) {
+	val, ok := unaryExpr.GetExpression().(*FloatLiteral)
+
+	if ok {
+		value = val.value
+	}
+
+	return
+}
+
 func getBoolLiter(unaryExpr UnaryOperator) (value bool, ok bool) {
 	ok = true
 
@@ -604,6 +771,21 @@ func (m *UnaryOperatorNegate) Optimise(context *OptimisationContext) Expression
 	if val, ok := getIntLiter(m); ok && in32(-val) {
 		return &IntLiteral{value: -val}
 	}
+	if val, ok := getLongLiter(m); ok && val != minLong {
+		return &LongLiteral{value: -val}
+	}
+	if val, ok := getFloatLiter(m); ok {
+		return &FloatLiteral{value: -val}
+	}
+	return m
+}
+
+//Optimise optimises for UnaryOperatorBitNot
+func (m *UnaryOperatorBitNot) Optimise(context *OptimisationContext) Expression {
+	m.UnaryOperatorBase.optimiseUnary(context)
+	if val, ok := getIntLiter(m); ok {
+		return &IntLiteral{value: ^val}
+	}
 	return m
 }
 
@@ -631,6 +813,37 @@ func (m *UnaryOperatorChr) Optimise(context *OptimisationContext) Expression {
 	return m
 }
 
+//Optimise optimises for UnaryOperatorLong
+func (m *UnaryOperatorLong) Optimise(context *OptimisationContext) Expression {
+	m.UnaryOperatorBase.optimiseUnary(context)
+	if val, ok := getIntLiter(m); ok {
+		return &LongLiteral{value: int64(val)}
+	}
+	return m
+}
+
+//Optimise optimises for UnaryOperatorInt
+func (m *UnaryOperatorInt) Optimise(context *OptimisationContext) Expression {
+	m.UnaryOperatorBase.optimiseUnary(context)
+	if val, ok := getLongLiter(m); ok && in32(int(val)) {
+		return &IntLiteral{value: int(val)}
+	}
+	// out of range floats saturate at runtime so they are not folded
+	if val, ok := getFloatLiter(m); ok && -2147483649 < val && val < 2147483648 {
+		return &IntLiteral{value: int(val)}
+	}
+	return m
+}
+
+//Optimise optimises for UnaryOperatorFloat
+func (m *UnaryOperatorFloat) Optimise(context *OptimisationContext) Expression {
+	m.UnaryOperatorBase.optimiseUnary(context)
+	if val, ok := getIntLiter(m); ok {
+		return &FloatLiteral{value: float32(val)}
+	}
+	return m
+}
+
 //------------------------------------------------------------------------------
 // BINARY OPERATOR OPTIMISATION
 //------------------------------------------------------------------------------
@@ -640,6 +853,19 @@ func (m *BinaryOperatorBase) optimiseBinary(context *OptimisationContext) {
 	m.rhs = m.rhs.Optimise(context)
 }
 
+// optimiseShortCircuit optimises the operands of the logical operators. The
+// RHS is executed only depending on the value of the LHS so it is optimised in
+// a conditional scope
+func (m *BinaryOperatorBase) optimiseShortCircuit(context *OptimisationContext) {
+	m.lhs = m.lhs.Optimise(context)
+	context.StartCondScope()
+	m.rhs = m.rhs.Optimise(context)
+	context.EndScope()
+}
+
+// minLong is the smallest long, its negation overflows
+const minLong = -1 << 63
+
 func in32(value int) bool {
 	return -2147483648 <= value && value <= 2147483647
 }
@@ -657,6 +883,63 @@ func getIntLiters(binExpr BinaryOperator) (lhsv, rhsv int, ok bool) {
 	return
 }
 
+func getLongLiters(binExpr BinaryOperator) (lhsv, rhsv int64, ok bool) {
+	lhs, ok1 := binExpr.GetLHS().(*LongLiteral)
+	rhs, ok2 := binExpr.GetRHS().(*LongLiteral)
+
+	ok = ok1 && ok2
+	if ok {
+		lhsv = lhs.value
+		rhsv = rhs.value
+	}
+
+	return
+}
+
+// addInLong returns the sum of two longs, ok is false if it overflows
+func addInLong(lhsv, rhsv int64) (int64, bool) {
+	sum := lhsv + rhsv
+	return sum, (sum > lhsv) == (rhsv > 0)
+}
+
+// subInLong returns the difference of two longs, ok is false if it overflows
+func subInLong(lhsv, rhsv int64) (int64, bool) {
+	diff := lhsv - rhsv
+	return diff, (diff < lhsv) == (rhsv > 0)
+}
+
+// mulInLong returns the product of two longs, ok is false if it overflows
+func mulInLong(lhsv, rhsv int64) (int64, bool) {
+	if lhsv == 0 || rhsv == 0 {
+		return 0, true
+	}
+	prod := lhsv * rhsv
+	return prod, prod/rhsv == lhsv && !(rhsv == -1 && lhsv == minLong)
+}
+
+// floatLiteral returns the literal holding a folded float. A NaN is replaced
+// by the default NaN 0x7FC00000 the ARM runtime produces, the sign of the one
+// produced by the host may differ
+func floatLiteral(value float32) *FloatLiteral {
+	if value != value {
+		value = math.Float32frombits(0x7FC00000)
+	}
+	return &FloatLiteral{value: value}
+}
+
+func getFloatLiters(binExpr BinaryOperator) (lhsv, rhsv float32, ok bool) {
+	lhs, ok1 := binExpr.GetLHS().(*FloatLiteral)
+	rhs, ok2 := binExpr.GetRHS().(*FloatLiteral)
+
+	ok = ok1 && ok2
+	if ok {
+		lhsv = lhs.value
+		rhsv = rhs.value
+	}
+
+	return
+}
+
 func getBoolLiters(binExpr BinaryOperator) (lhsv, rhsv bool, ok bool) {
 	ok = true
 
@@ -707,6 +990,14 @@ func (m *BinaryOperatorMult) Optimise(context *OptimisationContext) Expression {
 	if lhsv, rhsv, ok := getIntLiters(m); ok && in32(lhsv*rhsv) {
 		return &IntLiteral{value: lhsv * rhsv}
 	}
+	if lhsv, rhsv, ok := getLongLiters(m); ok {
+		if prod, ok := mulInLong(lhsv, rhsv); ok {
+			return &LongLiteral{value: prod}
+		}
+	}
+	if lhsv, rhsv, ok := getFloatLiters(m); ok {
+		return floatLiteral(lhsv * rhsv)
+	}
 	return m
 }
 
@@ -716,6 +1007,12 @@ func (m *BinaryOperatorDiv) Optimise(context *OptimisationContext) Expression {
 	if lhsv, rhsv, ok := getIntLiters(m); ok && rhsv != 0 {
 		return &IntLiteral{value: lhsv / rhsv}
 	}
+	if lhsv, rhsv, ok := getLongLiters(m); ok && rhsv != 0 {
+		return &LongLiteral{value: lhsv / rhsv}
+	}
+	if lhsv, rhsv, ok := getFloatLiters(m); ok {
+		return floatLiteral(lhsv / rhsv)
+	}
 	return m
 }
 
@@ -725,6 +1022,9 @@ func (m *BinaryOperatorMod) Optimise(context *OptimisationContext) Expression {
 	if lhsv, rhsv, ok := getIntLiters(m); ok && rhsv != 0 {
 		return &IntLiteral{value: lhsv % rhsv}
 	}
+	if lhsv, rhsv, ok := getLongLiters(m); ok && rhsv != 0 {
+		return &LongLiteral{value: lhsv % rhsv}
+	}
 	return m
 }
 
@@ -734,6 +1034,14 @@ func (m *BinaryOperatorAdd) Optimise(context *OptimisationContext) Expression {
 	if lhsv, rhsv, ok := getIntLiters(m); ok && in32(lhsv+rhsv) {
 		return &IntLiteral{value: lhsv + rhsv}
 	}
+	if lhsv, rhsv, ok := getLongLiters(m); ok {
+		if sum, ok := addInLong(lhsv, rhsv); ok {
+			return &LongLiteral{value: sum}
+		}
+	}
+	if lhsv, rhsv, ok := getFloatLiters(m); ok {
+		return floatLiteral(lhsv + rhsv)
+	}
 	return m
 }
 
@@ -743,6 +1051,14 @@ func (m *BinaryOperatorSub) Optimise(context *OptimisationContext) Expression {
 	if lhsv, rhsv, ok := getIntLiters(m); ok && in32(lhsv-rhsv) {
 		return &IntLiteral{value: lhsv - rhsv}
 	}
+	if lhsv, rhsv, ok := getLongLiters(m); ok {
+		if diff, ok := subInLong(lhsv, rhsv); ok {
+			return &LongLiteral{value: diff}
+		}
+	}
+	if lhsv, rhsv, ok := getFloatLiters(m); ok {
+		return floatLiteral(lhsv - rhsv)
+	}
 	return m
 }
 
@@ -752,6 +1068,12 @@ func (m *BinaryOperatorGreaterThan) Optimise(context *OptimisationContext) Expre
 	if lhsv, rhsv, ok := getIntLiters(m); ok {
 		return toWACCBool(lhsv > rhsv)
 	}
+	if lhsv, rhsv, ok := getLongLiters(m); ok {
+		return toWACCBool(lhsv > rhsv)
+	}
+	if lhsv, rhsv, ok := getFloatLiters(m); ok {
+		return toWACCBool(lhsv > rhsv)
+	}
 	if lhsv, rhsv, ok := getCharLiters(m); ok {
 		return toWACCBool(lhsv > rhsv)
 	}
@@ -764,6 +1086,12 @@ func (m *BinaryOperatorGreaterEqual) Optimise(context *OptimisationContext) Expr
 	if lhsv, rhsv, ok := getIntLiters(m); ok {
 		return toWACCBool(lhsv >= rhsv)
 	}
+	if lhsv, rhsv, ok := getLongLiters(m); ok {
+		return toWACCBool(lhsv >= rhsv)
+	}
+	if lhsv, rhsv, ok := getFloatLiters(m); ok {
+		return toWACCBool(lhsv >= rhsv)
+	}
 	if lhsv, rhsv, ok := getCharLiters(m); ok {
 		return toWACCBool(lhsv >= rhsv)
 	}
@@ -776,6 +1104,12 @@ func (m *BinaryOperatorLessThan) Optimise(context *OptimisationContext) Expressi
 	if lhsv, rhsv, ok := getIntLiters(m); ok {
 		return toWACCBool(lhsv < rhsv)
 	}
+	if lhsv, rhsv, ok := getLongLiters(m); ok {
+		return toWACCBool(lhsv < rhsv)
+	}
+	if lhsv, rhsv, ok := getFloatLiters(m); ok {
+		return toWACCBool(lhsv < rhsv)
+	}
 	if lhsv, rhsv, ok := getCharLiters(m); ok {
 		return toWACCBool(lhsv < rhsv)
 	}
@@ -788,6 +1122,12 @@ func (m *BinaryOperatorLessEqual) Optimise(context *OptimisationContext) Express
 	if lhsv, rhsv, ok := getIntLiters(m); ok {
 		return toWACCBool(lhsv <= rhsv)
 	}
+	if lhsv, rhsv, ok := getLongLiters(m); ok {
+		return toWACCBool(lhsv <= rhsv)
+	}
+	if lhsv, rhsv, ok := getFloatLiters(m); ok {
+		return toWACCBool(lhsv <= rhsv)
+	}
 	if lhsv, rhsv, ok := getCharLiters(m); ok {
 		return toWACCBool(lhsv <= rhsv)
 	}
@@ -800,6 +1140,12 @@ func (m *BinaryOperatorEqual) Optimise(context *OptimisationContext) Expression
 	if lhsv, rhsv, ok := getIntLiters(m); ok {
 		return toWACCBool(lhsv == rhsv)
 	}
+	if lhsv, rhsv, ok := getLongLiters(m); ok {
+		return toWACCBool(lhsv == rhsv)
+	}
+	if lhsv, rhsv, ok := getFloatLiters(m); ok {
+		return toWACCBool(lhsv == rhsv)
+	}
 	if lhsv, rhsv, ok := getBoolLiters(m); ok {
 		return toWACCBool(lhsv == rhsv)
 	}
@@ -815,6 +1161,12 @@ func (m *BinaryOperatorNotEqual) Optimise(context *OptimisationContext) Expressi
 	if lhsv, rhsv, ok := getIntLiters(m); ok {
 		return toWACCBool(lhsv != rhsv)
 	}
+	if lhsv, rhsv, ok := getLongLiters(m); ok {
+		return toWACCBool(lhsv != rhsv)
+	}
+	if lhsv, rhsv, ok := getFloatLiters(m); ok {
+		return toWACCBool(lhsv != rhsv)
+	}
 	if lhsv, rhsv, ok := getBoolLiters(m); ok {
 		return toWACCBool(lhsv != rhsv)
 	}
@@ -825,10 +1177,14 @@ func (m *BinaryOperatorNotEqual) Optimise(context *OptimisationContext) Expressi
 }
 
 //Optimise optimises for BinaryOperatorAnd
+// A literal LHS decides whether the RHS is the result
 func (m *BinaryOperatorAnd) Optimise(context *OptimisationContext) Expression {
-	m.BinaryOperatorBase.optimiseBinary(context)
-	if lhsv, rhsv, ok := getBoolLiters(m); ok {
-		return toWACCBool(lhsv && rhsv)
+	m.BinaryOperatorBase.optimiseShortCircuit(context)
+	switch m.lhs.(type) {
+	case *BoolLiteralFalse:
+		return m.lhs
+	case *BoolLiteralTrue:
+		return m.rhs
 	}
 	return m
 }
@@ -843,10 +1199,14 @@ func (m *BinaryOperatorBitAnd) Optimise(context *OptimisationContext) Expression
 }
 
 //Optimise optimises for BinaryOperatorOr
+// A literal LHS decides whether the RHS is the result
 func (m *BinaryOperatorOr) Optimise(context *OptimisationContext) Expression {
-	m.BinaryOperatorBase.optimiseBinary(context)
-	if lhsv, rhsv, ok := getBoolLiters(m); ok {
-		return toWACCBool(lhsv || rhsv)
+	m.BinaryOperatorBase.optimiseShortCircuit(context)
+	switch m.lhs.(type) {
+	case *BoolLiteralTrue:
+		return m.lhs
+	case *BoolLiteralFalse:
+		return m.rhs
 	}
 	return m
 }
@@ -860,6 +1220,65 @@ func (m *BinaryOperatorBitOr) Optimise(context *OptimisationContext) Expression
 	return m
 }
 
+//Optimise optimises for BinaryOperatorBitXor
+func (m *BinaryOperatorBitXor) Optimise(context *OptimisationContext) Expression {
+	m.BinaryOperatorBase.optimiseBinary(context)
+	if lhsv, rhsv, ok := getIntLiters(m); ok {
+		return &IntLiteral{value: lhsv ^ rhsv}
+	}
+	return m
+}
+
+// shiftAmount returns the amount a value is shifted by at runtime, only the
+// bottom byte of the register holding the amount is used
+func shiftAmount(value int) uint {
+	return uint(value & 0xff)
+}
+
+//Optimise optimises for BinaryOperatorShiftLeft
+func (m *BinaryOperatorShiftLeft) Optimise(context *OptimisationContext) Expression {
+	m.BinaryOperatorBase.optimiseBinary(context)
+	if lhsv, rhsv, ok := getIntLiters(m); ok {
+		return &IntLiteral{value: int(int32(lhsv) << shiftAmount(rhsv))}
+	}
+	return m
+}
+
+//Optimise optimises for BinaryOperatorShiftRight
+func (m *BinaryOperatorShiftRight) Optimise(context *OptimisationContext) Expression {
+	m.BinaryOperatorBase.optimiseBinary(context)
+	if lhsv, rhsv, ok := getIntLiters(m); ok {
+		return &IntLiteral{value: int(int32(lhsv) >> shiftAmount(rhsv))}
+	}
+	return m
+}
+
+//Optimise optimises for BinaryOperatorShiftRightLogical
+func (m *BinaryOperatorShiftRightLogical) Optimise(context *OptimisationContext) Expression {
+	m.BinaryOperatorBase.optimiseBinary(context)
+	if lhsv, rhsv, ok := getIntLiters(m); ok {
+		return &IntLiteral{value: int(int32(uint32(lhsv) >> shiftAmount(rhsv)))}
+	}
+	return m
+}
+
+//Optimise optimises for TernaryExpr
+// A literal condition decides which branch is the result
+func (m *TernaryExpr) Optimise(context *OptimisationContext) Expression {
+	m.cond = m.cond.Optimise(context)
+	context.StartCondScope()
+	m.then = m.then.Optimise(context)
+	m.elseExpr = m.elseExpr.Optimise(context)
+	context.EndScope()
+	switch m.cond.(type) {
+	case *BoolLiteralTrue:
+		return m.then
+	case *BoolLiteralFalse:
+		return m.elseExpr
+	}
+	return m
+}
+
 //Optimise optimises for VoidExpr
 func (m *VoidExpr) Optimise(context *OptimisationContext) Expression {
 	return m
@@ -887,15 +1306,56 @@ func (m *FunctionDef) Optimise() <-chan interface{} {
 	return ch
 }
 
+// Literal returns the literal a constant is folded to
+// ok is false for globals and constants that cannot be folded
+func (m *GlobalDef) Literal() (Expression, bool) {
+	if !m.constant {
+		return nil, false
+	}
+
+	if eRHS, ok := m.rhs.(*ExpressionRHS); ok {
+		switch e := eRHS.expr.(type) {
+		case *IntLiteral,
+			*LongLiteral,
+			*FloatLiteral,
+			*CharLiteral,
+			*BoolLiteralTrue,
+			*BoolLiteralFalse:
+			return e, true
+		}
+	}
+
+	return nil, false
+}
+
 // Optimise generates instructions for the whole program
 func (m *AST) Optimise() {
 	var chs []<-chan interface{}
-	for _, c := range m.classes {
+
+	// fold the globals first, in order, so that the constants can replace
+	// the identifiers referring to them
+	for _, g := range m.globals {
+		ctx := &OptimisationContext{}
+		ctx.StartScope()
+		g.rhs = g.rhs.Optimise(ctx)
+		ctx.EndScope()
+	}
+
+	for _, c := range m.Classes() {
 		for _, m := range c.methods {
 			chs = append(chs, m.Optimise())
 		}
 	}
 	for _, f := range m.functions {
+		if len(f.typeParams) > 0 {
+			for _, inst := range f.instances {
+				chs = append(chs, inst.Optimise())
+			}
+			continue
+		}
+		chs = append(chs, f.Optimise())
+	}
+	for _, f := range m.lambdas {
 		chs = append(chs, f.Optimise())
 	}
 	{
@@ -9,6 +9,7 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -24,6 +25,26 @@ func getIndentation(level int) string {
 	return fmt.Sprint(strings.Repeat(basicIndent, level))
 }
 
+// Given a label (string) naming a loop,
+// Returns the prefix placed before the loop, empty for unlabeled loops
+func getLoopLabel(label string) string {
+	if label == "" {
+		return ""
+	}
+	return fmt.Sprintf("%v: ", label)
+}
+
+// Given a value (float32),
+// Returns the shortest decimal literal parsing back to the same value, it
+// always contains a decimal point so that it reads as a float
+func formatFloat(value float32) string {
+	str := strconv.FormatFloat(float64(value), 'f', -1, 32)
+	if !strings.Contains(str, ".") {
+		str += ".0"
+	}
+	return str
+}
+
 // Given an expr (Expresion) and operator (string),
 // Returns a string with the given operator applied INLINE with the given expr.
 // Format:
@@ -40,6 +61,18 @@ func generateBinaryOperator(lhs Expression, rhs Expression, op string) string {
 	return fmt.Sprintf("%v %v %v", lhs, op, rhs)
 }
 
+// Given the typeParams of a generic function or class (string),
+// Returns a string with the type parameters, empty if there are none.
+// Format:
+// <[param](, [params])*>
+func typeParamsString(typeParams []string) string {
+	if len(typeParams) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("<%v>", strings.Join(typeParams, ", "))
+}
+
 //------------------------------------------------------------------------------
 
 // Prints the file includes. Format:
@@ -48,6 +81,37 @@ func includeString(file string) string {
 	return fmt.Sprintf("include \"%v\"", file)
 }
 
+// Prints the module imports. Format:
+//   "import <filename.wacc> as <alias>"
+func (m *Module) String() string {
+	return fmt.Sprintf("import \"%v\" as %v", m.file, m.alias)
+}
+
+// Prints the export list. Format:
+//   "export <ident>, <ident>"
+func exportString(exports []string) string {
+	return fmt.Sprintf("export %v", strings.Join(exports, ", "))
+}
+
+// Prints a global or constant definition. Format:
+//   "global [type] [ident] = [rhs]"
+//   "const [type] [ident] = [rhs]"
+//   "static [type] [field] = [rhs];"
+// Recurses on type, ident and rhs.
+func (m *GlobalDef) String() string {
+	if m.static {
+		field := m.ident[strings.LastIndex(m.ident, "::")+2:]
+		return fmt.Sprintf("static %v %v = %v;", m.wtype, field, m.rhs)
+	}
+
+	kind := "global"
+	if m.constant {
+		kind = "const"
+	}
+
+	return fmt.Sprintf("%v %v %v = %v", kind, m.wtype, m.ident, m.rhs)
+}
+
 // Prints identifier Types. Format:
 //   "[ident]"
 // Recurses on ident.
@@ -55,6 +119,32 @@ func (ident *Ident) String() string {
 	return ident.ident
 }
 
+// Prints lambda expressions. Format:
+//   "fun ([params]*) is [body] end"
+// Recurses on params and body.
+func (m *Lambda) String() string {
+	var params string
+	var body string
+
+	if len(m.function.params) > 0 {
+		params = fmt.Sprintf("%v", m.function.params[0])
+
+		for _, param := range m.function.params[1:] {
+			params = fmt.Sprintf("%v, %v", params, param)
+		}
+	}
+
+	st := m.function.body
+	for st.GetNext() != nil {
+		body = fmt.Sprintf("%v%v ; ", body, st.istring(0))
+		st = st.GetNext()
+	}
+
+	body = fmt.Sprintf("%v%v", body, st.istring(0))
+
+	return fmt.Sprintf("fun (%v) is %v end", params, body)
+}
+
 // Prints integer Literals. Format:
 //   "[int]"
 // Recurses on int.
@@ -62,6 +152,18 @@ func (liter *IntLiteral) String() string {
 	return fmt.Sprint(liter.value)
 }
 
+// Prints long Literals. Format:
+//   "[long]l"
+func (liter *LongLiteral) String() string {
+	return fmt.Sprintf("%dl", liter.value)
+}
+
+// Prints float Literals. Format:
+//   "[float]"
+func (liter *FloatLiteral) String() string {
+	return formatFloat(liter.value)
+}
+
 // Prints true bool Literal. Format:
 //   "true"
 func (liter *BoolLiteralTrue) String() string {
@@ -114,6 +216,13 @@ func (elem *ArrayElem) String() string {
 	return fmt.Sprintf("%v%v", elem.ident, indexes)
 }
 
+// Prints the map key check. Format:
+//   "has([map], [key])"
+// Recurses on map and key.
+func (op *MapHas) String() string {
+	return fmt.Sprintf("has(%v, %v)", op.expr, op.key)
+}
+
 // Prints ! unaryOperator. Format:
 //   "![expr]"
 // Recurses on expr.
@@ -128,6 +237,13 @@ func (op *UnaryOperatorNegate) String() string {
 	return generateUnaryOperator(op.GetExpression(), "-")
 }
 
+// Prints ~ unaryOperator. Format:
+//   "~[expr]"
+// Recurses on expr.
+func (op *UnaryOperatorBitNot) String() string {
+	return generateUnaryOperator(op.GetExpression(), "~")
+}
+
 // Prints len unaryOperator. Format:
 //   "len [expr]"
 // Recurses on expr.
@@ -149,6 +265,27 @@ func (op *UnaryOperatorChr) String() string {
 	return generateUnaryOperator(op.GetExpression(), "chr ")
 }
 
+// Prints long unaryOperator. Format:
+//   "long [expr]"
+// Recurses on expr.
+func (op *UnaryOperatorLong) String() string {
+	return generateUnaryOperator(op.GetExpression(), "long ")
+}
+
+// Prints int unaryOperator. Format:
+//   "int [expr]"
+// Recurses on expr.
+func (op *UnaryOperatorInt) String() string {
+	return generateUnaryOperator(op.GetExpression(), "int ")
+}
+
+// Prints float unaryOperator. Format:
+//   "float [expr]"
+// Recurses on expr.
+func (op *UnaryOperatorFloat) String() string {
+	return generateUnaryOperator(op.GetExpression(), "float ")
+}
+
 // Prints * unaryOperator. Format:
 //   "*[expr]"
 // Recurses on expr.
@@ -240,6 +377,55 @@ func (op *BinaryOperatorOr) String() string {
 	return generateBinaryOperator(op.GetLHS(), op.GetRHS(), "||")
 }
 
+// Prints the conditional operator. Format:
+//   "([cond] ? [then] : [else])"
+// Recurses on cond, then and else.
+func (op *TernaryExpr) String() string {
+	return fmt.Sprintf("(%v ? %v : %v)", op.cond, op.then, op.elseExpr)
+}
+
+// Prints & unaryOperator. Format:
+//   "&[expr]"
+// Recurses on expr.
+func (op *BinaryOperatorBitAnd) String() string {
+	return generateBinaryOperator(op.GetLHS(), op.GetRHS(), "&")
+}
+
+// Prints | unaryOperator. Format:
+//   "|[expr]"
+// Recurses on expr.
+func (op *BinaryOperatorBitOr) String() string {
+	return generateBinaryOperator(op.GetLHS(), op.GetRHS(), "|")
+}
+
+// Prints ^ unaryOperator. Format:
+//   "^[expr]"
+// Recurses on expr.
+func (op *BinaryOperatorBitXor) String() string {
+	return generateBinaryOperator(op.GetLHS(), op.GetRHS(), "^")
+}
+
+// Prints << unaryOperator. Format:
+//   "<<[expr]"
+// Recurses on expr.
+func (op *BinaryOperatorShiftLeft) String() string {
+	return generateBinaryOperator(op.GetLHS(), op.GetRHS(), "<<")
+}
+
+// Prints >> unaryOperator. Format:
+//   ">>[expr]"
+// Recurses on expr.
+func (op *BinaryOperatorShiftRight) String() string {
+	return generateBinaryOperator(op.GetLHS(), op.GetRHS(), ">>")
+}
+
+// Prints >>> unaryOperator. Format:
+//   ">>>[expr]"
+// Recurses on expr.
+func (op *BinaryOperatorShiftRightLogical) String() string {
+	return generateBinaryOperator(op.GetLHS(), op.GetRHS(), ">>>")
+}
+
 // Prints the lhs of a PairElem.
 func (lhs *PairElemLHS) String() string {
 	if lhs.snd {
@@ -293,6 +479,22 @@ func (rhs *ArrayLiterRHS) String() string {
 	return fmt.Sprintf("[%v]", elements)
 }
 
+// Prints a map Literal. Format:
+//   "{[key1]: [value1](, [keys]: [values])*}"
+// Recurses on the keys and values.
+func (rhs *MapLiterRHS) String() string {
+	var entries string
+
+	for i, key := range rhs.keys {
+		if i > 0 {
+			entries = fmt.Sprintf("%v, ", entries)
+		}
+		entries = fmt.Sprintf("%v%v: %v", entries, key, rhs.values[i])
+	}
+
+	return fmt.Sprintf("{%v}", entries)
+}
+
 // Prints the rhs of a PairElem.
 func (rhs *PairElemRHS) String() string {
 	if rhs.snd {
@@ -333,15 +535,21 @@ func (stmt *SkipStatement) istring(level int) string {
 }
 
 // Prints a continue statement. Format:
-//   "continue"
+//   "continue [label]"
 func (stmt *ContinueStatement) istring(level int) string {
-	return fmt.Sprintf("%vcontinue", getIndentation(level))
+	if stmt.label == "" {
+		return fmt.Sprintf("%vcontinue", getIndentation(level))
+	}
+	return fmt.Sprintf("%vcontinue %v", getIndentation(level), stmt.label)
 }
 
 // Prints a skip statement. Format:
-//   "break"
+//   "break [label]"
 func (stmt *BreakStatement) istring(level int) string {
-	return fmt.Sprintf("%vbreak", getIndentation(level))
+	if stmt.label == "" {
+		return fmt.Sprintf("%vbreak", getIndentation(level))
+	}
+	return fmt.Sprintf("%vbreak %v", getIndentation(level), stmt.label)
 }
 
 // Prints a useless block statement. Format:
@@ -351,11 +559,15 @@ func (stmt *BlockStatement) istring(level int) string {
 }
 
 // Prints a declaration assignment. Format:
-//   "[type] [ident]=[rhs]"
+//   "(scoped) [type] [ident]=[rhs]"
 // Recurses on type, ident and rhs.
 func (stmt *DeclareAssignStatement) istring(level int) string {
-	return fmt.Sprintf("%v%v %v = %v", getIndentation(level), stmt.wtype,
-		stmt.ident, stmt.rhs)
+	var scoped string
+	if stmt.scoped {
+		scoped = "scoped "
+	}
+	return fmt.Sprintf("%v%v%v %v = %v", getIndentation(level), scoped,
+		stmt.wtype, stmt.ident, stmt.rhs)
 }
 
 // Prints an assignment. Format:
@@ -378,6 +590,13 @@ func (stmt *FreeStatement) istring(level int) string {
 	return fmt.Sprintf("%vfree %v", getIndentation(level), stmt.expr)
 }
 
+// Prints a delete statement. Format:
+//   "delete([map], [key])"
+func (stmt *DeleteStatement) istring(level int) string {
+	return fmt.Sprintf("%vdelete(%v, %v)", getIndentation(level), stmt.expr,
+		stmt.key)
+}
+
 // Prints a return statement. Format:
 //   "return"
 func (ret *ReturnStatement) istring(level int) string {
@@ -390,6 +609,44 @@ func (stmt *ExitStatement) istring(level int) string {
 	return fmt.Sprintf("%vexit %v", getIndentation(level), stmt.expr)
 }
 
+// Prints a throw statement. Format:
+//   "throw"
+func (stmt *ThrowStatement) istring(level int) string {
+	return fmt.Sprintf("%vthrow %v", getIndentation(level), stmt.expr)
+}
+
+// Prints a try statement. Format:
+//   "try
+//    [body]*
+//    catch ([ident])
+//    [body]*
+//    end"
+// Recurses on (multiple) body and catch body.
+func (stmt *TryStatement) istring(level int) string {
+	var body string
+	var catchBody string
+	var indent = getIndentation(level)
+
+	for st := stmt.body; st != nil; st = st.GetNext() {
+		if st.GetNext() != nil {
+			body = fmt.Sprintf("%v\n%v ;", body, st.istring(level+1))
+		} else {
+			body = fmt.Sprintf("%v\n%v", body, st.istring(level+1))
+		}
+	}
+
+	for st := stmt.catchStat; st != nil; st = st.GetNext() {
+		if st.GetNext() != nil {
+			catchBody = fmt.Sprintf("%v\n%v ;", catchBody, st.istring(level+1))
+		} else {
+			catchBody = fmt.Sprintf("%v\n%v", catchBody, st.istring(level+1))
+		}
+	}
+
+	return fmt.Sprintf("%vtry%v\n%vcatch (%v)%v\n%vend", indent, body, indent,
+		stmt.ident, catchBody, indent)
+}
+
 // Prints a println statement. Format:
 //   "println"
 func (stmt *PrintLnStatement) istring(level int) string {
@@ -476,8 +733,8 @@ func (stmt *WhileStatement) istring(level int) string {
 
 	body = fmt.Sprintf("%v\n%v", body, st.istring(level+1))
 
-	return fmt.Sprintf("%vwhile (%v) do%v\n%vdone", indent, stmt.cond, body,
-		indent)
+	return fmt.Sprintf("%v%vwhile (%v) do%v\n%vdone", indent,
+		getLoopLabel(stmt.label), stmt.cond, body, indent)
 }
 
 // Prints a switch statement. Format:
@@ -542,8 +799,8 @@ func (stmt *DoWhileStatement) istring(level int) string {
 
 	body = fmt.Sprintf("%v\n%v", body, st.istring(level+1))
 
-	return fmt.Sprintf("%vdo\n%v\n%vwhile (%v) \n%vdone", indent, body,
-		indent, stmt.cond, indent)
+	return fmt.Sprintf("%v%vdo\n%v\n%vwhile (%v) \n%vdone", indent,
+		getLoopLabel(stmt.label), body, indent, stmt.cond, indent)
 }
 
 // Prints a for loop. Format:
@@ -568,19 +825,59 @@ func (stmt *ForStatement) istring(level int) string {
 
 	body = fmt.Sprintf("%v\n%v", body, st.istring(level+1))
 
-	return fmt.Sprintf("%vfor (%v; %v; %v) do%v\n%vdone",
-		indent, stmt.init, stmt.cond, stmt.after, body, indent)
+	return fmt.Sprintf("%v%vfor (%v; %v; %v) do%v\n%vdone",
+		indent, getLoopLabel(stmt.label), stmt.init, stmt.cond, stmt.after,
+		body, indent)
+}
+
+// Prints a foreach loop. Format:
+//   "for [type] [ident] in [expr] do
+//    [body]*
+//    done"
+// Recurses on expr and (multiple) body.
+func (stmt *ForEachStatement) istring(level int) string {
+	var body string
+	var indent = getIndentation(level)
+
+	st := stmt.body
+	for st.GetNext() != nil {
+		body = fmt.Sprintf("%v\n%v ;", body, st.istring(level+1))
+		st = st.GetNext()
+	}
+
+	body = fmt.Sprintf("%v\n%v", body, st.istring(level+1))
+
+	return fmt.Sprintf("%v%vfor %v %v in %v do%v\n%vdone", indent,
+		getLoopLabel(stmt.label), stmt.wtype, stmt.ident, stmt.expr, body,
+		indent)
+}
+
+// Prints a function defined inside a block. Format:
+//   "[type] [name]([args]*) is
+//    [body]*
+//    end"
+// Recurses on the function.
+func (stmt *LocalFuncStatement) istring(level int) string {
+	return stmt.function.istring(level)
 }
 
 // Prints a given function parameter. Format:
-//   "[type] [name]"
-// Recurses on type and name.
+//   "[type](...)? [name]( = [value])?"
+// Recurses on type, name and optional value.
 func (fp *FunctionParam) String() string {
+	if fp.variadic {
+		return fmt.Sprintf("%v... %v", fp.wtype.(ArrayType).base, fp.name)
+	}
+
+	if fp.value != nil {
+		return fmt.Sprintf("%v %v = %v", fp.wtype, fp.name, fp.value)
+	}
+
 	return fmt.Sprintf("%v %v", fp.wtype, fp.name)
 }
 
 // Prints a function definition. Format:
-//   "[type] [name]([args]*) is
+//   "(private )?(static )?[type] [name](<[types]*>)?([args]*) is
 //    [body] (;\n [bodies])*
 //    end"
 // Recurses on type, name, (multiple) args, body and (multpiple/optional) bodies
@@ -598,8 +895,16 @@ func (fd *FunctionDef) istring(level int) string {
 		}
 	}
 
-	declaration := fmt.Sprintf("%v%v %v(%v) is", indent, fd.returnType,
-		fd.ident, params)
+	modifiers := ""
+	if fd.private {
+		modifiers = "private "
+	}
+	if fd.static {
+		modifiers = fmt.Sprintf("%vstatic ", modifiers)
+	}
+
+	declaration := fmt.Sprintf("%v%v%v %v%v(%v) is", indent, modifiers,
+		fd.returnType, fd.ident, typeParamsString(fd.typeParams), params)
 
 	st := fd.body
 	for st.GetNext() != nil {
@@ -613,19 +918,71 @@ func (fd *FunctionDef) istring(level int) string {
 }
 
 // Prints the ClassMember. Format:
-//   "[type] [ident];"
+//   "(private )?[type] [ident];"
 func (m *ClassMember) istring(level int) string {
+	if m.private {
+		return fmt.Sprintf("%vprivate %v %v;", getIndentation(level), m.wtype,
+			m.ident)
+	}
+
 	return fmt.Sprintf("%v%v %v;", getIndentation(level), m.wtype, m.ident)
 }
 
+// Prints the InterfaceType. Format:
+//   "interface [name] is
+//      ([type] [ident]([params]);)*
+//    end"
+func (i *InterfaceType) istring(level int) string {
+	iface := fmt.Sprintf("%vinterface %v is", getIndentation(level), i.ident)
+
+	for _, method := range i.methods {
+		var params string
+
+		if len(method.params) > 0 {
+			params = fmt.Sprintf("%v", method.params[0])
+
+			for _, param := range method.params[1:] {
+				params = fmt.Sprintf("%v, %v", params, param)
+			}
+		}
+
+		iface = fmt.Sprintf("%v\n%v%v %v(%v);", iface,
+			getIndentation(level+1), method.returnType, method.ident,
+			params)
+	}
+
+	return fmt.Sprintf("%v\n%vend", iface, getIndentation(level))
+}
+
 // Prints the AST. Format:
-//   "class [name] is
+//   "class [name](<[typeparams]>)? (extends [parent])?
+//      (implements [interfaces])? is
 //      ([members])*
 //      ([methods])*
 //    end"
 // Recurses on (multpiple/optional) methods and members.
 func (c *ClassType) istring(level int) string {
-	class := fmt.Sprintf("%vclass %v is", getIndentation(level), c.name)
+	class := fmt.Sprintf("%vclass %v%v", getIndentation(level), c.name,
+		typeParamsString(c.typeParams))
+
+	if c.parent != nil {
+		class = fmt.Sprintf("%v extends %v", class, c.parent)
+	}
+
+	for n, i := range c.interfaces {
+		if n == 0 {
+			class = fmt.Sprintf("%v implements %v", class, i.ident)
+		} else {
+			class = fmt.Sprintf("%v, %v", class, i.ident)
+		}
+	}
+
+	class = fmt.Sprintf("%v is", class)
+
+	for _, static := range c.statics {
+		class = fmt.Sprintf("%v\n%v%v", class, getIndentation(level+1),
+			static)
+	}
 
 	for _, member := range c.members {
 		class = fmt.Sprintf("%v\n%v", class, member.istring(level+1))
@@ -657,6 +1014,26 @@ func (ast *AST) String() string {
 		tree = fmt.Sprintf("%v\n  %v\n", tree, includeString(include))
 	}
 
+	for _, module := range ast.modules {
+		tree = fmt.Sprintf("%v\n  %v\n", tree, module)
+	}
+
+	if ast.exports != nil {
+		tree = fmt.Sprintf("%v\n  %v\n", tree, exportString(ast.exports))
+	}
+
+	for _, global := range ast.globals {
+		if global.static {
+			continue
+		}
+		tree = fmt.Sprintf("%v\n  %v\n", tree, global)
+	}
+
+	for _, iface := range ast.interfaces {
+		tree = fmt.Sprintf("%v\n%v\n", tree,
+			iface.istring(startingIndent))
+	}
+
 	for _, class := range ast.classes {
 		tree = fmt.Sprintf("%v\n%v\n", tree,
 			class.istring(startingIndent))
@@ -50,9 +50,14 @@ func hasReturn(stm Statement) bool {
 		return true
 	case *ExitStatement:
 		return true
+	case *ThrowStatement:
+		return true
 	case *IfStatement:
 		return (hasReturn(t.trueStat) && hasReturn(t.falseStat)) ||
 			hasReturn(t.next)
+	case *TryStatement:
+		return (hasReturn(t.body) && hasReturn(t.catchStat)) ||
+			hasReturn(t.next)
 	default:
 		return hasReturn(t.GetNext())
 	}
@@ -100,6 +105,13 @@ func checkJunkStatement(stm Statement) <-chan error {
 			for err := range checkJunkStatement(t.body) {
 				out <- err
 			}
+		case *TryStatement:
+			for err := range checkJunkStatement(t.body) {
+				out <- err
+			}
+			for err := range checkJunkStatement(t.catchStat) {
+				out <- err
+			}
 		case *ForStatement:
 			for err := range checkJunkStatement(t.init) {
 				out <- err
@@ -110,6 +122,10 @@ func checkJunkStatement(stm Statement) <-chan error {
 			for err := range checkJunkStatement(t.body) {
 				out <- err
 			}
+		case *ForEachStatement:
+			for err := range checkJunkStatement(t.body) {
+				out <- err
+			}
 		case *ReturnStatement:
 			if n := t.next; n != nil {
 				out <- CreateUnreachableStatementError(
@@ -130,6 +146,57 @@ func checkJunkStatement(stm Statement) <-chan error {
 	return out
 }
 
+// checkLocalFunctions checks the code paths of the functions defined inside the
+// blocks of a statement and the statements following it
+func checkLocalFunctions(stm Statement) <-chan error {
+	out := make(chan error)
+
+	go func() {
+		var chs []<-chan error
+
+		for ; stm != nil; stm = stm.GetNext() {
+			switch t := stm.(type) {
+			case *LocalFuncStatement:
+				chs = append(chs,
+					checkFunctionReturns(t.function),
+					checkJunkStatement(t.function.body),
+					checkLocalFunctions(t.function.body))
+			case *BlockStatement:
+				chs = append(chs, checkLocalFunctions(t.body))
+			case *IfStatement:
+				chs = append(chs,
+					checkLocalFunctions(t.trueStat),
+					checkLocalFunctions(t.falseStat))
+			case *WhileStatement:
+				chs = append(chs, checkLocalFunctions(t.body))
+			case *DoWhileStatement:
+				chs = append(chs, checkLocalFunctions(t.body))
+			case *ForStatement:
+				chs = append(chs, checkLocalFunctions(t.body))
+			case *ForEachStatement:
+				chs = append(chs, checkLocalFunctions(t.body))
+			case *TryStatement:
+				chs = append(chs,
+					checkLocalFunctions(t.body),
+					checkLocalFunctions(t.catchStat))
+			case *SwitchStatement:
+				for _, body := range t.bodies {
+					chs = append(chs, checkLocalFunctions(body))
+				}
+				chs = append(chs, checkLocalFunctions(t.defaultCase))
+			}
+		}
+
+		for err := range mergeErrors(chs) {
+			out <- err
+		}
+
+		close(out)
+	}()
+
+	return out
+}
+
 // CheckFunctionCodePaths checks for a return or exit statment in the function
 // It also removes the deadcode from the function
 func (m *AST) CheckFunctionCodePaths() (errs []error) {
@@ -144,8 +211,27 @@ func (m *AST) CheckFunctionCodePaths() (errs []error) {
 			errorChannels,
 			checkJunkStatement(f.body),
 		)
+		errorChannels = append(
+			errorChannels,
+			checkLocalFunctions(f.body),
+		)
 	}
 
+	// the local functions are checked wherever they are defined
+	for _, c := range m.classes {
+		for _, f := range c.methods {
+			errorChannels = append(
+				errorChannels,
+				checkLocalFunctions(f.body),
+			)
+		}
+	}
+
+	errorChannels = append(
+		errorChannels,
+		checkLocalFunctions(m.main),
+	)
+
 	for err := range mergeErrors(errorChannels) {
 		errs = append(errs, err)
 	}
@@ -9,30 +9,61 @@ package main
 // TypeCheck: recursively checks for type mismatches in AST, statements, and
 //   expressions
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
 // Scope stores the available variables, functions, and expected return type
 // during lexical analysis
 
 type Scope struct {
 	parent     *Scope
 	vars       map[string]Type
+	scoped     map[string]bool
 	enums      map[string]*EnumType
 	classes    map[string]*ClassType
+	interfaces map[string]*InterfaceType
 	members    map[string]Type
 	funcs      map[string]map[string]map[string]*FunctionDef
+	localFuncs map[string]map[string]*FunctionDef
+	local      *FunctionDef
+	locals     *int
+	depth      int
 	class      *ClassType
 	returnType Type
 	loop       int
+	labels     []string
+	lambda     *FunctionDef
+	lambdas    *[]*FunctionDef
+	instances  *[]*ClassType
+	typeArgs   map[string]Type
+	module     string
+	aliases    map[string]map[string]string
+	hidden     map[string]string
+	globals    map[string]*GlobalDef
+	static     bool
+	refCount   bool
+	exceptions *bool
 }
 
 // CreateRootScope creates a global scope that has no parent
 func CreateRootScope() *Scope {
 	scope := &Scope{
-		parent:  nil,
-		vars:    make(map[string]Type),
-		enums:   make(map[string]*EnumType),
-		classes: make(map[string]*ClassType),
-		members: make(map[string]Type),
-		funcs:   make(map[string]map[string]map[string]*FunctionDef),
+		parent:     nil,
+		vars:       make(map[string]Type),
+		scoped:     make(map[string]bool),
+		enums:      make(map[string]*EnumType),
+		classes:    make(map[string]*ClassType),
+		interfaces: make(map[string]*InterfaceType),
+		members:    make(map[string]Type),
+		globals:    make(map[string]*GlobalDef),
+		funcs:      make(map[string]map[string]map[string]*FunctionDef),
+		lambdas:    new([]*FunctionDef),
+		locals:     new(int),
+		instances:  new([]*ClassType),
+		exceptions: new(bool),
 	}
 
 	return scope
@@ -44,18 +75,101 @@ func (m *Scope) Child() *Scope {
 	return &Scope{
 		parent:     m,
 		vars:       make(map[string]Type),
+		scoped:     make(map[string]bool),
 		enums:      m.enums,
 		classes:    m.classes,
+		interfaces: m.interfaces,
 		members:    m.members,
 		funcs:      m.funcs,
+		local:      m.local,
+		locals:     m.locals,
+		depth:      m.depth,
 		class:      m.class,
 		returnType: m.returnType,
 		loop:       m.loop,
+		labels:     m.labels,
+		lambda:     m.lambda,
+		lambdas:    m.lambdas,
+		instances:  m.instances,
+		typeArgs:   m.typeArgs,
+		module:     m.module,
+		aliases:    m.aliases,
+		hidden:     m.hidden,
+		globals:    m.globals,
+		static:     m.static,
+		refCount:   m.refCount,
+		exceptions: m.exceptions,
+	}
+}
+
+// HasLabel returns whether an enclosing loop carries the label. Every loop
+// matches the empty label
+func (m *Scope) HasLabel(label string) bool {
+	if label == "" {
+		return true
+	}
+
+	for _, l := range m.labels {
+		if l == label {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ModuleScope returns a child of the global scope for checking a top level
+// definition in the module it was imported from
+func (m *Scope) ModuleScope(ident string) *Scope {
+	global := m
+	for global.parent != nil {
+		global = global.parent
+	}
+
+	scope := global.Child()
+	scope.module = moduleOf(ident)
+
+	return scope
+}
+
+// qualify returns the name a top level definition referred to as ident from
+// the current module is declared under. Definitions of the module itself are
+// found first, then the ones of the modules imported under the alias ident is
+// qualified by. Definitions hidden by other modules are not found
+func (m *Scope) qualify(ident string, declared func(string) bool) (string, bool) {
+	var candidates []string
+
+	if len(m.module) > 0 {
+		candidates = append(candidates, fmt.Sprintf("%v.%v", m.module, ident))
+	}
+
+	if i := strings.Index(ident, "."); i >= 0 {
+		if module, ok := m.aliases[m.module][ident[:i]]; ok {
+			candidates = append(candidates, module+ident[i:])
+		}
+	}
+
+	candidates = append(candidates, ident)
+
+	for _, qualified := range candidates {
+		if !declared(qualified) {
+			continue
+		}
+
+		if module, ok := m.hidden[qualified]; ok && module != m.module {
+			return qualified, false
+		}
+
+		return qualified, true
 	}
+
+	return ident, false
 }
 
 // Lookup tries to recusively search for the type of a given variable
 // It returns InvalidType if not found
+// Variables found outside the body of a lambda are captured by the lambda and
+// those found outside the body of a local function are shared with it
 func (m *Scope) Lookup(ident string) Type {
 	t, ok := m.vars[ident]
 
@@ -65,31 +179,121 @@ func (m *Scope) Lookup(ident string) Type {
 		} else {
 			t = InvalidType{}
 		}
+
+		_, invalid := t.(InvalidType)
+		if !invalid && m.lambda != nil && m.parent != nil &&
+			m.parent.lambda != m.lambda && m.parent.LookupGlobal(ident) == nil {
+			m.lambda.Capture(ident, t)
+		}
+
+		if !invalid && m.local != nil && m.parent != nil &&
+			m.parent.local != m.local && m.parent.LookupGlobal(ident) == nil {
+			m.local.Share(ident)
+		}
 	}
 
 	return t
 }
 
+// LookupGlobal returns the global variable or constant an identifier refers to
+// It returns nil if the identifier is declared locally or not declared
+func (m *Scope) LookupGlobal(ident string) *GlobalDef {
+	for s := m; s.parent != nil; s = s.parent {
+		if _, ok := s.vars[ident]; ok {
+			return nil
+		}
+	}
+
+	qualified, ok := m.qualify(ident, func(qualified string) bool {
+		_, ok := m.globals[qualified]
+		return ok
+	})
+	if !ok {
+		return nil
+	}
+
+	return m.globals[qualified]
+}
+
+// globalIdent returns the name the variable referred to as ident is declared
+// under, which differs from ident for the globals of modules
+func (m *Scope) globalIdent(ident string) string {
+	if g := m.LookupGlobal(ident); g != nil {
+		return g.ident
+	}
+
+	return ident
+}
+
 // LookupMember tries to search for the type of a given member
 // It returns InvalidType if not found
 func (m *Scope) LookupMember(ident string) Type {
+	// static methods are not called on an object
+	if m.static {
+		return InvalidType{}
+	}
+
 	if ident == "this" {
+		if m.class == nil {
+			return InvalidType{}
+		}
 		return m.class
 	}
 
 	t, ok := m.members[ident]
 
-	if !ok {
-		return InvalidType{}
+	if ok {
+		return t
+	}
+
+	// search the members inherited from the parent classes
+	if m.class != nil {
+		for c := m.class.parent; c != nil; c = c.parent {
+			for _, member := range c.members {
+				if member.ident == ident {
+					return member.wtype
+				}
+			}
+		}
+	}
+
+	return InvalidType{}
+}
+
+// AccessMember returns the type of a member like LookupMember, reporting the
+// use of the private members inherited from a parent class
+func (m *Scope) AccessMember(token *token32, ident string, errch chan<- error) Type {
+	t := m.LookupMember(ident)
+
+	if _, own := m.members[ident]; own || m.class == nil {
+		return t
+	}
+
+	for c := m.class.parent; c != nil; c = c.parent {
+		for _, member := range c.members {
+			if member.ident != ident {
+				continue
+			}
+			if member.private {
+				errch <- CreateAccessViolationError(token, c.name, ident)
+			}
+			return t
+		}
 	}
 
 	return t
 }
 
+// CanAccess returns whether a method can be called in the scope. Private
+// methods can only be called by the methods of their class
+func (m *Scope) CanAccess(f *FunctionDef) bool {
+	return !f.private || (m.class != nil && m.class == f.class)
+}
+
 // LookupEnum tries to return the enum given it's identifier
 // returns nil if not found.
 func (m *Scope) LookupEnum(ident string, field string) (Type, int) {
-	e, ok := m.enums[ident]
+	e, ok := m.LookupEnumType(ident)
 	if !ok {
 		return InvalidType{}, 0
 	}
@@ -102,49 +306,658 @@ func (m *Scope) LookupEnum(ident string, field string) (Type, int) {
 	return e, val
 }
 
+// LookupEnumType tries to return the enum given it's identifier
+func (m *Scope) LookupEnumType(ident string) (*EnumType, bool) {
+	ident, ok := m.qualify(ident, func(ident string) bool {
+		_, ok := m.enums[ident]
+		return ok
+	})
+
+	return m.enums[ident], ok
+}
+
 // LookupFunction tries to return the function given it's identifier
 // returns nil if not found.
 func (m *Scope) LookupFunction(ident string) map[string]*FunctionDef {
-	t, ok := m.funcs[""][ident]
+	ident, ok := m.qualify(ident, func(ident string) bool {
+		_, ok := m.funcs[""][ident]
+		return ok
+	})
 
 	if !ok {
 		return nil
 	}
 
-	return t
+	return m.funcs[""][ident]
+}
+
+// LookupLocalFunction tries to return the local function given it's identifier
+// declared in the innermost enclosing block. The local functions declared
+// outside the body of a lambda are not found as the lambda can outlive the
+// frames they access
+// returns nil if not found.
+func (m *Scope) LookupLocalFunction(ident string) map[string]*FunctionDef {
+	for s := m; s != nil; s = s.parent {
+		if overloads, ok := s.localFuncs[ident]; ok {
+			return overloads
+		}
+
+		if s.lambda != nil && (s.parent == nil || s.parent.lambda != s.lambda) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// staticMethods returns the key the static methods of a class are declared
+// under
+func staticMethods(class string) string {
+	return fmt.Sprintf("%v::", class)
+}
+
+// LookupStaticMethod tries to return the static method referred to as
+// Class::method. Static methods of the parent classes are found unless the
+// class declares a static method with the same identifier. Generic classes
+// have no static methods.
+// returns nil if not found.
+func (m *Scope) LookupStaticMethod(ident string) map[string]*FunctionDef {
+	i := strings.LastIndex(ident, "::")
+	if i < 0 {
+		return nil
+	}
+
+	for c := m.LookupClass(ident[:i]); c != nil && len(c.typeParams) == 0; {
+		if t, ok := m.funcs[staticMethods(c.Symbol())][ident[i+2:]]; ok {
+			return t
+		}
+
+		if c.parent == nil {
+			break
+		}
+
+		c = m.LookupClass(c.parent.Symbol())
+	}
+
+	return nil
 }
 
 // LookupMethod tries to return the function given it's identifier and the name
-// of the class it is on
+// of the class it is on. Methods inherited from the parent classes are
+// included unless they are overridden. Constructors are not inherited.
 // returns nil if not found.
 func (m *Scope) LookupMethod(class, ident string) map[string]*FunctionDef {
 	t, ok := m.funcs[class][ident]
 
-	if !ok {
-		return nil
+	var inherited map[string]*FunctionDef
+	if c := m.LookupClass(class); c != nil && c.parent != nil && ident != "init" {
+		inherited = m.LookupMethod(c.parent.Symbol(), ident)
 	}
 
-	return t
+	if inherited == nil {
+		if !ok {
+			return nil
+		}
+
+		return t
+	}
+
+	overloads := make(map[string]*FunctionDef)
+
+	for symbol, f := range t {
+		overloads[symbol] = f
+	}
+
+	for symbol, pf := range inherited {
+		overridden := false
+		for _, f := range t {
+			if f.Signature() == pf.Signature() {
+				overridden = true
+			}
+		}
+
+		if !overridden {
+			overloads[symbol] = pf
+		}
+	}
+
+	return overloads
 }
 
 // LookupClass tries to return the class given it's identifier
 // returns nil if not found.
 func (m *Scope) LookupClass(ident string) *ClassType {
-	t, ok := m.classes[ident]
+	ident, ok := m.qualify(ident, func(ident string) bool {
+		_, ok := m.classes[ident]
+		return ok
+	})
 
 	if !ok {
 		return nil
 	}
 
+	return m.classes[ident]
+}
+
+// LookupClassType returns the declaration of a class type. Generic classes
+// are instantiated for the type arguments of the type
+// returns nil if not found or the type arguments do not fit the class.
+func (m *Scope) LookupClassType(ct *ClassType) *ClassType {
+	if c := m.LookupClass(ct.Symbol()); c != nil {
+		if len(c.typeParams) > 0 {
+			return nil
+		}
+		return c
+	}
+
+	c := m.LookupClass(ct.name)
+	if c == nil || len(c.typeParams) == 0 ||
+		len(c.typeParams) != len(ct.typeArgs) {
+		return nil
+	}
+
+	return m.InstantiateClass(c, ct.typeArgs)
+}
+
+// InstantiateClass returns the instance of a generic class for the given type
+// arguments. Every instance is parsed again from the generic definition so
+// that its methods have their own bodies to type check, optimise and generate
+// code for. The methods are checked after the rest of the program
+func (m *Scope) InstantiateClass(c *ClassType, typeArgs []Type) *ClassType {
+	inst, err := parseClass(c.node)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, arg := range typeArgs {
+		m.ResolveType(arg)
+	}
+
+	inst.name = c.name
+	inst.typeParams = nil
+	inst.node = nil
+	inst.typeArgs = typeArgs
+	inst.generic = c
+
+	// the instance is declared first so that its members and methods can
+	// refer to it
+	m.DeclareClass(inst.Symbol(), inst)
+	c.instances = append(c.instances, inst)
+	*m.instances = append(*m.instances, inst)
+
+	bindings := inst.TypeBindings()
+
+	// the types in the class are resolved in the module of the class
+	scope := m.ModuleScope(c.name)
+
+	// the parent is taken from the generic class as inheritance cycles
+	// have been broken there
+	inst.parent = nil
+	if c.parent != nil {
+		if p, ok := substituteType(c.parent, bindings).(*ClassType); ok {
+			inst.parent = p
+			if pc := scope.LookupClassType(p); pc != nil {
+				inst.parent = pc
+			}
+		}
+	}
+
+	for _, member := range inst.members {
+		member.wtype = substituteType(member.wtype, bindings)
+		scope.ResolveType(member.wtype)
+	}
+
+	for _, method := range inst.methods {
+		method.class = inst
+		method.returnType = substituteType(method.returnType, bindings)
+		scope.ResolveType(method.returnType)
+		for _, param := range method.params {
+			param.wtype = substituteType(param.wtype, bindings)
+			scope.ResolveType(param.wtype)
+		}
+		m.DeclareMethod(inst.Symbol(), method.ident, method.Symbol(), method)
+	}
+
+	return inst
+}
+
+// TypeBindings returns the type arguments of an instance of a generic class
+// by the name of the type parameters they replace
+func (m *ClassType) TypeBindings() map[string]Type {
+	if m.generic == nil {
+		return nil
+	}
+
+	bindings := make(map[string]Type)
+	for i, tp := range m.generic.typeParams {
+		bindings[tp] = m.typeArgs[i]
+	}
+
+	return bindings
+}
+
+// ResolveType links the class and interface types used in a type to their
+// declarations so that their parents and methods are known when matching
+// against other types
+func (m *Scope) ResolveType(t Type) {
+	switch o := t.(type) {
+	case *ClassType:
+		for _, arg := range o.typeArgs {
+			m.ResolveType(arg)
+		}
+		if c := m.LookupClassType(o); c != nil && c != o {
+			*o = *c
+		}
+	case *InterfaceType:
+		if i := m.LookupInterface(o.ident); i != nil && i != o {
+			o.ident = i.ident
+			o.methods = i.methods
+		}
+	case *EnumType:
+		if e, ok := m.LookupEnumType(o.ident); ok && e != o {
+			o.ident = e.ident
+			o.values = e.values
+		}
+	case ArrayType:
+		m.ResolveType(o.base)
+	case MapType:
+		m.ResolveType(o.key)
+		m.ResolveType(o.value)
+	case FunctionType:
+		m.ResolveType(o.returnType)
+		for _, param := range o.params {
+			m.ResolveType(param)
+		}
+	case PairType:
+		m.ResolveType(o.first)
+		m.ResolveType(o.second)
+	}
+}
+
+// Substitute replaces the type parameters in a type with the type arguments
+// of the generic function instance being checked
+func (m *Scope) Substitute(t Type) Type {
+	if len(m.typeArgs) == 0 {
+		return t
+	}
+
+	return substituteType(t, m.typeArgs)
+}
+
+// substituteType replaces the type parameters in a type with the given type
+// arguments. Type parameters are parsed as class types
+func substituteType(t Type, args map[string]Type) Type {
+	switch o := t.(type) {
+	case *ClassType:
+		if arg, ok := args[o.name]; ok {
+			return arg
+		}
+		if len(o.typeArgs) > 0 && o.generic == nil {
+			ct := &ClassType{name: o.name}
+			for _, arg := range o.typeArgs {
+				ct.typeArgs = append(ct.typeArgs, substituteType(arg, args))
+			}
+			return ct
+		}
+	case ArrayType:
+		return ArrayType{base: substituteType(o.base, args)}
+	case MapType:
+		return MapType{
+			key:   substituteType(o.key, args),
+			value: substituteType(o.value, args),
+		}
+	case PairType:
+		return PairType{
+			first:  substituteType(o.first, args),
+			second: substituteType(o.second, args),
+		}
+	case FunctionType:
+		ft := FunctionType{returnType: substituteType(o.returnType, args)}
+		for _, param := range o.params {
+			ft.params = append(ft.params, substituteType(param, args))
+		}
+		return ft
+	}
+
 	return t
 }
 
+// unifyType binds the type parameters in a parameter type to the parts of the
+// argument type in the same position. It returns false if a type parameter
+// would be bound to two different types
+func unifyType(param, arg Type, bindings map[string]Type) bool {
+	switch p := param.(type) {
+	case *ClassType:
+		if a, ok := arg.(*ClassType); ok && len(p.typeArgs) > 0 &&
+			a.name == p.name && len(a.typeArgs) == len(p.typeArgs) {
+			for i := range p.typeArgs {
+				if !unifyType(p.typeArgs[i], a.typeArgs[i], bindings) {
+					return false
+				}
+			}
+			return true
+		}
+		bound, ok := bindings[p.name]
+		if !ok {
+			return true
+		}
+		switch bound.(type) {
+		case nil, VoidType:
+			bindings[p.name] = arg
+			return true
+		}
+		return bound.Match(arg)
+	case ArrayType:
+		if a, ok := arg.(ArrayType); ok {
+			return unifyType(p.base, a.base, bindings)
+		}
+	case MapType:
+		if a, ok := arg.(MapType); ok {
+			return unifyType(p.key, a.key, bindings) &&
+				unifyType(p.value, a.value, bindings)
+		}
+	case PairType:
+		if a, ok := arg.(PairType); ok {
+			return unifyType(p.first, a.first, bindings) &&
+				unifyType(p.second, a.second, bindings)
+		}
+	case FunctionType:
+		if a, ok := arg.(FunctionType); ok && len(a.params) == len(p.params) {
+			for i := range p.params {
+				if !unifyType(p.params[i], a.params[i], bindings) {
+					return false
+				}
+			}
+			return unifyType(p.returnType, a.returnType, bindings)
+		}
+	}
+
+	return true
+}
+
+// argType returns the type of the argument passed by position for the i-th
+// parameter. The trailing arguments of variadic functions are elements of the
+// array passed as the last parameter
+func (m *FunctionDef) argType(i int) Type {
+	if m.Variadic() && i >= len(m.params)-1 {
+		return m.params[len(m.params)-1].wtype.(ArrayType).base
+	}
+
+	return m.params[i].wtype
+}
+
+// BindArgs matches the arguments of a call to the parameters of the function,
+// named arguments by the name of their parameter and the others by position.
+// The parameters left out are given their default values and the trailing
+// arguments of variadic functions follow the other parameters to be packed
+// into an array. It returns the arguments in the order of the parameters and
+// the number of defaults used, or false if the arguments do not fit
+func (m *FunctionDef) BindArgs(args []Expression, names []string) ([]Expression, int, bool) {
+	fixed := len(m.params)
+	if m.Variadic() {
+		fixed--
+	}
+
+	bound := make([]Expression, fixed)
+	var packed []Expression
+
+	for i, arg := range args {
+		pos := -1
+		switch {
+		case i < len(names) && len(names[i]) > 0:
+			for j, param := range m.params[:fixed] {
+				if param.name == names[i] {
+					pos = j
+				}
+			}
+		case i < fixed:
+			pos = i
+		case m.Variadic():
+			if !m.argType(i).Match(arg.Type()) {
+				return nil, 0, false
+			}
+			packed = append(packed, arg)
+			continue
+		}
+
+		if pos < 0 || bound[pos] != nil {
+			return nil, 0, false
+		}
+
+		if !m.params[pos].wtype.Match(arg.Type()) {
+			return nil, 0, false
+		}
+
+		bound[pos] = arg
+	}
+
+	defaults := 0
+	for i, param := range m.params[:fixed] {
+		if bound[i] != nil {
+			continue
+		}
+		if param.value == nil {
+			return nil, 0, false
+		}
+		bound[i] = param.value
+		defaults++
+	}
+
+	return append(bound, packed...), defaults, true
+}
+
+// typeCheckDefaults checks that the default values of the parameters trail
+// the parameters without one and are constant expressions of the parameter
+// types. They are checked in the module scope of the function as they are
+// evaluated at the call sites. Defaults are only allowed where the overloads
+// are resolved by BindArgs
+func (m *FunctionDef) typeCheckDefaults(ts *Scope, allowed bool, errch chan<- error) {
+	defaulted := false
+
+	for _, param := range m.params {
+		if param.value == nil {
+			if defaulted && !param.variadic {
+				errch <- CreateDefaultParamOrderError(
+					param.Token(),
+					param.name,
+				)
+			}
+			continue
+		}
+
+		defaulted = true
+
+		if !allowed || strings.HasPrefix(m.ident, "operator") {
+			errch <- CreateInvalidDefaultParamError(
+				param.Token(),
+				param.name,
+			)
+			continue
+		}
+
+		param.value.TypeCheck(ts, errch)
+
+		if valueT := param.value.Type(); !param.wtype.Match(valueT) {
+			errch <- CreateTypeMismatchError(
+				param.value.Token(),
+				param.wtype,
+				valueT,
+			)
+		} else if !isConstant(param.value) {
+			errch <- CreateDefaultParamValueError(
+				param.value.Token(),
+				param.name,
+			)
+		}
+	}
+}
+
+// typeCheckVariadic checks that only the last parameter is variadic. Variadic
+// parameters are only allowed where the trailing arguments of calls are packed
+// by BindArgs or InferTypeArgs
+func (m *FunctionDef) typeCheckVariadic(allowed bool, errch chan<- error) {
+	for i, param := range m.params {
+		if !param.variadic {
+			continue
+		}
+
+		if !allowed || i != len(m.params)-1 ||
+			strings.HasPrefix(m.ident, "operator") {
+			errch <- CreateInvalidVariadicParamError(
+				param.Token(),
+				param.name,
+			)
+		}
+	}
+}
+
+// InferTypeArgs deduces the type arguments of a generic function from the
+// arguments of a call. It returns false if they cannot be deduced or the
+// arguments do not match the instantiated parameters
+func (m *FunctionDef) InferTypeArgs(args []Expression) (map[string]Type, bool) {
+	switch {
+	case m.Variadic() && len(args) < len(m.params)-1:
+		return nil, false
+	case !m.Variadic() && len(args) != len(m.params):
+		return nil, false
+	}
+
+	bindings := make(map[string]Type)
+	for _, tp := range m.typeParams {
+		bindings[tp] = nil
+	}
+
+	for i, arg := range args {
+		if !unifyType(m.argType(i), arg.Type(), bindings) {
+			return nil, false
+		}
+	}
+
+	for _, t := range bindings {
+		switch t.(type) {
+		case nil, VoidType:
+			return nil, false
+		}
+	}
+
+	for i, arg := range args {
+		if !substituteType(m.argType(i), bindings).Match(arg.Type()) {
+			return nil, false
+		}
+	}
+
+	return bindings, true
+}
+
+// Instantiate returns the instance of a generic function for the given type
+// arguments. Every instance is parsed again from the generic definition so
+// that it has its own body to type check, optimise and generate code for
+func (m *Scope) Instantiate(f *FunctionDef, bindings map[string]Type, errch chan<- error) *FunctionDef {
+	inst, err := parseFunction(f.node)
+	if err != nil {
+		panic(err)
+	}
+
+	inst.ident = f.ident
+	inst.typeParams = nil
+	inst.node = nil
+	for _, tp := range f.typeParams {
+		inst.typeArgs = append(inst.typeArgs, bindings[tp])
+	}
+
+	// instances are checked in the global scope as any other function
+	fscope := m.ModuleScope(f.ident)
+
+	inst.returnType = substituteType(inst.returnType, bindings)
+	fscope.ResolveType(inst.returnType)
+	for _, param := range inst.params {
+		param.wtype = substituteType(param.wtype, bindings)
+		fscope.ResolveType(param.wtype)
+	}
+
+	for _, prev := range f.instances {
+		if prev.Symbol() == inst.Symbol() {
+			return prev
+		}
+	}
+
+	f.instances = append(f.instances, inst)
+
+	fscope.typeArgs = bindings
+	for _, arg := range inst.params {
+		switch arg.wtype.(type) {
+		case VoidType:
+			errch <- CreateInvalidVoidTypeError(
+				arg.Token(),
+				arg.name,
+			)
+		}
+		pt := fscope.Declare(arg.name, arg.wtype)
+		if pt != nil {
+			errch <- CreateVariableRedeclarationError(
+				arg.Token(),
+				arg.name,
+				pt,
+				arg.wtype,
+			)
+		}
+	}
+	fscope.returnType = inst.returnType
+	inst.body.TypeCheck(fscope, errch)
+
+	return inst
+}
+
+// LookupInterface tries to return the interface given it's identifier
+// returns nil if not found.
+func (m *Scope) LookupInterface(ident string) *InterfaceType {
+	ident, ok := m.qualify(ident, func(ident string) bool {
+		_, ok := m.interfaces[ident]
+		return ok
+	})
+
+	if !ok {
+		return nil
+	}
+
+	return m.interfaces[ident]
+}
+
+// LookupInterfaceMethod tries to return the method signatures given their
+// identifier and the name of the interface they are declared on
+// returns nil if not found.
+func (m *Scope) LookupInterfaceMethod(iface, ident string) map[string]*FunctionDef {
+	i := m.LookupInterface(iface)
+	if i == nil {
+		return nil
+	}
+
+	var overloads map[string]*FunctionDef
+
+	for _, f := range i.methods {
+		if f.ident != ident {
+			continue
+		}
+
+		if overloads == nil {
+			overloads = make(map[string]*FunctionDef)
+		}
+
+		overloads[f.Symbol()] = f
+	}
+
+	return overloads
+}
+
 // Declare creates a new variable in the current scope returning the previous
 // type in case of redeclaration, nil otherwise
 func (m *Scope) Declare(ident string, t Type) Type {
+	m.ResolveType(t)
+
 	pt, ok := m.vars[ident]
 
 	m.vars[ident] = t
+	delete(m.scoped, ident)
 
 	if ok {
 		return pt
@@ -152,6 +965,23 @@ func (m *Scope) Declare(ident string, t Type) Type {
 	return nil
 }
 
+// DeclareScoped marks a variable of the current scope as scoped, its instance
+// is destroyed when the scope is left
+func (m *Scope) DeclareScoped(ident string) {
+	m.scoped[ident] = true
+}
+
+// IsScoped returns whether the identifier refers to a scoped variable
+func (m *Scope) IsScoped(ident string) bool {
+	if _, ok := m.vars[ident]; ok {
+		return m.scoped[ident]
+	}
+	if m.parent != nil {
+		return m.parent.IsScoped(ident)
+	}
+	return false
+}
+
 // DeclareMember creates a new variable in the current scope returning the previous
 // type in case of redeclaration, nil otherwise
 func (m *Scope) DeclareMember(ident string, t Type) Type {
@@ -187,9 +1017,36 @@ func (m *Scope) DeclareFunction(ident, symbol string, f *FunctionDef) *FunctionD
 	return nil
 }
 
+// DeclareLocalFunction registers a new local function in the scope returning
+// the previous one in case of redeclaration, nil otherwise
+func (m *Scope) DeclareLocalFunction(ident, symbol string, f *FunctionDef) *FunctionDef {
+	if m.localFuncs == nil {
+		m.localFuncs = make(map[string]map[string]*FunctionDef)
+	}
+
+	if m.localFuncs[ident] == nil {
+		m.localFuncs[ident] = make(map[string]*FunctionDef)
+	}
+
+	pf, ok := m.localFuncs[ident][symbol]
+
+	m.localFuncs[ident][symbol] = f
+
+	if ok {
+		return pf
+	}
+
+	return nil
+}
+
 // DeclareMethod registers a new function in the scope returning the previous
 // one in case of redeclaration, nil otherwise
+// Static methods are kept apart from the methods called on the objects
 func (m *Scope) DeclareMethod(class, ident, symbol string, f *FunctionDef) *FunctionDef {
+	if f.static {
+		class = staticMethods(class)
+	}
+
 	if m.funcs[class] == nil {
 		m.funcs[class] = make(map[string]map[string]*FunctionDef)
 	}
@@ -209,6 +1066,15 @@ func (m *Scope) DeclareMethod(class, ident, symbol string, f *FunctionDef) *Func
 	return nil
 }
 
+// DeclareGlobal registers a global variable or constant in the global scope
+// returning the type of the previous declaration in case of redeclaration,
+// nil otherwise
+func (m *Scope) DeclareGlobal(g *GlobalDef) Type {
+	m.globals[g.ident] = g
+
+	return m.Declare(g.ident, g.wtype)
+}
+
 // DeclareEnum registers a new enum in the scope returning the previous
 // one in case of redeclaration, nil otherwise
 func (m *Scope) DeclareEnum(ident string, e *EnumType) *EnumType {
@@ -227,6 +1093,24 @@ func (m *Scope) DeclareEnum(ident string, e *EnumType) *EnumType {
 	return nil
 }
 
+// DeclareInterface registers a new interface in the scope returning the
+// previous one in case of redeclaration, nil otherwise
+func (m *Scope) DeclareInterface(ident string, i *InterfaceType) *InterfaceType {
+	if m.interfaces == nil {
+		m.interfaces = make(map[string]*InterfaceType)
+	}
+
+	pi, ok := m.interfaces[ident]
+
+	m.interfaces[ident] = i
+
+	if ok {
+		return pi
+	}
+
+	return nil
+}
+
 // DeclareClass registers a new class in the scope returning the previous
 // one in case of redeclaration, nil otherwise
 func (m *Scope) DeclareClass(ident string, c *ClassType) *ClassType {
@@ -251,16 +1135,40 @@ func (m InvalidType) Match(t Type) bool {
 }
 
 // Match checks whether a type is assignable to the current type
-func (m VoidType) Match(t Type) bool {
-	return true
+func (m VoidType) Match(t Type) bool {
+	return true
+}
+
+// Match checks whether a type is assignable to the current type
+func (m IntType) Match(t Type) bool {
+	switch t.(type) {
+	case IntType:
+		return true
+	case *EnumType:
+		return true
+	case VoidType:
+		return true
+	default:
+		return false
+	}
+}
+
+// Match checks whether a type is assignable to the current type
+func (m LongType) Match(t Type) bool {
+	switch t.(type) {
+	case LongType:
+		return true
+	case VoidType:
+		return true
+	default:
+		return false
+	}
 }
 
 // Match checks whether a type is assignable to the current type
-func (m IntType) Match(t Type) bool {
+func (m FloatType) Match(t Type) bool {
 	switch t.(type) {
-	case IntType:
-		return true
-	case *EnumType:
+	case FloatType:
 		return true
 	case VoidType:
 		return true
@@ -337,10 +1245,80 @@ func (m ArrayType) Match(t Type) bool {
 }
 
 // Match checks whether a type is assignable to the current type
+func (m MapType) Match(t Type) bool {
+	switch o := t.(type) {
+	case MapType:
+		return m.key.Match(o.key) && m.value.Match(o.value)
+	case VoidType:
+		return true
+	default:
+		return false
+	}
+}
+
+// Match checks whether a type is assignable to the current type
+// Functions are assignable if they accept the parameters and their result is
+// assignable to the expected result
+func (m FunctionType) Match(t Type) bool {
+	switch o := t.(type) {
+	case FunctionType:
+		if len(m.params) != len(o.params) {
+			return false
+		}
+		for i := range m.params {
+			if !o.params[i].Match(m.params[i]) {
+				return false
+			}
+		}
+		// a function without result cannot provide one
+		_, mvoid := m.returnType.(VoidType)
+		_, ovoid := o.returnType.(VoidType)
+		if ovoid && !mvoid {
+			return false
+		}
+		return m.returnType.Match(o.returnType)
+	case VoidType:
+		return true
+	default:
+		return false
+	}
+}
+
+// Match checks whether a type is assignable to the current type
+// Instances of subclasses are assignable to their parent classes
 func (m *ClassType) Match(t Type) bool {
 	switch o := t.(type) {
 	case *ClassType:
-		return m.name == o.name
+		for ; o != nil; o = o.parent {
+			if m.Symbol() == o.Symbol() {
+				return true
+			}
+		}
+		return false
+	case VoidType:
+		return true
+	default:
+		return false
+	}
+}
+
+// Match checks whether a type is assignable to the current type
+// Classes are assignable to the interfaces they declare to implement or whose
+// methods they all provide
+func (m *InterfaceType) Match(t Type) bool {
+	switch o := t.(type) {
+	case *InterfaceType:
+		return m.ident == o.ident
+	case *ClassType:
+		for c := o; c != nil; c = c.parent {
+			for _, i := range c.interfaces {
+				if m.ident == i.ident {
+					return true
+				}
+			}
+		}
+		_, ok := o.InterfaceTable(m)
+		return ok
 	case VoidType:
 		return true
 	default:
@@ -349,16 +1327,38 @@ func (m *ClassType) Match(t Type) bool {
 }
 
 // TypeCheck checks whether the AST has any type mismatches in expressions and
-// assignments
-func (m *AST) TypeCheck() []error {
+// assignments. The warnings are reported among the errors, such as freeing by
+// hand when refCount is set
+func (m *AST) TypeCheck(refCount bool) []error {
 	var errs []error
 
 	errch := make(chan error)
 
 	go func() {
 		global := CreateRootScope()
+		global.refCount = refCount
+		global.aliases = m.aliases
+		global.hidden = m.hidden
+
+		// report the modules that could not be imported
+		for _, module := range m.modules {
+			switch {
+			case module.clash:
+				errch <- CreateModuleClashError(
+					module.Token(),
+					module.file,
+					module.ident,
+				)
+			case module.redeclared:
+				errch <- CreateModuleRedeclarationError(
+					module.Token(),
+					module.alias,
+				)
+			}
+		}
 
-		// add the enums to the scope
+		// add the built-in enums and the enums to the scope
+		global.DeclareEnum("Exception", CreateExceptionEnum())
 		for _, e := range m.enums {
 			if pe := global.DeclareEnum(e.ident, e); pe != nil {
 				errch <- CreateEnumRedeclarationError(
@@ -368,6 +1368,16 @@ func (m *AST) TypeCheck() []error {
 			}
 		}
 
+		// add the interfaces to the scope
+		for _, i := range m.interfaces {
+			if pi := global.DeclareInterface(i.ident, i); pi != nil {
+				errch <- CreateInterfaceRedeclarationError(
+					i.Token(),
+					i.ident,
+				)
+			}
+		}
+
 		// add the classes and methods to the scope
 		for _, c := range m.classes {
 			if pc := global.DeclareClass(c.name, c); pc != nil {
@@ -378,6 +1388,13 @@ func (m *AST) TypeCheck() []error {
 			}
 			for _, m := range c.methods {
 				m.class = c
+				if len(m.typeParams) > 0 {
+					errch <- CreateGenericMethodError(
+						m.Token(),
+						c.name,
+						m.ident,
+					)
+				}
 				if pm := global.DeclareMethod(c.name, m.ident, m.Symbol(), m); pm != nil {
 					errch <- CreateFunctionRedelarationError(
 						m.Token(),
@@ -387,6 +1404,38 @@ func (m *AST) TypeCheck() []error {
 			}
 		}
 
+		// break inheritance cycles so lookups through the parents terminate.
+		// Parents are followed by name so that cycles through generic
+		// classes are broken before they are instantiated
+		for _, c := range m.classes {
+			depth := 0
+			owner := c
+			for p := c.parent; p != nil && depth <= len(m.classes); depth++ {
+				pc := global.ModuleScope(owner.name).LookupClass(p.name)
+				if pc == c {
+					errch <- CreateCyclicInheritanceError(
+						c.Token(),
+						c.name,
+					)
+					c.parent = nil
+					break
+				}
+				if pc == nil {
+					break
+				}
+				owner = pc
+				p = pc.parent
+			}
+		}
+
+		// resolve the parent classes
+		// the parents of generic classes are resolved for each instance
+		for _, c := range m.classes {
+			if len(c.typeParams) == 0 {
+				c.typeCheckParent(global.ModuleScope(c.name), errch)
+			}
+		}
+
 		// add the functions to the scope
 		for _, f := range m.functions {
 			if pf := global.DeclareFunction(f.ident, f.Symbol(), f); pf != nil {
@@ -397,52 +1446,78 @@ func (m *AST) TypeCheck() []error {
 			}
 		}
 
-		// check class methods
+		// resolve the class types used in the signatures and members
+		for _, i := range m.interfaces {
+			scope := global.ModuleScope(i.ident)
+			for _, m := range i.methods {
+				scope.ResolveType(m.returnType)
+				for _, arg := range m.params {
+					scope.ResolveType(arg.wtype)
+				}
+			}
+		}
 		for _, c := range m.classes {
-			cs := global.Child()
-			cs.class = c
-			cs.members = make(map[string]Type)
-			// add the members
+			if len(c.typeParams) > 0 {
+				continue
+			}
+			scope := global.ModuleScope(c.name)
 			for _, m := range c.members {
-				switch m.wtype.(type) {
-				case VoidType:
-					errch <- CreateInvalidVoidTypeError(
-						m.Token(),
-						m.ident,
-					)
-				}
-				if pt := cs.DeclareMember(m.ident, m.wtype); pt != nil {
-					errch <- CreateVariableRedeclarationError(
-						m.Token(),
-						m.ident,
-						pt,
-						m.wtype,
-					)
-				}
+				scope.ResolveType(m.wtype)
 			}
-			// typecheck methods
 			for _, m := range c.methods {
-				mscope := cs.Child()
+				scope.ResolveType(m.returnType)
 				for _, arg := range m.params {
-					switch arg.wtype.(type) {
-					case VoidType:
-						errch <- CreateInvalidVoidTypeError(
-							arg.Token(),
-							arg.name,
-						)
-					}
-					pt := mscope.Declare(arg.name, arg.wtype)
-					if pt != nil {
-						errch <- CreateVariableRedeclarationError(
-							arg.Token(),
-							arg.name,
-							pt,
-							arg.wtype,
-						)
-					}
+					scope.ResolveType(arg.wtype)
 				}
-				mscope.returnType = m.returnType
-				m.body.TypeCheck(mscope, errch)
+			}
+		}
+		for _, f := range m.functions {
+			if len(f.typeParams) > 0 {
+				continue
+			}
+			scope := global.ModuleScope(f.ident)
+			scope.ResolveType(f.returnType)
+			for _, arg := range f.params {
+				scope.ResolveType(arg.wtype)
+			}
+		}
+
+		// check the globals and add them to the scope in order, so that
+		// their initialisers only refer to the globals defined before them
+		for _, g := range m.globals {
+			g.TypeCheck(global, errch)
+		}
+
+		// the parameters of interface methods have no default values as
+		// the classes implementing them may disagree on them
+		for _, i := range m.interfaces {
+			for _, f := range i.methods {
+				f.typeCheckVariadic(true, errch)
+				f.typeCheckDefaults(global, false, errch)
+			}
+		}
+
+		// check that classes provide the methods of the interfaces they
+		// declare to implement
+		// generic classes are checked for each instance
+		for _, c := range m.classes {
+			if len(c.typeParams) == 0 {
+				c.typeCheckInterfaces(global.ModuleScope(c.name), errch)
+			}
+		}
+
+		// check that overriding methods are compatible with the methods
+		// they override
+		for _, c := range m.classes {
+			if len(c.typeParams) == 0 {
+				c.typeCheckOverrides(errch)
+			}
+		}
+
+		// check class methods
+		for _, c := range m.classes {
+			if len(c.typeParams) == 0 {
+				c.typeCheckMethods(global.ModuleScope(c.name), errch)
 			}
 		}
 
@@ -452,8 +1527,15 @@ func (m *AST) TypeCheck() []error {
 		m.main.TypeCheck(main, errch)
 
 		// check all the functions
+		// generic functions are checked when they are instantiated
 		for _, f := range m.functions {
-			fscope := global.Child()
+			f.typeCheckVariadic(true, errch)
+			if len(f.typeParams) > 0 {
+				f.typeCheckDefaults(global, false, errch)
+				continue
+			}
+			fscope := global.ModuleScope(f.ident)
+			f.typeCheckDefaults(fscope, true, errch)
 			for _, arg := range f.params {
 				switch arg.wtype.(type) {
 				case VoidType:
@@ -475,6 +1557,21 @@ func (m *AST) TypeCheck() []error {
 			fscope.returnType = f.returnType
 			f.body.TypeCheck(fscope, errch)
 		}
+
+		// check the instances of the generic classes. Checking them can
+		// instantiate further classes that are checked in turn
+		for i := 0; i < len(*global.instances); i++ {
+			c := (*global.instances)[i]
+			scope := global.ModuleScope(c.name)
+			c.typeCheckParent(scope, errch)
+			c.typeCheckInterfaces(scope, errch)
+			c.typeCheckOverrides(errch)
+			c.typeCheckMethods(scope, errch)
+		}
+
+		m.lambdas = *global.lambdas
+		m.exceptions = *global.exceptions
+
 		close(errch)
 	}()
 
@@ -485,6 +1582,228 @@ func (m *AST) TypeCheck() []error {
 	return errs
 }
 
+// TypeCheck checks that the initialiser of the global matches its type and that
+// constants are initialised by constant expressions. The global is added to
+// the global scope afterwards
+func (m *GlobalDef) TypeCheck(global *Scope, errch chan<- error) {
+	scope := global.ModuleScope(m.ident)
+	scope.ResolveType(m.wtype)
+
+	m.rhs.TypeCheck(scope, errch)
+
+	switch m.wtype.(type) {
+	case VoidType:
+		errch <- CreateInvalidVoidTypeError(
+			m.Token(),
+			m.ident,
+		)
+	}
+
+	if rhsT := m.rhs.Type(); !m.wtype.Match(rhsT) {
+		errch <- CreateTypeMismatchError(
+			m.rhs.Token(),
+			m.wtype,
+			rhsT,
+		)
+	} else {
+		typeMapLiter(m.rhs, m.wtype)
+	}
+
+	if m.constant {
+		if rhs, ok := m.rhs.(*ExpressionRHS); !ok || !isConstant(rhs.expr) {
+			errch <- CreateConstantExpressionError(
+				m.rhs.Token(),
+				m.ident,
+			)
+		}
+	}
+
+	if pt := global.DeclareGlobal(m); pt != nil {
+		errch <- CreateVariableRedeclarationError(
+			m.Token(),
+			m.ident,
+			pt,
+			m.wtype,
+		)
+	}
+}
+
+// isConstant returns whether an expression can be evaluated at compile time,
+// that is it is only made of literals, constants and operators
+func isConstant(expr Expression) bool {
+	switch e := expr.(type) {
+	case *IntLiteral,
+		*LongLiteral,
+		*FloatLiteral,
+		*CharLiteral,
+		*BoolLiteralTrue,
+		*BoolLiteralFalse,
+		*StringLiteral,
+		*EnumLiteral:
+		return true
+	case *Ident:
+		return e.global != nil && e.global.constant
+	case UnaryOperator:
+		return isConstant(e.GetExpression())
+	case BinaryOperator:
+		return isConstant(e.GetLHS()) && isConstant(e.GetRHS())
+	case *TernaryExpr:
+		return isConstant(e.cond) && isConstant(e.then) &&
+			isConstant(e.elseExpr)
+	default:
+		return false
+	}
+}
+
+// typeCheckParent links the class to the declaration of its parent class
+func (m *ClassType) typeCheckParent(global *Scope, errch chan<- error) {
+	if m.parent == nil {
+		return
+	}
+
+	p := global.LookupClassType(m.parent)
+	if p == nil {
+		if global.LookupClass(m.parent.name) != nil {
+			errch <- CreateTypeArgumentsError(
+				m.Token(),
+				m.parent,
+			)
+		} else {
+			errch <- CreateUndeclaredClassError(
+				m.Token(),
+				m.parent.name,
+			)
+		}
+	}
+	m.parent = p
+}
+
+// typeCheckInterfaces checks that the class provides the methods of the
+// interfaces it declares to implement
+func (m *ClassType) typeCheckInterfaces(global *Scope, errch chan<- error) {
+	for n, ci := range m.interfaces {
+		i := global.LookupInterface(ci.ident)
+		if i == nil {
+			errch <- CreateUndeclaredInterfaceError(
+				m.Token(),
+				ci.ident,
+			)
+			continue
+		}
+		m.interfaces[n] = i
+		if _, ok := m.InterfaceTable(i); !ok {
+			errch <- CreateInterfaceNotImplementedError(
+				m.Token(),
+				m.name,
+				i.ident,
+			)
+		}
+	}
+}
+
+// typeCheckOverrides checks that the overriding methods of the class are
+// compatible with the methods they override
+func (m *ClassType) typeCheckOverrides(errch chan<- error) {
+	if m.parent == nil {
+		return
+	}
+	for _, f := range m.methods {
+		if f.static {
+			continue
+		}
+		for _, pf := range m.parent.VTable() {
+			if pf.Signature() != f.Signature() {
+				continue
+			}
+			if !pf.returnType.Match(f.returnType) {
+				errch <- CreateOverrideMismatchError(
+					f.Token(),
+					f.ident,
+					pf.returnType,
+					f.returnType,
+				)
+			}
+		}
+	}
+}
+
+// typeCheckMethods checks the members and the method bodies of the class.
+// Methods of generic class instances are checked with the type parameters
+// replaced by the type arguments
+func (m *ClassType) typeCheckMethods(global *Scope, errch chan<- error) {
+	cs := global.Child()
+	cs.class = m
+	cs.members = make(map[string]Type)
+	cs.typeArgs = m.TypeBindings()
+	// add the members
+	for _, member := range m.members {
+		switch member.wtype.(type) {
+		case VoidType:
+			errch <- CreateInvalidVoidTypeError(
+				member.Token(),
+				member.ident,
+			)
+		}
+		// members cannot shadow the inherited ones
+		if _, own := cs.members[member.ident]; !own {
+			switch pt := cs.LookupMember(member.ident).(type) {
+			case InvalidType:
+			default:
+				errch <- CreateVariableRedeclarationError(
+					member.Token(),
+					member.ident,
+					pt,
+					member.wtype,
+				)
+			}
+		}
+		if pt := cs.DeclareMember(member.ident, member.wtype); pt != nil {
+			errch <- CreateVariableRedeclarationError(
+				member.Token(),
+				member.ident,
+				pt,
+				member.wtype,
+			)
+		}
+	}
+	// typecheck methods
+	for _, f := range m.methods {
+		// the destructor is called without arguments on free
+		_, void := f.returnType.(VoidType)
+		if f.ident == "fini" && !f.static && (len(f.params) > 0 || !void) {
+			errch <- CreateInvalidDestructorError(f.Token(), m.name)
+		}
+		f.typeCheckVariadic(true, errch)
+		f.typeCheckDefaults(global, true, errch)
+		mscope := cs.Child()
+		// static methods are not called on an object
+		if f.static {
+			mscope.static = true
+			mscope.members = make(map[string]Type)
+		}
+		for _, arg := range f.params {
+			switch arg.wtype.(type) {
+			case VoidType:
+				errch <- CreateInvalidVoidTypeError(
+					arg.Token(),
+					arg.name,
+				)
+			}
+			pt := mscope.Declare(arg.name, arg.wtype)
+			if pt != nil {
+				errch <- CreateVariableRedeclarationError(
+					arg.Token(),
+					arg.name,
+					pt,
+					arg.wtype,
+				)
+			}
+		}
+		mscope.returnType = f.returnType
+		f.body.TypeCheck(mscope, errch)
+	}
+}
+
 // TypeCheck checks whether the statement has any type mismatches in expressions
 // and assignments. The check is propagated recursively
 func (m *BaseStatement) TypeCheck(ts *Scope, errch chan<- error) {
@@ -508,6 +1827,8 @@ func (m *DeclareAssignStatement) TypeCheck(ts *Scope, errch chan<- error) {
 
 	if m.wtype == nil {
 		m.wtype = m.rhs.Type()
+	} else {
+		m.wtype = ts.Substitute(m.wtype)
 	}
 
 	switch m.wtype.(type) {
@@ -518,6 +1839,15 @@ func (m *DeclareAssignStatement) TypeCheck(ts *Scope, errch chan<- error) {
 		)
 	}
 
+	// only class instances have destructors to run when going out of scope
+	if _, ok := m.wtype.(*ClassType); m.scoped && !ok {
+		errch <- CreateScopedTypeError(
+			m.Token(),
+			m.ident,
+			m.wtype,
+		)
+	}
+
 	if pt := ts.Declare(m.ident, m.wtype); pt != nil {
 		errch <- CreateVariableRedeclarationError(
 			m.Token(),
@@ -527,12 +1857,18 @@ func (m *DeclareAssignStatement) TypeCheck(ts *Scope, errch chan<- error) {
 		)
 	}
 
+	if m.scoped {
+		ts.DeclareScoped(m.ident)
+	}
+
 	if rhsT := m.rhs.Type(); !m.wtype.Match(rhsT) {
 		errch <- CreateTypeMismatchError(
 			m.rhs.Token(),
 			m.wtype,
 			rhsT,
 		)
+	} else {
+		typeMapLiter(m.rhs, m.wtype)
 	}
 
 	m.BaseStatement.TypeCheck(ts, errch)
@@ -553,6 +1889,18 @@ func (m *AssignStatement) TypeCheck(ts *Scope, errch chan<- error) {
 			lhsT,
 			rhsT,
 		)
+	} else {
+		typeMapLiter(m.rhs, lhsT)
+	}
+
+	// the instance of a scoped variable is destroyed when leaving the scope
+	// so it can not be replaced
+	if v, ok := m.target.(*VarLHS); ok && ts.IsScoped(v.ident) {
+		errch <- CreateScopedVariableError(
+			m.target.Token(),
+			v.ident,
+			"reassigned",
+		)
 	}
 
 	m.BaseStatement.TypeCheck(ts, errch)
@@ -566,6 +1914,7 @@ func (m *ReadStatement) TypeCheck(ts *Scope, errch chan<- error) {
 	switch t := m.target.Type().(type) {
 	case IntType:
 	case CharType:
+	case FloatType:
 	default:
 		errch <- CreateTypeMismatchError(
 			m.target.Token(),
@@ -606,7 +1955,47 @@ func (m *FreeStatement) TypeCheck(ts *Scope, errch chan<- error) {
 		)
 		errch <- CreateTypeMismatchError(
 			m.expr.Token(),
-			&ClassType{name: "any class"},
+			&ClassType{name: "any class"},
+			t,
+		)
+	}
+
+	// objects are freed when their last reference is released
+	if ts.refCount {
+		errch <- CreateFreeIgnoredWarning(m.Token())
+	}
+
+	// the instance of a scoped variable is destroyed when leaving the scope
+	if v, ok := m.expr.(*Ident); ok && ts.IsScoped(v.ident) {
+		errch <- CreateScopedVariableError(
+			m.expr.Token(),
+			v.ident,
+			"freed",
+		)
+	}
+
+	m.BaseStatement.TypeCheck(ts, errch)
+}
+
+// TypeCheck checks whether the statement has any type mismatches in expressions
+// and assignments. The check is propagated recursively
+func (m *DeleteStatement) TypeCheck(ts *Scope, errch chan<- error) {
+	m.expr.TypeCheck(ts, errch)
+	m.key.TypeCheck(ts, errch)
+
+	switch t := m.expr.Type().(type) {
+	case MapType:
+		if !t.key.Match(m.key.Type()) {
+			errch <- CreateTypeMismatchError(
+				m.key.Token(),
+				t.key,
+				m.key.Type(),
+			)
+		}
+	default:
+		errch <- CreateTypeMismatchError(
+			m.expr.Token(),
+			MapType{key: VoidType{}, value: VoidType{}},
 			t,
 		)
 	}
@@ -622,6 +2011,14 @@ func (m *ReturnStatement) TypeCheck(ts *Scope, errch chan<- error) {
 	returnT := ts.returnType
 	exprT := m.expr.Type()
 
+	// the return type of a lambda is inferred from its first return
+	if ts.lambda != nil {
+		if ts.lambda.returnType == nil {
+			ts.lambda.returnType = exprT
+		}
+		returnT = ts.lambda.returnType
+	}
+
 	switch returnT.(type) {
 	case VoidType:
 		switch exprT.(type) {
@@ -663,6 +2060,39 @@ func (m *ExitStatement) TypeCheck(ts *Scope, errch chan<- error) {
 	m.BaseStatement.TypeCheck(ts, errch)
 }
 
+// TypeCheck checks whether the statement has any type mismatches in expressions
+// and assignments. The check is propagated recursively
+// Exceptions are ints, the runtime errors are named by the Exception enum
+func (m *ThrowStatement) TypeCheck(ts *Scope, errch chan<- error) {
+	m.expr.TypeCheck(ts, errch)
+	throwT := m.expr.Type()
+
+	if !(IntType{}.Match(throwT)) {
+		errch <- CreateTypeMismatchError(
+			m.expr.Token(),
+			IntType{},
+			throwT,
+		)
+	}
+
+	m.BaseStatement.TypeCheck(ts, errch)
+}
+
+// TypeCheck checks whether the statement has any type mismatches in expressions
+// and assignments. The check is propagated recursively
+// The exception caught is an int declared in the scope of the catch body
+func (m *TryStatement) TypeCheck(ts *Scope, errch chan<- error) {
+	*ts.exceptions = true
+
+	m.body.TypeCheck(ts.Child(), errch)
+
+	cs := ts.Child()
+	cs.Declare(m.ident, IntType{})
+	m.catchStat.TypeCheck(cs, errch)
+
+	m.BaseStatement.TypeCheck(ts, errch)
+}
+
 // TypeCheck checks whether the statement has any type mismatches in expressions
 // and assignments. The check is propagated recursively
 func (m *PrintLnStatement) TypeCheck(ts *Scope, errch chan<- error) {
@@ -684,19 +2114,28 @@ func (m *FunctionCallStat) TypeCheck(ts *Scope, errch chan<- error) {
 		arg.TypeCheck(ts, errch)
 	}
 
+	if len(m.obj) == 0 && m.typeCheckValueCall(m.Token(), ts, errch) {
+		m.BaseStatement.TypeCheck(ts, errch)
+		return
+	}
+
 	var classname string
 	if len(m.obj) > 0 {
 		var recvT Type
 		switch m.obj[0] {
 		case '@':
-			recvT = ts.LookupMember(m.obj[1:])
+			recvT = ts.AccessMember(m.Token(), m.obj[1:], errch)
 		default:
+			m.obj = ts.globalIdent(m.obj)
 			recvT = ts.Lookup(m.obj)
 		}
 
 		switch t := recvT.(type) {
 		case *ClassType:
-			classname = t.name
+			classname = t.Symbol()
+			m.class = ts.LookupClass(classname)
+		case *InterfaceType:
+			m.iface = ts.LookupInterface(t.ident)
 		default:
 			errch <- CreateFunctionCallOnNonObjectError(
 				m.Token(),
@@ -707,10 +2146,18 @@ func (m *FunctionCallStat) TypeCheck(ts *Scope, errch chan<- error) {
 	}
 
 	var overloads map[string]*FunctionDef
-	if len(classname) > 0 {
+	switch {
+	case m.iface != nil:
+		overloads = ts.LookupInterfaceMethod(m.iface.ident, m.ident)
+	case len(classname) > 0:
 		overloads = ts.LookupMethod(classname, m.ident)
-	} else {
-		overloads = ts.LookupFunction(m.ident)
+	case strings.Contains(m.ident, "::"):
+		overloads = ts.LookupStaticMethod(m.ident)
+	default:
+		// local functions hide the top level ones
+		if overloads = ts.LookupLocalFunction(m.ident); overloads == nil {
+			overloads = ts.LookupFunction(m.ident)
+		}
 	}
 
 	if overloads == nil {
@@ -720,46 +2167,23 @@ func (m *FunctionCallStat) TypeCheck(ts *Scope, errch chan<- error) {
 		)
 	}
 
-	found := false
-	mangledIdent := ""
-
 	m.wtype = InvalidType{}
-
-	for symbol, fun := range overloads {
-		if len(fun.params) != len(m.args) {
-			continue
-		}
-
-		match := true
-
-		for i := 0; i < len(fun.params) && i < len(m.args) && match; i++ {
-			paramT := fun.params[i].wtype
-			argT := m.args[i].Type()
-			if !argT.Match(paramT) {
-				match = false
-			}
-		}
-
-		if match && found {
-			errch <- CreateAmbigousFunctionCallError(
-				m.Token(),
-				m.ident,
-			)
-		}
-
-		if match {
-			found = true
-			mangledIdent = symbol
-			m.wtype = fun.returnType
+	m.mangledIdent = ""
+
+	// the defaults are filled in so that they are evaluated at the call site
+	if fun, args := ts.ResolveOverload(m.Token(), m.ident, overloads, m.args, m.names, errch); fun != nil {
+		m.args = args
+		m.names = nil
+		m.variadic, m.packed = fun.Packed(len(args))
+		m.mangledIdent = fun.Symbol()
+		m.wtype = fun.returnType
+		if fun.depth > 0 {
+			m.local = fun
 		}
-	}
-
-	if !found {
+	} else if !m.typeCheckGeneric(m.Token(), overloads, ts, errch) {
 		errch <- CreateNoSuchOverloadError(m.Token(), m.ident)
 	}
 
-	m.mangledIdent = mangledIdent
-
 	m.BaseStatement.TypeCheck(ts, errch)
 }
 
@@ -799,9 +2223,11 @@ func (m *WhileStatement) TypeCheck(ts *Scope, errch chan<- error) {
 		)
 	}
 	ts.loop = ts.loop + 1
+	ts.labels = append(ts.labels, m.label)
 
 	m.body.TypeCheck(ts.Child(), errch)
 
+	ts.labels = ts.labels[:len(ts.labels)-1]
 	ts.loop = ts.loop - 1
 
 	m.BaseStatement.TypeCheck(ts, errch)
@@ -822,12 +2248,14 @@ func (m *SwitchStatement) TypeCheck(ts *Scope, errch chan<- error) {
 		)
 	}
 
+	enum, isEnum := condT.(*EnumType)
+
 	for index := 0; index < len(m.cases); index++ {
 		m.cases[index].TypeCheck(ts.Child(), errch)
 
 		exprT := m.cases[index].Type()
 
-		if !condT.Match(exprT) {
+		if !isEnum && !condT.Match(exprT) {
 			errch <- CreateTypeMismatchError(
 				m.cond.Token(),
 				condT,
@@ -842,9 +2270,64 @@ func (m *SwitchStatement) TypeCheck(ts *Scope, errch chan<- error) {
 		m.defaultCase.TypeCheck(ts.Child(), errch)
 	}
 
+	if isEnum {
+		m.typeCheckEnumCases(enum, ts, errch)
+	}
+
 	m.BaseStatement.TypeCheck(ts, errch)
 }
 
+// typeCheckEnumCases checks that the cases of a switch on an enum are distinct
+// values of that enum and that all of its values are handled unless there is
+// a default case
+func (m *SwitchStatement) typeCheckEnumCases(enum *EnumType, ts *Scope, errch chan<- error) {
+	values := enum.values
+	if e, ok := ts.enums[enum.ident]; ok {
+		values = e.values
+	}
+
+	// members may be given the same value so cases are told apart by value
+	handled := make(map[int]bool)
+
+	for _, c := range m.cases {
+		lit, ok := c.(*EnumLiteral)
+		if ok {
+			e, isEnum := lit.Type().(*EnumType)
+			ok = isEnum && e.ident == enum.ident
+		}
+		if !ok {
+			errch <- CreateEnumCaseError(c.Token(), enum)
+			continue
+		}
+
+		value, ok := values[lit.field]
+		if !ok {
+			continue
+		}
+
+		if handled[value] {
+			errch <- CreateDuplicateCaseError(c.Token(), lit.ident, lit.field)
+		}
+		handled[value] = true
+	}
+
+	if m.defaultCase != nil {
+		return
+	}
+
+	var missing []string
+	for field, value := range values {
+		if !handled[value] {
+			missing = append(missing, field)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		errch <- CreateMissingCasesError(m.cond.Token(), enum, missing)
+	}
+}
+
 // TypeCheck checks whether the left hand is a valid assignment target.
 // The check propagated recursively.
 func (m *DoWhileStatement) TypeCheck(ts *Scope, errch chan<- error) {
@@ -860,7 +2343,9 @@ func (m *DoWhileStatement) TypeCheck(ts *Scope, errch chan<- error) {
 	}
 
 	ts.loop = ts.loop + 1
+	ts.labels = append(ts.labels, m.label)
 	m.body.TypeCheck(ts.Child(), errch)
+	ts.labels = ts.labels[:len(ts.labels)-1]
 	ts.loop = ts.loop - 1
 
 	m.BaseStatement.TypeCheck(ts, errch)
@@ -868,6 +2353,7 @@ func (m *DoWhileStatement) TypeCheck(ts *Scope, errch chan<- error) {
 
 func (m *ForStatement) TypeCheck(ts *Scope, errch chan<- error) {
 	ts.loop = ts.loop + 1
+	ts.labels = append(ts.labels, m.label)
 	child := ts.Child()
 
 	switch t := m.init.(type) {
@@ -898,16 +2384,128 @@ func (m *ForStatement) TypeCheck(ts *Scope, errch chan<- error) {
 	}
 
 	m.body.TypeCheck(child, errch)
+	ts.labels = ts.labels[:len(ts.labels)-1]
+	ts.loop = ts.loop - 1
+
+	m.BaseStatement.TypeCheck(ts, errch)
+}
+
+// TypeCheck checks that the statement iterates over an array and that its
+// elements can be assigned to the declared variable. The variable and the body
+// share a scope. The check is propagated recursively
+func (m *ForEachStatement) TypeCheck(ts *Scope, errch chan<- error) {
+	m.expr.TypeCheck(ts, errch)
+	exprT := m.expr.Type()
+
+	var elemT Type = VoidType{}
+	if arr, ok := exprT.(ArrayType); ok {
+		elemT = arr.base
+	} else {
+		errch <- CreateTypeMismatchError(
+			m.expr.Token(),
+			ArrayType{},
+			exprT,
+		)
+	}
+
+	if m.wtype == nil {
+		m.wtype = elemT
+	} else {
+		m.wtype = ts.Substitute(m.wtype)
+
+		if !m.wtype.Match(elemT) {
+			errch <- CreateTypeMismatchError(
+				m.expr.Token(),
+				ArrayType{base: m.wtype},
+				exprT,
+			)
+		}
+	}
+
+	ts.loop = ts.loop + 1
+	ts.labels = append(ts.labels, m.label)
+	child := ts.Child()
+
+	child.Declare(m.ident, m.wtype)
+	m.body.TypeCheck(child, errch)
+
+	ts.labels = ts.labels[:len(ts.labels)-1]
 	ts.loop = ts.loop - 1
 
 	m.BaseStatement.TypeCheck(ts, errch)
 }
 
+// TypeCheck declares the local function in the scope of the block before
+// checking its body, so that it can call itself. The body is checked in a child
+// scope where the variables of the enclosing functions are visible
+func (m *LocalFuncStatement) TypeCheck(ts *Scope, errch chan<- error) {
+	f := m.function
+
+	if len(f.typeParams) > 0 {
+		errch <- CreateGenericLocalFunctionError(m.Token(), f.ident)
+		m.BaseStatement.TypeCheck(ts, errch)
+		return
+	}
+
+	f.returnType = ts.Substitute(f.returnType)
+	ts.ResolveType(f.returnType)
+	for _, arg := range f.params {
+		arg.wtype = ts.Substitute(arg.wtype)
+		ts.ResolveType(arg.wtype)
+	}
+
+	if pf := ts.DeclareLocalFunction(f.ident, f.Symbol(), f); pf != nil {
+		errch <- CreateFunctionRedelarationError(
+			m.Token(),
+			f.ident,
+		)
+	}
+
+	*ts.locals++
+	f.local = *ts.locals
+	f.depth = ts.depth + 1
+
+	fs := ts.Child()
+	fs.local = f
+	fs.depth = f.depth
+	fs.returnType = f.returnType
+	fs.loop = 0
+	fs.labels = nil
+
+	f.typeCheckVariadic(true, errch)
+	f.typeCheckDefaults(fs, true, errch)
+
+	for _, arg := range f.params {
+		switch arg.wtype.(type) {
+		case VoidType:
+			errch <- CreateInvalidVoidTypeError(
+				arg.Token(),
+				arg.name,
+			)
+		}
+		pt := fs.Declare(arg.name, arg.wtype)
+		if pt != nil {
+			errch <- CreateVariableRedeclarationError(
+				arg.Token(),
+				arg.name,
+				pt,
+				arg.wtype,
+			)
+		}
+	}
+
+	f.body.TypeCheck(fs, errch)
+
+	m.BaseStatement.TypeCheck(ts, errch)
+}
+
 // TypeCheck checks whether the statement has any type mismatches in expressions
 // and assignments. The check is propagated recursively
 func (m *ContinueStatement) TypeCheck(ts *Scope, errch chan<- error) {
 	if ts.loop == 0 {
 		errch <- CreateContinueNotInLoopError(m.Token())
+	} else if !ts.HasLabel(m.label) {
+		errch <- CreateUnknownLabelError(m.Token(), m.label)
 	}
 
 	m.BaseStatement.TypeCheck(ts, errch)
@@ -918,6 +2516,8 @@ func (m *ContinueStatement) TypeCheck(ts *Scope, errch chan<- error) {
 func (m *BreakStatement) TypeCheck(ts *Scope, errch chan<- error) {
 	if ts.loop == 0 {
 		errch <- CreateBreakNotInLoopError(m.Token())
+	} else if !ts.HasLabel(m.label) {
+		errch <- CreateUnknownLabelError(m.Token(), m.label)
 	}
 
 	m.BaseStatement.TypeCheck(ts, errch)
@@ -951,14 +2551,32 @@ func (m *ArrayLHS) TypeCheck(ts *Scope, errch chan<- error) {
 	var t Type
 	switch m.ident[0] {
 	case '@':
-		t = ts.LookupMember(m.ident[1:])
+		t = ts.AccessMember(m.Token(), m.ident[1:], errch)
 	default:
+		m.ident = ts.globalIdent(m.ident)
 		t = ts.Lookup(m.ident)
 	}
 
+	m.indexed = nil
+
 	for _, i := range m.index {
 		i.TypeCheck(ts, errch)
 
+		m.indexed = append(m.indexed, t)
+
+		// maps are indexed by their keys
+		if mapT, ok := t.(MapType); ok {
+			if !mapT.key.Match(i.Type()) {
+				errch <- CreateTypeMismatchError(
+					i.Token(),
+					mapT.key,
+					i.Type(),
+				)
+			}
+			t = mapT.value
+			continue
+		}
+
 		if !(IntType{}).Match(i.Type()) {
 			errch <- CreateTypeMismatchError(
 				i.Token(),
@@ -988,8 +2606,17 @@ func (m *VarLHS) TypeCheck(ts *Scope, errch chan<- error) {
 	var t Type
 	switch m.ident[0] {
 	case '@':
-		t = ts.LookupMember(m.ident[1:])
+		t = ts.AccessMember(m.Token(), m.ident[1:], errch)
 	default:
+		if g := ts.LookupGlobal(m.ident); g != nil {
+			m.ident = g.ident
+			if g.constant {
+				errch <- CreateConstantAssignmentError(
+					m.Token(),
+					m.ident,
+				)
+			}
+		}
 		t = ts.Lookup(m.ident)
 	}
 
@@ -1028,6 +2655,48 @@ func (m *PairElemRHS) TypeCheck(ts *Scope, errch chan<- error) {
 	}
 }
 
+// TypeCheck checks whether the right hand side is valid and assignable
+// The check is propagated recursively.
+func (m *MapLiterRHS) TypeCheck(ts *Scope, errch chan<- error) {
+	if len(m.keys) == 0 {
+		return
+	}
+
+	for i := range m.keys {
+		m.keys[i].TypeCheck(ts, errch)
+		m.values[i].TypeCheck(ts, errch)
+	}
+
+	keyT := m.keys[0].Type()
+	valueT := m.values[0].Type()
+
+	for i := 1; i < len(m.keys); i++ {
+		if key := m.keys[i]; !keyT.Match(key.Type()) {
+			errch <- CreateTypeMismatchError(
+				key.Token(),
+				keyT,
+				key.Type(),
+			)
+		}
+
+		if value := m.values[i]; !valueT.Match(value.Type()) {
+			errch <- CreateTypeMismatchError(
+				value.Token(),
+				valueT,
+				value.Type(),
+			)
+		}
+	}
+}
+
+// typeMapLiter gives a map literal the type of the map it is assigned to
+func typeMapLiter(rhs RHS, t Type) {
+	lit, ok := rhs.(*MapLiterRHS)
+	if _, isMap := t.(MapType); ok && isMap {
+		lit.wtype = t
+	}
+}
+
 // TypeCheck checks whether the right hand side is valid and assignable
 // The check is propagated recursively.
 func (m *ArrayLiterRHS) TypeCheck(ts *Scope, errch chan<- error) {
@@ -1059,19 +2728,39 @@ func (m *FunctionCallRHS) TypeCheck(ts *Scope, errch chan<- error) {
 		arg.TypeCheck(ts, errch)
 	}
 
+	if len(m.obj) > 0 || !m.typeCheckValueCall(m.Token(), ts, errch) {
+		m.typeCheckOverloads(ts, errch)
+	}
+
+	switch m.wtype.(type) {
+	case VoidType:
+		errch <- CreateVoidAssignmentError(
+			m.Token(),
+			m.ident,
+		)
+	}
+}
+
+// typeCheckOverloads resolves the function or method called on the right hand
+// side of an assignment
+func (m *FunctionCallRHS) typeCheckOverloads(ts *Scope, errch chan<- error) {
 	var classname string
 	if len(m.obj) > 0 {
 		var recvT Type
 		switch m.obj[0] {
 		case '@':
-			recvT = ts.LookupMember(m.obj[1:])
+			recvT = ts.AccessMember(m.Token(), m.obj[1:], errch)
 		default:
+			m.obj = ts.globalIdent(m.obj)
 			recvT = ts.Lookup(m.obj)
 		}
 
 		switch t := recvT.(type) {
 		case *ClassType:
-			classname = t.name
+			classname = t.Symbol()
+			m.class = ts.LookupClass(classname)
+		case *InterfaceType:
+			m.iface = ts.LookupInterface(t.ident)
 		default:
 			errch <- CreateFunctionCallOnNonObjectError(
 				m.Token(),
@@ -1082,10 +2771,18 @@ func (m *FunctionCallRHS) TypeCheck(ts *Scope, errch chan<- error) {
 	}
 
 	var overloads map[string]*FunctionDef
-	if len(classname) > 0 {
+	switch {
+	case m.iface != nil:
+		overloads = ts.LookupInterfaceMethod(m.iface.ident, m.ident)
+	case len(classname) > 0:
 		overloads = ts.LookupMethod(classname, m.ident)
-	} else {
-		overloads = ts.LookupFunction(m.ident)
+	case strings.Contains(m.ident, "::"):
+		overloads = ts.LookupStaticMethod(m.ident)
+	default:
+		// local functions hide the top level ones
+		if overloads = ts.LookupLocalFunction(m.ident); overloads == nil {
+			overloads = ts.LookupFunction(m.ident)
+		}
 	}
 
 	if overloads == nil {
@@ -1095,53 +2792,163 @@ func (m *FunctionCallRHS) TypeCheck(ts *Scope, errch chan<- error) {
 		)
 	}
 
-	found := false
-	mangledIdent := ""
-
 	m.wtype = InvalidType{}
+	m.mangledIdent = ""
+
+	// the defaults are filled in so that they are evaluated at the call site
+	if fun, args := ts.ResolveOverload(m.Token(), m.ident, overloads, m.args, m.names, errch); fun != nil {
+		m.args = args
+		m.names = nil
+		m.variadic, m.packed = fun.Packed(len(args))
+		m.mangledIdent = fun.Symbol()
+		m.wtype = fun.returnType
+		if fun.depth > 0 {
+			m.local = fun
+		}
+	} else if !m.typeCheckGeneric(m.Token(), overloads, ts, errch) {
+		errch <- CreateNoSuchOverloadError(m.Token(), m.ident)
+	}
+}
 
-	for symbol, fun := range overloads {
-		if len(fun.params) != len(m.args) {
+// ResolveOverload returns the overload of a function that fits the arguments
+// of a call together with the arguments bound to its parameters. Overloads
+// that are not variadic are preferred, then those filling in fewer default
+// values, and the call is ambiguous if several overloads are equally
+// preferred. Generic overloads are left to type inference. It returns nil if
+// no overload fits
+func (m *Scope) ResolveOverload(token *token32, ident string, overloads map[string]*FunctionDef, args []Expression, names []string, errch chan<- error) (*FunctionDef, []Expression) {
+	var best *FunctionDef
+	var bestArgs []Expression
+	bestDefaults := 0
+	ambiguous := false
+
+	for _, fun := range overloads {
+		if len(fun.typeParams) > 0 {
 			continue
 		}
 
-		match := true
+		bound, defaults, ok := fun.BindArgs(args, names)
+		if !ok {
+			continue
+		}
 
-		for i := 0; i < len(fun.params) && i < len(m.args) && match; i++ {
-			paramT := fun.params[i].wtype
-			argT := m.args[i].Type()
-			if !argT.Match(paramT) {
-				match = false
-			}
+		switch {
+		case best == nil, !fun.Variadic() && best.Variadic():
+		case fun.Variadic() && !best.Variadic(), defaults > bestDefaults:
+			continue
+		case defaults == bestDefaults:
+			ambiguous = true
+			continue
 		}
 
-		if match && found {
+		best, bestArgs, bestDefaults = fun, bound, defaults
+		ambiguous = false
+	}
+
+	if best == nil {
+		return nil, nil
+	}
+
+	if ambiguous {
+		errch <- CreateAmbigousFunctionCallError(token, ident)
+	}
+
+	if !m.CanAccess(best) {
+		errch <- CreateAccessViolationError(token, best.class.name, ident)
+	}
+
+	return best, bestArgs
+}
+
+// typeCheckGeneric instantiates the generic overload whose type arguments can
+// be inferred from the arguments of the call
+// It returns false if there is no such overload
+func (m *FunctionCall) typeCheckGeneric(token *token32, overloads map[string]*FunctionDef, ts *Scope, errch chan<- error) bool {
+	// the type arguments are inferred from positional arguments only
+	for _, name := range m.names {
+		if len(name) > 0 {
+			return false
+		}
+	}
+
+	found := false
+
+	for _, fun := range overloads {
+		if len(fun.typeParams) == 0 {
+			continue
+		}
+
+		bindings, ok := fun.InferTypeArgs(m.args)
+		if !ok {
+			continue
+		}
+
+		if found {
 			errch <- CreateAmbigousFunctionCallError(
-				m.Token(),
+				token,
 				m.ident,
 			)
+			continue
 		}
 
-		if match {
-			found = true
-			mangledIdent = symbol
-			m.wtype = fun.returnType
-		}
+		found = true
+		inst := ts.Instantiate(fun, bindings, errch)
+		m.variadic, m.packed = inst.Packed(len(m.args))
+		m.mangledIdent = inst.Symbol()
+		m.wtype = inst.returnType
 	}
 
-	if !found {
-		errch <- CreateNoSuchOverloadError(m.Token(), m.ident)
+	return found
+}
+
+// typeCheckValueCall checks a call through a variable holding a function value
+// It returns false if the identifier does not name such a variable
+func (m *FunctionCall) typeCheckValueCall(token *token32, ts *Scope, errch chan<- error) bool {
+	var t Type
+	ident := m.ident
+	switch m.ident[0] {
+	case '@':
+		t = ts.AccessMember(token, m.ident[1:], errch)
+	default:
+		ident = ts.globalIdent(m.ident)
+		t = ts.Lookup(ident)
 	}
 
-	m.mangledIdent = mangledIdent
+	ft, ok := t.(FunctionType)
+	if !ok {
+		return false
+	}
 
-	switch m.wtype.(type) {
-	case VoidType:
-		errch <- CreateVoidAssignmentError(
-			m.Token(),
-			m.ident,
-		)
+	m.ident = ident
+
+	m.closure = true
+	m.wtype = ft.returnType
+
+	// function values do not carry the names of their parameters
+	for _, name := range m.names {
+		if len(name) > 0 {
+			errch <- CreateNoSuchOverloadError(token, m.ident)
+			return true
+		}
+	}
+
+	if len(ft.params) != len(m.args) {
+		errch <- CreateNoSuchOverloadError(token, m.ident)
+		return true
 	}
+
+	for i, paramT := range ft.params {
+		argT := m.args[i].Type()
+		if !paramT.Match(argT) {
+			errch <- CreateTypeMismatchError(
+				m.args[i].Token(),
+				paramT,
+				argT,
+			)
+		}
+	}
+
+	return true
 }
 
 // TypeCheck checks whether the right hand side is valid and assignable
@@ -1154,7 +2961,7 @@ func (m *ExpressionRHS) TypeCheck(ts *Scope, errch chan<- error) {
 // The check is propagated recursively.
 func (m *NewInstanceRHS) TypeCheck(ts *Scope, errch chan<- error) {
 	var ct *ClassType
-	switch t := m.wtype.(type) {
+	switch t := ts.Substitute(m.wtype).(type) {
 	case *ClassType:
 		ct = t
 	default:
@@ -1163,25 +2970,37 @@ func (m *NewInstanceRHS) TypeCheck(ts *Scope, errch chan<- error) {
 			&ClassType{name: "any class"},
 			t,
 		)
+		m.wtype = InvalidType{}
+		return
 	}
 
-	c := ts.LookupClass(ct.name)
+	c := ts.LookupClassType(ct)
 
-	if c == nil {
+	switch {
+	case c != nil:
+		m.wtype = c
+	case ts.LookupClass(ct.name) != nil:
+		errch <- CreateTypeArgumentsError(
+			m.Token(),
+			ct,
+		)
+		m.wtype = InvalidType{}
+	default:
 		errch <- CreateUndeclaredClassError(
 			m.Token(),
 			ct.name,
 		)
 		m.wtype = InvalidType{}
-	} else {
-		m.wtype = c
 	}
 
 	for _, arg := range m.args {
 		arg.TypeCheck(ts, errch)
 	}
 
-	var classname = ct.name
+	var classname = ct.Symbol()
+	if c != nil {
+		classname = c.Symbol()
+	}
 
 	var overloads map[string]*FunctionDef
 	if len(classname) > 0 {
@@ -1195,42 +3014,16 @@ func (m *NewInstanceRHS) TypeCheck(ts *Scope, errch chan<- error) {
 		)
 	}
 
-	found := false
-	constr := ""
-
-	for symbol, fun := range overloads {
-		if len(fun.params) != len(m.args) {
-			continue
-		}
-
-		match := true
-
-		for i := 0; i < len(fun.params) && i < len(m.args) && match; i++ {
-			paramT := fun.params[i].wtype
-			argT := m.args[i].Type()
-			if !argT.Match(paramT) {
-				match = false
-			}
-		}
-
-		if match && found {
-			errch <- CreateAmbigousFunctionCallError(
-				m.Token(),
-				"ident",
-			)
-		}
-
-		if match {
-			found = true
-			constr = symbol
-		}
-	}
+	m.constr = ""
 
-	if !found {
+	if fun, args := ts.ResolveOverload(m.Token(), "init", overloads, m.args, m.names, errch); fun != nil {
+		m.args = args
+		m.names = nil
+		m.variadic, m.packed = fun.Packed(len(args))
+		m.constr = fun.Symbol()
+	} else {
 		errch <- CreateNoSuchOverloadError(m.Token(), "init")
 	}
-
-	m.constr = constr
 }
 
 // TypeCheck checks expression whether all operators get the type they can
@@ -1240,11 +3033,49 @@ func (m *Ident) TypeCheck(ts *Scope, errch chan<- error) {
 	var t Type
 	switch m.ident[0] {
 	case '@':
-		t = ts.LookupMember(m.ident[1:])
+		t = ts.AccessMember(m.Token(), m.ident[1:], errch)
 	default:
+		if m.global = ts.LookupGlobal(m.ident); m.global != nil {
+			m.ident = m.global.ident
+		}
 		t = ts.Lookup(m.ident)
 	}
 
+	// local functions cannot be used as values as they access the frames of
+	// the enclosing functions
+	if _, ok := t.(InvalidType); ok && m.ident[0] != '@' &&
+		ts.LookupLocalFunction(m.ident) != nil {
+		errch <- CreateLocalFunctionValueError(m.Token(), m.ident)
+		t = VoidType{}
+	}
+
+	// functions that are not overloaded can be used as values
+	if _, ok := t.(InvalidType); ok && m.ident[0] != '@' {
+		overloads := ts.LookupFunction(m.ident)
+		switch len(overloads) {
+		case 0:
+		case 1:
+			for _, f := range overloads {
+				if len(f.typeParams) > 0 {
+					errch <- CreateTypeInferenceError(
+						m.Token(),
+						m.ident,
+					)
+					t = VoidType{}
+					break
+				}
+				m.function = f
+				t = f.ValueType()
+			}
+		default:
+			errch <- CreateAmbigousFunctionCallError(
+				m.Token(),
+				m.ident,
+			)
+			t = VoidType{}
+		}
+	}
+
 	switch t.(type) {
 	case InvalidType:
 		errch <- CreateUndeclaredVariableError(
@@ -1256,12 +3087,80 @@ func (m *Ident) TypeCheck(ts *Scope, errch chan<- error) {
 	m.wtype = t
 }
 
+// TypeCheck checks the body of the lambda in a scope where the variables of
+// the enclosing scopes are captured. The lambda is registered so that its code
+// is generated alongside the other functions
+func (m *Lambda) TypeCheck(ts *Scope, errch chan<- error) {
+	f := m.function
+
+	if len(f.ident) == 0 {
+		f.ident = fmt.Sprintf("lambda_%d", len(*ts.lambdas))
+		*ts.lambdas = append(*ts.lambdas, f)
+	}
+
+	ls := ts.Child()
+	ls.lambda = f
+	ls.local = nil
+	ls.depth = 0
+	ls.class = nil
+	ls.members = make(map[string]Type)
+	ls.loop = 0
+	ls.labels = nil
+
+	f.typeCheckVariadic(false, errch)
+	f.typeCheckDefaults(ls, false, errch)
+
+	for _, arg := range f.params {
+		arg.wtype = ls.Substitute(arg.wtype)
+		switch arg.wtype.(type) {
+		case VoidType:
+			errch <- CreateInvalidVoidTypeError(
+				arg.Token(),
+				arg.name,
+			)
+		}
+		pt := ls.Declare(arg.name, arg.wtype)
+		if pt != nil {
+			errch <- CreateVariableRedeclarationError(
+				arg.Token(),
+				arg.name,
+				pt,
+				arg.wtype,
+			)
+		}
+	}
+
+	f.body.TypeCheck(ls, errch)
+
+	switch f.returnType.(type) {
+	case nil:
+		f.returnType = VoidType{}
+	case VoidType:
+	default:
+		if !hasReturn(f.body) {
+			errch <- CreateMissingReturnError(m.Token(), f.ident)
+		}
+	}
+}
+
 // TypeCheck checks expression whether all operators get the type they can
 // operate on, all variables are declared, arrays are indexed properly.
 // The check is propagated recursively.
 func (m *IntLiteral) TypeCheck(ts *Scope, errch chan<- error) {
 }
 
+// TypeCheck checks expression whether all operators get the type they can
+// operate on, all variables are declared, arrays are indexed properly.
+// The check is propagated recursively.
+func (m *LongLiteral) TypeCheck(ts *Scope, errch chan<- error) {
+}
+
+// TypeCheck checks expression whether all operators get the type they can
+// operate on, all variables are declared, arrays are indexed properly.
+// The check is propagated recursively.
+func (m *FloatLiteral) TypeCheck(ts *Scope, errch chan<- error) {
+}
+
 // TypeCheck checks expression whether all operators get the type they can
 // operate on, all variables are declared, arrays are indexed properly.
 // The check is propagated recursively.
@@ -1314,11 +3213,29 @@ func (m *NullPair) TypeCheck(ts *Scope, errch chan<- error) {
 // operate on, all variables are declared, arrays are indexed properly.
 // The check is propagated recursively.
 func (m *ArrayElem) TypeCheck(ts *Scope, errch chan<- error) {
+	m.ident = ts.globalIdent(m.ident)
 	array := ts.Lookup(m.ident)
 
+	m.indexed = nil
+
 	for _, index := range m.indexes {
 		index.TypeCheck(ts, errch)
 
+		m.indexed = append(m.indexed, array)
+
+		// maps are indexed by their keys
+		if mapT, ok := array.(MapType); ok {
+			if !mapT.key.Match(index.Type()) {
+				errch <- CreateTypeMismatchError(
+					index.Token(),
+					mapT.key,
+					index.Type(),
+				)
+			}
+			array = mapT.value
+			continue
+		}
+
 		switch indexT := index.Type().(type) {
 		case IntType:
 		default:
@@ -1344,6 +3261,31 @@ func (m *ArrayElem) TypeCheck(ts *Scope, errch chan<- error) {
 	m.wtype = array
 }
 
+// TypeCheck checks expression whether all operators get the type they can
+// operate on, all variables are declared, arrays are indexed properly.
+// The check is propagated recursively.
+func (m *MapHas) TypeCheck(ts *Scope, errch chan<- error) {
+	m.expr.TypeCheck(ts, errch)
+	m.key.TypeCheck(ts, errch)
+
+	switch t := m.expr.Type().(type) {
+	case MapType:
+		if !t.key.Match(m.key.Type()) {
+			errch <- CreateTypeMismatchError(
+				m.key.Token(),
+				t.key,
+				m.key.Type(),
+			)
+		}
+	default:
+		errch <- CreateTypeMismatchError(
+			m.expr.Token(),
+			MapType{key: VoidType{}, value: VoidType{}},
+			t,
+		)
+	}
+}
+
 // TypeCheck checks expression whether all operators get the type they can
 // operate on, all variables are declared, arrays are indexed properly.
 // The check is propagated recursively.
@@ -1367,6 +3309,25 @@ func (m *UnaryOperatorNot) TypeCheck(ts *Scope, errch chan<- error) {
 func (m *UnaryOperatorNegate) TypeCheck(ts *Scope, errch chan<- error) {
 	m.expr.TypeCheck(ts, errch)
 
+	switch unopT := m.expr.Type().(type) {
+	case IntType:
+	case LongType:
+	case FloatType:
+	default:
+		errch <- CreateTypeMismatchError(
+			m.expr.Token(),
+			IntType{},
+			unopT,
+		)
+	}
+}
+
+// TypeCheck checks expression whether all operators get the type they can
+// operate on, all variables are declared, arrays are indexed properly.
+// The check is propagated recursively.
+func (m *UnaryOperatorBitNot) TypeCheck(ts *Scope, errch chan<- error) {
+	m.expr.TypeCheck(ts, errch)
+
 	switch unopT := m.expr.Type().(type) {
 	case IntType:
 	default:
@@ -1386,6 +3347,7 @@ func (m *UnaryOperatorLen) TypeCheck(ts *Scope, errch chan<- error) {
 
 	switch unopT := m.expr.Type().(type) {
 	case ArrayType:
+	case MapType:
 	default:
 		errch <- CreateTypeMismatchError(
 			m.expr.Token(),
@@ -1429,6 +3391,58 @@ func (m *UnaryOperatorChr) TypeCheck(ts *Scope, errch chan<- error) {
 	}
 }
 
+// TypeCheck checks expression whether all operators get the type they can
+// operate on, all variables are declared, arrays are indexed properly.
+// The check is propagated recursively.
+func (m *UnaryOperatorLong) TypeCheck(ts *Scope, errch chan<- error) {
+	m.expr.TypeCheck(ts, errch)
+
+	switch unopT := m.expr.Type().(type) {
+	case IntType:
+	default:
+		errch <- CreateTypeMismatchError(
+			m.expr.Token(),
+			IntType{},
+			unopT,
+		)
+	}
+}
+
+// TypeCheck checks expression whether all operators get the type they can
+// operate on, all variables are declared, arrays are indexed properly.
+// The check is propagated recursively.
+func (m *UnaryOperatorFloat) TypeCheck(ts *Scope, errch chan<- error) {
+	m.expr.TypeCheck(ts, errch)
+
+	switch unopT := m.expr.Type().(type) {
+	case IntType:
+	default:
+		errch <- CreateTypeMismatchError(
+			m.expr.Token(),
+			IntType{},
+			unopT,
+		)
+	}
+}
+
+// TypeCheck checks expression whether all operators get the type they can
+// operate on, all variables are declared, arrays are indexed properly.
+// The check is propagated recursively.
+func (m *UnaryOperatorInt) TypeCheck(ts *Scope, errch chan<- error) {
+	m.expr.TypeCheck(ts, errch)
+
+	switch unopT := m.expr.Type().(type) {
+	case LongType:
+	case FloatType:
+	default:
+		errch <- CreateTypeMismatchError(
+			m.expr.Token(),
+			LongType{},
+			unopT,
+		)
+	}
+}
+
 // TypeCheck checks expression whether all operators get the type they can
 // operate on, all variables are declared, arrays are indexed properly.
 // The check is propagated recursively.
@@ -1448,6 +3462,15 @@ func (m *BinaryOperatorDiv) TypeCheck(ts *Scope, errch chan<- error) {
 // The check is propagated recursively.
 func (m *BinaryOperatorMod) TypeCheck(ts *Scope, errch chan<- error) {
 	typeCheckArithmetic(m, ts, errch)
+
+	// floats have no remainder
+	if lhsT, ok := m.lhs.Type().(FloatType); ok {
+		errch <- CreateTypeMismatchError(
+			m.lhs.Token(),
+			IntType{},
+			lhsT,
+		)
+	}
 }
 
 // TypeCheck checks expression whether all operators get the type they can
@@ -1534,10 +3557,149 @@ func (m *BinaryOperatorBitOr) TypeCheck(ts *Scope, errch chan<- error) {
 	typeCheckArithmetic(m, ts, errch)
 }
 
+// TypeCheck checks expression whether all operators get the type they can
+// operate on, all variables are declared, arrays are indexed properly.
+// The check is propagated recursively.
+func (m *BinaryOperatorBitXor) TypeCheck(ts *Scope, errch chan<- error) {
+	typeCheckBitwise(m, ts, errch)
+}
+
+// TypeCheck checks expression whether all operators get the type they can
+// operate on, all variables are declared, arrays are indexed properly.
+// The check is propagated recursively.
+func (m *BinaryOperatorShiftLeft) TypeCheck(ts *Scope, errch chan<- error) {
+	typeCheckBitwise(m, ts, errch)
+}
+
+// TypeCheck checks expression whether all operators get the type they can
+// operate on, all variables are declared, arrays are indexed properly.
+// The check is propagated recursively.
+func (m *BinaryOperatorShiftRight) TypeCheck(ts *Scope, errch chan<- error) {
+	typeCheckBitwise(m, ts, errch)
+}
+
+// TypeCheck checks expression whether all operators get the type they can
+// operate on, all variables are declared, arrays are indexed properly.
+// The check is propagated recursively.
+func (m *BinaryOperatorShiftRightLogical) TypeCheck(ts *Scope, errch chan<- error) {
+	typeCheckBitwise(m, ts, errch)
+}
+
+// operatorSymbol returns the symbol a class uses to overload the operator
+func operatorSymbol(m BinaryOperator) string {
+	switch m.(type) {
+	case *BinaryOperatorMult:
+		return "*"
+	case *BinaryOperatorDiv:
+		return "/"
+	case *BinaryOperatorMod:
+		return "%"
+	case *BinaryOperatorAdd:
+		return "+"
+	case *BinaryOperatorSub:
+		return "-"
+	case *BinaryOperatorShiftLeft:
+		return "<<"
+	case *BinaryOperatorShiftRight:
+		return ">>"
+	case *BinaryOperatorShiftRightLogical:
+		return ">>>"
+	case *BinaryOperatorGreaterThan:
+		return ">"
+	case *BinaryOperatorGreaterEqual:
+		return ">="
+	case *BinaryOperatorLessThan:
+		return "<"
+	case *BinaryOperatorLessEqual:
+		return "<="
+	case *BinaryOperatorEqual:
+		return "=="
+	case *BinaryOperatorNotEqual:
+		return "!="
+	case *BinaryOperatorBitAnd:
+		return "&"
+	case *BinaryOperatorBitOr:
+		return "|"
+	case *BinaryOperatorBitXor:
+		return "^"
+	default:
+		return ""
+	}
+}
+
+// typeCheckOverload lowers the operator into a call of the method overloading
+// it when the left hand side is an object. It returns false if the class does
+// not overload the operator, in which case the operands are checked as usual
+func typeCheckOverload(m BinaryOperator, ts *Scope, errch chan<- error) bool {
+	lhsT, ok := m.GetLHS().Type().(*ClassType)
+	if !ok {
+		return false
+	}
+
+	class := ts.LookupClass(lhsT.Symbol())
+	if class == nil {
+		return false
+	}
+
+	ident := fmt.Sprintf("operator%s", operatorSymbol(m))
+
+	overloads := ts.LookupMethod(class.Symbol(), ident)
+	if overloads == nil {
+		return false
+	}
+
+	call := &FunctionCall{
+		class: class,
+		ident: ident,
+		args:  []Expression{m.GetRHS()},
+		wtype: InvalidType{},
+	}
+
+	found := false
+	rhsT := m.GetRHS().Type()
+
+	for _, fun := range overloads {
+		if len(fun.params) != 1 || !fun.params[0].wtype.Match(rhsT) {
+			continue
+		}
+
+		if found {
+			errch <- CreateAmbigousFunctionCallError(
+				m.Token(),
+				ident,
+			)
+		}
+
+		if !ts.CanAccess(fun) {
+			errch <- CreateAccessViolationError(
+				m.Token(),
+				fun.class.name,
+				ident,
+			)
+		}
+
+		found = true
+		call.mangledIdent = fun.Symbol()
+		call.wtype = fun.returnType
+	}
+
+	if !found {
+		errch <- CreateNoSuchOverloadError(m.GetRHS().Token(), ident)
+	}
+
+	m.SetOverload(call)
+
+	return true
+}
+
 func typeCheckArithmetic(m BinaryOperator, ts *Scope, errch chan<- error) {
 	m.GetLHS().TypeCheck(ts, errch)
 	m.GetRHS().TypeCheck(ts, errch)
 
+	if typeCheckOverload(m, ts, errch) {
+		return
+	}
+
 	lhsT := m.GetLHS().Type()
 	rhsT := m.GetRHS().Type()
 
@@ -1551,6 +3713,8 @@ func typeCheckArithmetic(m BinaryOperator, ts *Scope, errch chan<- error) {
 
 	switch lhsT.(type) {
 	case IntType:
+	case LongType:
+	case FloatType:
 	case *EnumType:
 	default:
 		errch <- CreateTypeMismatchError(
@@ -1561,10 +3725,63 @@ func typeCheckArithmetic(m BinaryOperator, ts *Scope, errch chan<- error) {
 	}
 }
 
+// TypeCheck checks expression whether the condition is a bool and both
+// branches have the same type.
+// The check is propagated recursively.
+func (m *TernaryExpr) TypeCheck(ts *Scope, errch chan<- error) {
+	m.cond.TypeCheck(ts, errch)
+	m.then.TypeCheck(ts, errch)
+	m.elseExpr.TypeCheck(ts, errch)
+
+	if boolT := m.cond.Type(); !(BoolType{}.Match(boolT)) {
+		errch <- CreateTypeMismatchError(
+			m.cond.Token(),
+			BoolType{},
+			boolT,
+		)
+	}
+
+	// either branch may be of a subtype of the other
+	if thenT, elseT := m.then.Type(), m.elseExpr.Type(); !thenT.Match(elseT) &&
+		!elseT.Match(thenT) {
+		errch <- CreateTypeMismatchError(
+			m.elseExpr.Token(),
+			thenT,
+			elseT,
+		)
+	}
+}
+
+// typeCheckBitwise checks that both operands of a bitwise operator are ints
+func typeCheckBitwise(m BinaryOperator, ts *Scope, errch chan<- error) {
+	m.GetLHS().TypeCheck(ts, errch)
+	m.GetRHS().TypeCheck(ts, errch)
+
+	if typeCheckOverload(m, ts, errch) {
+		return
+	}
+
+	for _, operand := range []Expression{m.GetLHS(), m.GetRHS()} {
+		switch operandT := operand.Type().(type) {
+		case IntType:
+		default:
+			errch <- CreateTypeMismatchError(
+				operand.Token(),
+				IntType{},
+				operandT,
+			)
+		}
+	}
+}
+
 func typeCheckComparator(m BinaryOperator, ts *Scope, errch chan<- error) {
 	m.GetLHS().TypeCheck(ts, errch)
 	m.GetRHS().TypeCheck(ts, errch)
 
+	if typeCheckOverload(m, ts, errch) {
+		return
+	}
+
 	lhsT := m.GetLHS().Type()
 	rhsT := m.GetRHS().Type()
 
@@ -1578,6 +3795,8 @@ func typeCheckComparator(m BinaryOperator, ts *Scope, errch chan<- error) {
 
 	switch lhsT.(type) {
 	case IntType:
+	case LongType:
+	case FloatType:
 	case CharType:
 	default:
 		errch <- CreateTypeMismatchError(
@@ -1592,6 +3811,10 @@ func typeCheckEquality(m BinaryOperator, ts *Scope, errch chan<- error) {
 	m.GetLHS().TypeCheck(ts, errch)
 	m.GetRHS().TypeCheck(ts, errch)
 
+	if typeCheckOverload(m, ts, errch) {
+		return
+	}
+
 	lhsT := m.GetLHS().Type()
 	rhsT := m.GetRHS().Type()
 
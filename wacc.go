@@ -70,10 +70,20 @@ func generateASTFromWACC(wacc *WACC, ifm *IncludeFiles) *AST {
 }
 
 // semanticAnalysis checks the semantics of the imput file and exits if there
-// any errors
-func semanticAnalysis(ast *AST) {
+// any errors. Warnings are printed without stopping the compilation
+func semanticAnalysis(ast *AST, flags *Flags) {
+	var typeErrs []error
+
 	// Check the semantics of the syntactically correct program
-	if typeErrs := ast.TypeCheck(); len(typeErrs) > 0 {
+	for _, err := range ast.TypeCheck(flags.RefCount()) {
+		if IsWarning(err) {
+			fmt.Fprintln(os.Stderr, err.Error())
+			continue
+		}
+		typeErrs = append(typeErrs, err)
+	}
+
+	if len(typeErrs) > 0 {
 		for _, err := range typeErrs {
 			fmt.Println(err.Error())
 		}
@@ -109,7 +119,7 @@ func codeGeneration(ast *AST, flags *Flags) {
 	// Take all the instructions in the channel and push them to the defined
 	// IO Writer
 	if !flags.noassembly {
-		for instr := range ast.CodeGen() {
+		for instr := range ast.CodeGen(flags.RefCount(), flags.VFP()) {
 			fInstr := fmt.Sprintf("%v\n", instr)
 			fmt.Fprint(armFile, fInstr)
 		}
@@ -149,7 +159,7 @@ func main() {
 	ast := generateASTFromWACC(wacc, ifm)
 
 	// Perform semantic analysis on the AST
-	semanticAnalysis(ast)
+	semanticAnalysis(ast, flags)
 
 	if flags.optimise {
 		// Perform optimisation on the AST